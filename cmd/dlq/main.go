@@ -0,0 +1,273 @@
+// Command dlq inspects, edits and redrives the messages of a service's SQS
+// dead-letter queue.
+//
+//	dlq -service wallet list [-max 20]
+//	dlq -service wallet show <message-id>
+//	dlq -service wallet edit <message-id> <body-file|->
+//	dlq -service wallet redrive [-body <body-file|->] <message-id>
+//	dlq -service wallet redrive -all
+//	dlq -service wallet depth
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	paymentsconfig "github.com/draftea/payment-system/payments-service/config"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
+	walletconfig "github.com/draftea/payment-system/wallet-service/config"
+)
+
+func main() {
+	service := flag.String("service", "", "service whose queues are used: payments or wallet")
+	dlqURL := flag.String("dlq", "", "dead-letter queue URL (overrides the service config)")
+	sourceURL := flag.String("source", "", "queue to redrive to when a message does not record one (overrides the service config)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	queueURL, sourceQueueURL, err := queueURLs(*service)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dlqURL != "" {
+		queueURL = *dlqURL
+	}
+	if *sourceURL != "" {
+		sourceQueueURL = *sourceURL
+	}
+	if queueURL == "" {
+		log.Fatal("no dead-letter queue configured; use -service or -dlq")
+	}
+
+	ctx := context.Background()
+	client, err := sharedinfra.NewSQSClient(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue := sharedinfra.NewDeadLetterQueue(client, queueURL, sourceQueueURL)
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "list":
+		err = list(ctx, queue, args)
+	case "show":
+		err = show(ctx, queue, args)
+	case "edit":
+		err = edit(ctx, queue, args)
+	case "redrive":
+		err = redrive(ctx, queue, args)
+	case "depth":
+		err = depth(ctx, queue)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: dlq [flags] <command> [args]
+
+Commands:
+  list [-max N]                       list dead letters with their failure reason
+  show <message-id>                   print a dead letter's attributes and body
+  edit <message-id> <file|->          replace a dead letter's body
+  redrive [-body <file|->] <id>       send a dead letter back to its source queue
+  redrive -all                        send every dead letter back
+  depth                               print the approximate number of dead letters
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// queueURLs returns the dead-letter and source queue URLs of a service
+func queueURLs(service string) (string, string, error) {
+	switch service {
+	case "":
+		return "", "", nil
+	case "payments":
+		cfg, err := paymentsconfig.ReadConfig()
+		if err != nil {
+			return "", "", err
+		}
+		return cfg.AWS.SQSDeadLetterQueueURL, cfg.AWS.SQSQueueURL, nil
+	case "wallet":
+		cfg, err := walletconfig.ReadConfig()
+		if err != nil {
+			return "", "", err
+		}
+		return cfg.AWS.SQSDeadLetterQueueURL, cfg.AWS.SQSQueueURL, nil
+	default:
+		return "", "", fmt.Errorf("unknown service %q", service)
+	}
+}
+
+func list(ctx context.Context, queue *sharedinfra.DeadLetterQueue, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	limit := flags.Int("max", 20, "maximum number of dead letters to list")
+	flags.Parse(args)
+
+	messages, err := queue.Peek(ctx, *limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE ID\tFAILED AT\tRECEIVES\tREASON")
+	for _, message := range messages {
+		failedAt := "-"
+		if !message.FailedAt.IsZero() {
+			failedAt = message.FailedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", message.MessageID, failedAt, message.ReceiveCount, message.FailureReason)
+	}
+
+	return w.Flush()
+}
+
+func show(ctx context.Context, queue *sharedinfra.DeadLetterQueue, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: dlq show <message-id>")
+	}
+
+	message, err := queue.Find(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer queue.Release(ctx, message)
+
+	names := make([]string, 0, len(message.Attributes))
+	for name := range message.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Message ID: %s\n", message.MessageID)
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, aws.ToString(message.Attributes[name].StringValue))
+	}
+	fmt.Println()
+
+	var body bytes.Buffer
+	if err := json.Indent(&body, []byte(message.Body), "", "  "); err != nil {
+		fmt.Println(message.Body)
+		return nil
+	}
+	fmt.Println(body.String())
+
+	return nil
+}
+
+func edit(ctx context.Context, queue *sharedinfra.DeadLetterQueue, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: dlq edit <message-id> <file|->")
+	}
+
+	body, err := readBody(args[1])
+	if err != nil {
+		return err
+	}
+
+	message, err := queue.Find(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	message.Body = body
+	messageID, err := queue.Replace(ctx, message)
+	if err != nil {
+		_ = queue.Release(ctx, message)
+		return err
+	}
+
+	fmt.Printf("Replaced %s with %s\n", message.MessageID, messageID)
+	return nil
+}
+
+func redrive(ctx context.Context, queue *sharedinfra.DeadLetterQueue, args []string) error {
+	flags := flag.NewFlagSet("redrive", flag.ExitOnError)
+	all := flags.Bool("all", false, "redrive every dead letter")
+	bodyFile := flags.String("body", "", "file (or - for stdin) with the body to redrive instead of the stored one")
+	flags.Parse(args)
+
+	if *all {
+		redriven, err := queue.RedriveAll(ctx)
+		fmt.Printf("Redrove %d messages\n", redriven)
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: dlq redrive [-body <file|->] <message-id> | dlq redrive -all")
+	}
+
+	var body string
+	if *bodyFile != "" {
+		var err error
+		if body, err = readBody(*bodyFile); err != nil {
+			return err
+		}
+	}
+
+	message, err := queue.Find(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if body != "" {
+		message.Body = body
+	}
+
+	if err := queue.Redrive(ctx, message); err != nil {
+		_ = queue.Release(ctx, message)
+		return err
+	}
+
+	fmt.Printf("Redrove %s\n", message.MessageID)
+	return nil
+}
+
+func depth(ctx context.Context, queue *sharedinfra.DeadLetterQueue) error {
+	count, err := queue.Depth(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(count)
+	return nil
+}
+
+func readBody(path string) (string, error) {
+	var (
+		body []byte
+		err  error
+	)
+	if path == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read body: %w", err)
+	}
+
+	return string(body), nil
+}
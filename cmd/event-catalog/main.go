@@ -0,0 +1,113 @@
+// Command event-catalog generates the payload schema section of the event
+// catalog from the JSON Schemas of the services' payload registries.
+//
+//	event-catalog                              # print the section
+//	event-catalog -out docs/event-catalog.md   # replace it in the catalog
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	paymentshandlers "github.com/draftea/payment-system/payments-service/handlers"
+	"github.com/draftea/payment-system/shared/events"
+	wallethandlers "github.com/draftea/payment-system/wallet-service/handlers"
+)
+
+const (
+	beginMarker = "<!-- BEGIN GENERATED PAYLOAD SCHEMAS -->"
+	endMarker   = "<!-- END GENERATED PAYLOAD SCHEMAS -->"
+)
+
+// service is a registry and the service it belongs to
+type service struct {
+	name     string
+	registry *events.Registry
+}
+
+func main() {
+	out := flag.String("out", "", "catalog whose generated section is replaced; the section is printed when empty")
+	flag.Parse()
+
+	section, err := render([]service{
+		{name: "payments-service", registry: paymentshandlers.NewPaymentEventRegistry()},
+		{name: "wallet-service", registry: wallethandlers.NewWalletEventRegistry()},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *out == "" {
+		fmt.Print(section)
+		return
+	}
+
+	catalog, err := os.ReadFile(*out)
+	if err != nil {
+		log.Fatalf("failed to read catalog: %v", err)
+	}
+
+	if err := os.WriteFile(*out, replaceSection(catalog, section), 0o644); err != nil {
+		log.Fatalf("failed to write catalog: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Updated %s\n", *out)
+}
+
+// render writes the schema of every registered topic, per service
+func render(services []service) (string, error) {
+	var b strings.Builder
+
+	b.WriteString(beginMarker + "\n")
+	b.WriteString("### Payload Schemas\n\n")
+	b.WriteString("Generated by `make event-catalog` from the payload registries; do not edit by hand. ")
+	b.WriteString("Publishers and consumers validate payloads against these schemas, and consumers dead-letter ")
+	b.WriteString("events that do not match with the offending field as the failure reason.\n")
+
+	for _, svc := range services {
+		fmt.Fprintf(&b, "\n#### %s\n", svc.name)
+
+		for _, topic := range svc.registry.Topics() {
+			schema, _ := svc.registry.Schema(topic)
+			raw, err := json.MarshalIndent(schema, "", "  ")
+			if err != nil {
+				return "", fmt.Errorf("failed to encode schema of %s: %w", topic, err)
+			}
+
+			version, _ := svc.registry.CurrentVersion(topic)
+			fmt.Fprintf(&b, "\n##### %s\n\n", topic)
+			fmt.Fprintf(&b, "Version %s, `%s`", version, schema.Description)
+			if len(schema.Required) > 0 {
+				fmt.Fprintf(&b, "; requires `%s`", strings.Join(schema.Required, "`, `"))
+			}
+			b.WriteString(".\n\n")
+			fmt.Fprintf(&b, "```json\n%s\n```\n", raw)
+		}
+	}
+
+	b.WriteString(endMarker + "\n")
+	return b.String(), nil
+}
+
+// replaceSection swaps the generated section of catalog for section,
+// appending it when the catalog has none
+func replaceSection(catalog []byte, section string) []byte {
+	begin := bytes.Index(catalog, []byte(beginMarker))
+	end := bytes.Index(catalog, []byte(endMarker))
+	if begin < 0 || end < begin {
+		return append(append(bytes.TrimRight(catalog, "\n"), "\n\n"...), section...)
+	}
+
+	end += len(endMarker)
+	if end < len(catalog) && catalog[end] == '\n' {
+		end++
+	}
+
+	replaced := append([]byte{}, catalog[:begin]...)
+	replaced = append(replaced, section...)
+	return append(replaced, catalog[end:]...)
+}
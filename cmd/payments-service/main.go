@@ -38,10 +38,61 @@ func main() {
 		}
 	}()
 
+	// Start outbox relay
+	if deps.OutboxRelay != nil {
+		relayCtx := ctx
+		if deps.Telemetry != nil {
+			relayCtx = telemetry.WithTelemetry(relayCtx, deps.Telemetry)
+		}
+		if err := deps.OutboxRelay.Start(relayCtx); err != nil {
+			log.Fatalf("Failed to start outbox relay: %v", err)
+		}
+	}
+
+	// Start inbox retention cleaner
+	if deps.Inbox != nil {
+		if err := deps.Inbox.Start(ctx); err != nil {
+			log.Fatalf("Failed to start inbox cleaner: %v", err)
+		}
+	}
+
+	// Start dead-letter queue depth monitor
+	if deps.DeadLetterMonitor != nil {
+		monitorCtx := ctx
+		if deps.Telemetry != nil {
+			monitorCtx = telemetry.WithTelemetry(monitorCtx, deps.Telemetry)
+		}
+		if err := deps.DeadLetterMonitor.Start(monitorCtx); err != nil {
+			log.Fatalf("Failed to start dead-letter monitor: %v", err)
+		}
+	}
+
+	// Start stuck-saga watchdog
+	if deps.SagaWatchdog != nil {
+		watchdogCtx := ctx
+		if deps.Telemetry != nil {
+			watchdogCtx = telemetry.WithTelemetry(watchdogCtx, deps.Telemetry)
+		}
+		if err := deps.SagaWatchdog.Start(watchdogCtx); err != nil {
+			log.Fatalf("Failed to start saga watchdog: %v", err)
+		}
+	}
+
+	// Start orchestrated saga timeouts
+	if deps.SagaTimeouts != nil {
+		timeoutsCtx := ctx
+		if deps.Telemetry != nil {
+			timeoutsCtx = telemetry.WithTelemetry(timeoutsCtx, deps.Telemetry)
+		}
+		if err := deps.SagaTimeouts.Start(timeoutsCtx); err != nil {
+			log.Fatalf("Failed to start saga timeouts: %v", err)
+		}
+	}
+
 	// Start event subscriber
 	go func() {
 		ctx := context.Background()
-		if err := deps.EventSubscriber.Subscribe(ctx, "", deps.PaymentEventHandlers); err != nil {
+		if err := deps.EventSubscriber.Subscribe(ctx, "", deps.EventHandler); err != nil {
 			log.Printf("Error in event subscriber: %v", err)
 		}
 	}()
@@ -106,6 +157,7 @@ func setupRouter(cfg *config.Config, deps *config.Dependencies) *chi.Mux {
 
 	// Register payment routes
 	deps.PaymentHandlers.RegisterRoutes(r)
+	deps.SagaHandlers.RegisterRoutes(r)
 
 	return r
 }
\ No newline at end of file
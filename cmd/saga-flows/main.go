@@ -0,0 +1,99 @@
+// Command saga-flows validates the declared saga choreographies and renders
+// them as Mermaid flowcharts or Graphviz digraphs. It exits non-zero when a
+// choreography has an unreachable step, a missing compensation or a cycle.
+//
+//	saga-flows                            # print the Mermaid document
+//	saga-flows -format dot                # print the Graphviz digraphs
+//	saga-flows -out docs/saga-flows.md    # write the Mermaid document
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/draftea/payment-system/shared/saga"
+)
+
+func main() {
+	format := flag.String("format", "mermaid", "output format: mermaid or dot")
+	out := flag.String("out", "", "file the output is written to; it is printed when empty")
+	flag.Parse()
+
+	choreographies := saga.Choreographies()
+
+	failed := false
+	for _, c := range choreographies {
+		if err := c.Validate(); err != nil {
+			log.Print(err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+
+	var output string
+	switch *format {
+	case "mermaid":
+		output = renderMermaid(choreographies)
+	case "dot":
+		output = renderDOT(choreographies)
+	default:
+		log.Fatalf("unknown format %q: use mermaid or dot", *format)
+	}
+
+	if *out == "" {
+		fmt.Print(output)
+		return
+	}
+
+	if err := os.WriteFile(*out, []byte(output), 0o644); err != nil {
+		log.Fatalf("failed to write %s: %v", *out, err)
+	}
+	fmt.Fprintf(os.Stderr, "Updated %s\n", *out)
+}
+
+// renderMermaid writes a Markdown document with a flowchart and the steps
+// without compensation of every choreography
+func renderMermaid(choreographies []*saga.Choreography) string {
+	var b strings.Builder
+
+	b.WriteString("# Saga Flows\n\n")
+	b.WriteString("Generated by `make saga-flows` from the choreographies declared in `shared/saga`; do not edit by hand. ")
+	b.WriteString("Thick edges are failures, dashed edges and steps are compensations, and the hexagon is the pivot, ")
+	b.WriteString("after which failures no longer undo earlier steps.\n")
+
+	for _, c := range choreographies {
+		fmt.Fprintf(&b, "\n## %s\n\n", strings.ToUpper(c.Name[:1])+c.Name[1:])
+		fmt.Fprintf(&b, "```mermaid\n%s```\n", c.Mermaid())
+
+		var exempt []string
+		for _, step := range c.Steps {
+			if step.NoCompensationReason != "" {
+				exempt = append(exempt, fmt.Sprintf("| %s | %s | %s |", step.Name, step.Participant, step.NoCompensationReason))
+			}
+		}
+		if len(exempt) > 0 {
+			b.WriteString("\nSteps declared without compensation:\n\n")
+			b.WriteString("| Step | Participant | Why |\n|------|-------------|-----|\n")
+			b.WriteString(strings.Join(exempt, "\n") + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// renderDOT writes the digraph of every choreography
+func renderDOT(choreographies []*saga.Choreography) string {
+	var b strings.Builder
+	for i, c := range choreographies {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(c.DOT())
+	}
+	return b.String()
+}
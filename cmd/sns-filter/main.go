@@ -0,0 +1,121 @@
+// Command sns-filter prints the SNS filter policy generated from the topics a
+// service's event handlers consume, and applies it to the subscription of the
+// service's SQS queue.
+//
+//	sns-filter -service wallet           # print the policy
+//	sns-filter -service wallet -apply    # set it on the subscription
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	paymentsapplication "github.com/draftea/payment-system/payments-service/application"
+	paymentsconfig "github.com/draftea/payment-system/payments-service/config"
+	paymentshandlers "github.com/draftea/payment-system/payments-service/handlers"
+	"github.com/draftea/payment-system/shared/events"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
+	"github.com/draftea/payment-system/shared/saga"
+	walletconfig "github.com/draftea/payment-system/wallet-service/config"
+	wallethandlers "github.com/draftea/payment-system/wallet-service/handlers"
+)
+
+func main() {
+	service := flag.String("service", "", "service whose handlers and queue are used: payments or wallet")
+	topicArn := flag.String("topic-arn", "", "SNS topic ARN (overrides the service config)")
+	queueURL := flag.String("queue-url", "", "SQS queue URL (overrides the service config)")
+	apply := flag.Bool("apply", false, "set the policy on the queue's subscription")
+	flag.Parse()
+
+	topics, configTopicArn, configQueueURL, err := serviceTopics(*service)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *topicArn == "" {
+		*topicArn = configTopicArn
+	}
+	if *queueURL == "" {
+		*queueURL = configQueueURL
+	}
+
+	policy, err := sharedinfra.TopicFilterPolicy(topics)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if policy == "" {
+		fmt.Println("no filter: the handlers consume every topic")
+	} else {
+		fmt.Println(policy)
+	}
+
+	if !*apply {
+		return
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+
+	queueArn, err := queueArn(ctx, sqs.NewFromConfig(cfg), *queueURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := sharedinfra.ApplyFilterPolicy(ctx, sns.NewFromConfig(cfg), *topicArn, queueArn, policy); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Applied to the subscription of %s to %s\n", queueArn, *topicArn)
+}
+
+// serviceTopics returns the topics consumed by a service's event handlers,
+// and its SNS topic and SQS queue. The handlers are built without use cases:
+// only their routes are read.
+func serviceTopics(service string) ([]events.Topic, string, string, error) {
+	switch service {
+	case "payments":
+		cfg, err := paymentsconfig.ReadConfig()
+		if err != nil {
+			return nil, "", "", err
+		}
+		handlers := paymentshandlers.NewPaymentEventHandlers(nil, nil, nil, nil, nil, nil, nil, nil, nil, paymentshandlers.NewPaymentEventRegistry()).
+			TrackSagas(saga.NewTracker(nil, nil))
+		if cfg.RefundSaga.Orchestrated {
+			handlers.Orchestrate(saga.NewOrchestrator(nil, nil, paymentsapplication.RefundOrchestration(cfg.RefundSaga.Policy())))
+		}
+		return handlers.ConsumedTopics(), cfg.AWS.SNSTopicArn, cfg.AWS.SQSQueueURL, nil
+	case "wallet":
+		cfg, err := walletconfig.ReadConfig()
+		if err != nil {
+			return nil, "", "", err
+		}
+		handlers := wallethandlers.NewWalletEventHandlers(nil, nil, wallethandlers.NewWalletEventRegistry())
+		return handlers.ConsumedTopics(), cfg.AWS.SNSTopicArn, cfg.AWS.SQSQueueURL, nil
+	default:
+		return nil, "", "", fmt.Errorf("unknown service %q; use -service payments or wallet", service)
+	}
+}
+
+// queueArn resolves the ARN of the queue, which is the subscription endpoint
+func queueArn(ctx context.Context, client *sqs.Client, queueURL string) (string, error) {
+	output, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get queue ARN: %w", err)
+	}
+
+	return output.Attributes[string(types.QueueAttributeNameQueueArn)], nil
+}
@@ -38,10 +38,39 @@ func main() {
 		}
 	}()
 
+	// Start outbox relay
+	if deps.OutboxRelay != nil {
+		relayCtx := ctx
+		if deps.Telemetry != nil {
+			relayCtx = telemetry.WithTelemetry(relayCtx, deps.Telemetry)
+		}
+		if err := deps.OutboxRelay.Start(relayCtx); err != nil {
+			log.Fatalf("Failed to start outbox relay: %v", err)
+		}
+	}
+
+	// Start inbox retention cleaner
+	if deps.Inbox != nil {
+		if err := deps.Inbox.Start(ctx); err != nil {
+			log.Fatalf("Failed to start inbox cleaner: %v", err)
+		}
+	}
+
+	// Start dead-letter queue depth monitor
+	if deps.DeadLetterMonitor != nil {
+		monitorCtx := ctx
+		if deps.Telemetry != nil {
+			monitorCtx = telemetry.WithTelemetry(monitorCtx, deps.Telemetry)
+		}
+		if err := deps.DeadLetterMonitor.Start(monitorCtx); err != nil {
+			log.Fatalf("Failed to start dead-letter monitor: %v", err)
+		}
+	}
+
 	// Start event subscriber
 	go func() {
 		ctx := context.Background()
-		if err := deps.EventSubscriber.Subscribe(ctx, "", deps.WalletEventHandlers); err != nil {
+		if err := deps.EventSubscriber.Subscribe(ctx, "", deps.EventHandler); err != nil {
 			log.Printf("Error in event subscriber: %v", err)
 		}
 	}()
@@ -0,0 +1,26 @@
+-- Transactional outbox
+-- Events are written here in the same transaction as the aggregate and
+-- relayed to SNS by each service's OutboxRelay
+
+CREATE TABLE IF NOT EXISTS outbox (
+    seq BIGSERIAL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL UNIQUE,
+    source VARCHAR(100) NOT NULL,
+    aggregate_id VARCHAR(36) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    version VARCHAR(50) NOT NULL DEFAULT '1.0',
+    data JSONB NOT NULL,
+    metadata JSONB DEFAULT '{}',
+    correlation_id VARCHAR(36),
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    published_at TIMESTAMP WITH TIME ZONE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes for outbox
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(source, next_attempt_at, seq) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending_aggregate ON outbox(aggregate_id, seq) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_published_at ON outbox(published_at);
@@ -0,0 +1,14 @@
+-- Idempotent inbox
+-- One row per (handler, event) pair processed by an SQS consumer; rows are
+-- written in the handler's transaction and purged after the retention window
+
+CREATE TABLE IF NOT EXISTS inbox (
+    handler_id VARCHAR(255) NOT NULL,
+    event_id VARCHAR(36) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (handler_id, event_id)
+);
+
+-- Create indexes for inbox
+CREATE INDEX IF NOT EXISTS idx_inbox_processed_at ON inbox(processed_at);
@@ -0,0 +1,29 @@
+-- Postgres event transport
+-- Each consumer group (one per service) registers the topic patterns it
+-- subscribes to; publishing copies an event into event_queue once per
+-- matching group and notifies the group on the event_queue channel
+
+CREATE TABLE IF NOT EXISTS event_subscriptions (
+    consumer_group VARCHAR(100) NOT NULL,
+    pattern VARCHAR(255) NOT NULL,
+    pattern_regex TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (consumer_group, pattern)
+);
+
+CREATE TABLE IF NOT EXISTS event_queue (
+    seq BIGSERIAL PRIMARY KEY,
+    consumer_group VARCHAR(100) NOT NULL,
+    event_id VARCHAR(36) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    visible_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    dead_lettered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes for event_queue
+CREATE INDEX IF NOT EXISTS idx_event_queue_claimable ON event_queue(consumer_group, visible_at, seq) WHERE dead_lettered_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_event_queue_dead_lettered ON event_queue(consumer_group, dead_lettered_at) WHERE dead_lettered_at IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Causation tracking
+-- causation_id is the ID of the event whose handler published the event;
+-- together with correlation_id it links every event of a payment across
+-- services into a tree
+
+ALTER TABLE event_stream ADD COLUMN IF NOT EXISTS causation_id VARCHAR(36);
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS causation_id VARCHAR(36);
+
+-- Create indexes for causation
+CREATE INDEX IF NOT EXISTS idx_event_stream_causation_id ON event_stream(causation_id);
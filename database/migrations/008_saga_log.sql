@@ -0,0 +1,30 @@
+-- Saga log
+-- Every choreography event is recorded as a step of the saga it belongs to,
+-- keyed by correlation ID. saga_instances keeps the status derived from the
+-- steps so sagas can be listed by payment and found by status.
+
+CREATE TABLE IF NOT EXISTS saga_steps (
+    event_id VARCHAR(36) NOT NULL,
+    correlation_id VARCHAR(36) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    aggregate_id VARCHAR(36) NOT NULL,
+    reason TEXT,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (correlation_id, event_id)
+);
+
+CREATE TABLE IF NOT EXISTS saga_instances (
+    correlation_id VARCHAR(36) PRIMARY KEY,
+    saga_type VARCHAR(50) NOT NULL,
+    aggregate_id VARCHAR(36) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    current_step VARCHAR(255) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create indexes for saga queries
+CREATE INDEX IF NOT EXISTS idx_saga_instances_aggregate_id ON saga_instances(aggregate_id);
+CREATE INDEX IF NOT EXISTS idx_saga_instances_status_updated_at ON saga_instances(status, updated_at);
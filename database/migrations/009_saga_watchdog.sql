@@ -0,0 +1,5 @@
+-- Stuck-saga watchdog
+-- The payments-service watchdog looks for payments left in a status past the
+-- deadline of their payment method
+
+CREATE INDEX IF NOT EXISTS idx_payments_status_method_updated_at ON payments(status, payment_method_type, updated_at);
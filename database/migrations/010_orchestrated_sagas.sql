@@ -0,0 +1,28 @@
+-- Orchestrated sagas
+-- Sagas run by the orchestrator keep their state here: the step awaiting a
+-- reply, the command it awaits and when the reply times out. Terminal sagas
+-- clear reply_key and deadline.
+
+CREATE TABLE IF NOT EXISTS orchestrated_sagas (
+    id VARCHAR(36) PRIMARY KEY,
+    saga_type VARCHAR(50) NOT NULL,
+    aggregate_id VARCHAR(36) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    step INTEGER NOT NULL,
+    attempt INTEGER NOT NULL,
+    command_id VARCHAR(36),
+    reply_key VARCHAR(255),
+    deadline TIMESTAMP WITH TIME ZONE,
+    completed_steps INTEGER[] NOT NULL DEFAULT '{}',
+    compensations INTEGER NOT NULL DEFAULT 0,
+    failure_reason TEXT,
+    data JSONB NOT NULL,
+    version INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Create indexes for reply matching and timeouts
+CREATE INDEX IF NOT EXISTS idx_orchestrated_sagas_reply_key ON orchestrated_sagas(reply_key, created_at) WHERE deadline IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_orchestrated_sagas_deadline ON orchestrated_sagas(deadline) WHERE deadline IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_orchestrated_sagas_aggregate_id ON orchestrated_sagas(aggregate_id);
@@ -0,0 +1,33 @@
+-- Refund ledger
+-- Every refund of a payment is recorded with its own status. The payment
+-- keeps the cumulative amount of its completed refunds; pending refunds are
+-- summed from refunds while the payment row is locked, so concurrent requests
+-- cannot refund more than the payment.
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_amount BIGINT NOT NULL DEFAULT 0;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'payments_refunded_amount_check') THEN
+        ALTER TABLE payments ADD CONSTRAINT payments_refunded_amount_check
+            CHECK (refunded_amount >= 0 AND refunded_amount <= amount);
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id VARCHAR(36) PRIMARY KEY,
+    payment_id VARCHAR(36) NOT NULL REFERENCES payments(id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL,
+    reason TEXT NOT NULL,
+    requested_by VARCHAR(36),
+    status VARCHAR(50) NOT NULL,
+    failure_reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+-- Create indexes for refund queries
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id_created_at ON refunds(payment_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id_pending ON refunds(payment_id, created_at) WHERE status = 'pending';
@@ -0,0 +1,7 @@
+-- Orchestrated saga reply keys
+-- A step raised for review when its reply times out keeps awaiting the reply
+-- without a deadline, so replies are matched on every saga holding a reply
+-- key rather than only on sagas with a deadline.
+
+DROP INDEX IF EXISTS idx_orchestrated_sagas_reply_key;
+CREATE INDEX IF NOT EXISTS idx_orchestrated_sagas_reply_key ON orchestrated_sagas(reply_key, created_at) WHERE reply_key IS NOT NULL;
@@ -5,6 +5,14 @@
 
 -- Run the updated schema
 \i 003_updated_schema.sql
+\i 004_outbox.sql
+\i 005_inbox.sql
+\i 006_event_queue.sql
+\i 007_causation.sql
+\i 008_saga_log.sql
+\i 009_saga_watchdog.sql
+\i 010_orchestrated_sagas.sql
+\i 011_refunds.sql
 
 \echo 'Database setup completed!'
 
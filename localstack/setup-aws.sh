@@ -21,18 +21,23 @@ aws --endpoint-url=$LOCALSTACK_ENDPOINT sqs create-queue --queue-name payment-ev
 echo "Creating SQS DLQ: payment-events-dlq"
 aws --endpoint-url=$LOCALSTACK_ENDPOINT sqs create-queue --queue-name payment-events-dlq
 
+echo "Creating S3 bucket: event-payloads"
+aws --endpoint-url=$LOCALSTACK_ENDPOINT s3 mb s3://event-payloads
+
 echo "Subscribing SQS queue to SNS topic"
 aws --endpoint-url=$LOCALSTACK_ENDPOINT sns subscribe \
     --topic-arn arn:aws:sns:us-east-1:000000000000:payment-events \
     --protocol sqs \
     --notification-endpoint arn:aws:sqs:us-east-1:000000000000:payment-events
 
-# Set up DLQ policy for the main queue
+# Set up DLQ policy for the main queue. The subscriber dead-letters messages
+# itself after SQS_MAX_RECEIVE_COUNT (5) receives, attaching the failure
+# reason; this policy is only a backstop and must allow more receives.
 echo "Configuring DLQ policy"
 aws --endpoint-url=$LOCALSTACK_ENDPOINT sqs set-queue-attributes \
     --queue-url http://localstack:4566/000000000000/payment-events \
     --attributes '{
-        "RedrivePolicy": "{\"deadLetterTargetArn\":\"arn:aws:sqs:us-east-1:000000000000:payment-events-dlq\",\"maxReceiveCount\":3}"
+        "RedrivePolicy": "{\"deadLetterTargetArn\":\"arn:aws:sqs:us-east-1:000000000000:payment-events-dlq\",\"maxReceiveCount\":10}"
     }'
 
 echo "AWS resources setup completed successfully!"
@@ -0,0 +1,174 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/pkg/errors"
+)
+
+// Error codes of the payment.inconsistent.state events raised for stuck sagas
+const (
+	ErrorCodePaymentTimeout = "payment_timeout"
+	ErrorCodeRefundTimeout  = "refund_timeout"
+)
+
+// SagaDeadlines is how long a saga may stay in each state without progress
+// before it is considered stuck. A zero deadline disables the check.
+type SagaDeadlines struct {
+	Initiated  time.Duration
+	Processing time.Duration
+	Refund     time.Duration
+}
+
+// StuckSaga is a payment or refund saga that made no progress past its
+// deadline
+type StuckSaga struct {
+	PaymentID models.ID
+	// CorrelationID is the saga's correlation ID, empty when the saga log
+	// has no record of it
+	CorrelationID models.ID
+	Type          saga.SagaType
+	// State is the payment status, or the current step of a refund saga
+	State         string
+	PaymentMethod domain.PaymentMethodType
+	LastActivity  time.Time
+	Deadline      time.Duration
+}
+
+// StuckSagaFinder finds sagas whose last activity is older than a deadline
+type StuckSagaFinder interface {
+	// FindStuckPayments returns payments of method in status whose last
+	// activity is before the given time, oldest first
+	FindStuckPayments(ctx context.Context, method domain.PaymentMethodType, status domain.PaymentStatus, before time.Time, limit int) ([]StuckSaga, error)
+	// FindStuckRefunds returns running refund sagas of payments of method
+	// whose last activity is before the given time, oldest first
+	FindStuckRefunds(ctx context.Context, method domain.PaymentMethodType, before time.Time, limit int) ([]StuckSaga, error)
+}
+
+// DetectStuckSagasCommand represents the command to detect stuck sagas
+type DetectStuckSagasCommand struct {
+	// Now is the time deadlines are measured against
+	Now time.Time
+	// Limit bounds the sagas raised per payment method and state
+	Limit int
+}
+
+// DetectStuckSagasResponse represents the sagas raised as inconsistent
+type DetectStuckSagasResponse struct {
+	Raised []StuckSaga
+}
+
+// DetectStuckSagas use case finds payments and refunds stuck past the
+// deadline of their state and raises payment.inconsistent.state for each, so
+// ProcessPaymentInconsistentOperation compensates them
+type DetectStuckSagas struct {
+	finder         StuckSagaFinder
+	eventPublisher events.Publisher
+	deadlines      map[domain.PaymentMethodType]SagaDeadlines
+}
+
+// NewDetectStuckSagas creates a new DetectStuckSagas use case. Payment
+// methods without deadlines are not checked.
+func NewDetectStuckSagas(
+	finder StuckSagaFinder,
+	eventPublisher events.Publisher,
+	deadlines map[domain.PaymentMethodType]SagaDeadlines,
+) *DetectStuckSagas {
+	return &DetectStuckSagas{
+		finder:         finder,
+		eventPublisher: eventPublisher,
+		deadlines:      deadlines,
+	}
+}
+
+// Execute raises every stuck saga
+func (uc *DetectStuckSagas) Execute(ctx context.Context, cmd *DetectStuckSagasCommand) (*DetectStuckSagasResponse, error) {
+	if cmd.Limit <= 0 {
+		return nil, errors.New("limit must be positive")
+	}
+
+	methods := make([]domain.PaymentMethodType, 0, len(uc.deadlines))
+	for method := range uc.deadlines {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i] < methods[j] })
+
+	response := &DetectStuckSagasResponse{}
+	for _, method := range methods {
+		deadlines := uc.deadlines[method]
+
+		for _, check := range []struct {
+			status   domain.PaymentStatus
+			deadline time.Duration
+		}{
+			{status: domain.PaymentStatusInitiated, deadline: deadlines.Initiated},
+			{status: domain.PaymentStatusProcessing, deadline: deadlines.Processing},
+		} {
+			if check.deadline <= 0 {
+				continue
+			}
+
+			stuck, err := uc.finder.FindStuckPayments(ctx, method, check.status, cmd.Now.Add(-check.deadline), cmd.Limit)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to find stuck %s payments", check.status)
+			}
+			if err := uc.raise(ctx, response, stuck, check.deadline, cmd.Now); err != nil {
+				return nil, err
+			}
+		}
+
+		if deadlines.Refund <= 0 {
+			continue
+		}
+
+		stuck, err := uc.finder.FindStuckRefunds(ctx, method, cmd.Now.Add(-deadlines.Refund), cmd.Limit)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find stuck refunds")
+		}
+		if err := uc.raise(ctx, response, stuck, deadlines.Refund, cmd.Now); err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// raise publishes payment.inconsistent.state for each stuck saga. The event
+// joins the stuck saga's correlation ID, so the saga log records it as a step
+// and the saga is not raised again before another deadline passes.
+func (uc *DetectStuckSagas) raise(ctx context.Context, response *DetectStuckSagasResponse, stuck []StuckSaga, deadline time.Duration, now time.Time) error {
+	for _, s := range stuck {
+		s.Deadline = deadline
+
+		errorCode := ErrorCodePaymentTimeout
+		if s.Type == saga.SagaTypeRefund {
+			errorCode = ErrorCodeRefundTimeout
+		}
+
+		event := events.NewEvent(s.PaymentID, events.PaymentInconsistentStateEvent, PaymentInconsistentStateData{
+			PaymentID: s.PaymentID,
+			Reason:    fmt.Sprintf("%s saga stuck in %s", s.Type, s.State),
+			ErrorCode: errorCode,
+			ErrorMessage: fmt.Sprintf("no progress for %s, deadline for %s %s is %s",
+				now.Sub(s.LastActivity).Round(time.Second), s.PaymentMethod, s.State, deadline),
+		})
+		if s.CorrelationID != "" {
+			event.WithCorrelationID(s.CorrelationID)
+		}
+
+		if err := uc.eventPublisher.Publish(ctx, event); err != nil {
+			return errors.Wrapf(err, "failed to raise stuck %s saga of payment %s", s.Type, s.PaymentID)
+		}
+
+		response.Raised = append(response.Raised, s)
+	}
+
+	return nil
+}
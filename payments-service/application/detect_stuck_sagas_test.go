@@ -0,0 +1,184 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/payments-service/mocks"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStuckSagaFinder returns the configured sagas and records the cutoffs
+// it was asked for
+type fakeStuckSagaFinder struct {
+	payments map[domain.PaymentStatus][]StuckSaga
+	refunds  []StuckSaga
+	err      error
+	cutoffs  map[string]time.Time
+}
+
+func (f *fakeStuckSagaFinder) FindStuckPayments(ctx context.Context, method domain.PaymentMethodType, status domain.PaymentStatus, before time.Time, limit int) ([]StuckSaga, error) {
+	f.cutoffs[method.String()+"/"+string(status)] = before
+	return f.payments[status], f.err
+}
+
+func (f *fakeStuckSagaFinder) FindStuckRefunds(ctx context.Context, method domain.PaymentMethodType, before time.Time, limit int) ([]StuckSaga, error) {
+	f.cutoffs[method.String()+"/refund"] = before
+	return f.refunds, f.err
+}
+
+func TestDetectStuckSagas_Execute(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	stuckPayment := StuckSaga{
+		PaymentID:     "payment-1",
+		CorrelationID: "correlation-1",
+		Type:          saga.SagaTypePayment,
+		State:         string(domain.PaymentStatusProcessing),
+		PaymentMethod: domain.PaymentMethodTypeWallet,
+		LastActivity:  now.Add(-3 * time.Minute),
+	}
+	stuckRefund := StuckSaga{
+		PaymentID:     "payment-2",
+		CorrelationID: "correlation-2",
+		Type:          saga.SagaTypeRefund,
+		State:         "wallet credit",
+		PaymentMethod: domain.PaymentMethodTypeWallet,
+		LastActivity:  now.Add(-2 * time.Hour),
+	}
+	untracked := StuckSaga{
+		PaymentID:     "payment-3",
+		Type:          saga.SagaTypePayment,
+		State:         string(domain.PaymentStatusInitiated),
+		PaymentMethod: domain.PaymentMethodTypeWallet,
+		LastActivity:  now.Add(-10 * time.Minute),
+	}
+
+	tests := []struct {
+		name            string
+		command         *DetectStuckSagasCommand
+		payments        map[domain.PaymentStatus][]StuckSaga
+		refunds         []StuckSaga
+		finderErr       error
+		setupMocks      func(*mocks.MockPublisher)
+		expectedError   string
+		expectedRaised  int
+		expectedCutoffs map[string]time.Time
+	}{
+		{
+			name:    "raises stuck payments and refunds",
+			command: &DetectStuckSagasCommand{Now: now, Limit: 10},
+			payments: map[domain.PaymentStatus][]StuckSaga{
+				domain.PaymentStatusProcessing: {stuckPayment},
+			},
+			refunds: []StuckSaga{stuckRefund},
+			setupMocks: func(publisher *mocks.MockPublisher) {
+				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
+					data := evt.Data.(PaymentInconsistentStateData)
+					return evt.Topic == events.PaymentInconsistentStateEvent &&
+						evt.CorrelationID == "correlation-1" &&
+						data.ErrorCode == ErrorCodePaymentTimeout &&
+						data.Reason == "payment saga stuck in processing" &&
+						data.ErrorMessage == "no progress for 3m0s, deadline for wallet processing is 2m0s"
+				})).Return(nil).Once()
+				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
+					data := evt.Data.(PaymentInconsistentStateData)
+					return evt.CorrelationID == "correlation-2" &&
+						data.ErrorCode == ErrorCodeRefundTimeout &&
+						data.Reason == "refund saga stuck in wallet credit"
+				})).Return(nil).Once()
+			},
+			expectedRaised: 2,
+			expectedCutoffs: map[string]time.Time{
+				"wallet/initiated":  now.Add(-5 * time.Minute),
+				"wallet/processing": now.Add(-2 * time.Minute),
+				"wallet/refund":     now.Add(-time.Hour),
+			},
+		},
+		{
+			name:    "payment unknown to the saga log",
+			command: &DetectStuckSagasCommand{Now: now, Limit: 10},
+			payments: map[domain.PaymentStatus][]StuckSaga{
+				domain.PaymentStatusInitiated: {untracked},
+			},
+			setupMocks: func(publisher *mocks.MockPublisher) {
+				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
+					return evt.AggregateID == "payment-3" && evt.CorrelationID == ""
+				})).Return(nil).Once()
+			},
+			expectedRaised: 1,
+		},
+		{
+			name:          "finder error",
+			command:       &DetectStuckSagasCommand{Now: now, Limit: 10},
+			finderErr:     errors.New("connection refused"),
+			setupMocks:    func(publisher *mocks.MockPublisher) {},
+			expectedError: "failed to find stuck initiated payments: connection refused",
+		},
+		{
+			name:          "limit must be positive",
+			command:       &DetectStuckSagasCommand{Now: now},
+			setupMocks:    func(publisher *mocks.MockPublisher) {},
+			expectedError: "limit must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finder := &fakeStuckSagaFinder{
+				payments: tt.payments,
+				refunds:  tt.refunds,
+				err:      tt.finderErr,
+				cutoffs:  make(map[string]time.Time),
+			}
+			publisher := mocks.NewMockPublisher(t)
+			tt.setupMocks(publisher)
+
+			useCase := NewDetectStuckSagas(finder, publisher, map[domain.PaymentMethodType]SagaDeadlines{
+				domain.PaymentMethodTypeWallet: {
+					Initiated:  5 * time.Minute,
+					Processing: 2 * time.Minute,
+					Refund:     time.Hour,
+				},
+			})
+
+			result, err := useCase.Execute(context.Background(), tt.command)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.Nil(t, result)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, result.Raised, tt.expectedRaised)
+			for _, raised := range result.Raised {
+				assert.NotZero(t, raised.Deadline)
+			}
+			for key, cutoff := range tt.expectedCutoffs {
+				assert.Equal(t, cutoff, finder.cutoffs[key], key)
+			}
+		})
+	}
+}
+
+func TestDetectStuckSagas_SkipsZeroDeadlines(t *testing.T) {
+	finder := &fakeStuckSagaFinder{cutoffs: make(map[string]time.Time)}
+	publisher := mocks.NewMockPublisher(t)
+
+	useCase := NewDetectStuckSagas(finder, publisher, map[domain.PaymentMethodType]SagaDeadlines{
+		domain.PaymentMethodTypeCreditCard: {Processing: 15 * time.Minute},
+	})
+
+	result, err := useCase.Execute(context.Background(), &DetectStuckSagasCommand{Now: time.Now(), Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, result.Raised)
+	assert.Len(t, finder.cutoffs, 1)
+	assert.Contains(t, finder.cutoffs, "credit_card/processing")
+}
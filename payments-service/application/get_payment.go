@@ -15,15 +15,16 @@ type GetPaymentQuery struct {
 
 // GetPaymentResponse represents the response for getting a payment
 type GetPaymentResponse struct {
-	PaymentID     string               `json:"payment_id"`
-	UserID        string               `json:"user_id"`
-	Amount        int64                `json:"amount"`
-	Currency      string               `json:"currency"`
-	PaymentMethod domain.PaymentMethod `json:"payment_method"`
-	Description   string               `json:"description"`
-	Status        string               `json:"status"`
-	CreatedAt     string               `json:"created_at"`
-	UpdatedAt     string               `json:"updated_at"`
+	PaymentID      string               `json:"payment_id"`
+	UserID         string               `json:"user_id"`
+	Amount         int64                `json:"amount"`
+	Currency       string               `json:"currency"`
+	PaymentMethod  domain.PaymentMethod `json:"payment_method"`
+	Description    string               `json:"description"`
+	Status         string               `json:"status"`
+	RefundedAmount int64                `json:"refunded_amount"`
+	CreatedAt      string               `json:"created_at"`
+	UpdatedAt      string               `json:"updated_at"`
 }
 
 // GetPayment use case
@@ -59,15 +60,16 @@ func (uc *GetPayment) Execute(ctx context.Context, query *GetPaymentQuery) (*Get
 	}
 	
 	response := &GetPaymentResponse{
-		PaymentID:     payment.ID.String(),
-		UserID:        payment.UserID.String(),
-		Amount:        payment.Amount.Amount,
-		Currency:      payment.Amount.Currency,
-		PaymentMethod: payment.PaymentMethod,
-		Description:   payment.Description,
-		Status:        string(payment.Status),
-		CreatedAt:     payment.Timestamps.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt:     payment.Timestamps.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		PaymentID:      payment.ID.String(),
+		UserID:         payment.UserID.String(),
+		Amount:         payment.Amount.Amount,
+		Currency:       payment.Amount.Currency,
+		PaymentMethod:  payment.PaymentMethod,
+		Description:    payment.Description,
+		Status:         string(payment.Status),
+		RefundedAmount: payment.RefundedAmount.Amount,
+		CreatedAt:      payment.Timestamps.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt:      payment.Timestamps.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	}
 
 	return response, nil
@@ -0,0 +1,108 @@
+package application
+
+import (
+	"context"
+	"time"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/pkg/errors"
+)
+
+// GetSagaQuery represents the query to get a saga
+type GetSagaQuery struct {
+	CorrelationID string `json:"correlation_id"`
+}
+
+// SagaStepResponse represents a step of a saga
+type SagaStepResponse struct {
+	EventID      string `json:"event_id"`
+	Topic        string `json:"topic"`
+	Name         string `json:"name"`
+	Outcome      string `json:"outcome,omitempty"`
+	Compensation bool   `json:"compensation"`
+	Reason       string `json:"reason,omitempty"`
+	OccurredAt   string `json:"occurred_at"`
+	// DurationMs is the time since the previous step
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// SagaResponse represents where a saga is and how long each hop took
+type SagaResponse struct {
+	CorrelationID string             `json:"correlation_id"`
+	Type          string             `json:"type"`
+	PaymentID     string             `json:"payment_id"`
+	Status        string             `json:"status"`
+	CurrentStep   string             `json:"current_step"`
+	StartedAt     string             `json:"started_at"`
+	UpdatedAt     string             `json:"updated_at"`
+	FinishedAt    string             `json:"finished_at,omitempty"`
+	DurationMs    int64              `json:"duration_ms"`
+	Steps         []SagaStepResponse `json:"steps"`
+}
+
+// newSagaResponse maps a saga instance to its response; running sagas are
+// timed up to now
+func newSagaResponse(instance *saga.Instance, now time.Time) *SagaResponse {
+	response := &SagaResponse{
+		CorrelationID: instance.CorrelationID.String(),
+		Type:          string(instance.Type),
+		PaymentID:     instance.AggregateID.String(),
+		Status:        string(instance.Status),
+		CurrentStep:   instance.CurrentStep,
+		StartedAt:     instance.StartedAt.Format(time.RFC3339Nano),
+		UpdatedAt:     instance.UpdatedAt.Format(time.RFC3339Nano),
+		DurationMs:    instance.Duration(now).Milliseconds(),
+		Steps:         make([]SagaStepResponse, 0, len(instance.Steps)),
+	}
+
+	if instance.FinishedAt != nil {
+		response.FinishedAt = instance.FinishedAt.Format(time.RFC3339Nano)
+	}
+
+	for _, step := range instance.Steps {
+		response.Steps = append(response.Steps, SagaStepResponse{
+			EventID:      step.EventID.String(),
+			Topic:        step.Topic.String(),
+			Name:         step.Name,
+			Outcome:      string(step.Outcome),
+			Compensation: step.Compensation,
+			Reason:       step.Reason,
+			OccurredAt:   step.OccurredAt.Format(time.RFC3339Nano),
+			DurationMs:   step.Duration.Milliseconds(),
+		})
+	}
+
+	return response
+}
+
+// GetSaga use case
+type GetSaga struct {
+	sagaLog saga.Log
+}
+
+// NewGetSaga creates a new GetSaga use case
+func NewGetSaga(sagaLog saga.Log) *GetSaga {
+	return &GetSaga{
+		sagaLog: sagaLog,
+	}
+}
+
+// Execute executes the get saga use case
+func (uc *GetSaga) Execute(ctx context.Context, query *GetSagaQuery) (*SagaResponse, error) {
+	if query.CorrelationID == "" {
+		return nil, errors.New("correlation ID is required")
+	}
+
+	correlationID, err := models.NewID(query.CorrelationID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid correlation ID")
+	}
+
+	instance, err := uc.sagaLog.Get(ctx, correlationID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get saga")
+	}
+
+	return newSagaResponse(instance, time.Now()), nil
+}
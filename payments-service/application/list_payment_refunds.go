@@ -0,0 +1,107 @@
+package application
+
+import (
+	"context"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+)
+
+// ListPaymentRefundsQuery represents the query to list the refunds of a
+// payment
+type ListPaymentRefundsQuery struct {
+	PaymentID string `json:"payment_id"`
+}
+
+// RefundResponse represents a refund of a payment
+type RefundResponse struct {
+	RefundID      string       `json:"refund_id"`
+	Amount        models.Money `json:"amount"`
+	Reason        string       `json:"reason"`
+	RequestedBy   string       `json:"requested_by,omitempty"`
+	Status        string       `json:"status"`
+	FailureReason string       `json:"failure_reason,omitempty"`
+	CreatedAt     string       `json:"created_at"`
+	UpdatedAt     string       `json:"updated_at"`
+}
+
+// ListPaymentRefundsResponse represents the refunds of a payment, oldest
+// first, and what is left to refund
+type ListPaymentRefundsResponse struct {
+	PaymentID        string            `json:"payment_id"`
+	Status           string            `json:"status"`
+	Amount           models.Money      `json:"amount"`
+	RefundedAmount   models.Money      `json:"refunded_amount"`
+	PendingAmount    models.Money      `json:"pending_amount"`
+	RefundableAmount models.Money      `json:"refundable_amount"`
+	Refunds          []*RefundResponse `json:"refunds"`
+}
+
+// ListPaymentRefunds use case
+type ListPaymentRefunds struct {
+	paymentRepository domain.PaymentRepository
+	refundRepository  domain.RefundRepository
+}
+
+// NewListPaymentRefunds creates a new ListPaymentRefunds use case
+func NewListPaymentRefunds(paymentRepository domain.PaymentRepository, refundRepository domain.RefundRepository) *ListPaymentRefunds {
+	return &ListPaymentRefunds{
+		paymentRepository: paymentRepository,
+		refundRepository:  refundRepository,
+	}
+}
+
+// Execute executes the list payment refunds use case
+func (uc *ListPaymentRefunds) Execute(ctx context.Context, query *ListPaymentRefundsQuery) (*ListPaymentRefundsResponse, error) {
+	if query.PaymentID == "" {
+		return nil, errors.New("payment ID is required")
+	}
+
+	paymentID, err := models.NewID(query.PaymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid payment ID")
+	}
+
+	payment, err := uc.paymentRepository.FindByID(ctx, paymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find payment")
+	}
+
+	if payment == nil {
+		return nil, errors.New("payment not found")
+	}
+
+	refunds, err := uc.refundRepository.FindByPaymentID(ctx, paymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find refunds")
+	}
+
+	pending := models.NewMoney(0, payment.Amount.Currency)
+	response := &ListPaymentRefundsResponse{
+		PaymentID:      payment.ID.String(),
+		Status:         string(payment.Status),
+		Amount:         payment.Amount,
+		RefundedAmount: models.NewMoney(payment.RefundedAmount.Amount, payment.Amount.Currency),
+		Refunds:        make([]*RefundResponse, 0, len(refunds)),
+	}
+	for _, refund := range refunds {
+		if refund.Status == domain.RefundStatusPending {
+			pending.Amount += refund.Amount.Amount
+		}
+		response.Refunds = append(response.Refunds, &RefundResponse{
+			RefundID:      refund.ID.String(),
+			Amount:        refund.Amount,
+			Reason:        refund.Reason,
+			RequestedBy:   refund.RequestedBy.String(),
+			Status:        string(refund.Status),
+			FailureReason: refund.FailureReason,
+			CreatedAt:     refund.Timestamps.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:     refund.Timestamps.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		})
+	}
+	response.PendingAmount = pending
+	response.RefundableAmount = payment.RefundableAmount(pending)
+
+	return response, nil
+}
@@ -0,0 +1,62 @@
+package application
+
+import (
+	"context"
+	"time"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/pkg/errors"
+)
+
+// ListPaymentSagasQuery represents the query to list the sagas of a payment
+type ListPaymentSagasQuery struct {
+	PaymentID string `json:"payment_id"`
+}
+
+// ListPaymentSagasResponse represents the payment and refund sagas of a
+// payment, oldest first
+type ListPaymentSagasResponse struct {
+	PaymentID string          `json:"payment_id"`
+	Sagas     []*SagaResponse `json:"sagas"`
+}
+
+// ListPaymentSagas use case
+type ListPaymentSagas struct {
+	sagaLog saga.Log
+}
+
+// NewListPaymentSagas creates a new ListPaymentSagas use case
+func NewListPaymentSagas(sagaLog saga.Log) *ListPaymentSagas {
+	return &ListPaymentSagas{
+		sagaLog: sagaLog,
+	}
+}
+
+// Execute executes the list payment sagas use case
+func (uc *ListPaymentSagas) Execute(ctx context.Context, query *ListPaymentSagasQuery) (*ListPaymentSagasResponse, error) {
+	if query.PaymentID == "" {
+		return nil, errors.New("payment ID is required")
+	}
+
+	paymentID, err := models.NewID(query.PaymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid payment ID")
+	}
+
+	instances, err := uc.sagaLog.FindByAggregate(ctx, paymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find sagas")
+	}
+
+	now := time.Now()
+	response := &ListPaymentSagasResponse{
+		PaymentID: paymentID.String(),
+		Sagas:     make([]*SagaResponse, 0, len(instances)),
+	}
+	for _, instance := range instances {
+		response.Sagas = append(response.Sagas, newSagaResponse(instance, now))
+	}
+
+	return response, nil
+}
@@ -21,6 +21,7 @@ type ProcessPaymentInconsistentOperationCommand struct {
 type ProcessPaymentInconsistentOperation struct {
 	paymentRepository domain.PaymentRepository
 	eventPublisher    events.Publisher
+	refundSagas       RefundSagaStarter
 }
 
 // NewProcessPaymentInconsistentOperation creates a new ProcessPaymentInconsistentOperation use case
@@ -34,6 +35,14 @@ func NewProcessPaymentInconsistentOperation(
 	}
 }
 
+// OrchestrateRefunds runs the refunds and wallet credits compensating
+// inconsistent payments as orchestrated sagas started through starter, so
+// their outcome is awaited instead of assumed
+func (uc *ProcessPaymentInconsistentOperation) OrchestrateRefunds(starter RefundSagaStarter) *ProcessPaymentInconsistentOperation {
+	uc.refundSagas = starter
+	return uc
+}
+
 // Execute processes inconsistent payments by initiating compensating actions
 func (uc *ProcessPaymentInconsistentOperation) Execute(ctx context.Context, cmd *ProcessPaymentInconsistentOperationCommand) error {
 	// Validate command
@@ -64,17 +73,31 @@ func (uc *ProcessPaymentInconsistentOperation) Execute(ctx context.Context, cmd
 		return errors.Wrap(err, "failed to publish audit event")
 	}
 
+	// The action is named after the status the payment was found in
+	action := uc.getCompensatingAction(payment.Status)
+
 	// Determine compensating actions based on payment status and reason
-	switch payment.Status {
-	case domain.PaymentStatusCompleted:
+	switch {
+	case cmd.ErrorCode == ErrorCodeRefundTimeout:
+		// A stuck refund is only reported: compensating the payment again
+		// could refund it twice
+		action = "manual_review_required"
+
+	case payment.Status == domain.PaymentStatusPartiallyRefunded,
+		payment.Status == domain.PaymentStatusRefunded:
+		// Refunds already went through the refund ledger: a full refund on top
+		// of them would refund more than was paid
+		action = "manual_review_required"
+
+	case payment.Status == domain.PaymentStatusCompleted:
 		// Payment was completed but there's an inconsistency - initiate full refund
 		err = uc.initiateFullRefund(ctx, payment, cmd.Reason)
 
-	case domain.PaymentStatusProcessing:
+	case payment.Status == domain.PaymentStatusProcessing:
 		// Payment is in processing state - try to cancel first, then refund if needed
 		err = uc.initiateCancellationOrRefund(ctx, payment, cmd.Reason)
 
-	case domain.PaymentStatusFailed:
+	case payment.Status == domain.PaymentStatusFailed:
 		// Payment already failed - check if wallet was debited and needs credit back
 		err = uc.initiateWalletCredit(ctx, payment, cmd.Reason)
 
@@ -84,6 +107,10 @@ func (uc *ProcessPaymentInconsistentOperation) Execute(ctx context.Context, cmd
 		if err == nil {
 			err = uc.paymentRepository.Save(ctx, payment)
 		}
+		if err == nil {
+			err = uc.eventPublisher.Publish(ctx, payment.Events()...)
+			payment.ClearEvents()
+		}
 	}
 
 	if err != nil {
@@ -94,7 +121,7 @@ func (uc *ProcessPaymentInconsistentOperation) Execute(ctx context.Context, cmd
 	completionEvent := events.NewEvent(payment.ID, events.PaymentInconsistentOperationProcessedEvent, PaymentInconsistentOperationProcessedData{
 		PaymentID: payment.ID,
 		Reason:    cmd.Reason,
-		Action:    uc.getCompensatingAction(payment.Status),
+		Action:    action,
 	})
 
 	if err := uc.eventPublisher.Publish(ctx, completionEvent); err != nil {
@@ -109,19 +136,18 @@ func (uc *ProcessPaymentInconsistentOperation) initiateFullRefund(ctx context.Co
 	// Create refund operation based on payment method
 	switch payment.PaymentMethod.PaymentMethodType {
 	case "wallet":
+		if uc.refundSagas != nil {
+			return uc.orchestrateRefund(ctx, payment, reason, "Refund for inconsistent payment "+payment.ID.String())
+		}
+
 		// For wallet payments, initiate wallet credit
-		creditEvent := events.NewEvent(payment.ID, events.WalletCreditRequestedEvent, WalletCreditRequestedData{
-			PaymentID: payment.ID,
-			WalletID:  payment.PaymentMethod.WalletID,
-			UserID:    payment.UserID,
-			Amount:    payment.Amount,
-			Reference: "Refund for inconsistent payment " + payment.ID.String(),
-			Reason:    reason,
-		})
-
-		return uc.eventPublisher.Publish(ctx, creditEvent)
-
-	case "stripe", "external_gateway":
+		return uc.creditWallet(ctx, payment, reason, "Refund for inconsistent payment "+payment.ID.String())
+
+	case domain.PaymentMethodTypeDebit, domain.PaymentMethodTypeCreditCard, "stripe", "external_gateway":
+		if uc.refundSagas != nil {
+			return uc.orchestrateRefund(ctx, payment, reason, "Refund for inconsistent payment "+payment.ID.String())
+		}
+
 		// For external payments, create refund operation
 		refundOperation := domain.NewPaymentOperation(
 			payment.ID,
@@ -168,19 +194,36 @@ func (uc *ProcessPaymentInconsistentOperation) initiateWalletCredit(ctx context.
 		return nil
 	}
 
+	if uc.refundSagas != nil {
+		return uc.orchestrateRefund(ctx, payment, reason, "Credit for failed inconsistent payment "+payment.ID.String())
+	}
+
 	// Credit the wallet back
-	creditEvent := events.NewEvent(payment.ID, events.WalletCreditRequestedEvent, WalletCreditRequestedData{
-		PaymentID: payment.ID,
-		WalletID:  payment.PaymentMethod.WalletID,
-		UserID:    payment.UserID,
-		Amount:    payment.Amount,
-		Reference: "Credit for failed inconsistent payment " + payment.ID.String(),
-		Reason:    reason,
+	return uc.creditWallet(ctx, payment, reason, "Credit for failed inconsistent payment "+payment.ID.String())
+}
+
+// creditWallet requests an income movement crediting the amount of payment
+// back to its wallet
+func (uc *ProcessPaymentInconsistentOperation) creditWallet(ctx context.Context, payment *domain.Payment, reason, reference string) error {
+	creditEvent := events.NewEvent(payment.ID, events.WalletMovementCreationRequestedEvent, WalletMovementCreationRequestedData{
+		WalletID:    payment.PaymentMethod.WalletID,
+		Type:        "income",
+		Amount:      payment.Amount.Amount,
+		Currency:    payment.Amount.Currency,
+		Reference:   reference,
+		PaymentID:   payment.ID.String(),
+		Description: reason,
 	})
 
 	return uc.eventPublisher.Publish(ctx, creditEvent)
 }
 
+// orchestrateRefund starts a refund saga for the full amount of payment
+func (uc *ProcessPaymentInconsistentOperation) orchestrateRefund(ctx context.Context, payment *domain.Payment, reason, reference string) error {
+	data := newRefundSagaData(payment, models.GenerateUUID(), payment.Amount, reason, "", reference)
+	return startRefundSaga(ctx, uc.refundSagas, data)
+}
+
 // getCompensatingAction returns the compensating action taken based on payment status
 func (uc *ProcessPaymentInconsistentOperation) getCompensatingAction(status domain.PaymentStatus) string {
 	switch status {
@@ -210,7 +253,7 @@ func (uc *ProcessPaymentInconsistentOperation) validateCommand(cmd *ProcessPayme
 
 // Event Data Structures
 type PaymentInconsistentOperationStartedData struct {
-	PaymentID     models.ID             `json:"payment_id"`
+	PaymentID     models.ID             `json:"payment_id" validate:"required"`
 	PaymentStatus domain.PaymentStatus  `json:"payment_status"`
 	Reason        string                `json:"reason"`
 	ErrorCode     string                `json:"error_code"`
@@ -218,16 +261,7 @@ type PaymentInconsistentOperationStartedData struct {
 }
 
 type PaymentInconsistentOperationProcessedData struct {
-	PaymentID models.ID `json:"payment_id"`
+	PaymentID models.ID `json:"payment_id" validate:"required"`
 	Reason    string    `json:"reason"`
 	Action    string    `json:"action"`
 }
-
-type WalletCreditRequestedData struct {
-	PaymentID models.ID    `json:"payment_id"`
-	WalletID  string       `json:"wallet_id"`
-	UserID    models.ID    `json:"user_id"`
-	Amount    models.Money `json:"amount"`
-	Reference string       `json:"reference"`
-	Reason    string       `json:"reason"`
-}
\ No newline at end of file
@@ -61,17 +61,19 @@ func (uc *ProcessPaymentMethod) Execute(ctx context.Context, cmd *ProcessPayment
 	// Process based on payment method type
 	switch payment.PaymentMethod.PaymentMethodType {
 	case domain.PaymentMethodTypeWallet:
-		// For wallet payments, request wallet debit
-		debitEvent := events.NewEvent(payment.ID, events.WalletDebitRequestedEvent, WalletDebitRequestedData{
-			PaymentID: payment.ID,
-			WalletID:  payment.PaymentMethod.WalletPaymentMethod.WalletID,
-			UserID:    payment.UserID,
-			Amount:    payment.Amount,
-			Reference: "Payment " + payment.ID.String(),
+		// For wallet payments, request an expense movement debiting the wallet
+		debitEvent := events.NewEvent(payment.ID, events.WalletMovementCreationRequestedEvent, WalletMovementCreationRequestedData{
+			WalletID:    payment.PaymentMethod.WalletPaymentMethod.WalletID,
+			Type:        "expense",
+			Amount:      payment.Amount.Amount,
+			Currency:    payment.Amount.Currency,
+			Reference:   "Payment " + payment.ID.String(),
+			PaymentID:   payment.ID.String(),
+			Description: payment.Description,
 		})
 
 		if err := uc.eventPublisher.Publish(ctx, debitEvent); err != nil {
-			return errors.Wrap(err, "failed to publish wallet debit requested event")
+			return errors.Wrap(err, "failed to publish wallet movement creation requested event")
 		}
 
 	case domain.PaymentMethodTypeCreditCard:
@@ -109,12 +111,3 @@ func (uc *ProcessPaymentMethod) Execute(ctx context.Context, cmd *ProcessPayment
 
 	return nil
 }
-
-// WalletDebitRequestedData represents data for wallet debit request event
-type WalletDebitRequestedData struct {
-	PaymentID models.ID    `json:"payment_id"`
-	WalletID  string       `json:"wallet_id"`
-	UserID    models.ID    `json:"user_id"`
-	Amount    models.Money `json:"amount"`
-	Reference string       `json:"reference"`
-}
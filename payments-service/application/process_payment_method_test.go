@@ -60,9 +60,12 @@ func TestProcessPaymentMethod_Execute(t *testing.T) {
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(walletPayment, nil).Once()
 				repo.EXPECT().Save(mock.Anything, mock.AnythingOfType("*domain.Payment")).Return(nil).Once()
 
-				// Expect wallet debit event
+				// Expect an expense movement debiting the wallet
 				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
-					return evt.EventType == events.WalletDebitRequestedEvent
+					var data WalletMovementCreationRequestedData
+					return evt.EventType == events.WalletMovementCreationRequestedEvent &&
+						evt.UnmarshalPayload(&data) == nil &&
+						data.Type == "expense" && data.PaymentID == validPaymentID.String() && data.Amount == 5000
 				})).Return(nil).Once()
 
 				// Expect payment events (variadic arguments)
@@ -148,7 +151,7 @@ func TestProcessPaymentMethod_Execute(t *testing.T) {
 			expectedError: "failed to save payment",
 		},
 		{
-			name: "wallet debit event publish error",
+			name: "wallet movement event publish error",
 			command: &ProcessPaymentMethodCommand{
 				PaymentID: validPaymentID,
 			},
@@ -170,10 +173,10 @@ func TestProcessPaymentMethod_Execute(t *testing.T) {
 				repo.EXPECT().Save(mock.Anything, mock.AnythingOfType("*domain.Payment")).Return(nil).Once()
 
 				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
-					return evt.EventType == events.WalletDebitRequestedEvent
+					return evt.EventType == events.WalletMovementCreationRequestedEvent
 				})).Return(errors.New("publish error")).Once()
 			},
-			expectedError: "failed to publish wallet debit requested event",
+			expectedError: "failed to publish wallet movement creation requested event",
 		},
 		{
 			name: "payment operation event publish error",
@@ -226,7 +229,7 @@ func TestProcessPaymentMethod_Execute(t *testing.T) {
 
 				// First publish succeeds
 				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
-					return evt.EventType == events.WalletDebitRequestedEvent
+					return evt.EventType == events.WalletMovementCreationRequestedEvent
 				})).Return(nil).Once()
 
 				// Second publish fails
@@ -20,22 +20,29 @@ type ProcessPaymentOperationResultCommand struct {
 	ExternalTransactionID   string                         `json:"external_transaction_id,omitempty"`
 	ErrorCode               string                         `json:"error_code,omitempty"`
 	ErrorMessage            string                         `json:"error_message,omitempty"`
+	// RefundID is the refund the result of a refund operation belongs to
+	RefundID models.ID `json:"refund_id,omitempty"`
 }
 
 // ProcessPaymentOperationResult use case applies payment operations to payments
 type ProcessPaymentOperationResult struct {
-	paymentRepository domain.PaymentRepository
-	eventPublisher    events.Publisher
+	paymentRepository   domain.PaymentRepository
+	eventPublisher      events.Publisher
+	processRefundResult *ProcessRefundResult
 }
 
-// NewProcessPaymentOperationResult creates a new ProcessPaymentOperationResult use case
+// NewProcessPaymentOperationResult creates a new ProcessPaymentOperationResult
+// use case. Refund results are applied to the refund ledger by
+// processRefundResult.
 func NewProcessPaymentOperationResult(
 	paymentRepository domain.PaymentRepository,
 	eventPublisher events.Publisher,
+	processRefundResult *ProcessRefundResult,
 ) *ProcessPaymentOperationResult {
 	return &ProcessPaymentOperationResult{
-		paymentRepository: paymentRepository,
-		eventPublisher:    eventPublisher,
+		paymentRepository:   paymentRepository,
+		eventPublisher:      eventPublisher,
+		processRefundResult: processRefundResult,
 	}
 }
 
@@ -46,6 +53,12 @@ func (uc *ProcessPaymentOperationResult) Execute(ctx context.Context, cmd *Proce
 		return errors.Wrap(err, "invalid command")
 	}
 
+	// Refund results change the refund ledger, which locks the payment
+	// before reading it
+	if cmd.Type == domain.PaymentOperationTypeRefund {
+		return uc.processRefundOperation(ctx, cmd)
+	}
+
 	// Find payment
 	payment, err := uc.paymentRepository.FindByID(ctx, cmd.PaymentID)
 	if err != nil {
@@ -60,8 +73,6 @@ func (uc *ProcessPaymentOperationResult) Execute(ctx context.Context, cmd *Proce
 	switch cmd.Type {
 	case domain.PaymentOperationTypeDebit:
 		err = uc.processDebitOperation(payment, cmd)
-	case domain.PaymentOperationTypeRefund:
-		err = uc.processRefundOperation(payment, cmd)
 	case domain.PaymentOperationTypeReversal:
 		err = uc.processReversalOperation(payment, cmd)
 	default:
@@ -117,30 +128,33 @@ func (uc *ProcessPaymentOperationResult) processDebitOperation(payment *domain.P
 	}
 }
 
-// processRefundOperation processes refund operation results
-func (uc *ProcessPaymentOperationResult) processRefundOperation(payment *domain.Payment, cmd *ProcessPaymentOperationResultCommand) error {
+// processRefundOperation applies refund operation results to their refund.
+// Results without a known refund ID go to the oldest pending refund of the
+// payment for the operation's amount.
+func (uc *ProcessPaymentOperationResult) processRefundOperation(ctx context.Context, cmd *ProcessPaymentOperationResultCommand) error {
+	var status domain.RefundStatus
 	switch cmd.Status {
 	case domain.PaymentOperationStatusCompleted:
-		// Refund successful - mark payment as refunded
-		// First check if payment is in a state that allows refunding
-		if payment.Status != domain.PaymentStatusCompleted {
-			return errors.New("can only refund completed payments")
-		}
-
-		// Create refund completed event - in a real system you might have a separate refund aggregate
-		// For now, we'll just clear events since we don't have access to private recordEvent method
-		payment.ClearEvents()
-
-		return nil
-
-	case domain.PaymentOperationStatusFailed:
-		// Refund failed - log the failure
-		return errors.Errorf("refund failed: %s", cmd.ErrorMessage)
-
+		status = domain.RefundStatusCompleted
+	case domain.PaymentOperationStatusFailed, domain.PaymentOperationStatusCancelled:
+		status = domain.RefundStatusFailed
 	default:
 		// For other statuses, no action needed
 		return nil
 	}
+
+	err := uc.processRefundResult.Execute(ctx, &ProcessRefundResultCommand{
+		RefundID:     cmd.RefundID,
+		PaymentID:    cmd.PaymentID,
+		Amount:       cmd.Amount,
+		Status:       status,
+		ErrorMessage: cmd.ErrorMessage,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to process refund result")
+	}
+
+	return nil
 }
 
 // processReversalOperation processes reversal operation results
@@ -189,6 +203,7 @@ func (uc *ProcessPaymentOperationResult) validateCommand(cmd *ProcessPaymentOper
 // Event Data Structures
 type PaymentRefundCompletedData struct {
 	PaymentID             models.ID    `json:"payment_id"`
+	RefundID              models.ID    `json:"refund_id,omitempty"`
 	RefundAmount          models.Money `json:"refund_amount"`
 	ProviderTransactionID string       `json:"provider_transaction_id"`
 	ExternalTransactionID string       `json:"external_transaction_id"`
@@ -196,13 +211,14 @@ type PaymentRefundCompletedData struct {
 
 type PaymentRefundFailedData struct {
 	PaymentID    models.ID    `json:"payment_id"`
+	RefundID     models.ID    `json:"refund_id,omitempty"`
 	RefundAmount models.Money `json:"refund_amount"`
 	ErrorCode    string       `json:"error_code"`
 	ErrorMessage string       `json:"error_message"`
 }
 
 type PaymentInconsistentStateData struct {
-	PaymentID    models.ID `json:"payment_id"`
+	PaymentID    models.ID `json:"payment_id" validate:"required"`
 	Reason       string    `json:"reason"`
 	ErrorCode    string    `json:"error_code"`
 	ErrorMessage string    `json:"error_message"`
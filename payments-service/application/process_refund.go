@@ -24,6 +24,7 @@ type ProcessRefundCommand struct {
 type ProcessRefund struct {
 	paymentRepository domain.PaymentRepository
 	eventPublisher    events.Publisher
+	refundSagas       RefundSagaStarter
 }
 
 // NewProcessRefund creates a new ProcessRefund use case
@@ -37,6 +38,13 @@ func NewProcessRefund(
 	}
 }
 
+// OrchestrateRefunds runs refunds as orchestrated sagas started through
+// starter instead of publishing their requests directly
+func (uc *ProcessRefund) OrchestrateRefunds(starter RefundSagaStarter) *ProcessRefund {
+	uc.refundSagas = starter
+	return uc
+}
+
 // Execute processes refund based on the payment method type
 func (uc *ProcessRefund) Execute(ctx context.Context, cmd *ProcessRefundCommand) error {
 	// Validate command
@@ -57,29 +65,44 @@ func (uc *ProcessRefund) Execute(ctx context.Context, cmd *ProcessRefundCommand)
 	// Process refund based on payment method
 	switch payment.PaymentMethod.PaymentMethodType {
 	case domain.PaymentMethodTypeWallet:
+		if uc.refundSagas != nil {
+			return uc.orchestrateRefund(ctx, payment, cmd)
+		}
 		return uc.processWalletRefund(ctx, cmd)
 	case domain.PaymentMethodTypeDebit:
+		if uc.refundSagas != nil {
+			return uc.orchestrateRefund(ctx, payment, cmd)
+		}
 		return uc.processExternalRefund(ctx, cmd)
 	default:
 		return errors.Errorf("unsupported payment method for refund: %s", cmd.PaymentMethod.PaymentMethodType)
 	}
 }
 
+// orchestrateRefund starts the refund saga of the refund
+func (uc *ProcessRefund) orchestrateRefund(ctx context.Context, payment *domain.Payment, cmd *ProcessRefundCommand) error {
+	data := newRefundSagaData(payment, cmd.RefundID, cmd.Amount, cmd.Reason, cmd.RequestedBy,
+		"Refund for payment "+cmd.PaymentID.String())
+	return startRefundSaga(ctx, uc.refundSagas, data)
+}
+
 // processWalletRefund processes refund for wallet payments
 func (uc *ProcessRefund) processWalletRefund(ctx context.Context, cmd *ProcessRefundCommand) error {
-	// For wallet refunds, credit the user's wallet
-	creditEvent := events.NewEvent(cmd.PaymentID, events.WalletCreditRequestedEvent, WalletCreditRequestedForRefundData{
-		PaymentID: cmd.PaymentID,
-		RefundID:  cmd.RefundID,
-		WalletID:  cmd.PaymentMethod.WalletPaymentMethod.WalletID,
-		UserID:    cmd.UserID,
-		Amount:    cmd.Amount,
-		Reference: "Refund for payment " + cmd.PaymentID.String(),
-		Reason:    cmd.Reason,
+	// For wallet refunds, ask the wallet service for a credit movement. The
+	// refund ID comes back on wallet.movement.created to complete the refund.
+	creditEvent := events.NewEvent(cmd.PaymentID, events.WalletMovementCreationRequestedEvent, WalletMovementCreationRequestedData{
+		WalletID:    cmd.PaymentMethod.WalletPaymentMethod.WalletID,
+		Type:        "income",
+		Amount:      cmd.Amount.Amount,
+		Currency:    cmd.Amount.Currency,
+		Reference:   "Refund for payment " + cmd.PaymentID.String(),
+		PaymentID:   cmd.PaymentID.String(),
+		Description: cmd.Reason,
+		RefundID:    cmd.RefundID.String(),
 	})
 
 	if err := uc.eventPublisher.Publish(ctx, creditEvent); err != nil {
-		return errors.Wrap(err, "failed to publish wallet credit requested event")
+		return errors.Wrap(err, "failed to publish wallet movement creation requested event")
 	}
 
 	return nil
@@ -154,14 +177,3 @@ func (uc *ProcessRefund) validateCommand(cmd *ProcessRefundCommand) error {
 
 	return nil
 }
-
-// WalletCreditRequestedForRefundData represents data for wallet credit request due to refund
-type WalletCreditRequestedForRefundData struct {
-	PaymentID models.ID    `json:"payment_id"`
-	RefundID  models.ID    `json:"refund_id"`
-	WalletID  string       `json:"wallet_id"`
-	UserID    models.ID    `json:"user_id"`
-	Amount    models.Money `json:"amount"`
-	Reference string       `json:"reference"`
-	Reason    string       `json:"reason"`
-}
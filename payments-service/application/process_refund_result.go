@@ -0,0 +1,184 @@
+package application
+
+import (
+	"context"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+)
+
+// ProcessRefundResultCommand represents the outcome of a refund. The refund
+// is identified by RefundID. Amount only matters for outcomes that do not
+// name a refund of the ledger, such as results of operations requested
+// before refund IDs were carried: they go to the oldest pending refund of
+// PaymentID for Amount.
+type ProcessRefundResultCommand struct {
+	RefundID     models.ID           `json:"refund_id,omitempty"`
+	PaymentID    models.ID           `json:"payment_id,omitempty"`
+	Amount       models.Money        `json:"amount,omitempty"`
+	Status       domain.RefundStatus `json:"status"`
+	ErrorMessage string              `json:"error_message,omitempty"`
+}
+
+// ProcessRefundResult use case applies refund outcomes to the refund ledger.
+// Completed refunds are added to the payment's refunded amount; failed ones
+// release their amount.
+type ProcessRefundResult struct {
+	paymentRepository domain.PaymentRepository
+	refundRepository  domain.RefundRepository
+	eventPublisher    events.Publisher
+}
+
+// NewProcessRefundResult creates a new ProcessRefundResult use case
+func NewProcessRefundResult(
+	paymentRepository domain.PaymentRepository,
+	refundRepository domain.RefundRepository,
+	eventPublisher events.Publisher,
+) *ProcessRefundResult {
+	return &ProcessRefundResult{
+		paymentRepository: paymentRepository,
+		refundRepository:  refundRepository,
+		eventPublisher:    eventPublisher,
+	}
+}
+
+// Execute applies the outcome to its refund. Outcomes of refunds outside the
+// ledger, such as compensations of inconsistent payments, and outcomes
+// already applied are ignored, so each refund is counted once whichever
+// event reports it first.
+func (uc *ProcessRefundResult) Execute(ctx context.Context, cmd *ProcessRefundResultCommand) error {
+	// Validate command
+	if err := uc.validateCommand(cmd); err != nil {
+		return errors.Wrap(err, "invalid command")
+	}
+
+	paymentID := cmd.PaymentID
+	if paymentID == "" {
+		refund, err := uc.refundRepository.FindByID(ctx, cmd.RefundID)
+		if err != nil {
+			return errors.Wrap(err, "failed to find refund")
+		}
+		if refund == nil {
+			return nil
+		}
+		paymentID = refund.PaymentID
+	}
+
+	// Lock the payment's refunds so concurrent outcomes and requests are
+	// applied one after the other
+	if _, err := uc.refundRepository.LockPendingAmount(ctx, paymentID); err != nil {
+		return errors.Wrap(err, "failed to lock refunds")
+	}
+
+	refund, err := uc.findRefund(ctx, cmd, paymentID)
+	if err != nil {
+		return err
+	}
+	if refund == nil || refund.Status != domain.RefundStatusPending {
+		return nil
+	}
+
+	switch cmd.Status {
+	case domain.RefundStatusCompleted:
+		return uc.completeRefund(ctx, refund)
+	default:
+		return uc.failRefund(ctx, refund, cmd.ErrorMessage)
+	}
+}
+
+// findRefund finds the refund of the outcome, once the payment is locked
+func (uc *ProcessRefundResult) findRefund(ctx context.Context, cmd *ProcessRefundResultCommand, paymentID models.ID) (*domain.Refund, error) {
+	if cmd.RefundID != "" {
+		refund, err := uc.refundRepository.FindByID(ctx, cmd.RefundID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find refund")
+		}
+		if refund != nil {
+			if refund.PaymentID != paymentID {
+				return nil, nil
+			}
+			return refund, nil
+		}
+		if !cmd.Amount.IsPositive() {
+			return nil, nil
+		}
+	}
+
+	refund, err := uc.refundRepository.FindPending(ctx, paymentID, cmd.Amount)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find pending refund")
+	}
+	return refund, nil
+}
+
+// completeRefund completes the refund and adds it to its payment
+func (uc *ProcessRefundResult) completeRefund(ctx context.Context, refund *domain.Refund) error {
+	payment, err := uc.paymentRepository.FindByID(ctx, refund.PaymentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to find payment")
+	}
+
+	if payment == nil {
+		return errors.New("payment not found")
+	}
+
+	if err := payment.CompleteRefund(refund); err != nil {
+		return errors.Wrap(err, "failed to complete refund")
+	}
+
+	if err := uc.paymentRepository.Save(ctx, payment); err != nil {
+		return errors.Wrap(err, "failed to save payment")
+	}
+
+	if err := uc.refundRepository.Save(ctx, refund); err != nil {
+		return errors.Wrap(err, "failed to save refund")
+	}
+
+	// Publish payment events
+	if err := uc.eventPublisher.Publish(ctx, payment.Events()...); err != nil {
+		return errors.Wrap(err, "failed to publish payment events")
+	}
+
+	// Clear events
+	payment.ClearEvents()
+
+	return nil
+}
+
+// failRefund fails the refund, releasing its amount
+func (uc *ProcessRefundResult) failRefund(ctx context.Context, refund *domain.Refund, reason string) error {
+	if reason == "" {
+		reason = "Refund failed"
+	}
+
+	if err := refund.Fail(reason); err != nil {
+		return errors.Wrap(err, "failed to fail refund")
+	}
+
+	if err := uc.refundRepository.Save(ctx, refund); err != nil {
+		return errors.Wrap(err, "failed to save refund")
+	}
+
+	return nil
+}
+
+// validateCommand validates the process refund result command
+func (uc *ProcessRefundResult) validateCommand(cmd *ProcessRefundResultCommand) error {
+	if cmd.RefundID.String() == "" {
+		if cmd.PaymentID.String() == "" {
+			return errors.New("refund ID or payment ID is required")
+		}
+
+		if !cmd.Amount.IsPositive() {
+			return errors.New("amount is required without a refund ID")
+		}
+	}
+
+	if cmd.Status != domain.RefundStatusCompleted && cmd.Status != domain.RefundStatusFailed {
+		return errors.Errorf("unsupported refund status: %s", cmd.Status)
+	}
+
+	return nil
+}
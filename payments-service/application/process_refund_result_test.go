@@ -0,0 +1,222 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/payments-service/mocks"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProcessRefundResult_Execute(t *testing.T) {
+	paymentID := models.ID("550e8400-e29b-41d4-a716-446655440020")
+	refundID := models.ID("550e8400-e29b-41d4-a716-446655440040")
+
+	newPayment := func(refunded int64) *domain.Payment {
+		status := domain.PaymentStatusCompleted
+		if refunded > 0 {
+			status = domain.PaymentStatusPartiallyRefunded
+		}
+		return &domain.Payment{
+			ID:             paymentID,
+			UserID:         "550e8400-e29b-41d4-a716-446655440010",
+			Amount:         models.NewMoney(10000, "USD"),
+			RefundedAmount: models.NewMoney(refunded, "USD"),
+			Status:         status,
+			Timestamps:     models.NewTimestamps(),
+		}
+	}
+	newRefund := func(amount int64, status domain.RefundStatus) *domain.Refund {
+		return &domain.Refund{
+			ID:         refundID,
+			PaymentID:  paymentID,
+			Amount:     models.NewMoney(amount, "USD"),
+			Reason:     "Damaged item",
+			Status:     status,
+			Timestamps: models.NewTimestamps(),
+			Version:    models.NewVersion(),
+		}
+	}
+	savedPayment := func(status domain.PaymentStatus, refunded int64) interface{} {
+		return mock.MatchedBy(func(payment *domain.Payment) bool {
+			return payment.Status == status && payment.RefundedAmount.Amount == refunded
+		})
+	}
+	savedRefund := func(status domain.RefundStatus, failureReason string) interface{} {
+		return mock.MatchedBy(func(refund *domain.Refund) bool {
+			return refund.Status == status && refund.FailureReason == failureReason
+		})
+	}
+	published := func(topic events.Topic) interface{} {
+		return mock.MatchedBy(func(event *events.Event) bool {
+			return event.Topic == topic
+		})
+	}
+
+	tests := []struct {
+		name          string
+		command       *ProcessRefundResultCommand
+		setupMocks    func(*mocks.MockPaymentRepository, *mocks.MockRefundRepository, *mocks.MockPublisher)
+		expectedError string
+	}{
+		{
+			name:    "partial refund completed by refund ID",
+			command: &ProcessRefundResultCommand{RefundID: refundID, Status: domain.RefundStatusCompleted},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().FindByID(mock.Anything, refundID).Return(newRefund(2500, domain.RefundStatusPending), nil).Twice()
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(2500, "USD"), nil).Once()
+				payments.EXPECT().FindByID(mock.Anything, paymentID).Return(newPayment(0), nil).Once()
+				payments.EXPECT().Save(mock.Anything, savedPayment(domain.PaymentStatusPartiallyRefunded, 2500)).Return(nil).Once()
+				refunds.EXPECT().Save(mock.Anything, savedRefund(domain.RefundStatusCompleted, "")).Return(nil).Once()
+				publisher.EXPECT().Publish(mock.Anything, published(events.PaymentPartiallyRefundedEvent)).Return(nil).Once()
+			},
+		},
+		{
+			name: "oldest pending refund of the amount completes the payment's refund",
+			command: &ProcessRefundResultCommand{
+				PaymentID: paymentID,
+				Amount:    models.NewMoney(4000, "USD"),
+				Status:    domain.RefundStatusCompleted,
+			},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(4000, "USD"), nil).Once()
+				refunds.EXPECT().FindPending(mock.Anything, paymentID, models.NewMoney(4000, "USD")).
+					Return(newRefund(4000, domain.RefundStatusPending), nil).Once()
+				payments.EXPECT().FindByID(mock.Anything, paymentID).Return(newPayment(6000), nil).Once()
+				payments.EXPECT().Save(mock.Anything, savedPayment(domain.PaymentStatusRefunded, 10000)).Return(nil).Once()
+				refunds.EXPECT().Save(mock.Anything, savedRefund(domain.RefundStatusCompleted, "")).Return(nil).Once()
+				publisher.EXPECT().Publish(mock.Anything, published(events.PaymentRefundedEvent)).Return(nil).Once()
+			},
+		},
+		{
+			name:    "failed refund releases its amount",
+			command: &ProcessRefundResultCommand{RefundID: refundID, PaymentID: paymentID, Status: domain.RefundStatusFailed, ErrorMessage: "card expired"},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(2500, "USD"), nil).Once()
+				refunds.EXPECT().FindByID(mock.Anything, refundID).Return(newRefund(2500, domain.RefundStatusPending), nil).Once()
+				refunds.EXPECT().Save(mock.Anything, savedRefund(domain.RefundStatusFailed, "card expired")).Return(nil).Once()
+			},
+		},
+		{
+			name: "unknown refund ID falls back to the oldest pending refund of the amount",
+			command: &ProcessRefundResultCommand{
+				RefundID:  "550e8400-e29b-41d4-a716-446655440041",
+				PaymentID: paymentID,
+				Amount:    models.NewMoney(2500, "USD"),
+				Status:    domain.RefundStatusCompleted,
+			},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(2500, "USD"), nil).Once()
+				refunds.EXPECT().FindByID(mock.Anything, models.ID("550e8400-e29b-41d4-a716-446655440041")).Return(nil, nil).Once()
+				refunds.EXPECT().FindPending(mock.Anything, paymentID, models.NewMoney(2500, "USD")).
+					Return(newRefund(2500, domain.RefundStatusPending), nil).Once()
+				payments.EXPECT().FindByID(mock.Anything, paymentID).Return(newPayment(0), nil).Once()
+				payments.EXPECT().Save(mock.Anything, savedPayment(domain.PaymentStatusPartiallyRefunded, 2500)).Return(nil).Once()
+				refunds.EXPECT().Save(mock.Anything, savedRefund(domain.RefundStatusCompleted, "")).Return(nil).Once()
+				publisher.EXPECT().Publish(mock.Anything, published(events.PaymentPartiallyRefundedEvent)).Return(nil).Once()
+			},
+		},
+		{
+			name: "refund of another payment is ignored",
+			command: &ProcessRefundResultCommand{
+				RefundID:  refundID,
+				PaymentID: "550e8400-e29b-41d4-a716-446655440021",
+				Amount:    models.NewMoney(2500, "USD"),
+				Status:    domain.RefundStatusCompleted,
+			},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, models.ID("550e8400-e29b-41d4-a716-446655440021")).
+					Return(models.NewMoney(0, "USD"), nil).Once()
+				refunds.EXPECT().FindByID(mock.Anything, refundID).Return(newRefund(2500, domain.RefundStatusPending), nil).Once()
+			},
+		},
+		{
+			name:    "refund outside the ledger is ignored",
+			command: &ProcessRefundResultCommand{RefundID: refundID, Status: domain.RefundStatusCompleted},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().FindByID(mock.Anything, refundID).Return(nil, nil).Once()
+			},
+		},
+		{
+			name: "no pending refund of the amount is ignored",
+			command: &ProcessRefundResultCommand{
+				PaymentID: paymentID,
+				Amount:    models.NewMoney(10000, "USD"),
+				Status:    domain.RefundStatusCompleted,
+			},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(0, "USD"), nil).Once()
+				refunds.EXPECT().FindPending(mock.Anything, paymentID, models.NewMoney(10000, "USD")).Return(nil, nil).Once()
+			},
+		},
+		{
+			name:    "refund already completed is counted once",
+			command: &ProcessRefundResultCommand{RefundID: refundID, PaymentID: paymentID, Status: domain.RefundStatusCompleted},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(0, "USD"), nil).Once()
+				refunds.EXPECT().FindByID(mock.Anything, refundID).Return(newRefund(2500, domain.RefundStatusCompleted), nil).Once()
+			},
+		},
+		{
+			name:    "refund over the payment amount is rejected",
+			command: &ProcessRefundResultCommand{RefundID: refundID, PaymentID: paymentID, Status: domain.RefundStatusCompleted},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(5000, "USD"), nil).Once()
+				refunds.EXPECT().FindByID(mock.Anything, refundID).Return(newRefund(5000, domain.RefundStatusPending), nil).Once()
+				payments.EXPECT().FindByID(mock.Anything, paymentID).Return(newPayment(8000), nil).Once()
+			},
+			expectedError: "failed to complete refund: refunded 13000 of 10000: refund exceeds the refundable amount",
+		},
+		{
+			name:    "payment save error",
+			command: &ProcessRefundResultCommand{RefundID: refundID, PaymentID: paymentID, Status: domain.RefundStatusCompleted},
+			setupMocks: func(payments *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, paymentID).Return(models.NewMoney(2500, "USD"), nil).Once()
+				refunds.EXPECT().FindByID(mock.Anything, refundID).Return(newRefund(2500, domain.RefundStatusPending), nil).Once()
+				payments.EXPECT().FindByID(mock.Anything, paymentID).Return(newPayment(0), nil).Once()
+				payments.EXPECT().Save(mock.Anything, mock.Anything).Return(errors.New("database error")).Once()
+			},
+			expectedError: "failed to save payment: database error",
+		},
+		{
+			name:          "no refund or payment",
+			command:       &ProcessRefundResultCommand{Status: domain.RefundStatusCompleted},
+			setupMocks:    func(*mocks.MockPaymentRepository, *mocks.MockRefundRepository, *mocks.MockPublisher) {},
+			expectedError: "invalid command: refund ID or payment ID is required",
+		},
+		{
+			name:          "payment without amount",
+			command:       &ProcessRefundResultCommand{PaymentID: paymentID, Status: domain.RefundStatusCompleted},
+			setupMocks:    func(*mocks.MockPaymentRepository, *mocks.MockRefundRepository, *mocks.MockPublisher) {},
+			expectedError: "invalid command: amount is required without a refund ID",
+		},
+		{
+			name:          "pending is not an outcome",
+			command:       &ProcessRefundResultCommand{RefundID: refundID, Status: domain.RefundStatusPending},
+			setupMocks:    func(*mocks.MockPaymentRepository, *mocks.MockRefundRepository, *mocks.MockPublisher) {},
+			expectedError: "invalid command: unsupported refund status: pending",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payments := mocks.NewMockPaymentRepository(t)
+			refunds := mocks.NewMockRefundRepository(t)
+			publisher := mocks.NewMockPublisher(t)
+			tt.setupMocks(payments, refunds, publisher)
+
+			err := NewProcessRefundResult(payments, refunds, publisher).Execute(context.Background(), tt.command)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -25,30 +25,43 @@ type RefundPaymentResponse struct {
 	Status    string    `json:"status"`
 }
 
-// RefundPayment use case publishes refund initiation event to begin the refund process
+// RefundPayment use case records a pending refund and publishes refund
+// initiation event to begin the refund process
 type RefundPayment struct {
 	paymentRepository domain.PaymentRepository
+	refundRepository  domain.RefundRepository
 	eventPublisher    events.Publisher
 }
 
 // NewRefundPayment creates a new RefundPayment use case
 func NewRefundPayment(
 	paymentRepository domain.PaymentRepository,
+	refundRepository domain.RefundRepository,
 	eventPublisher events.Publisher,
 ) *RefundPayment {
 	return &RefundPayment{
 		paymentRepository: paymentRepository,
+		refundRepository:  refundRepository,
 		eventPublisher:    eventPublisher,
 	}
 }
 
-// Execute initiates the refund process for a payment
+// Execute initiates the refund process for a payment. It must run in a
+// transaction: the payment's refunds stay locked until it ends, so refunds
+// requested concurrently cannot exceed the refundable amount together.
 func (uc *RefundPayment) Execute(ctx context.Context, cmd *RefundPaymentCommand) (*RefundPaymentResponse, error) {
 	// Validate command
 	if err := uc.validateCommand(cmd); err != nil {
 		return nil, errors.Wrap(err, "invalid command")
 	}
 
+	// Lock the payment's refunds before reading the payment, so its refunded
+	// amount and the pending refunds are read consistently
+	pending, err := uc.refundRepository.LockPendingAmount(ctx, cmd.PaymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to lock refunds")
+	}
+
 	// Find payment
 	payment, err := uc.paymentRepository.FindByID(ctx, cmd.PaymentID)
 	if err != nil {
@@ -60,21 +73,30 @@ func (uc *RefundPayment) Execute(ctx context.Context, cmd *RefundPaymentCommand)
 	}
 
 	// Validate that payment can be refunded
-	if err := uc.validateRefundEligibility(payment, cmd.Amount); err != nil {
+	if err := uc.validateRefundEligibility(payment, cmd.Amount, pending); err != nil {
 		return nil, errors.Wrap(err, "payment not eligible for refund")
 	}
 
 	// Determine refund amount
 	refundAmount := cmd.Amount
 	if refundAmount.Amount == 0 {
-		// Full refund if no amount specified
-		refundAmount = payment.Amount
+		// Refund whatever is left if no amount specified
+		refundAmount = payment.RefundableAmount(pending)
 	}
 
-	// Generate refund ID
-	refundID := models.GenerateUUID()
+	// Record the pending refund
+	refund, err := payment.RequestRefund(models.GenerateUUID(), refundAmount, cmd.Reason, cmd.RequestedBy, pending)
+	if err != nil {
+		return nil, errors.Wrap(err, "payment not eligible for refund")
+	}
+	refundID := refund.ID
+
+	if err := uc.refundRepository.Save(ctx, refund); err != nil {
+		return nil, errors.Wrap(err, "failed to save refund")
+	}
 
-	// Publish refund initiated event - this will trigger the refund saga
+	// Publish refund initiated event - this will trigger the refund saga. It
+	// correlates to the refund ID, which also identifies an orchestrated saga.
 	refundEvent := events.NewEvent(payment.ID, events.PaymentRefundInitiatedEvent, PaymentRefundInitiatedData{
 		PaymentID:   payment.ID,
 		RefundID:    refundID,
@@ -83,7 +105,7 @@ func (uc *RefundPayment) Execute(ctx context.Context, cmd *RefundPaymentCommand)
 		RequestedBy: cmd.RequestedBy,
 		PaymentMethod: payment.PaymentMethod,
 		UserID:      payment.UserID,
-	})
+	}).WithCorrelationID(refundID)
 
 	if err := uc.eventPublisher.Publish(ctx, refundEvent); err != nil {
 		return nil, errors.Wrap(err, "failed to publish refund initiated event")
@@ -93,36 +115,42 @@ func (uc *RefundPayment) Execute(ctx context.Context, cmd *RefundPaymentCommand)
 		PaymentID: payment.ID,
 		RefundID:  refundID,
 		Amount:    refundAmount,
-		Status:    "initiated",
+		Status:    string(refund.Status),
 	}, nil
 }
 
-// validateRefundEligibility checks if a payment can be refunded
-func (uc *RefundPayment) validateRefundEligibility(payment *domain.Payment, refundAmount models.Money) error {
-	// Only completed payments can be refunded
-	if payment.Status != domain.PaymentStatusCompleted {
-		return errors.New("only completed payments can be refunded")
+// validateRefundEligibility checks if a payment can be refunded, given the
+// amount of its pending refunds
+func (uc *RefundPayment) validateRefundEligibility(payment *domain.Payment, refundAmount models.Money, pending models.Money) error {
+	// Only completed payments can be refunded, including partially refunded
+	// ones
+	if payment.Status != domain.PaymentStatusCompleted && payment.Status != domain.PaymentStatusPartiallyRefunded {
+		return domain.ErrPaymentNotRefundable
 	}
 
-	// If partial refund amount is specified, validate it
-	if refundAmount.Amount != 0 {
-		if refundAmount.Amount <= 0 {
-			return errors.New("refund amount must be positive")
-		}
+	// Completed and pending refunds count against the payment amount
+	refundable := payment.RefundableAmount(pending)
 
-		if refundAmount.Currency != payment.Amount.Currency {
-			return errors.New("refund currency must match payment currency")
+	// A full refund needs something left to refund
+	if refundAmount.Amount == 0 {
+		if !refundable.IsPositive() {
+			return errors.Wrap(domain.ErrRefundExceedsRefundable, "payment has nothing left to refund")
 		}
+		return nil
+	}
 
-		if refundAmount.Amount > payment.Amount.Amount {
-			return errors.New("refund amount cannot exceed payment amount")
-		}
+	// If partial refund amount is specified, validate it
+	if refundAmount.Amount <= 0 {
+		return errors.New("refund amount must be positive")
+	}
+
+	if refundAmount.Currency != payment.Amount.Currency {
+		return errors.New("refund currency must match payment currency")
 	}
 
-	// TODO: In a real system, you might also check:
-	// - If the payment has already been partially or fully refunded
-	// - Time-based refund policies
-	// - Merchant/business specific refund rules
+	if refundAmount.Amount > refundable.Amount {
+		return errors.Wrapf(domain.ErrRefundExceedsRefundable, "requested %d, refundable %d", refundAmount.Amount, refundable.Amount)
+	}
 
 	return nil
 }
@@ -153,8 +181,8 @@ func (uc *RefundPayment) validateCommand(cmd *RefundPaymentCommand) error {
 
 // PaymentRefundInitiatedData represents data for payment refund initiated event
 type PaymentRefundInitiatedData struct {
-	PaymentID     models.ID     `json:"payment_id"`
-	RefundID      models.ID     `json:"refund_id"`
+	PaymentID     models.ID     `json:"payment_id" validate:"required"`
+	RefundID      models.ID     `json:"refund_id" validate:"required"`
 	Amount        models.Money  `json:"amount"`
 	Reason        string        `json:"reason"`
 	RequestedBy   models.ID     `json:"requested_by"`
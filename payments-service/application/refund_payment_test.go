@@ -33,11 +33,17 @@ func TestRefundPayment_Execute(t *testing.T) {
 		Status:      domain.PaymentStatusCompleted,
 		Timestamps:  models.NewTimestamps(),
 	}
+	partiallyRefundedPayment := *completedPayment
+	partiallyRefundedPayment.Status = domain.PaymentStatusPartiallyRefunded
+	partiallyRefundedPayment.RefundedAmount = models.NewMoney(4000, "USD")
+	refundedPayment := *completedPayment
+	refundedPayment.Status = domain.PaymentStatusRefunded
+	refundedPayment.RefundedAmount = models.NewMoney(10000, "USD")
 
 	tests := []struct {
 		name           string
 		command        *RefundPaymentCommand
-		setupMocks     func(*mocks.MockPaymentRepository, *mocks.MockPublisher)
+		setupMocks     func(*mocks.MockPaymentRepository, *mocks.MockRefundRepository, *mocks.MockPublisher)
 		expectedError  string
 		validateResult func(*RefundPaymentResponse)
 	}{
@@ -49,8 +55,10 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Customer requested refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
+				refunds.EXPECT().Save(mock.Anything, mock.AnythingOfType("*domain.Refund")).Return(nil).Once()
 				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
 					return evt.EventType == events.PaymentRefundInitiatedEvent
 				})).Return(nil).Once()
@@ -61,7 +69,7 @@ func TestRefundPayment_Execute(t *testing.T) {
 				assert.NotEmpty(t, result.RefundID)
 				assert.Equal(t, int64(10000), result.Amount.Amount)
 				assert.Equal(t, "USD", result.Amount.Currency)
-				assert.Equal(t, "initiated", result.Status)
+				assert.Equal(t, "pending", result.Status)
 			},
 		},
 		{
@@ -72,8 +80,10 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Partial refund requested",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
+				refunds.EXPECT().Save(mock.Anything, mock.AnythingOfType("*domain.Refund")).Return(nil).Once()
 				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
 					return evt.EventType == events.PaymentRefundInitiatedEvent
 				})).Return(nil).Once()
@@ -84,9 +94,103 @@ func TestRefundPayment_Execute(t *testing.T) {
 				assert.NotEmpty(t, result.RefundID)
 				assert.Equal(t, int64(5000), result.Amount.Amount)
 				assert.Equal(t, "USD", result.Amount.Currency)
-				assert.Equal(t, "initiated", result.Status)
+				assert.Equal(t, "pending", result.Status)
 			},
 		},
+		{
+			name: "full refund of a partially refunded payment refunds what is left",
+			command: &RefundPaymentCommand{
+				PaymentID:   validPaymentID,
+				Amount:      models.Money{},
+				Reason:      "Customer requested refund",
+				RequestedBy: validRequestedBy,
+			},
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.NewMoney(1000, "USD"), nil).Once()
+				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(&partiallyRefundedPayment, nil).Once()
+				refunds.EXPECT().Save(mock.Anything, mock.MatchedBy(func(refund *domain.Refund) bool {
+					return refund.PaymentID == validPaymentID && refund.Amount.Amount == 5000 && refund.Status == domain.RefundStatusPending
+				})).Return(nil).Once()
+				publisher.EXPECT().Publish(mock.Anything, mock.MatchedBy(func(evt *events.Event) bool {
+					return evt.EventType == events.PaymentRefundInitiatedEvent
+				})).Return(nil).Once()
+			},
+			validateResult: func(result *RefundPaymentResponse) {
+				assert.Equal(t, int64(5000), result.Amount.Amount)
+				assert.Equal(t, "pending", result.Status)
+			},
+		},
+		{
+			name: "pending refunds count against the refundable amount",
+			command: &RefundPaymentCommand{
+				PaymentID:   validPaymentID,
+				Amount:      models.NewMoney(5500, "USD"),
+				Reason:      "Test refund",
+				RequestedBy: validRequestedBy,
+			},
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.NewMoney(1000, "USD"), nil).Once()
+				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(&partiallyRefundedPayment, nil).Once()
+			},
+			expectedError: "requested 5500, refundable 5000: refund exceeds the refundable amount",
+		},
+		{
+			name: "refunded payment cannot be refunded again",
+			command: &RefundPaymentCommand{
+				PaymentID:   validPaymentID,
+				Amount:      models.Money{},
+				Reason:      "Test refund",
+				RequestedBy: validRequestedBy,
+			},
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
+				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(&refundedPayment, nil).Once()
+			},
+			expectedError: "only completed payments can be refunded",
+		},
+		{
+			name: "completed payment with every refund pending",
+			command: &RefundPaymentCommand{
+				PaymentID:   validPaymentID,
+				Amount:      models.Money{},
+				Reason:      "Test refund",
+				RequestedBy: validRequestedBy,
+			},
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.NewMoney(10000, "USD"), nil).Once()
+				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
+			},
+			expectedError: "payment has nothing left to refund",
+		},
+		{
+			name: "lock error",
+			command: &RefundPaymentCommand{
+				PaymentID:   validPaymentID,
+				Amount:      models.Money{},
+				Reason:      "Test refund",
+				RequestedBy: validRequestedBy,
+			},
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).
+					Return(models.Money{}, errors.New("database error")).Once()
+			},
+			expectedError: "failed to lock refunds",
+		},
+		{
+			name: "refund save error",
+			command: &RefundPaymentCommand{
+				PaymentID:   validPaymentID,
+				Amount:      models.Money{},
+				Reason:      "Test refund",
+				RequestedBy: validRequestedBy,
+			},
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
+				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
+				refunds.EXPECT().Save(mock.Anything, mock.Anything).Return(errors.New("database error")).Once()
+			},
+			expectedError: "failed to save refund",
+		},
 		{
 			name: "empty payment ID",
 			command: &RefundPaymentCommand{
@@ -95,7 +199,7 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				// No expectations - should fail validation
 			},
 			expectedError: "payment ID is required",
@@ -108,7 +212,7 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				// No expectations - should fail validation
 			},
 			expectedError: "reason is required",
@@ -121,7 +225,7 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: models.ID(""),
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				// No expectations - should fail validation
 			},
 			expectedError: "requested by user ID is required",
@@ -134,7 +238,8 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(nil, nil).Once()
 			},
 			expectedError: "payment not found",
@@ -147,7 +252,8 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).
 					Return(nil, errors.New("database error")).Once()
 			},
@@ -161,13 +267,14 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				incompletePayment := &domain.Payment{
 					ID:     validPaymentID,
 					UserID: validUserID,
 					Amount: models.NewMoney(10000, "USD"),
 					Status: domain.PaymentStatusProcessing, // Not completed
 				}
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(incompletePayment, nil).Once()
 			},
 			expectedError: "only completed payments can be refunded",
@@ -180,10 +287,11 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
 			},
-			expectedError: "refund amount cannot exceed payment amount",
+			expectedError: "refund exceeds the refundable amount",
 		},
 		{
 			name: "refund currency mismatch",
@@ -193,7 +301,8 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
 			},
 			expectedError: "refund currency must match payment currency",
@@ -206,7 +315,8 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
 			},
 			expectedError: "refund amount must be positive",
@@ -219,8 +329,10 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(completedPayment, nil).Once()
+				refunds.EXPECT().Save(mock.Anything, mock.AnythingOfType("*domain.Refund")).Return(nil).Once()
 				publisher.EXPECT().Publish(mock.Anything, mock.Anything).
 					Return(errors.New("publisher error")).Once()
 			},
@@ -234,7 +346,7 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				// No expectations - should fail validation
 			},
 			expectedError: "currency is required when amount is specified",
@@ -247,7 +359,7 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Product defective",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				cardPayment := &domain.Payment{
 					ID:     validPaymentID,
 					UserID: validUserID,
@@ -260,7 +372,9 @@ func TestRefundPayment_Execute(t *testing.T) {
 					},
 					Status: domain.PaymentStatusCompleted,
 				}
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(cardPayment, nil).Once()
+				refunds.EXPECT().Save(mock.Anything, mock.AnythingOfType("*domain.Refund")).Return(nil).Once()
 				publisher.EXPECT().Publish(mock.Anything, mock.Anything).Return(nil).Once()
 			},
 			expectedError: "",
@@ -269,7 +383,7 @@ func TestRefundPayment_Execute(t *testing.T) {
 				assert.NotEmpty(t, result.RefundID)
 				assert.Equal(t, int64(7500), result.Amount.Amount)
 				assert.Equal(t, "USD", result.Amount.Currency)
-				assert.Equal(t, "initiated", result.Status)
+				assert.Equal(t, "pending", result.Status)
 			},
 		},
 		{
@@ -280,13 +394,14 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				failedPayment := &domain.Payment{
 					ID:     validPaymentID,
 					UserID: validUserID,
 					Amount: models.NewMoney(10000, "USD"),
 					Status: domain.PaymentStatusFailed, // Failed status
 				}
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(failedPayment, nil).Once()
 			},
 			expectedError: "only completed payments can be refunded",
@@ -299,13 +414,14 @@ func TestRefundPayment_Execute(t *testing.T) {
 				Reason:      "Test refund",
 				RequestedBy: validRequestedBy,
 			},
-			setupMocks: func(repo *mocks.MockPaymentRepository, publisher *mocks.MockPublisher) {
+			setupMocks: func(repo *mocks.MockPaymentRepository, refunds *mocks.MockRefundRepository, publisher *mocks.MockPublisher) {
 				cancelledPayment := &domain.Payment{
 					ID:     validPaymentID,
 					UserID: validUserID,
 					Amount: models.NewMoney(10000, "USD"),
 					Status: domain.PaymentStatusCancelled, // Cancelled status
 				}
+				refunds.EXPECT().LockPendingAmount(mock.Anything, validPaymentID).Return(models.Money{}, nil).Once()
 				repo.EXPECT().FindByID(mock.Anything, validPaymentID).Return(cancelledPayment, nil).Once()
 			},
 			expectedError: "only completed payments can be refunded",
@@ -316,12 +432,13 @@ func TestRefundPayment_Execute(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup mocks
 			mockRepo := mocks.NewMockPaymentRepository(t)
+			mockRefunds := mocks.NewMockRefundRepository(t)
 			mockPublisher := mocks.NewMockPublisher(t)
 
-			tt.setupMocks(mockRepo, mockPublisher)
+			tt.setupMocks(mockRepo, mockRefunds, mockPublisher)
 
 			// Create use case
-			useCase := NewRefundPayment(mockRepo, mockPublisher)
+			useCase := NewRefundPayment(mockRepo, mockRefunds, mockPublisher)
 
 			// Execute
 			result, err := useCase.Execute(context.Background(), tt.command)
@@ -440,6 +557,7 @@ func TestRefundPayment_validateRefundEligibility(t *testing.T) {
 		name          string
 		payment       *domain.Payment
 		refundAmount  models.Money
+		pending       models.Money
 		expectedError string
 	}{
 		{
@@ -467,7 +585,44 @@ func TestRefundPayment_validateRefundEligibility(t *testing.T) {
 			name:          "refund amount exceeds payment",
 			payment:       completedPayment,
 			refundAmount:  models.NewMoney(15000, "USD"),
-			expectedError: "refund amount cannot exceed payment amount",
+			expectedError: "refund exceeds the refundable amount",
+		},
+		{
+			name: "valid refund of a partially refunded payment",
+			payment: &domain.Payment{
+				Amount:         models.NewMoney(10000, "USD"),
+				RefundedAmount: models.NewMoney(4000, "USD"),
+				Status:         domain.PaymentStatusPartiallyRefunded,
+			},
+			refundAmount:  models.NewMoney(6000, "USD"),
+			expectedError: "",
+		},
+		{
+			name: "refund exceeds what is left after refunds",
+			payment: &domain.Payment{
+				Amount:         models.NewMoney(10000, "USD"),
+				RefundedAmount: models.NewMoney(4000, "USD"),
+				Status:         domain.PaymentStatusPartiallyRefunded,
+			},
+			refundAmount:  models.NewMoney(6001, "USD"),
+			expectedError: "refund exceeds the refundable amount",
+		},
+		{
+			name:          "refund exceeds what is left after pending refunds",
+			payment:       completedPayment,
+			refundAmount:  models.NewMoney(5000, "USD"),
+			pending:       models.NewMoney(6000, "USD"),
+			expectedError: "refund exceeds the refundable amount",
+		},
+		{
+			name: "refunded payment",
+			payment: &domain.Payment{
+				Amount:         models.NewMoney(10000, "USD"),
+				RefundedAmount: models.NewMoney(10000, "USD"),
+				Status:         domain.PaymentStatusRefunded,
+			},
+			refundAmount:  models.Money{},
+			expectedError: "only completed payments can be refunded",
 		},
 		{
 			name:          "currency mismatch",
@@ -503,7 +658,7 @@ func TestRefundPayment_validateRefundEligibility(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := useCase.validateRefundEligibility(tt.payment, tt.refundAmount)
+			err := useCase.validateRefundEligibility(tt.payment, tt.refundAmount, tt.pending)
 
 			if tt.expectedError != "" {
 				assert.Error(t, err)
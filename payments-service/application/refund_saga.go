@@ -0,0 +1,357 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/pkg/errors"
+)
+
+// RefundSagaStarter starts orchestrated sagas; saga.Orchestrator implements it
+type RefundSagaStarter interface {
+	Start(ctx context.Context, sagaType saga.SagaType, id, aggregateID models.ID, data interface{}) (*saga.OrchestratedSaga, error)
+}
+
+// RefundSagaPolicy sets how long the orchestrated refund saga awaits each
+// participant
+type RefundSagaPolicy struct {
+	WalletTimeout   time.Duration
+	ProviderTimeout time.Duration
+}
+
+// RefundSagaData is the state of an orchestrated refund saga
+type RefundSagaData struct {
+	PaymentID         models.ID                `json:"payment_id"`
+	RefundID          models.ID                `json:"refund_id"`
+	Amount            models.Money             `json:"amount"`
+	Reason            string                   `json:"reason"`
+	RequestedBy       models.ID                `json:"requested_by"`
+	UserID            models.ID                `json:"user_id"`
+	PaymentMethodType domain.PaymentMethodType `json:"payment_method_type"`
+	WalletID          string                   `json:"wallet_id,omitempty"`
+	Reference         string                   `json:"reference"`
+	// MovementID is the wallet movement crediting the refund, once created
+	MovementID models.ID `json:"movement_id,omitempty"`
+	// Transaction IDs of the provider refund, once completed
+	ProviderTransactionID string `json:"provider_transaction_id,omitempty"`
+	ExternalTransactionID string `json:"external_transaction_id,omitempty"`
+}
+
+// newRefundSagaData returns the saga data refunding amount of payment
+func newRefundSagaData(payment *domain.Payment, refundID models.ID, amount models.Money, reason string, requestedBy models.ID, reference string) RefundSagaData {
+	data := RefundSagaData{
+		PaymentID:         payment.ID,
+		RefundID:          refundID,
+		Amount:            amount,
+		Reason:            reason,
+		RequestedBy:       requestedBy,
+		UserID:            payment.UserID,
+		PaymentMethodType: payment.PaymentMethod.PaymentMethodType,
+		Reference:         reference,
+	}
+	if payment.PaymentMethod.WalletPaymentMethod != nil {
+		data.WalletID = payment.PaymentMethod.WalletID
+	}
+	return data
+}
+
+// startRefundSaga starts the refund saga of data, identified by its refund
+// ID. A saga already started for the refund by a redelivered event is left
+// as is.
+func startRefundSaga(ctx context.Context, starter RefundSagaStarter, data RefundSagaData) error {
+	_, err := starter.Start(ctx, saga.SagaTypeRefund, data.RefundID, data.PaymentID, data)
+	if err != nil && !errors.Is(err, saga.ErrSagaExists) {
+		return errors.Wrap(err, "failed to start refund saga")
+	}
+	return nil
+}
+
+// RefundOrchestration declares the orchestrated refund saga. Wallet payments
+// are refunded with a credit movement, reverted should a later step fail;
+// other payments with a provider refund operation, whose result arrives as an
+// operation of the payment rather than in the saga's causal chain and is
+// matched by the refund ID it carries. The wallet service replies to credits
+// only when they are made, so a credit cannot be failed by a reply.
+//
+// A step whose reply is overdue is raised for manual review with
+// payment.inconsistent.state and the refund left pending: the credit or
+// provider refund may still go through, and failing the refund would release
+// its amount to be refunded again. A late reply completes the saga. The saga
+// ends with payment.refund.completed or payment.refund.failed.
+func RefundOrchestration(policy RefundSagaPolicy) *saga.Orchestration {
+	return &saga.Orchestration{
+		Type: saga.SagaTypeRefund,
+		Steps: []*saga.OrchestratedStep{
+			{
+				Name:      "credit wallet",
+				Command:   creditWalletCommand,
+				Success:   []events.Topic{events.WalletMovementCreatedEvent},
+				OnReply:   recordWalletCredit,
+				Timeout:   policy.WalletTimeout,
+				OnTimeout: raiseRefundTimeout("credit wallet"),
+				Compensation: &saga.OrchestratedStep{
+					Name:    "revert wallet credit",
+					Command: revertWalletCreditCommand,
+					Success: []events.Topic{events.WalletMovementRevertedEvent},
+					Timeout: policy.WalletTimeout,
+				},
+			},
+			{
+				Name:      "provider refund",
+				Command:   providerRefundCommand,
+				Success:   []events.Topic{events.PaymentOperationCompletedEvent},
+				Failure:   []events.Topic{events.PaymentOperationFailedEvent},
+				ReplyKey:  providerRefundReplyKey,
+				OnReply:   recordProviderRefund,
+				Timeout:   policy.ProviderTimeout,
+				OnTimeout: raiseRefundTimeout("provider refund"),
+			},
+		},
+		Finish: finishRefundSaga,
+	}
+}
+
+// creditWalletCommand asks the wallet service to credit the refund to the
+// wallet of a wallet payment
+func creditWalletCommand(s *saga.OrchestratedSaga) (*saga.Command, error) {
+	var data RefundSagaData
+	if err := s.Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.PaymentMethodType != domain.PaymentMethodTypeWallet {
+		return nil, nil
+	}
+
+	return &saga.Command{
+		Event: events.NewEvent(data.PaymentID, events.WalletMovementCreationRequestedEvent, WalletMovementCreationRequestedData{
+			WalletID:    data.WalletID,
+			Type:        "income",
+			Amount:      data.Amount.Amount,
+			Currency:    data.Amount.Currency,
+			Reference:   data.Reference,
+			PaymentID:   data.PaymentID.String(),
+			Description: data.Reason,
+			RefundID:    data.RefundID.String(),
+		}),
+	}, nil
+}
+
+// recordWalletCredit keeps the movement crediting the refund
+func recordWalletCredit(s *saga.OrchestratedSaga, reply *events.Event) error {
+	var created WalletMovementCreatedData
+	if err := reply.UnmarshalPayload(&created); err != nil {
+		return errors.Wrap(err, "failed to decode wallet movement")
+	}
+
+	var data RefundSagaData
+	if err := s.Decode(&data); err != nil {
+		return err
+	}
+	data.MovementID = created.TransactionID
+	return s.Encode(data)
+}
+
+// revertWalletCreditCommand asks the wallet service to revert the movement
+// crediting the refund
+func revertWalletCreditCommand(s *saga.OrchestratedSaga) (*saga.Command, error) {
+	var data RefundSagaData
+	if err := s.Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.MovementID == "" {
+		return nil, nil
+	}
+
+	requestedBy := data.RequestedBy.String()
+	if requestedBy == "" {
+		// Refunds of inconsistent payments are requested by the service
+		requestedBy = "payments-service"
+	}
+
+	return &saga.Command{
+		Event: events.NewEvent(data.PaymentID, events.WalletMovementRevertRequestedEvent, WalletMovementRevertRequestedData{
+			MovementID:  data.MovementID.String(),
+			Reason:      "Refund " + data.RefundID.String() + " failed: " + s.FailureReason,
+			RequestedBy: requestedBy,
+		}),
+	}, nil
+}
+
+// raiseRefundTimeout returns the OnTimeout of the step, raising the refund
+// for manual review
+func raiseRefundTimeout(step string) func(s *saga.OrchestratedSaga) (*events.Event, error) {
+	return func(s *saga.OrchestratedSaga) (*events.Event, error) {
+		var data RefundSagaData
+		if err := s.Decode(&data); err != nil {
+			return nil, err
+		}
+
+		return events.NewEvent(data.PaymentID, events.PaymentInconsistentStateEvent, PaymentInconsistentStateData{
+			PaymentID:    data.PaymentID,
+			Reason:       fmt.Sprintf("refund saga stuck in %s", step),
+			ErrorCode:    ErrorCodeRefundTimeout,
+			ErrorMessage: fmt.Sprintf("no reply to %s of refund %s in time, the refund is left pending", step, data.RefundID),
+		}), nil
+	}
+}
+
+// providerRefundCommand creates the refund operation of a payment charged
+// through a provider
+func providerRefundCommand(s *saga.OrchestratedSaga) (*saga.Command, error) {
+	var data RefundSagaData
+	if err := s.Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.PaymentMethodType == domain.PaymentMethodTypeWallet {
+		return nil, nil
+	}
+
+	operation := domain.NewPaymentOperation(
+		data.PaymentID,
+		domain.PaymentOperationTypeRefund,
+		data.Amount,
+		data.PaymentMethodType.String(),
+	)
+	operation.Metadata["refund_id"] = data.RefundID.String()
+	operation.Metadata["refund_reason"] = data.Reason
+	operation.Metadata["requested_by"] = data.RequestedBy.String()
+
+	created := operation.Events()
+	if len(created) == 0 {
+		return nil, errors.New("refund operation recorded no events")
+	}
+
+	return &saga.Command{
+		Event:    created[0],
+		ReplyKey: providerRefundKey(data.RefundID),
+	}, nil
+}
+
+// refundOperationResult is the part of payment operation events the
+// provider refund step reads
+type refundOperationResult struct {
+	PaymentID             models.ID                   `json:"payment_id"`
+	Type                  domain.PaymentOperationType `json:"type"`
+	ProviderTransactionID string                      `json:"provider_transaction_id"`
+	ExternalTransactionID string                      `json:"external_transaction_id"`
+	Metadata              map[string]interface{}      `json:"metadata"`
+}
+
+// providerRefundKey is the reply key of the provider refund of a refund, so
+// concurrent refunds of a payment each take their own result
+func providerRefundKey(refundID models.ID) string {
+	return "provider-refund:" + refundID.String()
+}
+
+// providerRefundReplyKey returns the reply key of refund operation results:
+// the refund ID their metadata carries or, for results in the refund's
+// causal chain, their correlation ID
+func providerRefundReplyKey(reply *events.Event) (string, bool) {
+	var result refundOperationResult
+	if err := reply.UnmarshalPayload(&result); err != nil {
+		return "", false
+	}
+	if result.Type != domain.PaymentOperationTypeRefund {
+		return "", false
+	}
+	if refundID, ok := result.Metadata["refund_id"].(string); ok && refundID != "" {
+		return providerRefundKey(models.ID(refundID)), true
+	}
+	if reply.CorrelationID != "" {
+		return providerRefundKey(reply.CorrelationID), true
+	}
+	return "", false
+}
+
+// recordProviderRefund keeps the transaction IDs of the provider refund
+func recordProviderRefund(s *saga.OrchestratedSaga, reply *events.Event) error {
+	var result refundOperationResult
+	if err := reply.UnmarshalPayload(&result); err != nil {
+		return errors.Wrap(err, "failed to decode refund operation")
+	}
+
+	var data RefundSagaData
+	if err := s.Decode(&data); err != nil {
+		return err
+	}
+	data.ProviderTransactionID = result.ProviderTransactionID
+	data.ExternalTransactionID = result.ExternalTransactionID
+	return s.Encode(data)
+}
+
+// finishRefundSaga announces the refund's outcome
+func finishRefundSaga(s *saga.OrchestratedSaga) (*events.Event, error) {
+	var data RefundSagaData
+	if err := s.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	if s.Status == saga.SagaStatusCompleted {
+		return events.NewEvent(data.PaymentID, events.PaymentRefundCompletedEvent, PaymentRefundCompletedData{
+			PaymentID:             data.PaymentID,
+			RefundID:              data.RefundID,
+			RefundAmount:          data.Amount,
+			ProviderTransactionID: data.ProviderTransactionID,
+			ExternalTransactionID: data.ExternalTransactionID,
+		}), nil
+	}
+
+	return events.NewEvent(data.PaymentID, events.PaymentRefundFailedEvent, PaymentRefundFailedData{
+		PaymentID:    data.PaymentID,
+		RefundID:     data.RefundID,
+		RefundAmount: data.Amount,
+		ErrorCode:    "refund_failed",
+		ErrorMessage: s.FailureReason,
+	}), nil
+}
+
+// WalletMovementCreationRequestedData represents the command asking the
+// wallet service to create a movement
+type WalletMovementCreationRequestedData struct {
+	WalletID    string `json:"wallet_id" validate:"required"`
+	Type        string `json:"type" validate:"required"`
+	Amount      int64  `json:"amount" validate:"required"`
+	Currency    string `json:"currency" validate:"required,len=3"`
+	Reference   string `json:"reference" validate:"required"`
+	PaymentID   string `json:"payment_id,omitempty"`
+	Description string `json:"description,omitempty"`
+	// RefundID is echoed on wallet.movement.created for credits paying out
+	// a refund
+	RefundID string `json:"refund_id,omitempty"`
+}
+
+// WalletMovementCreatedData represents the wallet service's reply to a
+// movement creation
+type WalletMovementCreatedData struct {
+	WalletID      models.ID    `json:"wallet_id" validate:"required"`
+	TransactionID models.ID    `json:"transaction_id" validate:"required"`
+	Type          string       `json:"type"`
+	Amount        models.Money `json:"amount"`
+	Reference     string       `json:"reference"`
+	PaymentID     *models.ID   `json:"payment_id,omitempty"`
+	RefundID      models.ID    `json:"refund_id,omitempty"`
+}
+
+// WalletMovementRevertRequestedData represents the command asking the wallet
+// service to revert a movement
+type WalletMovementRevertRequestedData struct {
+	MovementID  string `json:"movement_id" validate:"required"`
+	Reason      string `json:"reason" validate:"required"`
+	RequestedBy string `json:"requested_by" validate:"required"`
+}
+
+// WalletMovementRevertedData represents the wallet service's reply to a
+// movement revert
+type WalletMovementRevertedData struct {
+	WalletID              models.ID    `json:"wallet_id" validate:"required"`
+	OriginalTransactionID models.ID    `json:"original_transaction_id" validate:"required"`
+	ReversalTransactionID models.ID    `json:"reversal_transaction_id" validate:"required"`
+	Amount                models.Money `json:"amount"`
+	Reason                string       `json:"reason"`
+	PaymentID             *models.ID   `json:"payment_id,omitempty"`
+}
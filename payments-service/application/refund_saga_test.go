@@ -0,0 +1,310 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/payments-service/mocks"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRefundSagaStarter records the refund sagas it was asked to start
+type fakeRefundSagaStarter struct {
+	started []RefundSagaData
+	err     error
+}
+
+func (f *fakeRefundSagaStarter) Start(ctx context.Context, sagaType saga.SagaType, id, aggregateID models.ID, data interface{}) (*saga.OrchestratedSaga, error) {
+	f.started = append(f.started, data.(RefundSagaData))
+	return &saga.OrchestratedSaga{ID: id, Type: sagaType, AggregateID: aggregateID}, f.err
+}
+
+func refundSaga(t *testing.T, data RefundSagaData) *saga.OrchestratedSaga {
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err)
+	return &saga.OrchestratedSaga{ID: data.RefundID, Type: saga.SagaTypeRefund, Data: encoded}
+}
+
+func TestProcessRefund_OrchestrateRefunds(t *testing.T) {
+	paymentID := models.ID("550e8400-e29b-41d4-a716-446655440020")
+	walletPayment := &domain.Payment{
+		ID:     paymentID,
+		UserID: "user-1",
+		Amount: models.NewMoney(10000, "USD"),
+		PaymentMethod: domain.PaymentMethod{
+			PaymentMethodType:   domain.PaymentMethodTypeWallet,
+			WalletPaymentMethod: &domain.WalletPaymentMethod{WalletID: "wallet-1"},
+		},
+		Status: domain.PaymentStatusCompleted,
+	}
+	command := &ProcessRefundCommand{
+		PaymentID:     paymentID,
+		RefundID:      "refund-1",
+		Amount:        models.NewMoney(2500, "USD"),
+		Reason:        "Damaged item",
+		RequestedBy:   "admin-1",
+		PaymentMethod: walletPayment.PaymentMethod,
+		UserID:        "user-1",
+	}
+
+	tests := []struct {
+		name          string
+		startErr      error
+		expectedError string
+	}{
+		{
+			name: "starts the refund saga",
+		},
+		{
+			name:     "refund saga already started",
+			startErr: saga.ErrSagaExists,
+		},
+		{
+			name:          "start fails",
+			startErr:      errors.New("database down"),
+			expectedError: "failed to start refund saga: database down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewMockPaymentRepository(t)
+			publisher := mocks.NewMockPublisher(t)
+			starter := &fakeRefundSagaStarter{err: tt.startErr}
+			repo.EXPECT().FindByID(context.Background(), paymentID).Return(walletPayment, nil).Once()
+
+			uc := NewProcessRefund(repo, publisher).OrchestrateRefunds(starter)
+			err := uc.Execute(context.Background(), command)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			require.Len(t, starter.started, 1)
+			assert.Equal(t, RefundSagaData{
+				PaymentID:         paymentID,
+				RefundID:          "refund-1",
+				Amount:            models.NewMoney(2500, "USD"),
+				Reason:            "Damaged item",
+				RequestedBy:       "admin-1",
+				UserID:            "user-1",
+				PaymentMethodType: domain.PaymentMethodTypeWallet,
+				WalletID:          "wallet-1",
+				Reference:         "Refund for payment " + paymentID.String(),
+			}, starter.started[0])
+		})
+	}
+}
+
+func TestRefundOrchestration_Commands(t *testing.T) {
+	walletRefund := RefundSagaData{
+		PaymentID:         "payment-1",
+		RefundID:          "refund-1",
+		Amount:            models.NewMoney(2500, "USD"),
+		Reason:            "Damaged item",
+		PaymentMethodType: domain.PaymentMethodTypeWallet,
+		WalletID:          "wallet-1",
+		Reference:         "Refund for payment payment-1",
+	}
+	debitRefund := walletRefund
+	debitRefund.PaymentMethodType = domain.PaymentMethodTypeDebit
+	debitRefund.WalletID = ""
+
+	orchestration := RefundOrchestration(RefundSagaPolicy{})
+	creditWallet, providerRefund := orchestration.Steps[0], orchestration.Steps[1]
+
+	t.Run("wallet payments are credited", func(t *testing.T) {
+		s := refundSaga(t, walletRefund)
+
+		command, err := creditWallet.Command(s)
+		require.NoError(t, err)
+		require.NotNil(t, command)
+		assert.Equal(t, events.WalletMovementCreationRequestedEvent, command.Event.EventType)
+		assert.Empty(t, command.ReplyKey)
+
+		var requested WalletMovementCreationRequestedData
+		require.NoError(t, command.Event.UnmarshalPayload(&requested))
+		assert.Equal(t, WalletMovementCreationRequestedData{
+			WalletID:    "wallet-1",
+			Type:        "income",
+			Amount:      2500,
+			Currency:    "USD",
+			Reference:   "Refund for payment payment-1",
+			PaymentID:   "payment-1",
+			Description: "Damaged item",
+			RefundID:    "refund-1",
+		}, requested)
+
+		command, err = providerRefund.Command(s)
+		require.NoError(t, err)
+		assert.Nil(t, command)
+	})
+
+	t.Run("other payments are refunded by the provider", func(t *testing.T) {
+		s := refundSaga(t, debitRefund)
+
+		command, err := creditWallet.Command(s)
+		require.NoError(t, err)
+		assert.Nil(t, command)
+
+		command, err = providerRefund.Command(s)
+		require.NoError(t, err)
+		require.NotNil(t, command)
+		assert.Equal(t, events.PaymentOperationCreatedEvent, command.Event.EventType)
+		assert.Equal(t, "provider-refund:refund-1", command.ReplyKey)
+
+		var created domain.PaymentOperationCreatedData
+		require.NoError(t, command.Event.UnmarshalPayload(&created))
+		assert.Equal(t, "refund-1", created.Metadata["refund_id"])
+	})
+
+	t.Run("wallet credits are reverted", func(t *testing.T) {
+		s := refundSaga(t, walletRefund)
+		s.FailureReason = "provider down"
+
+		command, err := creditWallet.Compensation.Command(s)
+		require.NoError(t, err)
+		assert.Nil(t, command, "nothing to revert before the credit is made")
+
+		credited := walletRefund
+		credited.MovementID = "movement-1"
+		s = refundSaga(t, credited)
+		s.FailureReason = "provider down"
+
+		command, err = creditWallet.Compensation.Command(s)
+		require.NoError(t, err)
+		require.NotNil(t, command)
+		assert.Equal(t, events.WalletMovementRevertRequestedEvent, command.Event.EventType)
+
+		var requested WalletMovementRevertRequestedData
+		require.NoError(t, command.Event.UnmarshalPayload(&requested))
+		assert.Equal(t, WalletMovementRevertRequestedData{
+			MovementID:  "movement-1",
+			Reason:      "Refund refund-1 failed: provider down",
+			RequestedBy: "payments-service",
+		}, requested)
+	})
+}
+
+func TestRefundOrchestration_Timeouts(t *testing.T) {
+	orchestration := RefundOrchestration(RefundSagaPolicy{})
+	s := refundSaga(t, RefundSagaData{PaymentID: "payment-1", RefundID: "refund-1"})
+
+	for _, step := range orchestration.Steps {
+		t.Run(step.Name, func(t *testing.T) {
+			require.NotNil(t, step.OnTimeout, "a timed out refund step must not fail the refund")
+
+			event, err := step.OnTimeout(s)
+			require.NoError(t, err)
+			assert.Equal(t, events.PaymentInconsistentStateEvent, event.EventType)
+
+			var raised PaymentInconsistentStateData
+			require.NoError(t, event.UnmarshalPayload(&raised))
+			assert.Equal(t, models.ID("payment-1"), raised.PaymentID)
+			assert.Equal(t, ErrorCodeRefundTimeout, raised.ErrorCode)
+			assert.Equal(t, "refund saga stuck in "+step.Name, raised.Reason)
+		})
+	}
+}
+
+func TestRefundOrchestration_Replies(t *testing.T) {
+	orchestration := RefundOrchestration(RefundSagaPolicy{})
+	creditWallet, providerRefund := orchestration.Steps[0], orchestration.Steps[1]
+	s := refundSaga(t, RefundSagaData{PaymentID: "payment-1", RefundID: "refund-1"})
+
+	created := events.NewEvent("wallet-1", events.WalletMovementCreatedEvent, WalletMovementCreatedData{
+		WalletID:      "wallet-1",
+		TransactionID: "movement-1",
+	})
+	require.NoError(t, creditWallet.OnReply(s, created))
+
+	refunded := events.NewEvent("payment-1", events.PaymentOperationCompletedEvent, map[string]interface{}{
+		"payment_id":              "payment-1",
+		"type":                    "refund",
+		"provider_transaction_id": "provider-1",
+		"external_transaction_id": "external-1",
+		"metadata":                map[string]interface{}{"refund_id": "refund-1"},
+	})
+	key, ok := providerRefund.ReplyKey(refunded)
+	assert.True(t, ok)
+	assert.Equal(t, "provider-refund:refund-1", key)
+	require.NoError(t, providerRefund.OnReply(s, refunded))
+
+	var data RefundSagaData
+	require.NoError(t, s.Decode(&data))
+	assert.Equal(t, models.ID("movement-1"), data.MovementID)
+	assert.Equal(t, "provider-1", data.ProviderTransactionID)
+	assert.Equal(t, "external-1", data.ExternalTransactionID)
+
+	correlated := events.NewEvent("payment-1", events.PaymentOperationFailedEvent, map[string]interface{}{
+		"payment_id": "payment-1",
+		"type":       "refund",
+	}).WithCorrelationID("refund-2")
+	key, ok = providerRefund.ReplyKey(correlated)
+	assert.True(t, ok)
+	assert.Equal(t, "provider-refund:refund-2", key)
+
+	unattributed := events.NewEvent("payment-1", events.PaymentOperationCompletedEvent, map[string]interface{}{
+		"payment_id": "payment-1",
+		"type":       "refund",
+	})
+	_, ok = providerRefund.ReplyKey(unattributed)
+	assert.False(t, ok)
+
+	charged := events.NewEvent("payment-1", events.PaymentOperationCompletedEvent, map[string]interface{}{
+		"payment_id": "payment-1",
+		"type":       "charge",
+		"metadata":   map[string]interface{}{"refund_id": "refund-1"},
+	})
+	_, ok = providerRefund.ReplyKey(charged)
+	assert.False(t, ok)
+}
+
+func TestRefundOrchestration_Finish(t *testing.T) {
+	data := RefundSagaData{
+		PaymentID:             "payment-1",
+		RefundID:              "refund-1",
+		Amount:                models.NewMoney(2500, "USD"),
+		ProviderTransactionID: "provider-1",
+	}
+	orchestration := RefundOrchestration(RefundSagaPolicy{})
+
+	t.Run("completed", func(t *testing.T) {
+		s := refundSaga(t, data)
+		s.Status = saga.SagaStatusCompleted
+
+		event, err := orchestration.Finish(s)
+		require.NoError(t, err)
+		assert.Equal(t, events.PaymentRefundCompletedEvent, event.EventType)
+
+		var completed PaymentRefundCompletedData
+		require.NoError(t, event.UnmarshalPayload(&completed))
+		assert.Equal(t, models.ID("refund-1"), completed.RefundID)
+		assert.Equal(t, int64(2500), completed.RefundAmount.Amount)
+		assert.Equal(t, "provider-1", completed.ProviderTransactionID)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		s := refundSaga(t, data)
+		s.Status = saga.SagaStatusFailed
+		s.FailureReason = `step "credit wallet" timed out after 2m0s`
+
+		event, err := orchestration.Finish(s)
+		require.NoError(t, err)
+		assert.Equal(t, events.PaymentRefundFailedEvent, event.EventType)
+
+		var failed PaymentRefundFailedData
+		require.NoError(t, event.UnmarshalPayload(&failed))
+		assert.Equal(t, models.ID("refund-1"), failed.RefundID)
+		assert.Equal(t, "refund_failed", failed.ErrorCode)
+		assert.Equal(t, s.FailureReason, failed.ErrorMessage)
+	})
+}
@@ -18,6 +18,10 @@ const (
 	PaymentStatusCompleted  PaymentStatus = "completed"
 	PaymentStatusFailed     PaymentStatus = "failed"
 	PaymentStatusCancelled  PaymentStatus = "cancelled"
+	// A completed payment is partially refunded until refunds of its whole
+	// amount complete
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
 )
 
 // Payment aggregate root
@@ -28,8 +32,10 @@ type Payment struct {
 	PaymentMethod PaymentMethod
 	Description   string
 	Status        PaymentStatus
-	Timestamps    models.Timestamps
-	Version       models.Version
+	// RefundedAmount is the cumulative amount of completed refunds
+	RefundedAmount models.Money
+	Timestamps     models.Timestamps
+	Version        models.Version
 
 	events []*events.Event
 }
@@ -41,14 +47,15 @@ func CreatePayment(userID models.ID, amount models.Money, paymentMethod PaymentM
 	}
 
 	payment := &Payment{
-		ID:            models.GenerateUUID(),
-		UserID:        userID,
-		Amount:        amount,
-		PaymentMethod: paymentMethod,
-		Description:   description,
-		Status:        PaymentStatusInitiated,
-		Timestamps:    models.NewTimestamps(),
-		Version:       models.NewVersion(),
+		ID:             models.GenerateUUID(),
+		UserID:         userID,
+		Amount:         amount,
+		PaymentMethod:  paymentMethod,
+		Description:    description,
+		Status:         PaymentStatusInitiated,
+		RefundedAmount: models.NewMoney(0, amount.Currency),
+		Timestamps:     models.NewTimestamps(),
+		Version:        models.NewVersion(),
 	}
 
 	// Record domain event
@@ -108,7 +115,7 @@ func (p *Payment) Complete(gatewayTransactionID, transactionID string) error {
 
 // Fail marks payment as failed
 func (p *Payment) Fail(reason string, errorCode string) error {
-	if p.Status == PaymentStatusCompleted {
+	if p.settled() {
 		return errors.New("cannot fail a completed payment")
 	}
 
@@ -131,7 +138,7 @@ func (p *Payment) Fail(reason string, errorCode string) error {
 
 // Cancel marks payment as cancelled
 func (p *Payment) Cancel() error {
-	if p.Status == PaymentStatusCompleted {
+	if p.settled() {
 		return errors.New("cannot cancel a completed payment")
 	}
 
@@ -149,6 +156,100 @@ func (p *Payment) Cancel() error {
 	return nil
 }
 
+// RefundableAmount returns what is left to refund of the payment once its
+// completed refunds and the pending amount are deducted
+func (p *Payment) RefundableAmount(pending models.Money) models.Money {
+	refundable := p.Amount.Amount - p.RefundedAmount.Amount - pending.Amount
+	if refundable < 0 {
+		refundable = 0
+	}
+	return models.NewMoney(refundable, p.Amount.Currency)
+}
+
+// RequestRefund creates a pending refund of amount, given the amount of the
+// payment's pending refunds. Refunds of more than the refundable amount are
+// rejected with ErrRefundExceedsRefundable.
+func (p *Payment) RequestRefund(refundID models.ID, amount models.Money, reason string, requestedBy models.ID, pending models.Money) (*Refund, error) {
+	if p.Status != PaymentStatusCompleted && p.Status != PaymentStatusPartiallyRefunded {
+		return nil, ErrPaymentNotRefundable
+	}
+
+	if !amount.IsPositive() {
+		return nil, errors.New("refund amount must be positive")
+	}
+
+	if amount.Currency != p.Amount.Currency {
+		return nil, errors.New("refund currency must match payment currency")
+	}
+
+	refundable := p.RefundableAmount(pending)
+	if amount.Amount > refundable.Amount {
+		return nil, errors.Wrapf(ErrRefundExceedsRefundable, "requested %d, refundable %d", amount.Amount, refundable.Amount)
+	}
+
+	return &Refund{
+		ID:          refundID,
+		PaymentID:   p.ID,
+		Amount:      amount,
+		Reason:      reason,
+		RequestedBy: requestedBy,
+		Status:      RefundStatusPending,
+		Timestamps:  models.NewTimestamps(),
+		Version:     models.NewVersion(),
+	}, nil
+}
+
+// CompleteRefund completes a pending refund of the payment and adds its
+// amount to the refunded amount. The payment is refunded once its whole
+// amount is, and partially refunded until then.
+func (p *Payment) CompleteRefund(refund *Refund) error {
+	if refund.PaymentID != p.ID {
+		return errors.Errorf("refund %s is not of payment %s", refund.ID, p.ID)
+	}
+
+	refunded := models.NewMoney(p.RefundedAmount.Amount+refund.Amount.Amount, p.Amount.Currency)
+	if refunded.Amount > p.Amount.Amount {
+		return errors.Wrapf(ErrRefundExceedsRefundable, "refunded %d of %d", refunded.Amount, p.Amount.Amount)
+	}
+
+	if err := refund.Complete(); err != nil {
+		return err
+	}
+
+	p.RefundedAmount = refunded
+	p.Status = PaymentStatusPartiallyRefunded
+	topic := events.PaymentPartiallyRefundedEvent
+	if refunded.Amount == p.Amount.Amount {
+		p.Status = PaymentStatusRefunded
+		topic = events.PaymentRefundedEvent
+	}
+	p.Timestamps = p.Timestamps.Update()
+	p.Version = p.Version.Update()
+
+	event := events.NewEvent(p.ID, topic, PaymentRefundedData{
+		PaymentID:      p.ID,
+		UserID:         p.UserID,
+		RefundID:       refund.ID,
+		RefundAmount:   refund.Amount,
+		RefundedAmount: p.RefundedAmount,
+		RefundedAt:     time.Now(),
+	})
+
+	p.recordEvent(event)
+	return nil
+}
+
+// settled reports whether the payment was completed, whether or not it has
+// been refunded since
+func (p *Payment) settled() bool {
+	switch p.Status {
+	case PaymentStatusCompleted, PaymentStatusPartiallyRefunded, PaymentStatusRefunded:
+		return true
+	default:
+		return false
+	}
+}
+
 // Events returns domain events
 func (p *Payment) Events() []*events.Event {
 	return p.events
@@ -166,21 +267,21 @@ func (p *Payment) recordEvent(event *events.Event) {
 
 // Event Data Structures
 type PaymentInitiatedData struct {
-	PaymentID     models.ID     `json:"payment_id"`
-	UserID        models.ID     `json:"user_id"`
+	PaymentID     models.ID     `json:"payment_id" validate:"required"`
+	UserID        models.ID     `json:"user_id" validate:"required"`
 	Amount        models.Money  `json:"amount"`
 	PaymentMethod PaymentMethod `json:"payment_method"`
 	Description   string        `json:"description"`
 }
 
 type PaymentProcessingData struct {
-	PaymentID models.ID `json:"payment_id"`
+	PaymentID models.ID `json:"payment_id" validate:"required"`
 	UserID    models.ID `json:"user_id"`
 }
 
 type PaymentCompletedData struct {
-	PaymentID            models.ID    `json:"payment_id"`
-	UserID               models.ID    `json:"user_id"`
+	PaymentID            models.ID    `json:"payment_id" validate:"required"`
+	UserID               models.ID    `json:"user_id" validate:"required"`
 	Amount               models.Money `json:"amount"`
 	GatewayTransactionID string       `json:"gateway_transaction_id"`
 	TransactionID        string       `json:"transaction_id"`
@@ -188,8 +289,8 @@ type PaymentCompletedData struct {
 }
 
 type PaymentFailedData struct {
-	PaymentID models.ID    `json:"payment_id"`
-	UserID    models.ID    `json:"user_id"`
+	PaymentID models.ID    `json:"payment_id" validate:"required"`
+	UserID    models.ID    `json:"user_id" validate:"required"`
 	Amount    models.Money `json:"amount"`
 	Reason    string       `json:"reason"`
 	ErrorCode string       `json:"error_code"`
@@ -197,11 +298,22 @@ type PaymentFailedData struct {
 }
 
 type PaymentCancelledData struct {
-	PaymentID   models.ID `json:"payment_id"`
+	PaymentID   models.ID `json:"payment_id" validate:"required"`
 	UserID      models.ID `json:"user_id"`
 	CancelledAt time.Time `json:"cancelled_at"`
 }
 
+// PaymentRefundedData is the payload of payment.partially_refunded and
+// payment.refunded
+type PaymentRefundedData struct {
+	PaymentID      models.ID    `json:"payment_id" validate:"required"`
+	UserID         models.ID    `json:"user_id"`
+	RefundID       models.ID    `json:"refund_id" validate:"required"`
+	RefundAmount   models.Money `json:"refund_amount"`
+	RefundedAmount models.Money `json:"refunded_amount"`
+	RefundedAt     time.Time    `json:"refunded_at"`
+}
+
 // PaymentRepository interface
 type PaymentRepository interface {
 	Save(ctx context.Context, payment *Payment) error
@@ -72,6 +72,7 @@ func NewPaymentOperation(
 		Type:        operation.Type,
 		Amount:      operation.Amount,
 		Provider:    operation.Provider,
+		Metadata:    operation.Metadata,
 	})
 
 	operation.recordEvent(event)
@@ -107,6 +108,7 @@ func (po *PaymentOperation) Complete(providerTransactionID, externalTransactionI
 		Amount:                  po.Amount,
 		ProviderTransactionID:   po.ProviderTransactionID,
 		ExternalTransactionID:   po.ExternalTransactionID,
+		Metadata:                po.Metadata,
 		CompletedAt:             time.Now(),
 	})
 
@@ -128,6 +130,7 @@ func (po *PaymentOperation) Fail(errorCode, errorMessage string) {
 		Amount:       po.Amount,
 		ErrorCode:    po.ErrorCode,
 		ErrorMessage: po.ErrorMessage,
+		Metadata:     po.Metadata,
 		FailedAt:     time.Now(),
 	})
 
@@ -151,34 +154,39 @@ func (po *PaymentOperation) recordEvent(event *events.Event) {
 
 // Event Data Structures
 type PaymentOperationCreatedData struct {
-	OperationID models.ID                `json:"operation_id"`
-	PaymentID   models.ID                `json:"payment_id"`
+	OperationID models.ID                `json:"operation_id" validate:"required"`
+	PaymentID   models.ID                `json:"payment_id" validate:"required"`
 	Type        PaymentOperationType     `json:"type"`
 	Amount      models.Money             `json:"amount"`
 	Provider    string                   `json:"provider"`
+	// Metadata shares the operation's map, so entries added before the
+	// event is published, such as the refund ID, are carried
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
 type PaymentOperationProcessingData struct {
-	OperationID models.ID `json:"operation_id"`
-	PaymentID   models.ID `json:"payment_id"`
+	OperationID models.ID `json:"operation_id" validate:"required"`
+	PaymentID   models.ID `json:"payment_id" validate:"required"`
 }
 
 type PaymentOperationCompletedData struct {
-	OperationID             models.ID                `json:"operation_id"`
-	PaymentID               models.ID                `json:"payment_id"`
+	OperationID             models.ID                `json:"operation_id" validate:"required"`
+	PaymentID               models.ID                `json:"payment_id" validate:"required"`
 	Type                    PaymentOperationType     `json:"type"`
 	Amount                  models.Money             `json:"amount"`
 	ProviderTransactionID   string                   `json:"provider_transaction_id"`
 	ExternalTransactionID   string                   `json:"external_transaction_id"`
+	Metadata                map[string]interface{}   `json:"metadata,omitempty"`
 	CompletedAt             time.Time                `json:"completed_at"`
 }
 
 type PaymentOperationFailedData struct {
-	OperationID  models.ID                `json:"operation_id"`
-	PaymentID    models.ID                `json:"payment_id"`
+	OperationID  models.ID                `json:"operation_id" validate:"required"`
+	PaymentID    models.ID                `json:"payment_id" validate:"required"`
 	Type         PaymentOperationType     `json:"type"`
 	Amount       models.Money             `json:"amount"`
 	ErrorCode    string                   `json:"error_code"`
 	ErrorMessage string                   `json:"error_message"`
+	Metadata     map[string]interface{}   `json:"metadata,omitempty"`
 	FailedAt     time.Time                `json:"failed_at"`
 }
\ No newline at end of file
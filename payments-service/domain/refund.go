@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+)
+
+// RefundStatus represents the status of a refund
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusCompleted RefundStatus = "completed"
+	RefundStatusFailed    RefundStatus = "failed"
+)
+
+var (
+	// ErrPaymentNotRefundable is returned for refunds of payments that were
+	// not completed
+	ErrPaymentNotRefundable = errors.New("only completed payments can be refunded")
+	// ErrRefundExceedsRefundable is returned for refunds of more than the
+	// payment has left to refund
+	ErrRefundExceedsRefundable = errors.New("refund exceeds the refundable amount")
+	// ErrRefundNotPending is returned when completing or failing a refund
+	// that already has an outcome
+	ErrRefundNotPending = errors.New("refund is not pending")
+)
+
+// Refund is a refund of part or all of a payment. Pending refunds count
+// against the payment's refundable amount until they fail.
+type Refund struct {
+	ID            models.ID
+	PaymentID     models.ID
+	Amount        models.Money
+	Reason        string
+	RequestedBy   models.ID
+	Status        RefundStatus
+	FailureReason string
+	Timestamps    models.Timestamps
+	Version       models.Version
+}
+
+// Complete marks the refund as completed
+func (r *Refund) Complete() error {
+	if r.Status != RefundStatusPending {
+		return errors.Wrapf(ErrRefundNotPending, "refund %s is %s", r.ID, r.Status)
+	}
+
+	r.Status = RefundStatusCompleted
+	r.Timestamps = r.Timestamps.Update()
+	r.Version = r.Version.Update()
+	return nil
+}
+
+// Fail marks the refund as failed, which releases its amount
+func (r *Refund) Fail(reason string) error {
+	if r.Status != RefundStatusPending {
+		return errors.Wrapf(ErrRefundNotPending, "refund %s is %s", r.ID, r.Status)
+	}
+
+	r.Status = RefundStatusFailed
+	r.FailureReason = reason
+	r.Timestamps = r.Timestamps.Update()
+	r.Version = r.Version.Update()
+	return nil
+}
+
+// RefundRepository interface
+type RefundRepository interface {
+	// LockPendingAmount locks the refunds of a payment until the transaction
+	// in ctx ends and returns the amount of its pending refunds, so
+	// concurrent requests and outcomes see each other's changes
+	LockPendingAmount(ctx context.Context, paymentID models.ID) (models.Money, error)
+	Save(ctx context.Context, refund *Refund) error
+	FindByID(ctx context.Context, id models.ID) (*Refund, error)
+	// FindPending returns the oldest pending refund of a payment for amount
+	FindPending(ctx context.Context, paymentID models.ID, amount models.Money) (*Refund, error)
+	FindByPaymentID(ctx context.Context, paymentID models.ID) ([]*Refund, error)
+}
@@ -2,12 +2,14 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"log"
+
 	"github.com/draftea/payment-system/payments-service/application"
 	"github.com/draftea/payment-system/payments-service/domain"
 	"github.com/draftea/payment-system/shared/events"
 	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
 	"github.com/pkg/errors"
 )
 
@@ -21,31 +23,20 @@ type PaymentEventHandlers struct {
 	processPaymentInconsistentOp   *application.ProcessPaymentInconsistentOperation
 	refundPayment                  *application.RefundPayment
 	processRefund                  *application.ProcessRefund
+	processRefundResult            *application.ProcessRefundResult
+	registry                       *events.Registry
+	router                         *events.Router
 }
 
 // Handle implements the events.EventHandler interface
 func (h *PaymentEventHandlers) Handle(ctx context.Context, event *events.Event) error {
-	switch event.EventType {
-	case events.PaymentCreatedEvent:
-		return h.HandlePaymentInitiated(ctx, event)
-	case events.WalletDebitedEvent:
-		return h.HandleWalletDebited(ctx, event)
-	case events.InsufficientFundsEvent:
-		return h.HandleInsufficientFunds(ctx, event)
-	case events.ExternalProviderUpdateEvent:
-		return h.HandleExternalProviderUpdate(ctx, event)
-	case events.PaymentOperationCompletedEvent:
-		return h.HandlePaymentOperationCompleted(ctx, event)
-	case events.PaymentOperationFailedEvent:
-		return h.HandlePaymentOperationFailed(ctx, event)
-	case events.PaymentInconsistentStateEvent:
-		return h.HandlePaymentInconsistentState(ctx, event)
-	case events.PaymentRefundInitiatedEvent:
-		return h.HandlePaymentRefundInitiated(ctx, event)
-	default:
-		// Unknown event type, ignore
-		return nil
-	}
+	return h.router.Handle(ctx, event)
+}
+
+// ConsumedTopics implements the events.TopicConsumer interface with the
+// topics routed in the constructor
+func (h *PaymentEventHandlers) ConsumedTopics() []events.Topic {
+	return h.router.Topics()
 }
 
 // HandlerID returns the unique identifier for this event handler
@@ -63,8 +54,10 @@ func NewPaymentEventHandlers(
 	processPaymentInconsistentOp *application.ProcessPaymentInconsistentOperation,
 	refundPayment *application.RefundPayment,
 	processRefund *application.ProcessRefund,
+	processRefundResult *application.ProcessRefundResult,
+	registry *events.Registry,
 ) *PaymentEventHandlers {
-	return &PaymentEventHandlers{
+	h := &PaymentEventHandlers{
 		processPaymentMethod:           processPaymentMethod,
 		processWalletDebit:             processWalletDebit,
 		handleExternalWebhooks:         handleExternalWebhooks,
@@ -73,19 +66,49 @@ func NewPaymentEventHandlers(
 		processPaymentInconsistentOp:   processPaymentInconsistentOp,
 		refundPayment:                  refundPayment,
 		processRefund:                  processRefund,
+		processRefundResult:            processRefundResult,
+		registry:                       registry,
+	}
+
+	h.router = events.NewRouter(h.HandlerID()).
+		RegisterFunc(events.PaymentCreatedEvent, h.HandlePaymentInitiated).
+		RegisterFunc(events.WalletDebitedEvent, h.HandleWalletDebited).
+		RegisterFunc(events.InsufficientFundsEvent, h.HandleInsufficientFunds).
+		RegisterFunc(events.ExternalProviderUpdateEvent, h.HandleExternalProviderUpdate).
+		RegisterFunc(events.PaymentOperationCompletedEvent, h.HandlePaymentOperationCompleted).
+		RegisterFunc(events.PaymentOperationFailedEvent, h.HandlePaymentOperationFailed).
+		RegisterFunc(events.PaymentInconsistentStateEvent, h.HandlePaymentInconsistentState).
+		RegisterFunc(events.PaymentRefundInitiatedEvent, h.HandlePaymentRefundInitiated).
+		RegisterFunc(events.WalletMovementCreatedEvent, h.HandleWalletMovementCreated).
+		RegisterFunc(events.PaymentRefundCompletedEvent, h.HandlePaymentRefundCompleted).
+		RegisterFunc(events.PaymentRefundFailedEvent, h.HandlePaymentRefundFailed)
+
+	return h
+}
+
+// TrackSagas also routes every event of the tracked sagas to tracker, after
+// the use case handlers, so they are consumed and recorded in the saga log
+func (h *PaymentEventHandlers) TrackSagas(tracker *saga.Tracker) *PaymentEventHandlers {
+	for _, topic := range tracker.Topics() {
+		h.router.Register(topic, tracker)
 	}
+	return h
 }
 
-// HandlePaymentInitiated handles payment initiated events
-func (h *PaymentEventHandlers) HandlePaymentInitiated(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.PaymentCreatedEvent {
-		return nil
+// Orchestrate also routes the replies awaited by orchestrator's sagas to it,
+// after the use case handlers
+func (h *PaymentEventHandlers) Orchestrate(orchestrator *saga.Orchestrator) *PaymentEventHandlers {
+	for _, topic := range orchestrator.Topics() {
+		h.router.Register(topic, orchestrator)
 	}
+	return h
+}
 
-	// Extract payment ID from event
-	var data PaymentInitiatedData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse payment initiated data")
+// HandlePaymentInitiated handles payment initiated events
+func (h *PaymentEventHandlers) HandlePaymentInitiated(ctx context.Context, event *events.Event) error {
+	data, err := events.DecodePayload[PaymentInitiatedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode payment initiated data")
 	}
 
 	// Process payment method
@@ -94,7 +117,7 @@ func (h *PaymentEventHandlers) HandlePaymentInitiated(ctx context.Context, event
 	}
 
 	if err := h.processPaymentMethod.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process payment method for payment %s: %v\n", data.PaymentID, err)
+		log.Printf("failed to process payment method for payment %s: %v", data.PaymentID, err)
 		return nil // Don't return error to avoid retries - inconsistent operation handler will catch this
 	}
 
@@ -103,13 +126,9 @@ func (h *PaymentEventHandlers) HandlePaymentInitiated(ctx context.Context, event
 
 // HandleWalletDebited handles wallet debited events from wallet service
 func (h *PaymentEventHandlers) HandleWalletDebited(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.WalletDebitedEvent {
-		return nil
-	}
-
-	var data WalletDebitedData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse wallet debited data")
+	data, err := events.DecodePayload[WalletDebitedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode wallet debited data")
 	}
 
 	// Process wallet debit result
@@ -122,7 +141,7 @@ func (h *PaymentEventHandlers) HandleWalletDebited(ctx context.Context, event *e
 	}
 
 	if err := h.processWalletDebit.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process wallet debit for payment %s: %v\n", data.PaymentID, err)
+		log.Printf("failed to process wallet debit for payment %s: %v", data.PaymentID, err)
 		return nil
 	}
 
@@ -131,13 +150,9 @@ func (h *PaymentEventHandlers) HandleWalletDebited(ctx context.Context, event *e
 
 // HandleInsufficientFunds handles insufficient funds events from wallet service
 func (h *PaymentEventHandlers) HandleInsufficientFunds(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.InsufficientFundsEvent {
-		return nil
-	}
-
-	var data InsufficientFundsData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse insufficient funds data")
+	data, err := events.DecodePayload[InsufficientFundsData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode insufficient funds data")
 	}
 
 	// Process wallet debit failure
@@ -151,7 +166,7 @@ func (h *PaymentEventHandlers) HandleInsufficientFunds(ctx context.Context, even
 	}
 
 	if err := h.processWalletDebit.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process wallet debit failure for payment %s: %v\n", data.PaymentID, err)
+		log.Printf("failed to process wallet debit failure for payment %s: %v", data.PaymentID, err)
 		return nil
 	}
 
@@ -160,13 +175,9 @@ func (h *PaymentEventHandlers) HandleInsufficientFunds(ctx context.Context, even
 
 // HandleExternalProviderUpdate handles external provider update events
 func (h *PaymentEventHandlers) HandleExternalProviderUpdate(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.ExternalProviderUpdateEvent {
-		return nil
-	}
-
-	var data application.ExternalProviderUpdateData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse external provider update data")
+	data, err := events.DecodePayload[application.ExternalProviderUpdateData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode external provider update data")
 	}
 
 	// Process external provider update
@@ -184,7 +195,7 @@ func (h *PaymentEventHandlers) HandleExternalProviderUpdate(ctx context.Context,
 	}
 
 	if err := h.processExternalProviderUpdates.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process external provider update: %v\n", err)
+		log.Printf("failed to process external provider update: %v", err)
 		return nil
 	}
 
@@ -193,13 +204,9 @@ func (h *PaymentEventHandlers) HandleExternalProviderUpdate(ctx context.Context,
 
 // HandlePaymentOperationCompleted handles payment operation completed events
 func (h *PaymentEventHandlers) HandlePaymentOperationCompleted(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.PaymentOperationCompletedEvent {
-		return nil
-	}
-
-	var data PaymentOperationCompletedData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse payment operation completed data")
+	data, err := events.DecodePayload[PaymentOperationCompletedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode payment operation completed data")
 	}
 
 	// Process payment operation result
@@ -211,10 +218,11 @@ func (h *PaymentEventHandlers) HandlePaymentOperationCompleted(ctx context.Conte
 		Amount:                data.Amount,
 		ProviderTransactionID: data.ProviderTransactionID,
 		ExternalTransactionID: data.ExternalTransactionID,
+		RefundID:              operationRefundID(data.Type, data.Metadata, event),
 	}
 
 	if err := h.processPaymentOperationResult.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process payment operation result for payment %s: %v\n", data.PaymentID, err)
+		log.Printf("failed to process payment operation result for payment %s: %v", data.PaymentID, err)
 		return nil
 	}
 
@@ -223,13 +231,9 @@ func (h *PaymentEventHandlers) HandlePaymentOperationCompleted(ctx context.Conte
 
 // HandlePaymentOperationFailed handles payment operation failed events
 func (h *PaymentEventHandlers) HandlePaymentOperationFailed(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.PaymentOperationFailedEvent {
-		return nil
-	}
-
-	var data PaymentOperationFailedData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse payment operation failed data")
+	data, err := events.DecodePayload[PaymentOperationFailedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode payment operation failed data")
 	}
 
 	// Process payment operation result
@@ -241,25 +245,35 @@ func (h *PaymentEventHandlers) HandlePaymentOperationFailed(ctx context.Context,
 		Amount:       data.Amount,
 		ErrorCode:    data.ErrorCode,
 		ErrorMessage: data.ErrorMessage,
+		RefundID:     operationRefundID(data.Type, data.Metadata, event),
 	}
 
 	if err := h.processPaymentOperationResult.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process payment operation failure for payment %s: %v\n", data.PaymentID, err)
+		log.Printf("failed to process payment operation failure for payment %s: %v", data.PaymentID, err)
 		return nil
 	}
 
 	return nil
 }
 
-// HandlePaymentInconsistentState handles payment inconsistent state events
-func (h *PaymentEventHandlers) HandlePaymentInconsistentState(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.PaymentInconsistentStateEvent {
-		return nil
+// operationRefundID returns the refund a refund operation's result belongs
+// to: the refund ID its metadata carries, or else its correlation ID, which
+// is the refund ID when the result descends from the refund's request
+func operationRefundID(operationType domain.PaymentOperationType, metadata map[string]interface{}, event *events.Event) models.ID {
+	if operationType != domain.PaymentOperationTypeRefund {
+		return ""
+	}
+	if refundID, ok := metadata["refund_id"].(string); ok && refundID != "" {
+		return models.ID(refundID)
 	}
+	return models.ID(event.CorrelationID)
+}
 
-	var data application.PaymentInconsistentStateData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse payment inconsistent state data")
+// HandlePaymentInconsistentState handles payment inconsistent state events
+func (h *PaymentEventHandlers) HandlePaymentInconsistentState(ctx context.Context, event *events.Event) error {
+	data, err := events.DecodePayload[application.PaymentInconsistentStateData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode payment inconsistent state data")
 	}
 
 	// Process inconsistent payment
@@ -271,8 +285,7 @@ func (h *PaymentEventHandlers) HandlePaymentInconsistentState(ctx context.Contex
 	}
 
 	if err := h.processPaymentInconsistentOp.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process inconsistent payment %s: %v\n", data.PaymentID, err)
-		return err // Return error for inconsistent operation failures
+		return errors.Wrapf(err, "failed to process inconsistent payment %s", data.PaymentID)
 	}
 
 	return nil
@@ -280,13 +293,9 @@ func (h *PaymentEventHandlers) HandlePaymentInconsistentState(ctx context.Contex
 
 // HandlePaymentRefundInitiated handles payment refund initiated events
 func (h *PaymentEventHandlers) HandlePaymentRefundInitiated(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.PaymentRefundInitiatedEvent {
-		return nil
-	}
-
-	var data application.PaymentRefundInitiatedData
-	if err := h.parseEventData(event, &data); err != nil {
-		return errors.Wrap(err, "failed to parse payment refund initiated data")
+	data, err := events.DecodePayload[application.PaymentRefundInitiatedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode payment refund initiated data")
 	}
 
 	// Process refund
@@ -301,23 +310,88 @@ func (h *PaymentEventHandlers) HandlePaymentRefundInitiated(ctx context.Context,
 	}
 
 	if err := h.processRefund.Execute(ctx, cmd); err != nil {
-		fmt.Printf("Failed to process refund for payment %s: %v\n", data.PaymentID, err)
+		log.Printf("failed to process refund for payment %s: %v", data.PaymentID, err)
 		return nil
 	}
 
 	return nil
 }
 
-// parseEventData parses event data into the specified struct
-func (h *PaymentEventHandlers) parseEventData(event *events.Event, target interface{}) error {
-	// Convert event data to JSON and then to target struct
-	jsonData, err := json.Marshal(event.Data)
+// HandleWalletMovementCreated handles wallet movement created events from
+// wallet service. Credits paying out a refund carry its refund ID; other
+// movements, such as payment debits, are ignored.
+func (h *PaymentEventHandlers) HandleWalletMovementCreated(ctx context.Context, event *events.Event) error {
+	data, err := events.DecodePayload[application.WalletMovementCreatedData](h.registry, event)
 	if err != nil {
-		return errors.Wrap(err, "failed to marshal event data")
+		return errors.Wrap(err, "failed to decode wallet movement created data")
 	}
 
-	if err := json.Unmarshal(jsonData, target); err != nil {
-		return errors.Wrap(err, "failed to unmarshal event data")
+	if data.RefundID == "" {
+		return nil
+	}
+
+	cmd := &application.ProcessRefundResultCommand{
+		RefundID: data.RefundID,
+		Status:   domain.RefundStatusCompleted,
+	}
+	if data.PaymentID != nil {
+		cmd.PaymentID = *data.PaymentID
+	}
+
+	if err := h.processRefundResult.Execute(ctx, cmd); err != nil {
+		log.Printf("failed to process wallet credit of refund %s: %v", data.RefundID, err)
+		return nil
+	}
+
+	return nil
+}
+
+// HandlePaymentRefundCompleted handles payment refund completed events
+func (h *PaymentEventHandlers) HandlePaymentRefundCompleted(ctx context.Context, event *events.Event) error {
+	data, err := events.DecodePayload[application.PaymentRefundCompletedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode payment refund completed data")
+	}
+
+	if data.RefundID == "" {
+		return nil
+	}
+
+	cmd := &application.ProcessRefundResultCommand{
+		RefundID:  data.RefundID,
+		PaymentID: data.PaymentID,
+		Status:    domain.RefundStatusCompleted,
+	}
+
+	if err := h.processRefundResult.Execute(ctx, cmd); err != nil {
+		log.Printf("failed to process completion of refund %s: %v", data.RefundID, err)
+		return nil
+	}
+
+	return nil
+}
+
+// HandlePaymentRefundFailed handles payment refund failed events
+func (h *PaymentEventHandlers) HandlePaymentRefundFailed(ctx context.Context, event *events.Event) error {
+	data, err := events.DecodePayload[application.PaymentRefundFailedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode payment refund failed data")
+	}
+
+	if data.RefundID == "" {
+		return nil
+	}
+
+	cmd := &application.ProcessRefundResultCommand{
+		RefundID:     data.RefundID,
+		PaymentID:    data.PaymentID,
+		Status:       domain.RefundStatusFailed,
+		ErrorMessage: data.ErrorMessage,
+	}
+
+	if err := h.processRefundResult.Execute(ctx, cmd); err != nil {
+		log.Printf("failed to process failure of refund %s: %v", data.RefundID, err)
+		return nil
 	}
 
 	return nil
@@ -325,7 +399,7 @@ func (h *PaymentEventHandlers) parseEventData(event *events.Event, target interf
 
 // Event data structures (imported from domain and other use cases)
 type PaymentInitiatedData struct {
-	PaymentID     models.ID            `json:"payment_id"`
+	PaymentID     models.ID            `json:"payment_id" validate:"required"`
 	UserID        models.ID            `json:"user_id"`
 	Amount        models.Money         `json:"amount"`
 	PaymentMethod domain.PaymentMethod `json:"payment_method"`
@@ -333,9 +407,9 @@ type PaymentInitiatedData struct {
 }
 
 type WalletDebitedData struct {
-	WalletID      models.ID    `json:"wallet_id"`
+	WalletID      models.ID    `json:"wallet_id" validate:"required"`
 	UserID        models.ID    `json:"user_id"`
-	PaymentID     models.ID    `json:"payment_id"`
+	PaymentID     models.ID    `json:"payment_id" validate:"required"`
 	TransactionID models.ID    `json:"transaction_id"`
 	Amount        models.Money `json:"amount"`
 	BalanceBefore models.Money `json:"balance_before"`
@@ -343,29 +417,41 @@ type WalletDebitedData struct {
 	Reference     string       `json:"reference"`
 }
 
+type WalletCreditedData struct {
+	WalletID      models.ID    `json:"wallet_id" validate:"required"`
+	UserID        models.ID    `json:"user_id"`
+	TransactionID models.ID    `json:"transaction_id" validate:"required"`
+	Amount        models.Money `json:"amount"`
+	BalanceBefore models.Money `json:"balance_before"`
+	BalanceAfter  models.Money `json:"balance_after"`
+	Reference     string       `json:"reference"`
+}
+
 type InsufficientFundsData struct {
 	WalletID         models.ID    `json:"wallet_id"`
 	UserID           models.ID    `json:"user_id"`
-	PaymentID        models.ID    `json:"payment_id"`
+	PaymentID        models.ID    `json:"payment_id" validate:"required"`
 	RequestedAmount  models.Money `json:"requested_amount"`
 	AvailableBalance models.Money `json:"available_balance"`
 	Shortfall        models.Money `json:"shortfall"`
 }
 
 type PaymentOperationCompletedData struct {
-	OperationID           models.ID                   `json:"operation_id"`
-	PaymentID             models.ID                   `json:"payment_id"`
+	OperationID           models.ID                   `json:"operation_id" validate:"required"`
+	PaymentID             models.ID                   `json:"payment_id" validate:"required"`
 	Type                  domain.PaymentOperationType `json:"type"`
 	Amount                models.Money                `json:"amount"`
 	ProviderTransactionID string                      `json:"provider_transaction_id"`
 	ExternalTransactionID string                      `json:"external_transaction_id"`
+	Metadata              map[string]interface{}      `json:"metadata,omitempty"`
 }
 
 type PaymentOperationFailedData struct {
-	OperationID  models.ID                   `json:"operation_id"`
-	PaymentID    models.ID                   `json:"payment_id"`
+	OperationID  models.ID                   `json:"operation_id" validate:"required"`
+	PaymentID    models.ID                   `json:"payment_id" validate:"required"`
 	Type         domain.PaymentOperationType `json:"type"`
 	Amount       models.Money                `json:"amount"`
 	ErrorCode    string                      `json:"error_code"`
 	ErrorMessage string                      `json:"error_message"`
+	Metadata     map[string]interface{}      `json:"metadata,omitempty"`
 }
@@ -1,27 +1,41 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/draftea/payment-system/payments-service/application"
+	"github.com/draftea/payment-system/payments-service/domain"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
+	"github.com/draftea/payment-system/shared/models"
 	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
 )
 
 // PaymentHandlers contains payment HTTP handlers
 type PaymentHandlers struct {
-	createPayment *application.CreatePaymentChoreography
-	getPayment    *application.GetPayment
+	createPayment      *application.CreatePaymentChoreography
+	getPayment         *application.GetPayment
+	refundPayment      *application.RefundPayment
+	listPaymentRefunds *application.ListPaymentRefunds
+	transactor         sharedinfra.Transactor
 }
 
 // NewPaymentHandlers creates new payment handlers
 func NewPaymentHandlers(
 	createPayment *application.CreatePaymentChoreography,
 	getPayment *application.GetPayment,
+	refundPayment *application.RefundPayment,
+	listPaymentRefunds *application.ListPaymentRefunds,
+	transactor sharedinfra.Transactor,
 ) *PaymentHandlers {
 	return &PaymentHandlers{
-		createPayment: createPayment,
-		getPayment:    getPayment,
+		createPayment:      createPayment,
+		getPayment:         getPayment,
+		refundPayment:      refundPayment,
+		listPaymentRefunds: listPaymentRefunds,
+		transactor:         transactor,
 	}
 }
 
@@ -33,7 +47,12 @@ func (h *PaymentHandlers) CreatePayment(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	response, err := h.createPayment.Execute(r.Context(), &cmd)
+	var response *application.CreatePaymentResponse
+	err := h.transactor.WithinTransaction(r.Context(), func(ctx context.Context) error {
+		var err error
+		response, err = h.createPayment.Execute(ctx, &cmd)
+		return err
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -70,10 +89,77 @@ func (h *PaymentHandlers) GetPayment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// RefundPayment handles refund requests. Refunds of more than the payment
+// has left to refund, counting pending refunds, are rejected with 409.
+func (h *PaymentHandlers) RefundPayment(w http.ResponseWriter, r *http.Request) {
+	paymentID := chi.URLParam(r, "id")
+	if paymentID == "" {
+		http.Error(w, "Payment ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var cmd application.RefundPaymentCommand
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	cmd.PaymentID = models.ID(paymentID)
+
+	var response *application.RefundPaymentResponse
+	err := h.transactor.WithinTransaction(r.Context(), func(ctx context.Context) error {
+		var err error
+		response, err = h.refundPayment.Execute(ctx, &cmd)
+		return err
+	})
+	if err != nil {
+		switch {
+		case err.Error() == "payment not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, domain.ErrPaymentNotRefundable), errors.Is(err, domain.ErrRefundExceedsRefundable):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListPaymentRefunds handles requests for the refunds of a payment
+func (h *PaymentHandlers) ListPaymentRefunds(w http.ResponseWriter, r *http.Request) {
+	paymentID := chi.URLParam(r, "id")
+	if paymentID == "" {
+		http.Error(w, "Payment ID is required", http.StatusBadRequest)
+		return
+	}
+
+	query := &application.ListPaymentRefundsQuery{
+		PaymentID: paymentID,
+	}
+
+	response, err := h.listPaymentRefunds.Execute(r.Context(), query)
+	if err != nil {
+		if err.Error() == "payment not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // RegisterRoutes registers payment routes
 func (h *PaymentHandlers) RegisterRoutes(r chi.Router) {
 	r.Route("/payments", func(r chi.Router) {
 		r.Post("/", h.CreatePayment)
 		r.Get("/{id}", h.GetPayment)
+		r.Post("/{id}/refunds", h.RefundPayment)
+		r.Get("/{id}/refunds", h.ListPaymentRefunds)
 	})
 }
\ No newline at end of file
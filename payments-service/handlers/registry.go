@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"github.com/draftea/payment-system/payments-service/application"
+	"github.com/draftea/payment-system/payments-service/domain"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/saga"
+)
+
+// NewPaymentEventRegistry creates the payload registry for the topics the
+// payment service consumes and produces. Topics it does both for are bound
+// to the consumer's view of the payload.
+func NewPaymentEventRegistry() *events.Registry {
+	return events.NewRegistry().
+		// Consumed
+		MustRegister(events.PaymentCreatedEvent, PaymentInitiatedData{}).
+		MustRegister(events.WalletDebitedEvent, WalletDebitedData{}).
+		MustRegister(events.InsufficientFundsEvent, InsufficientFundsData{}).
+		MustRegister(events.ExternalProviderUpdateEvent, application.ExternalProviderUpdateData{}).
+		MustRegister(events.PaymentOperationCompletedEvent, PaymentOperationCompletedData{}).
+		MustRegister(events.PaymentOperationFailedEvent, PaymentOperationFailedData{}).
+		MustRegister(events.PaymentInconsistentStateEvent, application.PaymentInconsistentStateData{}).
+		MustRegister(events.PaymentRefundInitiatedEvent, application.PaymentRefundInitiatedData{}).
+		// Wallet credits carrying a refund ID complete refunds in the refund
+		// ledger, as do the payment.refund.* events registered below. They
+		// are also the replies awaited by the orchestrated refund saga.
+		MustRegister(events.WalletMovementCreatedEvent, application.WalletMovementCreatedData{}).
+		// Replies to the reverts compensating orchestrated refunds
+		MustRegister(events.WalletMovementRevertedEvent, application.WalletMovementRevertedData{}).
+		// Recorded in the saga log
+		MustRegister(events.WalletCreditedEvent, WalletCreditedData{}).
+		// Produced
+		MustRegister(events.PaymentProcessingEvent, domain.PaymentProcessingData{}).
+		MustRegister(events.PaymentCompletedEvent, domain.PaymentCompletedData{}).
+		MustRegister(events.PaymentFailedEvent, domain.PaymentFailedData{}).
+		MustRegister(events.PaymentCancelledEvent, domain.PaymentCancelledData{}).
+		MustRegister(events.PaymentPartiallyRefundedEvent, domain.PaymentRefundedData{}).
+		MustRegister(events.PaymentRefundedEvent, domain.PaymentRefundedData{}).
+		MustRegister(events.PaymentOperationCreatedEvent, domain.PaymentOperationCreatedData{}).
+		MustRegister(events.PaymentOperationProcessingEvent, domain.PaymentOperationProcessingData{}).
+		MustRegister(events.PaymentInconsistentOperationStartedEvent, application.PaymentInconsistentOperationStartedData{}).
+		MustRegister(events.PaymentInconsistentOperationProcessedEvent, application.PaymentInconsistentOperationProcessedData{}).
+		// Debits of wallet payments, and credits paying out refunds or
+		// compensating inconsistent payments
+		MustRegister(events.WalletMovementCreationRequestedEvent, application.WalletMovementCreationRequestedData{}).
+		MustRegister(events.WalletMovementRevertRequestedEvent, application.WalletMovementRevertRequestedData{}).
+		MustRegister(events.PaymentRefundCompletedEvent, application.PaymentRefundCompletedData{}).
+		MustRegister(events.PaymentRefundFailedEvent, application.PaymentRefundFailedData{}).
+		// Published by the saga tracker
+		MustRegister(events.SagaStartedEvent, saga.SagaStatusChangedPayload{}).
+		MustRegister(events.SagaCompletedEvent, saga.SagaStatusChangedPayload{}).
+		MustRegister(events.SagaFailedEvent, saga.SagaStatusChangedPayload{}).
+		MustRegister(events.SagaCompensatedEvent, saga.SagaStatusChangedPayload{})
+}
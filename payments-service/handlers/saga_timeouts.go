@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SagaTimeouts expires the overdue replies of orchestrated sagas on a
+// schedule
+type SagaTimeouts struct {
+	orchestrator *saga.Orchestrator
+	batchSize    int
+}
+
+// NewSagaTimeouts creates a SagaTimeouts expiring up to batchSize sagas on
+// each run
+func NewSagaTimeouts(orchestrator *saga.Orchestrator, batchSize int) *SagaTimeouts {
+	return &SagaTimeouts{
+		orchestrator: orchestrator,
+		batchSize:    batchSize,
+	}
+}
+
+// Run expires the replies overdue as of now and records
+// saga_steps_timed_out_total
+func (t *SagaTimeouts) Run(ctx context.Context) error {
+	expired, err := t.orchestrator.ExpireTimeouts(ctx, time.Now(), t.batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range expired {
+		telemetry.RecordCounter(ctx, "saga_steps_timed_out_total", "Total orchestrated saga steps whose reply timed out", 1,
+			attribute.String("saga_type", string(s.Type)),
+			attribute.String("status", string(s.Status)),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/draftea/payment-system/payments-service/application"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+// SagaHandlers contains the saga log HTTP handlers
+type SagaHandlers struct {
+	getSaga          *application.GetSaga
+	listPaymentSagas *application.ListPaymentSagas
+}
+
+// NewSagaHandlers creates new saga handlers
+func NewSagaHandlers(getSaga *application.GetSaga, listPaymentSagas *application.ListPaymentSagas) *SagaHandlers {
+	return &SagaHandlers{
+		getSaga:          getSaga,
+		listPaymentSagas: listPaymentSagas,
+	}
+}
+
+// GetSaga handles saga retrieval requests by correlation ID
+func (h *SagaHandlers) GetSaga(w http.ResponseWriter, r *http.Request) {
+	correlationID := chi.URLParam(r, "correlation_id")
+	if correlationID == "" {
+		http.Error(w, "Correlation ID is required", http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.getSaga.Execute(r.Context(), &application.GetSagaQuery{CorrelationID: correlationID})
+	if err != nil {
+		if errors.Is(err, saga.ErrSagaNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListPaymentSagas handles requests for the sagas of a payment
+func (h *SagaHandlers) ListPaymentSagas(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.URL.Query().Get("payment_id")
+	if paymentID == "" {
+		http.Error(w, "payment_id is required", http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.listPaymentSagas.Execute(r.Context(), &application.ListPaymentSagasQuery{PaymentID: paymentID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RegisterRoutes registers saga routes
+func (h *SagaHandlers) RegisterRoutes(r chi.Router) {
+	r.Route("/sagas", func(r chi.Router) {
+		r.Get("/", h.ListPaymentSagas)
+		r.Get("/{correlation_id}", h.GetSaga)
+	})
+}
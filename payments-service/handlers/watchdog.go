@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/draftea/payment-system/payments-service/application"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SagaWatchdog runs DetectStuckSagas on a schedule
+type SagaWatchdog struct {
+	detectStuckSagas *application.DetectStuckSagas
+	batchSize        int
+}
+
+// NewSagaWatchdog creates a SagaWatchdog raising up to batchSize sagas per
+// payment method and state on each run
+func NewSagaWatchdog(detectStuckSagas *application.DetectStuckSagas, batchSize int) *SagaWatchdog {
+	return &SagaWatchdog{
+		detectStuckSagas: detectStuckSagas,
+		batchSize:        batchSize,
+	}
+}
+
+// Run raises the sagas stuck as of now and records stuck_sagas_raised_total
+func (w *SagaWatchdog) Run(ctx context.Context) error {
+	response, err := w.detectStuckSagas.Execute(ctx, &application.DetectStuckSagasCommand{
+		Now:   time.Now(),
+		Limit: w.batchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, stuck := range response.Raised {
+		telemetry.RecordCounter(ctx, "stuck_sagas_raised_total", "Total sagas raised as inconsistent by the watchdog", 1,
+			attribute.String("saga_type", string(stuck.Type)),
+			attribute.String("state", stuck.State),
+			attribute.String("payment_method", stuck.PaymentMethod.String()),
+		)
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/draftea/payment-system/payments-service/domain"
 	"github.com/draftea/payment-system/shared/events"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
 	"github.com/draftea/payment-system/shared/models"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -32,6 +33,7 @@ type postgresPayment struct {
 	PaymentMethodWallet *string    `db:"payment_method_wallet_id"`
 	Description         string     `db:"description"`
 	Status              string     `db:"status"`
+	RefundedAmount      int64      `db:"refunded_amount"`
 	CreatedAt           time.Time  `db:"created_at"`
 	UpdatedAt           time.Time  `db:"updated_at"`
 	DeletedAt           *time.Time `db:"deleted_at"`
@@ -46,7 +48,8 @@ func (r *PostgresPaymentRepository) Save(ctx context.Context, payment *domain.Pa
 		case events.PaymentCreatedEvent:
 			return r.insertPayment(ctx, payment)
 		case events.PaymentProcessingEvent, events.PaymentCompletedEvent,
-			events.PaymentFailedEvent, events.PaymentCancelledEvent:
+			events.PaymentFailedEvent, events.PaymentCancelledEvent,
+			events.PaymentPartiallyRefundedEvent, events.PaymentRefundedEvent:
 			return r.updatePayment(ctx, payment)
 		}
 	}
@@ -67,7 +70,7 @@ func (r *PostgresPaymentRepository) insertPayment(ctx context.Context, payment *
 		)`
 
 	pgPayment := r.toPostgres(payment)
-	_, err := r.db.NamedExecContext(ctx, query, pgPayment)
+	_, err := sharedinfra.Executor(ctx, r.db).NamedExecContext(ctx, query, pgPayment)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert payment")
 	}
@@ -79,15 +82,17 @@ func (r *PostgresPaymentRepository) insertPayment(ctx context.Context, payment *
 func (r *PostgresPaymentRepository) updatePayment(ctx context.Context, payment *domain.Payment) error {
 	query := `
 		UPDATE payments
-		SET status = :status, updated_at = :updated_at, version = :version
+		SET status = :status, refunded_amount = :refunded_amount,
+			updated_at = :updated_at, version = :version
 		WHERE id = :id AND version = :old_version`
 
-	_, err := r.db.NamedExecContext(ctx, query, map[string]interface{}{
-		"id":          payment.ID.String(),
-		"status":      string(payment.Status),
-		"updated_at":  payment.Timestamps.UpdatedAt,
-		"version":     payment.Version.Value,
-		"old_version": payment.Version.Value - 1, // Optimistic locking
+	_, err := sharedinfra.Executor(ctx, r.db).NamedExecContext(ctx, query, map[string]interface{}{
+		"id":              payment.ID.String(),
+		"status":          string(payment.Status),
+		"refunded_amount": payment.RefundedAmount.Amount,
+		"updated_at":      payment.Timestamps.UpdatedAt,
+		"version":         payment.Version.Value,
+		"old_version":     payment.Version.Value - 1, // Optimistic locking
 	})
 
 	if err != nil {
@@ -101,13 +106,13 @@ func (r *PostgresPaymentRepository) updatePayment(ctx context.Context, payment *
 func (r *PostgresPaymentRepository) FindByID(ctx context.Context, id models.ID) (*domain.Payment, error) {
 	query := `
 		SELECT id, user_id, amount, currency, payment_method_type,
-			   payment_method_wallet_id, description, status,
+			   payment_method_wallet_id, description, status, refunded_amount,
 			   created_at, updated_at, deleted_at, version
 		FROM payments
 		WHERE id = $1 AND deleted_at IS NULL`
 
 	var pgPayment postgresPayment
-	err := r.db.GetContext(ctx, &pgPayment, query, id.String())
+	err := sharedinfra.Executor(ctx, r.db).GetContext(ctx, &pgPayment, query, id.String())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Payment not found
@@ -122,14 +127,14 @@ func (r *PostgresPaymentRepository) FindByID(ctx context.Context, id models.ID)
 func (r *PostgresPaymentRepository) FindByUserID(ctx context.Context, userID models.ID) ([]*domain.Payment, error) {
 	query := `
 		SELECT id, user_id, amount, currency, payment_method_type,
-			   payment_method_wallet_id, description, status,
+			   payment_method_wallet_id, description, status, refunded_amount,
 			   created_at, updated_at, deleted_at, version
 		FROM payments
 		WHERE user_id = $1 AND deleted_at IS NULL
 		ORDER BY created_at DESC`
 
 	var pgPayments []postgresPayment
-	err := r.db.SelectContext(ctx, &pgPayments, query, userID.String())
+	err := sharedinfra.Executor(ctx, r.db).SelectContext(ctx, &pgPayments, query, userID.String())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find payments by user ID")
 	}
@@ -162,6 +167,7 @@ func (r *PostgresPaymentRepository) toPostgres(payment *domain.Payment) *postgre
 		PaymentMethodWallet: walletID,
 		Description:         payment.Description,
 		Status:              string(payment.Status),
+		RefundedAmount:      payment.RefundedAmount.Amount,
 		CreatedAt:           payment.Timestamps.CreatedAt,
 		UpdatedAt:           payment.Timestamps.UpdatedAt,
 		DeletedAt:           payment.Timestamps.DeletedAt,
@@ -203,12 +209,13 @@ func (r *PostgresPaymentRepository) toDomain(pgPayment *postgresPayment) (*domai
 	}
 
 	payment := &domain.Payment{
-		ID:            id,
-		UserID:        userID,
-		Amount:        amount,
-		PaymentMethod: *paymentMethod,
-		Description:   pgPayment.Description,
-		Status:        domain.PaymentStatus(pgPayment.Status),
+		ID:             id,
+		UserID:         userID,
+		Amount:         amount,
+		PaymentMethod:  *paymentMethod,
+		Description:    pgPayment.Description,
+		Status:         domain.PaymentStatus(pgPayment.Status),
+		RefundedAmount: models.NewMoney(pgPayment.RefundedAmount, pgPayment.Currency),
 		Timestamps: models.Timestamps{
 			CreatedAt: pgPayment.CreatedAt,
 			UpdatedAt: pgPayment.UpdatedAt,
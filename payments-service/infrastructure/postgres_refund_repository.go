@@ -0,0 +1,201 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/draftea/payment-system/payments-service/domain"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// PostgresRefundRepository implements RefundRepository using PostgreSQL
+type PostgresRefundRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresRefundRepository creates a new PostgresRefundRepository
+func NewPostgresRefundRepository(db *sqlx.DB) *PostgresRefundRepository {
+	return &PostgresRefundRepository{db: db}
+}
+
+// postgresRefund represents refund in database
+type postgresRefund struct {
+	ID            string         `db:"id"`
+	PaymentID     string         `db:"payment_id"`
+	Amount        int64          `db:"amount"`
+	Currency      string         `db:"currency"`
+	Reason        string         `db:"reason"`
+	RequestedBy   sql.NullString `db:"requested_by"`
+	Status        string         `db:"status"`
+	FailureReason sql.NullString `db:"failure_reason"`
+	CreatedAt     time.Time      `db:"created_at"`
+	UpdatedAt     time.Time      `db:"updated_at"`
+	Version       int            `db:"version"`
+}
+
+const refundColumns = `id, payment_id, amount, currency, reason, requested_by, status,
+	failure_reason, created_at, updated_at, version`
+
+// LockPendingAmount locks the payment's row, which serializes refund
+// requests and outcomes of the payment until the transaction in ctx ends, and
+// sums its pending refunds. The sum is read by a statement of its own so it
+// sees the refunds committed while waiting for the lock. A missing payment
+// has nothing pending.
+func (r *PostgresRefundRepository) LockPendingAmount(ctx context.Context, paymentID models.ID) (models.Money, error) {
+	executor := sharedinfra.Executor(ctx, r.db)
+
+	var currency string
+	err := executor.GetContext(ctx, &currency, `
+		SELECT currency FROM payments
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`,
+		paymentID.String(),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Money{}, nil
+		}
+		return models.Money{}, errors.Wrap(err, "failed to lock payment")
+	}
+
+	var pending int64
+	err = executor.GetContext(ctx, &pending, `
+		SELECT COALESCE(SUM(amount), 0) FROM refunds
+		WHERE payment_id = $1 AND status = $2`,
+		paymentID.String(), string(domain.RefundStatusPending),
+	)
+	if err != nil {
+		return models.Money{}, errors.Wrap(err, "failed to sum pending refunds")
+	}
+
+	return models.NewMoney(pending, currency), nil
+}
+
+// Save inserts a new refund or updates the status of an existing one
+func (r *PostgresRefundRepository) Save(ctx context.Context, refund *domain.Refund) error {
+	query := `
+		INSERT INTO refunds (` + refundColumns + `)
+		VALUES (
+			:id, :payment_id, :amount, :currency, :reason, :requested_by, :status,
+			:failure_reason, :created_at, :updated_at, :version
+		)
+		ON CONFLICT (id) DO UPDATE
+		SET status = EXCLUDED.status, failure_reason = EXCLUDED.failure_reason,
+			updated_at = EXCLUDED.updated_at, version = EXCLUDED.version
+		WHERE refunds.version = EXCLUDED.version - 1`
+
+	res, err := sharedinfra.Executor(ctx, r.db).NamedExecContext(ctx, query, r.toPostgres(refund))
+	if err != nil {
+		return errors.Wrap(err, "failed to save refund")
+	}
+
+	// Optimistic locking: a refund changed concurrently is not overwritten
+	saved, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to read saved refunds")
+	}
+	if saved == 0 {
+		return errors.Errorf("refund %s was modified concurrently", refund.ID)
+	}
+
+	return nil
+}
+
+// FindByID finds a refund by ID
+func (r *PostgresRefundRepository) FindByID(ctx context.Context, id models.ID) (*domain.Refund, error) {
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE id = $1`
+
+	var pgRefund postgresRefund
+	err := sharedinfra.Executor(ctx, r.db).GetContext(ctx, &pgRefund, query, id.String())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Refund not found
+		}
+		return nil, errors.Wrap(err, "failed to find refund")
+	}
+
+	return r.toDomain(&pgRefund), nil
+}
+
+// FindPending finds the oldest pending refund of a payment for amount
+func (r *PostgresRefundRepository) FindPending(ctx context.Context, paymentID models.ID, amount models.Money) (*domain.Refund, error) {
+	query := `
+		SELECT ` + refundColumns + `
+		FROM refunds
+		WHERE payment_id = $1 AND status = $2 AND amount = $3 AND currency = $4
+		ORDER BY created_at
+		LIMIT 1`
+
+	var pgRefund postgresRefund
+	err := sharedinfra.Executor(ctx, r.db).GetContext(ctx, &pgRefund, query,
+		paymentID.String(), string(domain.RefundStatusPending), amount.Amount, amount.Currency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No pending refund
+		}
+		return nil, errors.Wrap(err, "failed to find pending refund")
+	}
+
+	return r.toDomain(&pgRefund), nil
+}
+
+// FindByPaymentID finds the refunds of a payment, oldest first
+func (r *PostgresRefundRepository) FindByPaymentID(ctx context.Context, paymentID models.ID) ([]*domain.Refund, error) {
+	query := `
+		SELECT ` + refundColumns + `
+		FROM refunds
+		WHERE payment_id = $1
+		ORDER BY created_at`
+
+	var pgRefunds []postgresRefund
+	err := sharedinfra.Executor(ctx, r.db).SelectContext(ctx, &pgRefunds, query, paymentID.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find refunds by payment ID")
+	}
+
+	refunds := make([]*domain.Refund, len(pgRefunds))
+	for i := range pgRefunds {
+		refunds[i] = r.toDomain(&pgRefunds[i])
+	}
+
+	return refunds, nil
+}
+
+// toPostgres converts domain refund to postgres model
+func (r *PostgresRefundRepository) toPostgres(refund *domain.Refund) *postgresRefund {
+	return &postgresRefund{
+		ID:            refund.ID.String(),
+		PaymentID:     refund.PaymentID.String(),
+		Amount:        refund.Amount.Amount,
+		Currency:      refund.Amount.Currency,
+		Reason:        refund.Reason,
+		RequestedBy:   sql.NullString{String: refund.RequestedBy.String(), Valid: refund.RequestedBy != ""},
+		Status:        string(refund.Status),
+		FailureReason: sql.NullString{String: refund.FailureReason, Valid: refund.FailureReason != ""},
+		CreatedAt:     refund.Timestamps.CreatedAt,
+		UpdatedAt:     refund.Timestamps.UpdatedAt,
+		Version:       refund.Version.Value,
+	}
+}
+
+// toDomain converts postgres model to domain refund
+func (r *PostgresRefundRepository) toDomain(pgRefund *postgresRefund) *domain.Refund {
+	return &domain.Refund{
+		ID:            models.ID(pgRefund.ID),
+		PaymentID:     models.ID(pgRefund.PaymentID),
+		Amount:        models.NewMoney(pgRefund.Amount, pgRefund.Currency),
+		Reason:        pgRefund.Reason,
+		RequestedBy:   models.ID(pgRefund.RequestedBy.String),
+		Status:        domain.RefundStatus(pgRefund.Status),
+		FailureReason: pgRefund.FailureReason.String,
+		Timestamps: models.Timestamps{
+			CreatedAt: pgRefund.CreatedAt,
+			UpdatedAt: pgRefund.UpdatedAt,
+		},
+		Version: models.Version{Value: pgRefund.Version},
+	}
+}
@@ -0,0 +1,116 @@
+package infrastructure
+
+import (
+	"context"
+	"time"
+
+	"github.com/draftea/payment-system/payments-service/application"
+	"github.com/draftea/payment-system/payments-service/domain"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var _ application.StuckSagaFinder = (*PostgresStuckSagaFinder)(nil)
+
+// PostgresStuckSagaFinder implements StuckSagaFinder over the payments table
+// and the saga log
+type PostgresStuckSagaFinder struct {
+	db *sqlx.DB
+}
+
+// NewPostgresStuckSagaFinder creates a new PostgresStuckSagaFinder
+func NewPostgresStuckSagaFinder(db *sqlx.DB) *PostgresStuckSagaFinder {
+	return &PostgresStuckSagaFinder{db: db}
+}
+
+// postgresStuckSaga represents a stuck saga row
+type postgresStuckSaga struct {
+	PaymentID         string    `db:"payment_id"`
+	CorrelationID     string    `db:"correlation_id"`
+	State             string    `db:"state"`
+	PaymentMethodType string    `db:"payment_method_type"`
+	LastActivity      time.Time `db:"last_activity"`
+}
+
+// FindStuckPayments finds payments in status whose last activity, the later
+// of their last update and the last step of their saga, is before the given
+// time
+func (f *PostgresStuckSagaFinder) FindStuckPayments(ctx context.Context, method domain.PaymentMethodType, status domain.PaymentStatus, before time.Time, limit int) ([]application.StuckSaga, error) {
+	query := `
+		SELECT payment_id, correlation_id, state, payment_method_type, last_activity
+		FROM (
+			SELECT p.id AS payment_id,
+				   COALESCE(i.correlation_id, '') AS correlation_id,
+				   p.status AS state,
+				   p.payment_method_type,
+				   GREATEST(p.updated_at, COALESCE(i.updated_at, p.updated_at)) AS last_activity
+			FROM payments p
+			LEFT JOIN LATERAL (
+				SELECT correlation_id, updated_at
+				FROM saga_instances
+				WHERE aggregate_id = p.id AND saga_type = $1
+				ORDER BY started_at DESC
+				LIMIT 1
+			) i ON TRUE
+			WHERE p.status = $2 AND p.payment_method_type = $3 AND p.deleted_at IS NULL
+		) stuck
+		WHERE last_activity < $4
+		ORDER BY last_activity
+		LIMIT $5`
+
+	var rows []postgresStuckSaga
+	err := sharedinfra.Executor(ctx, f.db).SelectContext(ctx, &rows, query,
+		string(saga.SagaTypePayment), string(status), method.String(), before, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find stuck payments")
+	}
+
+	return f.toStuckSagas(rows, saga.SagaTypePayment), nil
+}
+
+// FindStuckRefunds finds running refund sagas of payments of method whose
+// last step is before the given time
+func (f *PostgresStuckSagaFinder) FindStuckRefunds(ctx context.Context, method domain.PaymentMethodType, before time.Time, limit int) ([]application.StuckSaga, error) {
+	query := `
+		SELECT i.aggregate_id AS payment_id,
+			   i.correlation_id,
+			   i.current_step AS state,
+			   p.payment_method_type,
+			   i.updated_at AS last_activity
+		FROM saga_instances i
+		JOIN payments p ON p.id = i.aggregate_id
+		WHERE i.saga_type = $1 AND i.status IN ($2, $3)
+		  AND p.payment_method_type = $4 AND i.updated_at < $5
+		ORDER BY i.updated_at
+		LIMIT $6`
+
+	var rows []postgresStuckSaga
+	err := sharedinfra.Executor(ctx, f.db).SelectContext(ctx, &rows, query,
+		string(saga.SagaTypeRefund), string(saga.SagaStatusStarted), string(saga.SagaStatusInProgress),
+		method.String(), before, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find stuck refunds")
+	}
+
+	return f.toStuckSagas(rows, saga.SagaTypeRefund), nil
+}
+
+func (f *PostgresStuckSagaFinder) toStuckSagas(rows []postgresStuckSaga, sagaType saga.SagaType) []application.StuckSaga {
+	stuck := make([]application.StuckSaga, 0, len(rows))
+	for _, row := range rows {
+		stuck = append(stuck, application.StuckSaga{
+			PaymentID:     models.ID(row.PaymentID),
+			CorrelationID: models.ID(row.CorrelationID),
+			Type:          sagaType,
+			State:         row.State,
+			PaymentMethod: domain.PaymentMethodType(row.PaymentMethodType),
+			LastActivity:  row.LastActivity,
+		})
+	}
+	return stuck
+}
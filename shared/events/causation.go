@@ -0,0 +1,152 @@
+package events
+
+import (
+	"context"
+	"sort"
+
+	"github.com/draftea/payment-system/shared/models"
+)
+
+var (
+	_ Publisher = (*CausalPublisher)(nil)
+	_ Publisher = (*RecordingPublisher)(nil)
+)
+
+// Context key for the event being handled
+type eventContextKey struct{}
+
+// ContextWithEvent returns a context carrying the event being handled.
+// Subscribers call it before invoking handlers so events published while
+// handling are linked to it.
+func ContextWithEvent(ctx context.Context, event *Event) context.Context {
+	return context.WithValue(ctx, eventContextKey{}, event)
+}
+
+// EventFromContext returns the event being handled, or nil
+func EventFromContext(ctx context.Context) *Event {
+	if event, ok := ctx.Value(eventContextKey{}).(*Event); ok {
+		return event
+	}
+	return nil
+}
+
+// CausedBy links the event to the event that caused it: CausationID is set
+// to the cause's ID and the cause's CorrelationID is inherited. Values that
+// are already set are kept.
+func (e *Event) CausedBy(cause *Event) *Event {
+	if e.CausationID == "" {
+		e.CausationID = cause.ID
+	}
+
+	if e.CorrelationID == "" {
+		e.CorrelationID = cause.CorrelationID
+		if e.CorrelationID == "" {
+			e.CorrelationID = cause.ID
+		}
+	}
+
+	return e
+}
+
+// StampCausation links event to the event handled in ctx. An event published
+// outside a handler starts a new causal chain and correlates to itself.
+func StampCausation(ctx context.Context, event *Event) {
+	if cause := EventFromContext(ctx); cause != nil && cause.ID != event.ID {
+		event.CausedBy(cause)
+		return
+	}
+
+	if event.CorrelationID == "" {
+		event.CorrelationID = event.ID
+	}
+}
+
+// CausalPublisher stamps CorrelationID and CausationID on events from the
+// context before publishing them
+type CausalPublisher struct {
+	next Publisher
+}
+
+// NewCausalPublisher creates a new CausalPublisher
+func NewCausalPublisher(next Publisher) *CausalPublisher {
+	return &CausalPublisher{next: next}
+}
+
+// Publish stamps the events and forwards them
+func (p *CausalPublisher) Publish(ctx context.Context, evts ...*Event) error {
+	for _, event := range evts {
+		StampCausation(ctx, event)
+	}
+
+	return p.next.Publish(ctx, evts...)
+}
+
+// RecordingPublisher appends published events to their aggregate stream in
+// an EventStore before forwarding them, so the store holds every event a
+// service emitted
+type RecordingPublisher struct {
+	next  Publisher
+	store EventStore
+}
+
+// NewRecordingPublisher creates a new RecordingPublisher
+func NewRecordingPublisher(next Publisher, store EventStore) *RecordingPublisher {
+	return &RecordingPublisher{
+		next:  next,
+		store: store,
+	}
+}
+
+// Publish records the events per aggregate and forwards them
+func (p *RecordingPublisher) Publish(ctx context.Context, evts ...*Event) error {
+	var aggregateIDs []models.ID
+	streams := make(map[models.ID][]*Event)
+	for _, event := range evts {
+		if _, ok := streams[event.AggregateID]; !ok {
+			aggregateIDs = append(aggregateIDs, event.AggregateID)
+		}
+		streams[event.AggregateID] = append(streams[event.AggregateID], event)
+	}
+
+	for _, aggregateID := range aggregateIDs {
+		if err := p.store.SaveEvents(ctx, aggregateID, streams[aggregateID], AnyVersion); err != nil {
+			return err
+		}
+	}
+
+	return p.next.Publish(ctx, evts...)
+}
+
+// CausalNode is an event and the events it caused
+type CausalNode struct {
+	Event    *Event
+	Children []*CausalNode
+}
+
+// BuildCausalTree links events through their CausationID. Events whose cause
+// is not among evts are roots. Roots and children are ordered by timestamp.
+func BuildCausalTree(evts []*Event) []*CausalNode {
+	sorted := make([]*Event, len(evts))
+	copy(sorted, evts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	nodes := make(map[models.ID]*CausalNode, len(sorted))
+	for _, event := range sorted {
+		nodes[event.ID] = &CausalNode{Event: event}
+	}
+
+	var roots []*CausalNode
+	for _, event := range sorted {
+		node := nodes[event.ID]
+		parent, ok := nodes[event.CausationID]
+		if !ok || event.CausationID == "" || parent == node {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	return roots
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryEventStore struct {
+	streams map[models.ID][]*Event
+}
+
+func (s *memoryEventStore) SaveEvents(ctx context.Context, aggregateID models.ID, evts []*Event, expectedVersion int) error {
+	s.streams[aggregateID] = append(s.streams[aggregateID], evts...)
+	return nil
+}
+
+func (s *memoryEventStore) GetEvents(ctx context.Context, aggregateID models.ID) ([]*Event, error) {
+	return s.streams[aggregateID], nil
+}
+
+func (s *memoryEventStore) GetEventsByType(ctx context.Context, eventType string, offset, limit int) ([]*Event, error) {
+	return nil, nil
+}
+
+func TestStampCausation(t *testing.T) {
+	tests := []struct {
+		name                  string
+		cause                 *Event
+		event                 *Event
+		expectedCorrelationID func(cause, event *Event) models.ID
+		expectedCausationID   func(cause, event *Event) models.ID
+	}{
+		{
+			name:  "root event correlates to itself",
+			event: NewEvent("payment-1", PaymentCreatedEvent, nil),
+			expectedCorrelationID: func(cause, event *Event) models.ID {
+				return event.ID
+			},
+			expectedCausationID: func(cause, event *Event) models.ID {
+				return ""
+			},
+		},
+		{
+			name:  "inherits the correlation of the handled event",
+			cause: NewEvent("payment-1", PaymentCreatedEvent, nil).WithCorrelationID("chain-1"),
+			event: NewEvent("wallet-1", WalletDebitRequestedEvent, nil),
+			expectedCorrelationID: func(cause, event *Event) models.ID {
+				return "chain-1"
+			},
+			expectedCausationID: func(cause, event *Event) models.ID {
+				return cause.ID
+			},
+		},
+		{
+			name:  "handled event without correlation becomes the root",
+			cause: NewEvent("payment-1", PaymentCreatedEvent, nil),
+			event: NewEvent("wallet-1", WalletDebitRequestedEvent, nil),
+			expectedCorrelationID: func(cause, event *Event) models.ID {
+				return cause.ID
+			},
+			expectedCausationID: func(cause, event *Event) models.ID {
+				return cause.ID
+			},
+		},
+		{
+			name:  "keeps an explicit correlation",
+			cause: NewEvent("payment-1", PaymentCreatedEvent, nil).WithCorrelationID("chain-1"),
+			event: NewEvent("wallet-1", WalletDebitRequestedEvent, nil).WithCorrelationID("payment-1"),
+			expectedCorrelationID: func(cause, event *Event) models.ID {
+				return "payment-1"
+			},
+			expectedCausationID: func(cause, event *Event) models.ID {
+				return cause.ID
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.cause != nil {
+				ctx = ContextWithEvent(ctx, tt.cause)
+			}
+
+			StampCausation(ctx, tt.event)
+
+			assert.Equal(t, tt.expectedCorrelationID(tt.cause, tt.event), tt.event.CorrelationID)
+			assert.Equal(t, tt.expectedCausationID(tt.cause, tt.event), tt.event.CausationID)
+		})
+	}
+}
+
+func TestCausalPublisher_Publish(t *testing.T) {
+	next := &recordingPublisher{}
+	store := &memoryEventStore{streams: make(map[models.ID][]*Event)}
+	publisher := NewCausalPublisher(NewRecordingPublisher(next, store))
+
+	cause := NewEvent("payment-1", PaymentCreatedEvent, nil)
+	first := NewEvent("payment-1", WalletDebitRequestedEvent, nil)
+	second := NewEvent("wallet-1", WalletDebitedEvent, nil)
+
+	require.NoError(t, publisher.Publish(ContextWithEvent(context.Background(), cause), first, second))
+
+	require.Len(t, next.calls, 1)
+	for _, event := range next.calls[0] {
+		assert.Equal(t, cause.ID, event.CausationID)
+		assert.Equal(t, cause.ID, event.CorrelationID)
+	}
+
+	assert.Equal(t, []*Event{first}, store.streams["payment-1"])
+	assert.Equal(t, []*Event{second}, store.streams["wallet-1"])
+}
+
+func TestBuildCausalTree(t *testing.T) {
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(event *Event, offset int) *Event {
+		event.Timestamp = base.Add(time.Duration(offset) * time.Second)
+		return event
+	}
+
+	created := at(NewEvent("payment-1", PaymentCreatedEvent, nil), 0)
+	debitRequested := at(NewEvent("payment-1", WalletDebitRequestedEvent, nil), 1).CausedBy(created)
+	debited := at(NewEvent("wallet-1", WalletDebitedEvent, nil), 2).CausedBy(debitRequested)
+	operationCompleted := at(NewEvent("payment-1", PaymentOperationCompletedEvent, nil), 3).CausedBy(debited)
+	balanceUpdated := at(NewEvent("wallet-1", "wallet.balance_update", nil), 2).CausedBy(debitRequested)
+	orphan := at(NewEvent("payment-2", PaymentCreatedEvent, nil), 4).WithCausationID("missing")
+
+	roots := BuildCausalTree([]*Event{orphan, operationCompleted, debited, balanceUpdated, debitRequested, created})
+
+	require.Len(t, roots, 2)
+	assert.Equal(t, created, roots[0].Event)
+	assert.Equal(t, orphan, roots[1].Event)
+
+	require.Len(t, roots[0].Children, 1)
+	requested := roots[0].Children[0]
+	assert.Equal(t, debitRequested, requested.Event)
+
+	require.Len(t, requested.Children, 2)
+	assert.Equal(t, debited, requested.Children[0].Event)
+	assert.Equal(t, balanceUpdated, requested.Children[1].Event)
+	require.Len(t, requested.Children[0].Children, 1)
+	assert.Equal(t, operationCompleted, requested.Children[0].Children[0].Event)
+}
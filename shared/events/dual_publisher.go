@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"fmt"
+)
+
+var _ Publisher = (*DualPublisher)(nil)
+
+// SchemaVersionKey is the metadata key carrying the payload schema version,
+// so subscriptions can filter on it while a topic is dual-published
+const SchemaVersionKey = "schema_version"
+
+// DualPublisher publishes events of migrating topics twice: once in their
+// own schema version and once converted to a legacy version with the
+// registry downcasters. Both copies share the event ID, so consumers behind
+// an idempotent inbox process whichever copy they understand first.
+//
+// It must wrap a transport publisher such as SNS rather than the outbox,
+// which stores one row per event ID.
+type DualPublisher struct {
+	next     Publisher
+	registry *Registry
+	legacy   map[Topic]string
+}
+
+// NewDualPublisher creates a DualPublisher. legacyVersions maps each topic
+// being migrated to the schema version old consumers still expect.
+func NewDualPublisher(next Publisher, registry *Registry, legacyVersions map[Topic]string) *DualPublisher {
+	legacy := make(map[Topic]string, len(legacyVersions))
+	for topic, version := range legacyVersions {
+		legacy[topic] = version
+	}
+
+	return &DualPublisher{
+		next:     next,
+		registry: registry,
+		legacy:   legacy,
+	}
+}
+
+// Publish publishes the events followed by their legacy copies
+func (p *DualPublisher) Publish(ctx context.Context, evts ...*Event) error {
+	if len(evts) == 0 {
+		return nil
+	}
+
+	current := make([]*Event, 0, len(evts))
+	var copies []*Event
+
+	for _, event := range evts {
+		version, ok := p.legacy[eventTopic(event)]
+		if !ok || version == eventVersion(event) {
+			current = append(current, event)
+			continue
+		}
+
+		legacy, err := p.registry.Convert(event, version)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s to schema %s: %w", event.ID, version, err)
+		}
+
+		current = append(current, event.Clone().WithMetadata(SchemaVersionKey, eventVersion(event)))
+		copies = append(copies, legacy.WithMetadata(SchemaVersionKey, version))
+	}
+
+	if err := p.next.Publish(ctx, current...); err != nil {
+		return err
+	}
+
+	// Copies go in a separate call so a batch never holds two entries with
+	// the same event ID
+	if len(copies) == 0 {
+		return nil
+	}
+
+	return p.next.Publish(ctx, copies...)
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingPublisher struct {
+	calls [][]*Event
+	err   error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, evts ...*Event) error {
+	p.calls = append(p.calls, evts)
+	return p.err
+}
+
+func TestDualPublisher_Publish(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   func() []*Event
+		validate func(t *testing.T, calls [][]*Event)
+	}{
+		{
+			name: "publishes legacy copy in a second call",
+			events: func() []*Event {
+				return []*Event{
+					NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","method":{"type":"wallet"}}`)).WithVersion("3.0"),
+				}
+			},
+			validate: func(t *testing.T, calls [][]*Event) {
+				require.Len(t, calls, 2)
+				require.Len(t, calls[0], 1)
+				require.Len(t, calls[1], 1)
+
+				current, legacy := calls[0][0], calls[1][0]
+				assert.Equal(t, current.ID, legacy.ID)
+				assert.Equal(t, "3.0", current.Metadata[SchemaVersionKey])
+				assert.Equal(t, "2.0", legacy.Version)
+				assert.Equal(t, "2.0", legacy.Metadata[SchemaVersionKey])
+			},
+		},
+		{
+			name: "passes through other topics and legacy producers",
+			events: func() []*Event {
+				return []*Event{
+					NewEvent("agg", "registry.other", json.RawMessage(`{}`)),
+					NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1"}`)).WithVersion("2.0"),
+				}
+			},
+			validate: func(t *testing.T, calls [][]*Event) {
+				require.Len(t, calls, 1)
+				assert.Len(t, calls[0], 2)
+				assert.NotContains(t, calls[0][1].Metadata, SchemaVersionKey)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recordingPublisher{}
+			publisher := NewDualPublisher(next, newVersionedTestRegistry(), map[Topic]string{
+				registryTestTopic: "2.0",
+			})
+
+			require.NoError(t, publisher.Publish(context.Background(), tt.events()...))
+			tt.validate(t, next.calls)
+		})
+	}
+}
+
+func TestDualPublisher_PublishError(t *testing.T) {
+	next := &recordingPublisher{err: errors.New("sns unavailable")}
+	publisher := NewDualPublisher(next, newVersionedTestRegistry(), map[Topic]string{
+		registryTestTopic: "2.0",
+	})
+
+	event := NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","method":{}}`)).WithVersion("3.0")
+
+	err := publisher.Publish(context.Background(), event)
+	assert.EqualError(t, err, "sns unavailable")
+	assert.Len(t, next.calls, 1)
+}
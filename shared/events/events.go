@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"time"
@@ -16,8 +17,36 @@ var (
 	ErrInvalidPayload  = errors.New("invalid payload")
 	ErrInvalidReceiver = errors.New("receiver should be a pointer")
 	ErrInvalidHandleID = errors.New("invalid handle ID")
+	ErrVersionConflict = errors.New("event stream version conflict")
 )
 
+// DefaultSchemaVersion is the payload schema version of events created
+// without an explicit one
+const DefaultSchemaVersion = "1.0"
+
+// AnyVersion can be passed as expectedVersion to append without a concurrency check
+const AnyVersion = -1
+
+// VersionConflictError is returned by an EventStore when the stream version
+// does not match the version the caller expected
+type VersionConflictError struct {
+	AggregateID     models.ID
+	ExpectedVersion int
+	ActualVersion   int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf(
+		"%s: aggregate %s expected version %d, actual version %d",
+		ErrVersionConflict, e.AggregateID, e.ExpectedVersion, e.ActualVersion,
+	)
+}
+
+// Is allows errors.Is(err, ErrVersionConflict)
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
 // Topic represents an event topic with pattern matching support
 type Topic string
 
@@ -28,35 +57,24 @@ func NewTopic(topic string) (Topic, error) {
 	return Topic(topic), nil
 }
 
+// Matches reports whether the topic matches an AMQP-style pattern. Patterns
+// are dot-separated words where "*" matches exactly one word and "#" matches
+// zero or more words, e.g. "payment.#.failed" or "wallet.*.#".
 func (t Topic) Matches(pattern Topic) bool {
-	topicStr := t.String()
-	patternStr := pattern.String()
-
-	if strings.HasPrefix(patternStr, "#") && strings.HasSuffix(patternStr, "#") {
-		return strings.Contains(
-			topicStr,
-			strings.TrimSuffix(strings.TrimPrefix(patternStr, "#"), "#"),
-		)
-	}
-
-	if strings.HasPrefix(patternStr, "#") {
-		return strings.HasSuffix(
-			topicStr,
-			strings.TrimPrefix(patternStr, "#"),
-		)
-	}
+	return matchPattern(
+		strings.Split(pattern.String(), "."),
+		strings.Split(t.String(), "."),
+	)
+}
 
-	if strings.HasSuffix(patternStr, "#") {
-		return strings.HasPrefix(
-			topicStr,
-			strings.TrimSuffix(patternStr, "#"),
-		)
+// IsPattern reports whether the topic contains wildcards
+func (t Topic) IsPattern() bool {
+	for _, word := range strings.Split(t.String(), ".") {
+		if word == "*" || word == "#" {
+			return true
+		}
 	}
-
-	patternParts := strings.Split(patternStr, ".")
-	topicParts := strings.Split(topicStr, ".")
-
-	return matchPattern(patternParts, topicParts)
+	return false
 }
 
 func (t Topic) String() string {
@@ -64,23 +82,39 @@ func (t Topic) String() string {
 }
 
 func matchPattern(patternParts, topicParts []string) bool {
-	if len(patternParts) == 1 && patternParts[0] == "#" {
-		return true
-	}
-
-	if len(patternParts) != len(topicParts) {
-		return false
-	}
-
-	if len(patternParts) == 0 {
-		return true
-	}
+	for len(patternParts) > 0 {
+		switch patternParts[0] {
+		case "#":
+			// Collapse consecutive "#", then try every split of the
+			// remaining topic words
+			rest := patternParts[1:]
+			for len(rest) > 0 && rest[0] == "#" {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(topicParts); i++ {
+				if matchPattern(rest, topicParts[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(topicParts) == 0 {
+				return false
+			}
+		default:
+			if len(topicParts) == 0 || patternParts[0] != topicParts[0] {
+				return false
+			}
+		}
 
-	if patternParts[0] == "*" || patternParts[0] == topicParts[0] {
-		return matchPattern(patternParts[1:], topicParts[1:])
+		patternParts = patternParts[1:]
+		topicParts = topicParts[1:]
 	}
 
-	return false
+	return len(topicParts) == 0
 }
 
 // Metadata represents event metadata
@@ -145,6 +179,7 @@ type Event struct {
 	Metadata      Metadata    `json:"metadata"`
 	Timestamp     time.Time   `json:"timestamp"`
 	CorrelationID models.ID   `json:"correlation_id"`
+	CausationID   models.ID   `json:"causation_id,omitempty"`
 }
 
 // Publisher publishes events
@@ -162,6 +197,12 @@ type EventHandler interface {
 	Handle(ctx context.Context, event *Event) error
 }
 
+// TopicConsumer is implemented by handlers that declare the topics, or topic
+// patterns, they consume so subscriptions can be filtered to them
+type TopicConsumer interface {
+	ConsumedTopics() []Topic
+}
+
 // EventStore stores and retrieves events
 type EventStore interface {
 	SaveEvents(ctx context.Context, aggregateID models.ID, events []*Event, expectedVersion int) error
@@ -177,7 +218,7 @@ func NewEvent(aggregateID models.ID, eventType string, data interface{}) *Event
 		AggregateID: aggregateID,
 		Topic:       topic,
 		EventType:   eventType,
-		Version:     "1.0",
+		Version:     DefaultSchemaVersion,
 		Data:        data,
 		Metadata:    make(Metadata),
 		Timestamp:   time.Now(),
@@ -191,7 +232,7 @@ func NewEventWithTopic(aggregateID models.ID, topic Topic, data interface{}) *Ev
 		AggregateID: aggregateID,
 		Topic:       topic,
 		EventType:   topic.String(), // Set EventType from topic for backward compatibility
-		Version:     "1.0",
+		Version:     DefaultSchemaVersion,
 		Data:        data,
 		Metadata:    make(Metadata),
 		Timestamp:   time.Now(),
@@ -204,6 +245,18 @@ func (e *Event) WithCorrelationID(correlationID models.ID) *Event {
 	return e
 }
 
+// WithCausationID sets the ID of the event that caused this one
+func (e *Event) WithCausationID(causationID models.ID) *Event {
+	e.CausationID = causationID
+	return e
+}
+
+// WithVersion sets the payload schema version
+func (e *Event) WithVersion(version string) *Event {
+	e.Version = version
+	return e
+}
+
 // WithMetadata adds metadata
 func (e *Event) WithMetadata(key string, value string) *Event {
 	if e.Metadata == nil {
@@ -285,6 +338,7 @@ func (e *Event) Clone() *Event {
 		Metadata:      e.Metadata.Clone(),
 		Timestamp:     e.Timestamp,
 		CorrelationID: e.CorrelationID,
+		CausationID:   e.CausationID,
 	}
 }
 
@@ -297,6 +351,8 @@ const (
 	PaymentCompletedEvent                      = "payment.completed"
 	PaymentFailedEvent                         = "payment.failed"
 	PaymentCancelledEvent                      = "payment.cancelled"
+	PaymentPartiallyRefundedEvent              = "payment.partially_refunded"
+	PaymentRefundedEvent                       = "payment.refunded"
 	PaymentRefundInitiatedEvent                = "payment.refund.initiated"
 	PaymentRefundCompletedEvent                = "payment.refund.completed"
 	PaymentRefundFailedEvent                   = "payment.refund.failed"
@@ -314,6 +370,7 @@ const (
 	ExternalProviderUpdateEvent = "external.provider.update"
 
 	// Wallet Events
+	WalletCreatedEvent                   = "wallet.created"
 	WalletDebitRequestedEvent            = "wallet.debit.requested"
 	WalletCreditRequestedEvent           = "wallet.credit.requested"
 	WalletDebitedEvent                   = "wallet.debited"
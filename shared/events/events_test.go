@@ -0,0 +1,51 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopic_Matches(t *testing.T) {
+	tests := []struct {
+		topic    Topic
+		pattern  Topic
+		expected bool
+	}{
+		{topic: "payment.created", pattern: "payment.created", expected: true},
+		{topic: "payment.created", pattern: "payment.failed", expected: false},
+		{topic: "payment.created", pattern: "payment.*", expected: true},
+		{topic: "payment.refund.failed", pattern: "payment.*", expected: false},
+		{topic: "payment.refund.failed", pattern: "payment.*.failed", expected: true},
+		{topic: "payment.created", pattern: "#", expected: true},
+		{topic: "payment", pattern: "payment.#", expected: true},
+		{topic: "payment.refund.failed", pattern: "payment.#", expected: true},
+		{topic: "wallet.debited", pattern: "payment.#", expected: false},
+		{topic: "payment.failed", pattern: "payment.#.failed", expected: true},
+		{topic: "payment.refund.failed", pattern: "payment.#.failed", expected: true},
+		{topic: "payment.operation.refund.failed", pattern: "payment.#.failed", expected: true},
+		{topic: "payment.refund.completed", pattern: "payment.#.failed", expected: false},
+		{topic: "payment.failed.retry", pattern: "payment.#.failed", expected: false},
+		{topic: "wallet.movement.created", pattern: "wallet.*.#", expected: true},
+		{topic: "wallet.debited", pattern: "wallet.*.#", expected: true},
+		{topic: "wallet", pattern: "wallet.*.#", expected: false},
+		{topic: "wallet.insufficient.funds", pattern: "#.funds", expected: true},
+		{topic: "wallet.insufficient.funds", pattern: "#.#.funds", expected: true},
+		{topic: "wallet.insufficient.funds", pattern: "*.#.*", expected: true},
+		{topic: "wallet", pattern: "*.#.*", expected: false},
+		{topic: "paymentcreated", pattern: "payment#", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic.String()+"~"+tt.pattern.String(), func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.topic.Matches(tt.pattern))
+		})
+	}
+}
+
+func TestTopic_IsPattern(t *testing.T) {
+	assert.False(t, Topic("payment.created").IsPattern())
+	assert.True(t, Topic("payment.*").IsPattern())
+	assert.True(t, Topic("payment.#.failed").IsPattern())
+	assert.False(t, Topic("payment.#x").IsPattern())
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var _ Publisher = PublisherFunc(nil)
+
+// ErrInvalidEvent is returned by the validation middleware for events that
+// cannot be published
+var ErrInvalidEvent = errors.New("invalid event")
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(ctx context.Context, evts ...*Event) error
+
+// Publish calls f(ctx, evts...)
+func (f PublisherFunc) Publish(ctx context.Context, evts ...*Event) error {
+	return f(ctx, evts...)
+}
+
+// PublisherMiddleware decorates a Publisher with a cross-cutting concern
+type PublisherMiddleware func(next Publisher) Publisher
+
+// ChainPublisher wraps publisher with middlewares. The first middleware is
+// the outermost one and sees the events first.
+func ChainPublisher(publisher Publisher, middlewares ...PublisherMiddleware) Publisher {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		publisher = middlewares[i](publisher)
+	}
+	return publisher
+}
+
+// CausationMiddleware links published events to the event handled in the
+// context (see CausalPublisher)
+func CausationMiddleware() PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return NewCausalPublisher(next)
+	}
+}
+
+// RecordingMiddleware appends published events to their stream in store
+// (see RecordingPublisher)
+func RecordingMiddleware(store EventStore) PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return NewRecordingPublisher(next, store)
+	}
+}
+
+// ValidationMiddleware rejects the whole call when an event lacks its ID,
+// aggregate or topic, or when the payload of a topic known to registry does
+// not match its JSON Schema. Nothing is published if any event is invalid.
+func ValidationMiddleware(registry *Registry) PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(ctx context.Context, evts ...*Event) error {
+			for _, event := range evts {
+				if err := validateEvent(registry, event); err != nil {
+					return err
+				}
+			}
+			return next.Publish(ctx, evts...)
+		})
+	}
+}
+
+// validateEvent checks the envelope and, for registered topics, the payload
+func validateEvent(registry *Registry, event *Event) error {
+	if event == nil {
+		return errors.Wrap(ErrInvalidEvent, "event is nil")
+	}
+
+	switch {
+	case event.ID == "":
+		return errors.Wrapf(ErrInvalidEvent, "%s: missing id", eventTopic(event))
+	case event.AggregateID == "":
+		return errors.Wrapf(ErrInvalidEvent, "%s %s: missing aggregate id", eventTopic(event), event.ID)
+	case eventTopic(event) == "":
+		return errors.Wrapf(ErrInvalidEvent, "%s: missing topic", event.ID)
+	}
+
+	if registry == nil {
+		return nil
+	}
+	if _, ok := registry.Lookup(eventTopic(event)); !ok {
+		return nil
+	}
+
+	if _, err := registry.Decode(event); err != nil {
+		return errors.Wrap(ErrInvalidEvent, fmt.Sprintf("%s %s: %v", eventTopic(event), event.ID, err))
+	}
+
+	return nil
+}
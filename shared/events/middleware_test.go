@@ -0,0 +1,79 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type middlewareTestPayload struct {
+	PaymentID models.ID `json:"payment_id" validate:"required"`
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	registry := NewRegistry().MustRegister(PaymentCreatedEvent, middlewareTestPayload{})
+
+	tests := []struct {
+		name        string
+		event       *Event
+		expectedErr bool
+	}{
+		{
+			name:  "valid payload",
+			event: NewEvent("payment-1", PaymentCreatedEvent, middlewareTestPayload{PaymentID: "payment-1"}),
+		},
+		{
+			name:        "missing required field",
+			event:       NewEvent("payment-1", PaymentCreatedEvent, middlewareTestPayload{}),
+			expectedErr: true,
+		},
+		{
+			name:        "missing aggregate",
+			event:       NewEvent("", PaymentCreatedEvent, middlewareTestPayload{PaymentID: "payment-1"}),
+			expectedErr: true,
+		},
+		{
+			name:  "topic unknown to the registry",
+			event: NewEvent("wallet-1", WalletDebitedEvent, nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recordingPublisher{}
+			publisher := ChainPublisher(next, ValidationMiddleware(registry))
+
+			err := publisher.Publish(context.Background(), tt.event)
+
+			if tt.expectedErr {
+				assert.ErrorIs(t, err, ErrInvalidEvent)
+				assert.Empty(t, next.calls)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, next.calls, 1)
+		})
+	}
+}
+
+func TestChainPublisher(t *testing.T) {
+	var order []string
+	tag := func(name string) PublisherMiddleware {
+		return func(next Publisher) Publisher {
+			return PublisherFunc(func(ctx context.Context, evts ...*Event) error {
+				order = append(order, name)
+				return next.Publish(ctx, evts...)
+			})
+		}
+	}
+
+	next := &recordingPublisher{}
+	publisher := ChainPublisher(next, tag("outer"), tag("inner"))
+
+	require.NoError(t, publisher.Publish(context.Background(), NewEvent("payment-1", PaymentCreatedEvent, nil)))
+	assert.Equal(t, []string{"outer", "inner"}, order)
+	assert.Len(t, next.calls, 1)
+}
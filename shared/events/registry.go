@@ -0,0 +1,527 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownTopic       = errors.New("unknown topic")
+	ErrMissingField       = errors.New("missing required field")
+	ErrTopicRegistered    = errors.New("topic already registered")
+	ErrInvalidPayloadType = errors.New("payload type must be a struct")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	ErrInvalidConverter   = errors.New("invalid schema converter")
+)
+
+// requiredTag marks payload fields that must hold a non-zero value, e.g.
+// `json:"payment_id" validate:"required"`. See JSONSchema for the other
+// rules of the validate tag.
+const requiredTag = "required"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// PayloadConverter rewrites a JSON payload from one schema version to another
+type PayloadConverter func(payload json.RawMessage) (json.RawMessage, error)
+
+// Validator can be implemented by payload structs that need checks beyond
+// their JSON Schema
+type Validator interface {
+	Validate() error
+}
+
+// UnknownTopicError is returned when decoding an event whose topic has no
+// registered payload type
+type UnknownTopicError struct {
+	Topic Topic
+}
+
+func (e *UnknownTopicError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnknownTopic, e.Topic)
+}
+
+// Is allows errors.Is(err, ErrUnknownTopic)
+func (e *UnknownTopicError) Is(target error) bool {
+	return target == ErrUnknownTopic
+}
+
+// PayloadError is returned when an event payload does not match the shape
+// registered for its topic
+type PayloadError struct {
+	Topic Topic
+	// Field is the JSON path of the offending field, empty when the error
+	// concerns the payload as a whole
+	Field string
+	Err   error
+}
+
+func (e *PayloadError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s for topic %s: %v", ErrInvalidPayload, e.Topic, e.Err)
+	}
+	return fmt.Sprintf("%s for topic %s: field %s: %v", ErrInvalidPayload, e.Topic, e.Field, e.Err)
+}
+
+func (e *PayloadError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows errors.Is(err, ErrInvalidPayload)
+func (e *PayloadError) Is(target error) bool {
+	return target == ErrInvalidPayload
+}
+
+// Registry maps topics to the Go type of their payload. A topic can have
+// several schema versions; the highest registered one is current, and
+// payloads of older versions are brought up to it by upcasters on Decode.
+type Registry struct {
+	mux     sync.RWMutex
+	schemas map[Topic]*topicSchema
+}
+
+// topicSchema holds the schema versions registered for a topic
+type topicSchema struct {
+	current     string
+	types       map[string]reflect.Type
+	jsonSchemas map[string]*JSONSchema
+	upcasters   map[string]schemaConverter
+	downcasters map[string]schemaConverter
+}
+
+// schemaConverter converts a payload to version to
+type schemaConverter struct {
+	to string
+	fn PayloadConverter
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		schemas: make(map[Topic]*topicSchema),
+	}
+}
+
+// Register associates topic with the type of prototype at
+// DefaultSchemaVersion. prototype must be a struct value such as
+// domain.WalletDebitedData{}.
+func (r *Registry) Register(topic Topic, prototype interface{}) error {
+	return r.RegisterVersion(topic, DefaultSchemaVersion, prototype)
+}
+
+// RegisterVersion associates a schema version of topic with the type of
+// prototype and generates its JSON Schema. The highest registered version
+// becomes the current one.
+func (r *Registry) RegisterVersion(topic Topic, version string, prototype interface{}) error {
+	typ := reflect.TypeOf(prototype)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %s got %v", ErrInvalidPayloadType, topic, typ)
+	}
+
+	jsonSchema, err := NewJSONSchema(typ)
+	if err != nil {
+		return fmt.Errorf("%s@%s: %w", topic, version, err)
+	}
+	jsonSchema.Schema = JSONSchemaDialect
+	jsonSchema.ID = fmt.Sprintf("urn:events:%s:%s", topic, version)
+	jsonSchema.Title = string(topic)
+	jsonSchema.Description = typ.String()
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	schema := r.schemaFor(topic)
+	if existing, ok := schema.types[version]; ok {
+		return fmt.Errorf("%w: %s@%s is bound to %s", ErrTopicRegistered, topic, version, existing)
+	}
+
+	schema.types[version] = typ
+	schema.jsonSchemas[version] = jsonSchema
+	if schema.current == "" || CompareVersions(version, schema.current) > 0 {
+		schema.current = version
+	}
+
+	return nil
+}
+
+// RegisterUpcaster registers fn to convert payloads of topic from an older
+// schema version to a newer one. Chains such as 1.0 -> 2.0 -> 3.0 are
+// followed on Decode.
+func (r *Registry) RegisterUpcaster(topic Topic, from, to string, fn PayloadConverter) error {
+	if CompareVersions(from, to) >= 0 {
+		return fmt.Errorf("%w: upcaster for %s must go forward, got %s -> %s", ErrInvalidConverter, topic, from, to)
+	}
+
+	return r.registerConverter(topic, from, to, fn, func(s *topicSchema) map[string]schemaConverter {
+		return s.upcasters
+	})
+}
+
+// RegisterDowncaster registers fn to convert payloads of topic from a newer
+// schema version to an older one. It is used to dual-publish events while
+// consumers migrate.
+func (r *Registry) RegisterDowncaster(topic Topic, from, to string, fn PayloadConverter) error {
+	if CompareVersions(from, to) <= 0 {
+		return fmt.Errorf("%w: downcaster for %s must go backward, got %s -> %s", ErrInvalidConverter, topic, from, to)
+	}
+
+	return r.registerConverter(topic, from, to, fn, func(s *topicSchema) map[string]schemaConverter {
+		return s.downcasters
+	})
+}
+
+func (r *Registry) registerConverter(
+	topic Topic,
+	from, to string,
+	fn PayloadConverter,
+	converters func(*topicSchema) map[string]schemaConverter,
+) error {
+	if fn == nil {
+		return fmt.Errorf("%w: %s %s -> %s has no function", ErrInvalidConverter, topic, from, to)
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	byVersion := converters(r.schemaFor(topic))
+	if existing, ok := byVersion[from]; ok {
+		return fmt.Errorf("%w: %s already converts %s -> %s", ErrInvalidConverter, topic, from, existing.to)
+	}
+
+	byVersion[from] = schemaConverter{to: to, fn: fn}
+	return nil
+}
+
+// schemaFor returns the schema of topic, creating it if needed. The caller
+// must hold the write lock.
+func (r *Registry) schemaFor(topic Topic) *topicSchema {
+	schema, ok := r.schemas[topic]
+	if !ok {
+		schema = &topicSchema{
+			types:       make(map[string]reflect.Type),
+			jsonSchemas: make(map[string]*JSONSchema),
+			upcasters:   make(map[string]schemaConverter),
+			downcasters: make(map[string]schemaConverter),
+		}
+		r.schemas[topic] = schema
+	}
+	return schema
+}
+
+// MustRegister is like Register but panics on error. It is meant for
+// building registries at startup.
+func (r *Registry) MustRegister(topic Topic, prototype interface{}) *Registry {
+	if err := r.Register(topic, prototype); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// MustRegisterVersion is like RegisterVersion but panics on error
+func (r *Registry) MustRegisterVersion(topic Topic, version string, prototype interface{}) *Registry {
+	if err := r.RegisterVersion(topic, version, prototype); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// MustRegisterUpcaster is like RegisterUpcaster but panics on error
+func (r *Registry) MustRegisterUpcaster(topic Topic, from, to string, fn PayloadConverter) *Registry {
+	if err := r.RegisterUpcaster(topic, from, to, fn); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// MustRegisterDowncaster is like RegisterDowncaster but panics on error
+func (r *Registry) MustRegisterDowncaster(topic Topic, from, to string, fn PayloadConverter) *Registry {
+	if err := r.RegisterDowncaster(topic, from, to, fn); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Lookup returns the payload type of the current schema version of topic
+func (r *Registry) Lookup(topic Topic) (reflect.Type, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	schema, ok := r.schemas[topic]
+	if !ok || schema.current == "" {
+		return nil, false
+	}
+	return schema.types[schema.current], true
+}
+
+// CurrentVersion returns the current schema version of topic
+func (r *Registry) CurrentVersion(topic Topic) (string, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	schema, ok := r.schemas[topic]
+	if !ok || schema.current == "" {
+		return "", false
+	}
+	return schema.current, true
+}
+
+// Schema returns the JSON Schema of the current version of topic
+func (r *Registry) Schema(topic Topic) (*JSONSchema, bool) {
+	version, ok := r.CurrentVersion(topic)
+	if !ok {
+		return nil, false
+	}
+	return r.SchemaVersion(topic, version)
+}
+
+// SchemaVersion returns the JSON Schema of a version of topic
+func (r *Registry) SchemaVersion(topic Topic, version string) (*JSONSchema, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	schema, ok := r.schemas[topic]
+	if !ok {
+		return nil, false
+	}
+	jsonSchema, ok := schema.jsonSchemas[version]
+	return jsonSchema, ok
+}
+
+// Versions returns the registered schema versions of topic, oldest first
+func (r *Registry) Versions(topic Topic) []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	schema, ok := r.schemas[topic]
+	if !ok {
+		return nil
+	}
+
+	versions := make([]string, 0, len(schema.types))
+	for version := range schema.types {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) < 0
+	})
+	return versions
+}
+
+// Topics returns the registered topics in lexical order
+func (r *Registry) Topics() []Topic {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	topics := make([]Topic, 0, len(r.schemas))
+	for topic, schema := range r.schemas {
+		if schema.current != "" {
+			topics = append(topics, topic)
+		}
+	}
+
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i] < topics[j]
+	})
+
+	return topics
+}
+
+// Decode returns the event payload as a value of the type registered for the
+// current schema version of its topic, upcasting older payloads first, after
+// validating it against the JSON Schema of the topic and Validator
+func (r *Registry) Decode(event *Event) (interface{}, error) {
+	topic := eventTopic(event)
+
+	typ, ok := r.Lookup(topic)
+	if !ok {
+		return nil, &UnknownTopicError{Topic: topic}
+	}
+
+	if event.Data == nil {
+		return nil, &PayloadError{Topic: topic, Err: errors.New("payload is empty")}
+	}
+
+	current, _ := r.CurrentVersion(topic)
+	jsonSchema, _ := r.SchemaVersion(topic, current)
+
+	var raw json.RawMessage
+	if eventVersion(event) == current {
+		var err error
+		if raw, err = event.MarshalPayload(); err != nil {
+			return nil, &PayloadError{Topic: topic, Err: err}
+		}
+	} else {
+		var err error
+		if raw, err = r.convert(topic, event, current); err != nil {
+			return nil, err
+		}
+	}
+
+	if field, err := jsonSchema.Validate(raw); err != nil {
+		return nil, &PayloadError{Topic: topic, Field: field, Err: err}
+	}
+
+	target := reflect.New(typ)
+	if err := json.Unmarshal(raw, target.Interface()); err != nil {
+		return nil, newShapeError(topic, err)
+	}
+
+	payload := target.Elem().Interface()
+	if v, ok := target.Interface().(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, &PayloadError{Topic: topic, Err: err}
+		}
+	}
+
+	return payload, nil
+}
+
+// Convert returns a copy of event whose payload has been converted to the
+// given schema version with the registered upcasters or downcasters. The copy
+// keeps the event ID so idempotent consumers process only one version.
+func (r *Registry) Convert(event *Event, version string) (*Event, error) {
+	topic := eventTopic(event)
+	if _, ok := r.Lookup(topic); !ok {
+		return nil, &UnknownTopicError{Topic: topic}
+	}
+
+	raw, err := r.convert(topic, event, version)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := event.Clone()
+	converted.Version = version
+	converted.Data = raw
+	return converted, nil
+}
+
+// convert walks the converter chain from the event version to version and
+// returns the resulting JSON payload
+func (r *Registry) convert(topic Topic, event *Event, version string) (json.RawMessage, error) {
+	raw, err := event.MarshalPayload()
+	if err != nil {
+		return nil, &PayloadError{Topic: topic, Err: err}
+	}
+
+	r.mux.RLock()
+	schema := r.schemas[topic]
+	r.mux.RUnlock()
+
+	from := eventVersion(event)
+	direction := CompareVersions(version, from)
+	converters := schema.upcasters
+	if direction < 0 {
+		converters = schema.downcasters
+	}
+
+	for from != version {
+		step, ok := converters[from]
+		if !ok || CompareVersions(version, step.to)*direction < 0 {
+			return nil, &PayloadError{
+				Topic: topic,
+				Err:   fmt.Errorf("%w: no conversion from %s to %s", ErrUnsupportedVersion, from, version),
+			}
+		}
+
+		raw, err = step.fn(raw)
+		if err != nil {
+			return nil, &PayloadError{
+				Topic: topic,
+				Err:   fmt.Errorf("converting %s to %s: %w", from, step.to, err),
+			}
+		}
+		from = step.to
+	}
+
+	return raw, nil
+}
+
+// eventTopic returns the topic of event, falling back to its type
+func eventTopic(event *Event) Topic {
+	if event.Topic == "" {
+		return Topic(event.EventType)
+	}
+	return event.Topic
+}
+
+// eventVersion returns the schema version of event, treating an empty
+// version as DefaultSchemaVersion
+func eventVersion(event *Event) string {
+	if event.Version == "" {
+		return DefaultSchemaVersion
+	}
+	return event.Version
+}
+
+// CompareVersions compares dotted schema versions such as "1.0" and "2.1"
+// numerically, returning -1, 0 or 1. Non-numeric segments compare lexically.
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		xn, xErr := strconv.Atoi(x)
+		yn, yErr := strconv.Atoi(y)
+		switch {
+		case xErr == nil && yErr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (xErr != nil || yErr != nil) && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// DecodePayload decodes the event payload with the registry and returns it as T
+func DecodePayload[T any](r *Registry, event *Event) (T, error) {
+	var zero T
+
+	payload, err := r.Decode(event)
+	if err != nil {
+		return zero, err
+	}
+
+	typed, ok := payload.(T)
+	if !ok {
+		return zero, &PayloadError{
+			Topic: eventTopic(event),
+			Err:   fmt.Errorf("registered type %T does not match requested type %T", payload, zero),
+		}
+	}
+
+	return typed, nil
+}
+
+// newShapeError converts a JSON decoding error into a PayloadError
+func newShapeError(topic Topic, err error) *PayloadError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &PayloadError{
+			Topic: topic,
+			Field: typeErr.Field,
+			Err:   fmt.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		}
+	}
+
+	return &PayloadError{Topic: topic, Err: err}
+}
@@ -0,0 +1,356 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const registryTestTopic = "registry.test"
+
+type registryTestMoney struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency" validate:"required"`
+}
+
+type registryTestPayload struct {
+	PaymentID models.ID         `json:"payment_id" validate:"required"`
+	Amount    registryTestMoney `json:"amount"`
+	Note      string            `json:"note,omitempty"`
+}
+
+type validatedTestPayload struct {
+	Amount int64 `json:"amount"`
+}
+
+func (p *validatedTestPayload) Validate() error {
+	if p.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+func newTestRegistry() *Registry {
+	return NewRegistry().
+		MustRegister(registryTestTopic, registryTestPayload{}).
+		MustRegister("registry.validated", validatedTestPayload{})
+}
+
+func TestRegistry_Decode(t *testing.T) {
+	tests := []struct {
+		name          string
+		event         *Event
+		expectedError error
+		expectedField string
+		validate      func(t *testing.T, payload interface{})
+	}{
+		{
+			name:  "json payload keeps int64 precision",
+			event: NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","amount":{"amount":9007199254740993,"currency":"USD"}}`)),
+			validate: func(t *testing.T, payload interface{}) {
+				typed, ok := payload.(registryTestPayload)
+				require.True(t, ok)
+				assert.Equal(t, models.ID("p-1"), typed.PaymentID)
+				assert.Equal(t, int64(9007199254740993), typed.Amount.Amount)
+			},
+		},
+		{
+			name: "typed payload",
+			event: NewEvent("agg", registryTestTopic, registryTestPayload{
+				PaymentID: "p-1",
+				Amount:    registryTestMoney{Amount: 100, Currency: "USD"},
+			}),
+			validate: func(t *testing.T, payload interface{}) {
+				assert.Equal(t, models.ID("p-1"), payload.(registryTestPayload).PaymentID)
+			},
+		},
+		{
+			name: "map payload",
+			event: NewEvent("agg", registryTestTopic, map[string]interface{}{
+				"payment_id": "p-1",
+				"amount":     map[string]interface{}{"amount": 100, "currency": "USD"},
+			}),
+			validate: func(t *testing.T, payload interface{}) {
+				assert.Equal(t, int64(100), payload.(registryTestPayload).Amount.Amount)
+			},
+		},
+		{
+			name:          "unknown topic",
+			event:         NewEvent("agg", "registry.unknown", json.RawMessage(`{}`)),
+			expectedError: ErrUnknownTopic,
+		},
+		{
+			name:          "missing required field",
+			event:         NewEvent("agg", registryTestTopic, json.RawMessage(`{"amount":{"amount":1,"currency":"USD"}}`)),
+			expectedError: ErrMissingField,
+			expectedField: "payment_id",
+		},
+		{
+			name:          "missing nested required field",
+			event:         NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","amount":{"amount":1}}`)),
+			expectedError: ErrMissingField,
+			expectedField: "amount.currency",
+		},
+		{
+			name:          "empty nested required field",
+			event:         NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","amount":{"amount":1,"currency":""}}`)),
+			expectedError: ErrMissingField,
+			expectedField: "amount.currency",
+		},
+		{
+			name:          "type mismatch",
+			event:         NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","amount":{"amount":"100","currency":"USD"}}`)),
+			expectedError: ErrInvalidPayload,
+			expectedField: "amount.amount",
+		},
+		{
+			name:          "empty payload",
+			event:         NewEvent("agg", registryTestTopic, nil),
+			expectedError: ErrInvalidPayload,
+		},
+		{
+			name:          "validator rejects payload",
+			event:         NewEvent("agg", "registry.validated", json.RawMessage(`{"amount":0}`)),
+			expectedError: ErrInvalidPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := newTestRegistry().Decode(tt.event)
+
+			if tt.expectedError != nil {
+				assert.True(t, errors.Is(err, tt.expectedError), "unexpected error: %v", err)
+				assert.Nil(t, payload)
+
+				var payloadErr *PayloadError
+				if errors.As(err, &payloadErr) {
+					assert.Equal(t, tt.expectedField, payloadErr.Field)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			tt.validate(t, payload)
+		})
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	tests := []struct {
+		name          string
+		prototype     interface{}
+		expectedError error
+	}{
+		{
+			name:      "struct prototype",
+			prototype: validatedTestPayload{},
+		},
+		{
+			name:          "duplicate topic",
+			prototype:     registryTestPayload{},
+			expectedError: ErrTopicRegistered,
+		},
+		{
+			name:          "pointer prototype",
+			prototype:     &registryTestPayload{},
+			expectedError: ErrInvalidPayloadType,
+		},
+		{
+			name:          "nil prototype",
+			prototype:     nil,
+			expectedError: ErrInvalidPayloadType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry().MustRegister(registryTestTopic, registryTestPayload{})
+
+			topic := Topic(registryTestTopic)
+			if tt.expectedError != ErrTopicRegistered {
+				topic = "registry.other"
+			}
+
+			err := registry.Register(topic, tt.prototype)
+			if tt.expectedError != nil {
+				assert.True(t, errors.Is(err, tt.expectedError), "unexpected error: %v", err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, []Topic{"registry.other", registryTestTopic}, registry.Topics())
+		})
+	}
+}
+
+func TestRegistry_Schema(t *testing.T) {
+	registry := newTestRegistry().MustRegisterVersion(registryTestTopic, "2.0", versionedTestPayload{})
+
+	schema, ok := registry.Schema(registryTestTopic)
+	require.True(t, ok)
+	assert.Equal(t, JSONSchemaDialect, schema.Schema)
+	assert.Equal(t, "urn:events:registry.test:2.0", schema.ID)
+	assert.Equal(t, registryTestTopic, schema.Title)
+	assert.Equal(t, []string{"payment_id"}, schema.Required)
+	assert.Contains(t, schema.Properties, "method")
+
+	assert.Equal(t, []string{"1.0", "2.0"}, registry.Versions(registryTestTopic))
+
+	schema, ok = registry.SchemaVersion(registryTestTopic, "1.0")
+	require.True(t, ok)
+	assert.Contains(t, schema.Properties, "amount")
+
+	_, ok = registry.Schema("registry.unknown")
+	assert.False(t, ok)
+}
+
+func TestDecodePayload(t *testing.T) {
+	registry := newTestRegistry()
+	event := NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","amount":{"amount":1,"currency":"USD"}}`))
+
+	payload, err := DecodePayload[registryTestPayload](registry, event)
+	require.NoError(t, err)
+	assert.Equal(t, models.ID("p-1"), payload.PaymentID)
+
+	_, err = DecodePayload[validatedTestPayload](registry, event)
+	assert.True(t, errors.Is(err, ErrInvalidPayload))
+}
+
+type versionedTestPayload struct {
+	PaymentID string `json:"payment_id" validate:"required"`
+	Method    struct {
+		Type     string `json:"type"`
+		WalletID string `json:"wallet_id"`
+	} `json:"method"`
+}
+
+// renameField returns a converter that moves a top-level JSON field
+func renameField(from, to string) PayloadConverter {
+	return func(payload json.RawMessage) (json.RawMessage, error) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			return nil, err
+		}
+		fields[to] = fields[from]
+		delete(fields, from)
+		return json.Marshal(fields)
+	}
+}
+
+func newVersionedTestRegistry() *Registry {
+	return NewRegistry().
+		MustRegisterVersion(registryTestTopic, "3.0", versionedTestPayload{}).
+		MustRegisterUpcaster(registryTestTopic, "1.0", "2.0", renameField("id", "payment_id")).
+		MustRegisterUpcaster(registryTestTopic, "2.0", "3.0", renameField("payment_method", "method")).
+		MustRegisterDowncaster(registryTestTopic, "3.0", "2.0", renameField("method", "payment_method"))
+}
+
+func TestRegistry_DecodeVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       string
+		payload       string
+		expectedError error
+	}{
+		{
+			name:    "current version",
+			version: "3.0",
+			payload: `{"payment_id":"p-1","method":{"type":"wallet","wallet_id":"w-1"}}`,
+		},
+		{
+			name:    "one step upcast",
+			version: "2.0",
+			payload: `{"payment_id":"p-1","payment_method":{"type":"wallet","wallet_id":"w-1"}}`,
+		},
+		{
+			name:    "chained upcast from empty version",
+			version: "",
+			payload: `{"id":"p-1","payment_method":{"type":"wallet","wallet_id":"w-1"}}`,
+		},
+		{
+			name:          "newer than current",
+			version:       "4.0",
+			payload:       `{"payment_id":"p-1"}`,
+			expectedError: ErrUnsupportedVersion,
+		},
+		{
+			name:          "upcast result is validated",
+			version:       "2.0",
+			payload:       `{"payment_method":{"type":"wallet"}}`,
+			expectedError: ErrMissingField,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := NewEvent("agg", registryTestTopic, json.RawMessage(tt.payload)).WithVersion(tt.version)
+
+			payload, err := DecodePayload[versionedTestPayload](newVersionedTestRegistry(), event)
+
+			if tt.expectedError != nil {
+				assert.True(t, errors.Is(err, tt.expectedError), "unexpected error: %v", err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "p-1", payload.PaymentID)
+			assert.Equal(t, "w-1", payload.Method.WalletID)
+		})
+	}
+}
+
+func TestRegistry_Convert(t *testing.T) {
+	registry := newVersionedTestRegistry()
+	event := NewEvent("agg", registryTestTopic, json.RawMessage(`{"payment_id":"p-1","method":{"type":"wallet"}}`)).
+		WithVersion("3.0")
+
+	legacy, err := registry.Convert(event, "2.0")
+	require.NoError(t, err)
+	assert.Equal(t, event.ID, legacy.ID)
+	assert.Equal(t, "2.0", legacy.Version)
+	assert.Equal(t, "3.0", event.Version)
+
+	raw, err := legacy.MarshalPayload()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"payment_id":"p-1","payment_method":{"type":"wallet"}}`, string(raw))
+
+	_, err = registry.Convert(event, "1.0")
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
+
+func TestRegistry_RegisterConverter(t *testing.T) {
+	registry := newVersionedTestRegistry()
+
+	assert.True(t, errors.Is(registry.RegisterUpcaster(registryTestTopic, "2.0", "1.0", renameField("a", "b")), ErrInvalidConverter))
+	assert.True(t, errors.Is(registry.RegisterDowncaster(registryTestTopic, "1.0", "2.0", renameField("a", "b")), ErrInvalidConverter))
+	assert.True(t, errors.Is(registry.RegisterUpcaster(registryTestTopic, "1.0", "3.0", renameField("a", "b")), ErrInvalidConverter))
+
+	current, ok := registry.CurrentVersion(registryTestTopic)
+	require.True(t, ok)
+	assert.Equal(t, "3.0", current)
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{a: "1.0", b: "1.0", expected: 0},
+		{a: "1", b: "1.0", expected: 0},
+		{a: "1.0", b: "2.0", expected: -1},
+		{a: "10.0", b: "9.0", expected: 1},
+		{a: "1.10", b: "1.9", expected: 1},
+		{a: "1.0-beta", b: "1.0-alpha", expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.expected, CompareVersions(tt.a, tt.b))
+		})
+	}
+}
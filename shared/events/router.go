@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+var _ EventHandler = (*Router)(nil)
+
+// EventHandlerFunc adapts a function to the EventHandler interface
+type EventHandlerFunc func(ctx context.Context, event *Event) error
+
+// Handle calls f(ctx, event)
+func (f EventHandlerFunc) Handle(ctx context.Context, event *Event) error {
+	return f(ctx, event)
+}
+
+// route binds a handler to a topic pattern and a metadata filter
+type route struct {
+	pattern Topic
+	filter  Metadata
+	handler EventHandler
+}
+
+// RouteError describes a handler that failed while routing an event
+type RouteError struct {
+	Pattern Topic
+	Err     error
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("handler for %s: %v", e.Pattern, e.Err)
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned in place of a handler that panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// RoutingError is returned by Router.Handle when one or more handlers fail.
+// The remaining handlers still run.
+type RoutingError struct {
+	Topic  Topic
+	Errors []*RouteError
+}
+
+func (e *RoutingError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d handler(s) failed for %s: %s", len(e.Errors), e.Topic, strings.Join(messages, "; "))
+}
+
+// Unwrap allows errors.Is and errors.As to inspect each handler error
+func (e *RoutingError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Router dispatches events to every handler whose topic pattern and metadata
+// filter match the event (see Event.Matches). Handlers run sequentially in
+// registration order; a failing or panicking handler does not stop the
+// others.
+type Router struct {
+	id     string
+	mux    sync.RWMutex
+	routes []route
+}
+
+// NewRouter creates an empty Router identified by id
+func NewRouter(id string) *Router {
+	return &Router{id: id}
+}
+
+// HandlerID returns the router identifier
+func (r *Router) HandlerID() string {
+	return r.id
+}
+
+// Register routes events whose topic matches pattern to handler
+func (r *Router) Register(pattern Topic, handler EventHandler) *Router {
+	return r.RegisterFiltered(pattern, nil, handler)
+}
+
+// RegisterFunc is like Register for a plain function
+func (r *Router) RegisterFunc(pattern Topic, fn func(ctx context.Context, event *Event) error) *Router {
+	return r.Register(pattern, EventHandlerFunc(fn))
+}
+
+// RegisterFiltered routes events whose topic matches pattern and whose
+// metadata contains every key/value of filter to handler
+func (r *Router) RegisterFiltered(pattern Topic, filter Metadata, handler EventHandler) *Router {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.routes = append(r.routes, route{
+		pattern: pattern,
+		filter:  filter.Clone(),
+		handler: handler,
+	})
+	return r
+}
+
+// Topics returns the registered topic patterns in registration order,
+// without duplicates
+func (r *Router) Topics() []Topic {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	seen := make(map[Topic]bool, len(r.routes))
+	var topics []Topic
+	for _, rt := range r.routes {
+		if !seen[rt.pattern] {
+			seen[rt.pattern] = true
+			topics = append(topics, rt.pattern)
+		}
+	}
+	return topics
+}
+
+// HasRoute reports whether any handler matches the event
+func (r *Router) HasRoute(event *Event) bool {
+	return len(r.match(event)) > 0
+}
+
+// Handle runs every matching handler. Events without a match are ignored.
+func (r *Router) Handle(ctx context.Context, event *Event) error {
+	var failures []*RouteError
+	for _, rt := range r.match(event) {
+		if err := r.dispatch(ctx, rt, event); err != nil {
+			failures = append(failures, &RouteError{Pattern: rt.pattern, Err: err})
+		}
+	}
+
+	if len(failures) > 0 {
+		return &RoutingError{Topic: eventTopic(event), Errors: failures}
+	}
+
+	return nil
+}
+
+// match returns the matching routes in registration order
+func (r *Router) match(event *Event) []route {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	topic := eventTopic(event)
+
+	var matched []route
+	for _, rt := range r.routes {
+		if topic.Matches(rt.pattern) && event.Metadata.Matches(rt.filter) {
+			matched = append(matched, rt)
+		}
+	}
+	return matched
+}
+
+// dispatch calls the route handler, converting a panic into a *PanicError
+func (r *Router) dispatch(ctx context.Context, rt route, event *Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+
+	return rt.handler.Handle(ctx, event)
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordingHandler(name string, calls *[]string, err error) EventHandlerFunc {
+	return func(ctx context.Context, event *Event) error {
+		*calls = append(*calls, name)
+		return err
+	}
+}
+
+func TestRouter_Handle(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name          string
+		event         func() *Event
+		register      func(r *Router, calls *[]string)
+		expectedCalls []string
+		expectedError error
+	}{
+		{
+			name:  "fans out to every matching handler in registration order",
+			event: func() *Event { return NewEvent("agg", "payment.refund.failed", nil) },
+			register: func(r *Router, calls *[]string) {
+				r.Register("payment.#", recordingHandler("all-payments", calls, nil)).
+					Register("wallet.#", recordingHandler("wallet", calls, nil)).
+					Register("payment.#.failed", recordingHandler("failures", calls, nil)).
+					Register("payment.refund.failed", recordingHandler("exact", calls, nil))
+			},
+			expectedCalls: []string{"all-payments", "failures", "exact"},
+		},
+		{
+			name: "applies metadata filters",
+			event: func() *Event {
+				return NewEvent("agg", "payment.created", nil).WithMetadata("provider", "stripe")
+			},
+			register: func(r *Router, calls *[]string) {
+				r.RegisterFiltered("payment.*", Metadata{"provider": "stripe"}, recordingHandler("stripe", calls, nil)).
+					RegisterFiltered("payment.*", Metadata{"provider": "paypal"}, recordingHandler("paypal", calls, nil))
+			},
+			expectedCalls: []string{"stripe"},
+		},
+		{
+			name:  "falls back to event type",
+			event: func() *Event { return &Event{EventType: "wallet.debited"} },
+			register: func(r *Router, calls *[]string) {
+				r.Register("wallet.*", recordingHandler("wallet", calls, nil))
+			},
+			expectedCalls: []string{"wallet"},
+		},
+		{
+			name:  "ignores unmatched events",
+			event: func() *Event { return NewEvent("agg", "saga.started", nil) },
+			register: func(r *Router, calls *[]string) {
+				r.Register("payment.#", recordingHandler("payment", calls, nil))
+			},
+		},
+		{
+			name:  "isolates failing handlers",
+			event: func() *Event { return NewEvent("agg", "payment.created", nil) },
+			register: func(r *Router, calls *[]string) {
+				r.Register("payment.created", recordingHandler("failing", calls, errBoom)).
+					RegisterFunc("payment.created", func(ctx context.Context, event *Event) error {
+						*calls = append(*calls, "panicking")
+						panic("unexpected payload")
+					}).
+					Register("payment.created", recordingHandler("healthy", calls, nil))
+			},
+			expectedCalls: []string{"failing", "panicking", "healthy"},
+			expectedError: errBoom,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			router := NewRouter("test-router")
+			tt.register(router, &calls)
+
+			err := router.Handle(context.Background(), tt.event())
+			assert.Equal(t, tt.expectedCalls, calls)
+
+			if tt.expectedError != nil {
+				assert.True(t, errors.Is(err, tt.expectedError))
+
+				var routingErr *RoutingError
+				require.True(t, errors.As(err, &routingErr))
+				assert.Len(t, routingErr.Errors, 2)
+
+				var panicErr *PanicError
+				assert.True(t, errors.As(err, &panicErr), "a panic is returned as *PanicError")
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRouter_Topics(t *testing.T) {
+	noop := func(ctx context.Context, event *Event) error { return nil }
+
+	router := NewRouter("test").
+		RegisterFunc(PaymentCreatedEvent, noop).
+		RegisterFunc("wallet.#", noop).
+		RegisterFiltered(PaymentCreatedEvent, Metadata{"source": "api"}, EventHandlerFunc(noop))
+
+	assert.Equal(t, []Topic{PaymentCreatedEvent, "wallet.#"}, router.Topics())
+}
@@ -0,0 +1,502 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// JSONSchemaDialect is the JSON Schema draft the generated schemas follow
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// ErrInvalidValidateTag is returned when registering a payload whose
+// validate tags contain an unknown rule
+var ErrInvalidValidateTag = errors.New("invalid validate tag")
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// JSONSchema is the subset of JSON Schema generated from payload structs.
+// Properties follow the json tags, and the validate tag adds constraints:
+//
+//	required      the property must be present and non-zero
+//	len=3         strings of exactly 3 characters
+//	min=1, max=9  string length or numeric bounds
+//	oneof=a b c   one of the listed strings
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 SchemaType             `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Not                  *JSONSchema            `json:"not,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+// SchemaType lists the JSON types a value may have. It is written as a
+// single string when there is only one.
+type SchemaType []string
+
+// MarshalJSON implements json.Marshaler
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *SchemaType) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = SchemaType{single}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// NewJSONSchema generates the schema of the JSON encoding of typ
+func NewJSONSchema(typ reflect.Type) (*JSONSchema, error) {
+	b := &schemaBuilder{visiting: make(map[reflect.Type]bool)}
+	return b.build(typ)
+}
+
+// schemaBuilder walks a Go type, guarding against recursive types
+type schemaBuilder struct {
+	visiting map[reflect.Type]bool
+}
+
+func (b *schemaBuilder) build(typ reflect.Type) (*JSONSchema, error) {
+	nullable := false
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+		nullable = true
+	}
+
+	var schema *JSONSchema
+	switch {
+	case typ == timeType:
+		schema = &JSONSchema{Type: SchemaType{"string"}, Format: "date-time"}
+	case typ == rawMessageType:
+		// Any JSON value
+		return &JSONSchema{}, nil
+	default:
+		var err error
+		if schema, err = b.buildKind(typ); err != nil {
+			return nil, err
+		}
+	}
+
+	if nullable && len(schema.Type) > 0 {
+		schema.Type = append(schema.Type, "null")
+	}
+	return schema, nil
+}
+
+func (b *schemaBuilder) buildKind(typ reflect.Type) (*JSONSchema, error) {
+	switch typ.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: SchemaType{"string"}}, nil
+	case reflect.Bool:
+		return &JSONSchema{Type: SchemaType{"boolean"}}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &JSONSchema{Type: SchemaType{"integer"}}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: SchemaType{"integer"}, Minimum: float64Ptr(0)}, nil
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: SchemaType{"number"}}, nil
+	case reflect.Interface:
+		return &JSONSchema{}, nil
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes byte slices as base64 strings
+			return &JSONSchema{Type: SchemaType{"string"}}, nil
+		}
+		items, err := b.build(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		schema := &JSONSchema{Type: SchemaType{"array"}, Items: items}
+		if typ.Kind() == reflect.Slice {
+			schema.Type = append(schema.Type, "null")
+		}
+		return schema, nil
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%w: map key %s is not a string", ErrInvalidPayloadType, typ.Key())
+		}
+		values, err := b.build(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: SchemaType{"object", "null"}, AdditionalProperties: values}, nil
+	case reflect.Struct:
+		return b.object(typ)
+	default:
+		return nil, fmt.Errorf("%w: %s cannot be encoded as JSON", ErrInvalidPayloadType, typ)
+	}
+}
+
+// object builds the schema of a struct. A type that contains itself accepts
+// any object below the first level.
+func (b *schemaBuilder) object(typ reflect.Type) (*JSONSchema, error) {
+	if b.visiting[typ] {
+		return &JSONSchema{Type: SchemaType{"object"}}, nil
+	}
+	b.visiting[typ] = true
+	defer delete(b.visiting, typ)
+
+	schema := &JSONSchema{
+		Type:       SchemaType{"object"},
+		Properties: make(map[string]*JSONSchema),
+	}
+	if err := b.fields(typ, schema, true); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// fields adds the properties of the fields of typ to schema. Fields of
+// embedded structs are promoted like encoding/json does; those of embedded
+// pointers are never required since a nil pointer omits them.
+func (b *schemaBuilder) fields(typ reflect.Type, schema *JSONSchema, canRequire bool) error {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct && embedded != timeType {
+				if err := b.fields(embedded, schema, canRequire && field.Type.Kind() != reflect.Ptr); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := b.build(field.Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+
+		required, err := applyValidateTag(property, field.Tag.Get("validate"))
+		if err != nil {
+			return fmt.Errorf("field %s of %s: %w", field.Name, typ, err)
+		}
+		if required && canRequire {
+			schema.Required = append(schema.Required, name)
+		}
+
+		schema.Properties[name] = property
+	}
+
+	return nil
+}
+
+// applyValidateTag adds the constraints of a validate tag to schema and
+// reports whether the field is required. Required strings must not be empty
+// and required numbers must not be zero, as a missing field decodes to its
+// zero value.
+func applyValidateTag(schema *JSONSchema, tag string) (bool, error) {
+	if tag == "" {
+		return false, nil
+	}
+
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case requiredTag:
+			required = true
+		case "len", "min", "max":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return false, fmt.Errorf("%w: %q needs a number", ErrInvalidValidateTag, rule)
+			}
+			applyBound(schema, key, n)
+		case "oneof":
+			for _, option := range strings.Fields(value) {
+				schema.Enum = append(schema.Enum, option)
+			}
+		default:
+			return false, fmt.Errorf("%w: unknown rule %q", ErrInvalidValidateTag, rule)
+		}
+	}
+
+	if required {
+		switch schema.Type.primary() {
+		case "string":
+			if schema.MinLength == nil || *schema.MinLength < 1 {
+				schema.MinLength = intPtr(1)
+			}
+		case "integer", "number":
+			schema.Not = &JSONSchema{Enum: []interface{}{0}}
+		}
+	}
+
+	return required, nil
+}
+
+// applyBound sets the length or value bound named key to n
+func applyBound(schema *JSONSchema, key string, n int) {
+	if schema.Type.primary() == "string" {
+		if key == "len" || key == "min" {
+			schema.MinLength = intPtr(n)
+		}
+		if key == "len" || key == "max" {
+			schema.MaxLength = intPtr(n)
+		}
+		return
+	}
+
+	if key == "len" || key == "min" {
+		schema.Minimum = float64Ptr(float64(n))
+	}
+	if key == "len" || key == "max" {
+		schema.Maximum = float64Ptr(float64(n))
+	}
+}
+
+// primary returns the first type, ignoring "null"
+func (t SchemaType) primary() string {
+	for _, typ := range t {
+		if typ != "null" {
+			return typ
+		}
+	}
+	return ""
+}
+
+// Validate checks the JSON document doc against the schema. It returns the
+// JSON path of the first offending value, empty for the document itself.
+// Required properties holding a zero value are reported as ErrMissingField.
+func (s *JSONSchema) Validate(doc []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+
+	return s.validate(value, "")
+}
+
+func (s *JSONSchema) validate(value interface{}, path string) (string, error) {
+	actual := jsonType(value)
+	if len(s.Type) > 0 && !s.Type.accepts(actual) {
+		return path, fmt.Errorf("expected %s, got %s", strings.Join(s.Type, " or "), actual)
+	}
+
+	if len(s.Enum) > 0 && !containsJSON(s.Enum, value) {
+		return path, fmt.Errorf("must be one of %s", formatJSON(s.Enum))
+	}
+	if s.Not != nil {
+		if _, err := s.Not.validate(value, path); err == nil {
+			return path, fmt.Errorf("must not be %s", formatJSON(s.Not.Enum))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return s.validateString(v, path)
+	case json.Number:
+		return s.validateNumber(v, path)
+	case []interface{}:
+		if s.Items == nil {
+			return "", nil
+		}
+		for i, item := range v {
+			if field, err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return field, err
+			}
+		}
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	}
+
+	return "", nil
+}
+
+func (s *JSONSchema) validateString(value, path string) (string, error) {
+	length := utf8.RuneCountInString(value)
+	if s.MinLength != nil && length < *s.MinLength {
+		return path, fmt.Errorf("must be at least %d characters, got %q", *s.MinLength, value)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return path, fmt.Errorf("must be at most %d characters, got %q", *s.MaxLength, value)
+	}
+	if s.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+			return path, fmt.Errorf("must be an RFC 3339 date-time, got %q", value)
+		}
+	}
+	return "", nil
+}
+
+func (s *JSONSchema) validateNumber(value json.Number, path string) (string, error) {
+	n, ok := new(big.Float).SetString(value.String())
+	if !ok {
+		return path, fmt.Errorf("invalid number %s", value)
+	}
+	if s.Minimum != nil && n.Cmp(big.NewFloat(*s.Minimum)) < 0 {
+		return path, fmt.Errorf("must be at least %v, got %s", *s.Minimum, value)
+	}
+	if s.Maximum != nil && n.Cmp(big.NewFloat(*s.Maximum)) > 0 {
+		return path, fmt.Errorf("must be at most %v, got %s", *s.Maximum, value)
+	}
+	return "", nil
+}
+
+func (s *JSONSchema) validateObject(value map[string]interface{}, path string) (string, error) {
+	for _, name := range s.Required {
+		if v, ok := value[name]; !ok || isZeroJSON(v) {
+			return joinPath(path, name), ErrMissingField
+		}
+	}
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := s.Properties[name]
+		if !ok {
+			property = s.AdditionalProperties
+		}
+		if property == nil {
+			continue
+		}
+		if field, err := property.validate(value[name], joinPath(path, name)); err != nil {
+			return field, err
+		}
+	}
+
+	return "", nil
+}
+
+// accepts reports whether a value of JSON type actual is allowed
+func (t SchemaType) accepts(actual string) bool {
+	for _, typ := range t {
+		if typ == actual || (typ == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a value decoded with UseNumber
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if n, ok := new(big.Float).SetString(v.String()); ok && n.IsInt() {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// isZeroJSON reports whether value decodes to the zero value of a scalar
+func isZeroJSON(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case json.Number:
+		n, ok := new(big.Float).SetString(v.String())
+		return ok && n.Sign() == 0
+	default:
+		return false
+	}
+}
+
+// containsJSON reports whether value equals one of options once encoded
+func containsJSON(options []interface{}, value interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		for _, option := range options {
+			if f, ok := new(big.Float).SetString(fmt.Sprint(option)); ok {
+				if v, ok := new(big.Float).SetString(n.String()); ok && f.Cmp(v) == 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, option := range options {
+		if option == value {
+			return true
+		}
+	}
+	return false
+}
+
+func formatJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// joinPath appends the property name to a JSON path
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,229 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type schemaTestReference struct {
+	Type string `json:"type" validate:"oneof=payment refund"`
+	ID   string `json:"id"`
+}
+
+type schemaTestEmbedded struct {
+	Source string `json:"source" validate:"required"`
+}
+
+type schemaTestOptional struct {
+	Note string `json:"note" validate:"required"`
+}
+
+type schemaTestPayload struct {
+	schemaTestEmbedded
+	*schemaTestOptional
+	WalletID  models.ID              `json:"wallet_id" validate:"required"`
+	Amount    models.Money           `json:"amount"`
+	Attempts  int                    `json:"attempts" validate:"required,max=5"`
+	Reference *schemaTestReference   `json:"reference,omitempty"`
+	Tags      []string               `json:"tags"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	Ignored   string                 `json:"-"`
+	internal  string
+}
+
+func TestNewJSONSchema(t *testing.T) {
+	schema, err := NewJSONSchema(reflect.TypeOf(schemaTestPayload{}))
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(schema)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"type": "object",
+		"properties": {
+			"source": {"type": "string", "minLength": 1},
+			"note": {"type": "string", "minLength": 1},
+			"wallet_id": {"type": "string", "minLength": 1},
+			"amount": {
+				"type": "object",
+				"properties": {
+					"amount": {"type": "integer"},
+					"currency": {"type": "string", "minLength": 3, "maxLength": 3}
+				},
+				"required": ["currency"]
+			},
+			"attempts": {"type": "integer", "maximum": 5, "not": {"enum": [0]}},
+			"reference": {
+				"type": ["object", "null"],
+				"properties": {
+					"type": {"type": "string", "enum": ["payment", "refund"]},
+					"id": {"type": "string"}
+				}
+			},
+			"tags": {"type": ["array", "null"], "items": {"type": "string"}},
+			"metadata": {"type": ["object", "null"], "additionalProperties": {}},
+			"created_at": {"type": "string", "format": "date-time"}
+		},
+		"required": ["source", "wallet_id", "attempts"]
+	}`, string(raw))
+}
+
+func TestNewJSONSchema_InvalidTypes(t *testing.T) {
+	tests := []struct {
+		name          string
+		prototype     interface{}
+		expectedError error
+	}{
+		{
+			name: "unknown validate rule",
+			prototype: struct {
+				ID string `json:"id" validate:"requird"`
+			}{},
+			expectedError: ErrInvalidValidateTag,
+		},
+		{
+			name: "bound without a number",
+			prototype: struct {
+				ID string `json:"id" validate:"len=three"`
+			}{},
+			expectedError: ErrInvalidValidateTag,
+		},
+		{
+			name: "field that cannot be encoded",
+			prototype: struct {
+				Done chan bool `json:"done"`
+			}{},
+			expectedError: ErrInvalidPayloadType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJSONSchema(reflect.TypeOf(tt.prototype))
+			assert.True(t, errors.Is(err, tt.expectedError), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema, err := NewJSONSchema(reflect.TypeOf(schemaTestPayload{}))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		doc           string
+		invalid       bool
+		expectedField string
+		expectedError error
+	}{
+		{
+			name: "valid document",
+			doc: `{"source":"wallet","wallet_id":"w-1","amount":{"amount":100,"currency":"USD"},"attempts":1,
+				"reference":{"type":"refund","id":"r-1"},"tags":["a"],"metadata":{"k":[1]},"created_at":"2024-05-01T12:00:00Z"}`,
+		},
+		{
+			name: "null optional values",
+			doc:  `{"source":"wallet","wallet_id":"w-1","amount":{"currency":"USD"},"attempts":1,"reference":null,"tags":null}`,
+		},
+		{
+			name:          "missing required property",
+			doc:           `{"source":"wallet","amount":{"currency":"USD"},"attempts":1}`,
+			invalid:       true,
+			expectedField: "wallet_id",
+			expectedError: ErrMissingField,
+		},
+		{
+			name:          "empty required string",
+			doc:           `{"source":"wallet","wallet_id":"","amount":{"currency":"USD"},"attempts":1}`,
+			invalid:       true,
+			expectedField: "wallet_id",
+			expectedError: ErrMissingField,
+		},
+		{
+			name:          "zero required number",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"currency":"USD"},"attempts":0}`,
+			invalid:       true,
+			expectedField: "attempts",
+			expectedError: ErrMissingField,
+		},
+		{
+			name:          "empty currency",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"amount":100,"currency":""},"attempts":1}`,
+			invalid:       true,
+			expectedField: "amount.currency",
+			expectedError: ErrMissingField,
+		},
+		{
+			name:          "currency of the wrong length",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"amount":100,"currency":"US"},"attempts":1}`,
+			invalid:       true,
+			expectedField: "amount.currency",
+		},
+		{
+			name:          "wrong type",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"amount":"100","currency":"USD"},"attempts":1}`,
+			invalid:       true,
+			expectedField: "amount.amount",
+		},
+		{
+			name:          "fraction for an integer",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"amount":1.5,"currency":"USD"},"attempts":1}`,
+			invalid:       true,
+			expectedField: "amount.amount",
+		},
+		{
+			name:          "above maximum",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"currency":"USD"},"attempts":6}`,
+			invalid:       true,
+			expectedField: "attempts",
+		},
+		{
+			name:          "value outside enum",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"currency":"USD"},"attempts":1,"reference":{"type":"order"}}`,
+			invalid:       true,
+			expectedField: "reference.type",
+		},
+		{
+			name:          "wrong array item",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"currency":"USD"},"attempts":1,"tags":["a",2]}`,
+			invalid:       true,
+			expectedField: "tags[1]",
+		},
+		{
+			name:          "invalid date-time",
+			doc:           `{"source":"wallet","wallet_id":"w-1","amount":{"currency":"USD"},"attempts":1,"created_at":"yesterday"}`,
+			invalid:       true,
+			expectedField: "created_at",
+		},
+		{
+			name:    "not an object",
+			doc:     `[]`,
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, err := schema.Validate([]byte(tt.doc))
+
+			if !tt.invalid {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedField, field)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pkg/errors"
+)
+
+// ClaimCheckKey is the message attribute holding the reference of a payload
+// offloaded to a PayloadStore
+const ClaimCheckKey = "claim_check"
+
+const (
+	// snsMaxPayloadSize is the SNS limit of a message, and of a whole
+	// batch, counting the body and the message attributes
+	snsMaxPayloadSize = 256 * 1024
+	// defaultClaimCheckThreshold leaves room for the SNS envelope, which
+	// escapes the body, under the SQS limit of the same size
+	defaultClaimCheckThreshold = 192 * 1024
+
+	claimCheckLifecycleRuleID = "claim-check-expiration"
+	s3RefScheme               = "s3://"
+)
+
+var ErrPayloadNotFound = errors.New("claim-checked payload not found")
+
+// PayloadStore keeps message bodies too large for the broker. Put returns a
+// reference that Get resolves back to the body.
+type PayloadStore interface {
+	Put(ctx context.Context, key string, payload []byte) (string, error)
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// claimCheckStub is the body sent in place of an offloaded one
+type claimCheckStub struct {
+	ClaimCheck string `json:"claim_check"`
+}
+
+// claimCheckRef returns the payload reference when body, possibly in an SNS
+// envelope, is a claim-check stub
+func claimCheckRef(body []byte) (string, bool) {
+	message, _, err := unwrapSNSEnvelope(body)
+	if err != nil {
+		return "", false
+	}
+
+	var stub claimCheckStub
+	if err := json.Unmarshal(message, &stub); err != nil || stub.ClaimCheck == "" {
+		return "", false
+	}
+	return stub.ClaimCheck, true
+}
+
+// S3PayloadStore stores payloads as objects of an S3 bucket, referenced as
+// s3://bucket/key
+type S3PayloadStore struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+type S3PayloadStoreOption func(*S3PayloadStore)
+
+// WithPayloadKeyPrefix sets the prefix of the object keys, which the
+// lifecycle rule is scoped to
+func WithPayloadKeyPrefix(prefix string) S3PayloadStoreOption {
+	return func(s *S3PayloadStore) {
+		s.prefix = prefix
+	}
+}
+
+// NewS3PayloadStore creates a new S3PayloadStore
+func NewS3PayloadStore(client *s3.Client, bucket string, opts ...S3PayloadStoreOption) *S3PayloadStore {
+	store := &S3PayloadStore{
+		client: client,
+		bucket: bucket,
+		prefix: "claim-check/",
+	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	return store
+}
+
+// NewS3Client creates an S3 client from the default AWS config. LocalStack
+// needs path-style addressing.
+func NewS3Client(ctx context.Context, usePathStyle bool) (*s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load AWS config")
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = usePathStyle
+	}), nil
+}
+
+// Put stores payload under key and returns its reference
+func (s *S3PayloadStore) Put(ctx context.Context, key string, payload []byte) (string, error) {
+	key = s.prefix + key
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(payload),
+		ContentLength: aws.Int64(int64(len(payload))),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to store payload %s", key)
+	}
+
+	return s3RefScheme + s.bucket + "/" + key, nil
+}
+
+// Get returns the payload of ref. Expired or deleted payloads return
+// ErrPayloadNotFound.
+func (s *S3PayloadStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(ref, s3RefScheme), "/")
+	if !strings.HasPrefix(ref, s3RefScheme) || !ok || bucket == "" || key == "" {
+		return nil, errors.Wrapf(ErrMalformedMessage, "invalid payload reference %q", ref)
+	}
+
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *s3types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return nil, errors.Wrapf(ErrPayloadNotFound, "%s", ref)
+		}
+		return nil, errors.Wrapf(err, "failed to get payload %s", ref)
+	}
+	defer output.Body.Close()
+
+	payload, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read payload %s", ref)
+	}
+
+	return payload, nil
+}
+
+// EnsureLifecycle expires stored payloads after days. Payloads must outlive
+// the messages referencing them, including dead letters waiting for a
+// redrive. It replaces the lifecycle configuration of the bucket, so the
+// bucket should be dedicated to payloads.
+func (s *S3PayloadStore) EnsureLifecycle(ctx context.Context, days int32) error {
+	_, err := s.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.bucket),
+		LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{
+			Rules: []s3types.LifecycleRule{
+				{
+					ID:         aws.String(claimCheckLifecycleRuleID),
+					Status:     s3types.ExpirationStatusEnabled,
+					Filter:     &s3types.LifecycleRuleFilter{Prefix: aws.String(s.prefix)},
+					Expiration: &s3types.LifecycleExpiration{Days: aws.Int32(days)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to configure lifecycle of bucket %s", s.bucket)
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memPayloadStore keeps payloads in memory, referenced as mem://key
+type memPayloadStore struct {
+	mux      sync.Mutex
+	payloads map[string][]byte
+	getErr   error
+}
+
+func newMemPayloadStore() *memPayloadStore {
+	return &memPayloadStore{payloads: make(map[string][]byte)}
+}
+
+func (s *memPayloadStore) Put(ctx context.Context, key string, payload []byte) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.payloads["mem://"+key] = payload
+	return "mem://" + key, nil
+}
+
+func (s *memPayloadStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.getErr != nil {
+		return nil, s.getErr
+	}
+	payload, ok := s.payloads[ref]
+	if !ok {
+		return nil, ErrPayloadNotFound
+	}
+	return payload, nil
+}
+
+func largeEvent(size int) *events.Event {
+	return events.NewEvent("payment-1", events.ExternalProviderUpdateEvent, map[string]interface{}{
+		"metadata": strings.Repeat("x", size),
+	})
+}
+
+func TestSNSEventPublisher_ClaimCheck(t *testing.T) {
+	store := newMemPayloadStore()
+	publisher := NewSNSEventPublisher(nil, "arn:aws:sns:us-east-1:000000000000:payment-events",
+		WithClaimCheck(store, 0),
+	)
+
+	small := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+	entry, err := publisher.entry(context.Background(), small)
+	require.NoError(t, err)
+	assert.NotContains(t, entry.MessageAttributes, ClaimCheckKey)
+	assert.Empty(t, store.payloads)
+
+	large := largeEvent(300 * 1024)
+	entry, err = publisher.entry(context.Background(), large)
+	require.NoError(t, err)
+
+	ref := aws.ToString(entry.MessageAttributes[ClaimCheckKey].StringValue)
+	assert.True(t, strings.HasPrefix(ref, "mem://"+events.ExternalProviderUpdateEvent+"/"+large.ID.String()+"/"), ref)
+	assert.Less(t, entrySize(entry), 1024, "only the reference is published")
+
+	stubRef, ok := claimCheckRef([]byte(aws.ToString(entry.Message)))
+	require.True(t, ok)
+	assert.Equal(t, ref, stubRef)
+
+	decoded, err := NewJSONCodec().Decode(store.payloads[ref])
+	require.NoError(t, err)
+	assert.Equal(t, large.ID, decoded.ID)
+}
+
+func TestSNSEventPublisher_ClaimCheckKeysPerBody(t *testing.T) {
+	store := newMemPayloadStore()
+	publisher := NewSNSEventPublisher(nil, "arn:aws:sns:us-east-1:000000000000:payment-events",
+		WithClaimCheck(store, 0),
+	)
+
+	// A dual-published legacy copy shares the topic and ID of the event
+	current := largeEvent(300 * 1024).WithVersion("2.0")
+	legacy := *current
+	legacy.Version = "1.0"
+	legacy.Data = map[string]interface{}{"details": strings.Repeat("x", 300*1024)}
+
+	currentEntry, err := publisher.entry(context.Background(), current)
+	require.NoError(t, err)
+	legacyEntry, err := publisher.entry(context.Background(), &legacy)
+	require.NoError(t, err)
+
+	currentRef := aws.ToString(currentEntry.MessageAttributes[ClaimCheckKey].StringValue)
+	legacyRef := aws.ToString(legacyEntry.MessageAttributes[ClaimCheckKey].StringValue)
+	assert.NotEqual(t, currentRef, legacyRef)
+	require.Len(t, store.payloads, 2)
+
+	decoded, err := NewJSONCodec().Decode(store.payloads[currentRef])
+	require.NoError(t, err)
+	assert.Equal(t, current.Version, decoded.Version)
+}
+
+func TestSNSEventPublisher_BatchesBySize(t *testing.T) {
+	publisher := NewSNSEventPublisher(nil, "arn:aws:sns:us-east-1:000000000000:payment-events")
+
+	evts := make([]*events.Event, maxBatchSize)
+	for i := range evts {
+		evts[i] = largeEvent(100 * 1024)
+	}
+	evts = append(evts, events.NewEvent("payment-2", events.PaymentCreatedEvent, nil))
+
+	batches, err := publisher.batches(context.Background(), evts)
+	require.NoError(t, err)
+
+	var batched []*events.Event
+	for _, batch := range batches {
+		assert.LessOrEqual(t, batch.size, snsMaxPayloadSize)
+		assert.LessOrEqual(t, len(batch.entries), maxBatchSize)
+		batched = append(batched, batch.events...)
+	}
+	assert.Len(t, batches, 5)
+	assert.Equal(t, evts, batched, "events keep their order")
+}
+
+func TestSQSEventSubscriber_InlinePayload(t *testing.T) {
+	event := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+	body, err := NewJSONCodec().Encode(event)
+	require.NoError(t, err)
+
+	store := newMemPayloadStore()
+	ref, err := store.Put(context.Background(), "payment.created/"+event.ID.String(), body)
+	require.NoError(t, err)
+
+	stub, err := json.Marshal(&claimCheckStub{ClaimCheck: ref})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		body          []byte
+		opts          []SQSSubscriberOption
+		expectedBody  []byte
+		expectedError error
+	}{
+		{
+			name:         "regular body is kept",
+			body:         body,
+			opts:         []SQSSubscriberOption{WithPayloadStore(store)},
+			expectedBody: body,
+		},
+		{
+			name:         "stub is replaced by the stored body",
+			body:         stub,
+			opts:         []SQSSubscriberOption{WithPayloadStore(store)},
+			expectedBody: body,
+		},
+		{
+			name:         "stub in an SNS envelope",
+			body:         wrapInSNSEnvelope(t, stub, map[string]string{ClaimCheckKey: ref}),
+			opts:         []SQSSubscriberOption{WithPayloadStore(store)},
+			expectedBody: body,
+		},
+		{
+			name:          "stub without a payload store is malformed",
+			body:          stub,
+			expectedError: ErrMalformedMessage,
+		},
+		{
+			name:          "expired payload",
+			body:          []byte(`{"claim_check":"mem://expired"}`),
+			opts:          []SQSSubscriberOption{WithPayloadStore(store)},
+			expectedError: ErrPayloadNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSQSEventSubscriber(nil, testQueueURL, nil, tt.opts...)
+
+			inlined, err := s.inlinePayload(context.Background(), tt.body)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, inlined)
+		})
+	}
+}
+
+func TestSQSEventSubscriber_ReadClaimCheck(t *testing.T) {
+	event := largeEvent(300 * 1024)
+	store := newMemPayloadStore()
+	publisher := NewSNSEventPublisher(nil, "arn:aws:sns:us-east-1:000000000000:payment-events", WithClaimCheck(store, 0))
+	entry, err := publisher.entry(context.Background(), event)
+	require.NoError(t, err)
+
+	fake, client := newFakeSQS(t)
+	fake.mux.Lock()
+	fake.add(testQueueURL, aws.ToString(entry.Message), nil)
+	fake.mux.Unlock()
+
+	s := NewSQSEventSubscriber(client, testQueueURL, nil, WithPayloadStore(store))
+
+	// A store outage leaves the message for a later receive
+	store.getErr = assert.AnError
+	require.NoError(t, s.read(context.Background()))
+	assert.Empty(t, s.inboundMessages)
+	require.Len(t, fake.messages(testQueueURL), 1)
+
+	store.getErr = nil
+	fake.mux.Lock()
+	fake.queues[testQueueURL][0].hidden = false
+	fake.mux.Unlock()
+
+	require.NoError(t, s.read(context.Background()))
+	require.Len(t, s.inboundMessages, 1)
+	received := <-s.inboundMessages
+	assert.Equal(t, event.ID, received.Event.ID)
+
+	expected, err := event.MarshalPayload()
+	require.NoError(t, err)
+	actual, err := received.Event.MarshalPayload()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+}
+
+// fakeS3 serves path-style PutObject, GetObject and
+// PutBucketLifecycleConfiguration, keeping objects in memory
+type fakeS3 struct {
+	mux       sync.Mutex
+	objects   map[string][]byte
+	lifecycle string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.URL.Query().Has("lifecycle"):
+		f.lifecycle = string(body)
+	case r.Method == http.MethodPut:
+		f.objects[r.URL.Path] = body
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		_, _ = w.Write(object)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestS3PayloadStore(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  aws.AnonymousCredentials{},
+		UsePathStyle: true,
+	})
+	store := NewS3PayloadStore(client, "event-payloads")
+
+	ref, err := store.Put(context.Background(), "payment.created/event-1", []byte(`{"id":"event-1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "s3://event-payloads/claim-check/payment.created/event-1", ref)
+
+	payload, err := store.Get(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"event-1"}`, string(payload))
+
+	_, err = store.Get(context.Background(), "s3://event-payloads/claim-check/missing")
+	assert.ErrorIs(t, err, ErrPayloadNotFound)
+
+	_, err = store.Get(context.Background(), "event-payloads/claim-check/payment.created/event-1")
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+
+	require.NoError(t, store.EnsureLifecycle(context.Background(), 15))
+	assert.Contains(t, fake.lifecycle, "<ID>claim-check-expiration</ID>")
+	assert.Contains(t, fake.lifecycle, "<Prefix>claim-check/</Prefix>")
+	assert.Contains(t, fake.lifecycle, "<Days>15</Days>")
+}
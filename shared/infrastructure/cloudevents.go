@@ -0,0 +1,388 @@
+package infrastructure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+)
+
+var (
+	_ Codec = (*CloudEventsCodec)(nil)
+
+	ErrInvalidCloudEvent = errors.New("invalid cloud event")
+)
+
+const (
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEventsContentType is the content type of structured-mode messages
+	CloudEventsContentType = "application/cloudevents+json"
+
+	// cloudEventsDataContentType is the content type of the event data
+	cloudEventsDataContentType = "application/json"
+)
+
+// Extension attributes carrying the events.Event fields that have no
+// CloudEvents core attribute
+const (
+	CloudEventsCorrelationIDExtension = "correlationid"
+	CloudEventsCausationIDExtension   = "causationid"
+	CloudEventsSchemaVersionExtension = "schemaversion"
+)
+
+// cloudEventsCoreAttributes are the attributes defined by the spec
+var cloudEventsCoreAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"data":            true,
+	"data_base64":     true,
+}
+
+// CloudEvent is a CloudEvents 1.0 event with JSON data. Extensions holds
+// every context attribute that is not a core attribute.
+type CloudEvent struct {
+	ID              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Data            json.RawMessage
+	Extensions      map[string]string
+}
+
+// Attributes returns the context attributes keyed by name, as carried by
+// binary-mode messages
+func (e *CloudEvent) Attributes() map[string]string {
+	attributes := make(map[string]string, len(e.Extensions)+8)
+	for name, value := range e.Extensions {
+		attributes[name] = value
+	}
+
+	attributes["specversion"] = e.SpecVersion
+	attributes["id"] = e.ID
+	attributes["source"] = e.Source
+	attributes["type"] = e.Type
+	if e.Subject != "" {
+		attributes["subject"] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		attributes["time"] = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if e.DataContentType != "" {
+		attributes["datacontenttype"] = e.DataContentType
+	}
+	if e.DataSchema != "" {
+		attributes["dataschema"] = e.DataSchema
+	}
+
+	return attributes
+}
+
+// cloudEventFromAttributes builds a CloudEvent from binary-mode attributes
+func cloudEventFromAttributes(attributes map[string]string, data []byte) (*CloudEvent, error) {
+	event := &CloudEvent{
+		ID:              attributes["id"],
+		Source:          attributes["source"],
+		SpecVersion:     attributes["specversion"],
+		Type:            attributes["type"],
+		Subject:         attributes["subject"],
+		DataContentType: attributes["datacontenttype"],
+		DataSchema:      attributes["dataschema"],
+		Data:            data,
+		Extensions:      make(map[string]string),
+	}
+
+	if value := attributes["time"]; value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidCloudEvent, "invalid time %q", value)
+		}
+		event.Time = t
+	}
+
+	for name, value := range attributes {
+		if !isCloudEventsCoreAttribute(name) {
+			event.Extensions[name] = value
+		}
+	}
+
+	return event, event.validate()
+}
+
+// validate checks the required context attributes
+func (e *CloudEvent) validate() error {
+	if e.SpecVersion != CloudEventsSpecVersion {
+		return errors.Wrapf(ErrInvalidCloudEvent, "unsupported specversion %q", e.SpecVersion)
+	}
+
+	for name, value := range map[string]string{"id": e.ID, "source": e.Source, "type": e.Type} {
+		if value == "" {
+			return errors.Wrapf(ErrInvalidCloudEvent, "missing %s", name)
+		}
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the event in the structured-mode JSON format
+func (e *CloudEvent) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{}, len(e.Extensions)+9)
+	for name, value := range e.Attributes() {
+		fields[name] = value
+	}
+
+	if len(e.Data) > 0 {
+		fields["data"] = e.Data
+	}
+
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes the structured-mode JSON format. Extension values
+// that are not strings keep their JSON text.
+func (e *CloudEvent) UnmarshalJSON(body []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return errors.Wrap(ErrInvalidCloudEvent, err.Error())
+	}
+
+	attributes := make(map[string]string, len(fields))
+	for name, raw := range fields {
+		if name == "data" || name == "data_base64" {
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		attributes[name] = value
+	}
+
+	data := []byte(fields["data"])
+	if raw, ok := fields["data_base64"]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return errors.Wrap(ErrInvalidCloudEvent, "data_base64 must be a string")
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return errors.Wrap(ErrInvalidCloudEvent, err.Error())
+		}
+		data = decoded
+	}
+
+	event, err := cloudEventFromAttributes(attributes, data)
+	if err != nil {
+		return err
+	}
+
+	*e = *event
+	return nil
+}
+
+// CloudEventsCodec converts events to and from CloudEvents 1.0. Topic maps to
+// type, the producing service to source and AggregateID to subject;
+// CorrelationID, CausationID, Version and metadata travel as extensions.
+//
+// As a Codec it uses the structured JSON mode, and decodes bodies without a
+// specversion with JSONCodec so consumers can switch before producers do.
+type CloudEventsCodec struct {
+	source   string
+	fallback Codec
+}
+
+// NewCloudEventsCodec creates a codec emitting events with the given source,
+// usually the service name
+func NewCloudEventsCodec(source string) *CloudEventsCodec {
+	return &CloudEventsCodec{
+		source:   source,
+		fallback: NewJSONCodec(),
+	}
+}
+
+// ToCloudEvent converts an event
+func (c *CloudEventsCodec) ToCloudEvent(event *events.Event) (*CloudEvent, error) {
+	data, err := event.MarshalPayload()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal payload")
+	}
+
+	extensions := make(map[string]string, len(event.Metadata)+3)
+	for key, value := range transportFreeMetadata(event.Metadata) {
+		name := cloudEventsExtensionName(key)
+		if name == "" || isCloudEventsReservedName(name) {
+			continue
+		}
+		extensions[name] = value
+	}
+
+	if event.CorrelationID != "" {
+		extensions[CloudEventsCorrelationIDExtension] = event.CorrelationID.String()
+	}
+	if event.CausationID != "" {
+		extensions[CloudEventsCausationIDExtension] = event.CausationID.String()
+	}
+	if event.Version != "" {
+		extensions[CloudEventsSchemaVersionExtension] = event.Version
+	}
+
+	return &CloudEvent{
+		ID:              event.ID.String(),
+		Source:          c.source,
+		SpecVersion:     CloudEventsSpecVersion,
+		Type:            event.Topic.String(),
+		Subject:         event.AggregateID.String(),
+		Time:            event.Timestamp,
+		DataContentType: cloudEventsDataContentType,
+		Data:            data,
+		Extensions:      extensions,
+	}, nil
+}
+
+// FromCloudEvent converts a CloudEvent back to an event. Extensions other
+// than correlation, causation and schema version become metadata.
+func (c *CloudEventsCodec) FromCloudEvent(ce *CloudEvent) (*events.Event, error) {
+	if err := ce.validate(); err != nil {
+		return nil, err
+	}
+
+	metadata := make(events.Metadata, len(ce.Extensions))
+	for name, value := range ce.Extensions {
+		if !isCloudEventsReservedName(name) {
+			metadata[name] = value
+		}
+	}
+
+	version := ce.Extensions[CloudEventsSchemaVersionExtension]
+	if version == "" {
+		version = events.DefaultSchemaVersion
+	}
+
+	return &events.Event{
+		ID:            models.ID(ce.ID),
+		AggregateID:   models.ID(ce.Subject),
+		Topic:         events.Topic(ce.Type),
+		EventType:     ce.Type,
+		Version:       version,
+		Data:          ce.Data,
+		Metadata:      metadata,
+		Timestamp:     ce.Time,
+		CorrelationID: models.ID(ce.Extensions[CloudEventsCorrelationIDExtension]),
+		CausationID:   models.ID(ce.Extensions[CloudEventsCausationIDExtension]),
+	}, nil
+}
+
+// Encode encodes an event as a structured-mode CloudEvent
+func (c *CloudEventsCodec) Encode(event *events.Event) ([]byte, error) {
+	ce, err := c.ToCloudEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(ce)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal cloud event")
+	}
+
+	return body, nil
+}
+
+// Decode decodes a structured-mode CloudEvent, unwrapping the SNS envelope
+// when present. Bodies that are not CloudEvents are decoded with JSONCodec.
+func (c *CloudEventsCodec) Decode(body []byte) (*events.Event, error) {
+	message, attributes, err := unwrapSNSEnvelope(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	if err := json.Unmarshal(message, &probe); err != nil {
+		return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+
+	if probe.SpecVersion == "" {
+		return c.fallback.Decode(body)
+	}
+
+	var ce CloudEvent
+	if err := json.Unmarshal(message, &ce); err != nil {
+		return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+
+	event, err := c.FromCloudEvent(&ce)
+	if err != nil {
+		return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+
+	metadata := make(events.Metadata)
+	metadata.Merge(attributes)
+	metadata.Merge(event.Metadata)
+	event.Metadata = metadata
+
+	return event, nil
+}
+
+// EncodeBinary encodes an event in binary mode: the context attributes keyed
+// by name and the data as the message body
+func (c *CloudEventsCodec) EncodeBinary(event *events.Event) (map[string]string, []byte, error) {
+	ce, err := c.ToCloudEvent(event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ce.Attributes(), ce.Data, nil
+}
+
+// DecodeBinary decodes a binary-mode event
+func (c *CloudEventsCodec) DecodeBinary(attributes map[string]string, data []byte) (*events.Event, error) {
+	ce, err := cloudEventFromAttributes(attributes, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.FromCloudEvent(ce)
+}
+
+// cloudEventsExtensionName turns a metadata key into a valid extension name
+// (lower-case letters and digits), e.g. "user_id" becomes "userid"
+func cloudEventsExtensionName(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(key) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isCloudEventsCoreAttribute(name string) bool {
+	return cloudEventsCoreAttributes[name]
+}
+
+// isCloudEventsReservedName reports whether name is a core attribute or an
+// extension mapped to an events.Event field, so metadata cannot use it
+func isCloudEventsReservedName(name string) bool {
+	switch name {
+	case CloudEventsCorrelationIDExtension, CloudEventsCausationIDExtension, CloudEventsSchemaVersionExtension:
+		return true
+	}
+	return isCloudEventsCoreAttribute(name)
+}
@@ -0,0 +1,174 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudEventsCodec_RoundTrip(t *testing.T) {
+	codec := NewCloudEventsCodec("wallet-service")
+
+	tests := []struct {
+		name             string
+		roundTrip        func(t *testing.T, event *events.Event) *events.Event
+		expectedMetadata events.Metadata
+	}{
+		{
+			name: "structured mode",
+			roundTrip: func(t *testing.T, event *events.Event) *events.Event {
+				body, err := codec.Encode(event)
+				require.NoError(t, err)
+
+				decoded, err := codec.Decode(body)
+				require.NoError(t, err)
+				return decoded
+			},
+			expectedMetadata: events.Metadata{"paymentid": "550e8400-e29b-41d4-a716-446655440099"},
+		},
+		{
+			name: "structured mode in sns notification envelope",
+			roundTrip: func(t *testing.T, event *events.Event) *events.Event {
+				body, err := codec.Encode(event)
+				require.NoError(t, err)
+
+				decoded, err := codec.Decode(wrapInSNSEnvelope(t, body, map[string]string{
+					"topic": events.WalletDebitedEvent,
+				}))
+				require.NoError(t, err)
+				return decoded
+			},
+			expectedMetadata: events.Metadata{
+				"paymentid": "550e8400-e29b-41d4-a716-446655440099",
+				"topic":     events.WalletDebitedEvent,
+			},
+		},
+		{
+			name: "binary mode",
+			roundTrip: func(t *testing.T, event *events.Event) *events.Event {
+				attributes, data, err := codec.EncodeBinary(event)
+				require.NoError(t, err)
+
+				decoded, err := codec.DecodeBinary(attributes, data)
+				require.NoError(t, err)
+				return decoded
+			},
+			expectedMetadata: events.Metadata{"paymentid": "550e8400-e29b-41d4-a716-446655440099"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := newCodecTestEvent()
+
+			decoded := tt.roundTrip(t, original)
+
+			assert.Equal(t, original.ID, decoded.ID)
+			assert.Equal(t, original.AggregateID, decoded.AggregateID)
+			assert.Equal(t, original.Topic, decoded.Topic)
+			assert.Equal(t, original.Version, decoded.Version)
+			assert.Equal(t, original.CorrelationID, decoded.CorrelationID)
+			assert.Equal(t, original.CausationID, decoded.CausationID)
+			assert.True(t, original.Timestamp.Equal(decoded.Timestamp))
+			assert.Equal(t, tt.expectedMetadata, decoded.Metadata)
+
+			var payload codecTestPayload
+			require.NoError(t, decoded.UnmarshalPayload(&payload))
+			assert.Equal(t, original.Data, payload)
+		})
+	}
+}
+
+func TestCloudEventsCodec_Encode(t *testing.T) {
+	body, err := NewCloudEventsCodec("wallet-service").Encode(newCodecTestEvent().
+		WithMetadata(SQSReceiptHandleKey, "receipt-handle").
+		WithMetadata("type", "ignored"),
+	)
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &fields))
+
+	assert.Equal(t, "1.0", fields["specversion"])
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440001", fields["subject"])
+	assert.Equal(t, events.WalletDebitedEvent, fields["type"])
+	assert.Equal(t, "wallet-service", fields["source"])
+	assert.Equal(t, "2024-01-02T03:04:05Z", fields["time"])
+	assert.Equal(t, "application/json", fields["datacontenttype"])
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440050", fields["correlationid"])
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440051", fields["causationid"])
+	assert.Equal(t, "1.0", fields["schemaversion"])
+	assert.NotContains(t, fields, "sqsreceipthandle")
+	assert.Contains(t, fields, "data")
+}
+
+func TestCloudEventsCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		expectedError error
+		validate      func(t *testing.T, event *events.Event)
+	}{
+		{
+			name: "third-party event with base64 data and non-string extension",
+			body: `{
+				"specversion": "1.0",
+				"id": "evt-1",
+				"source": "partner",
+				"type": "payment.external_provider.updated",
+				"data_base64": "eyJzdGF0dXMiOiJvayJ9",
+				"retries": 3
+			}`,
+			validate: func(t *testing.T, event *events.Event) {
+				assert.Equal(t, models.ID("evt-1"), event.ID)
+				assert.Equal(t, events.DefaultSchemaVersion, event.Version)
+				assert.Equal(t, "3", event.Metadata["retries"])
+
+				var payload map[string]string
+				require.NoError(t, event.UnmarshalPayload(&payload))
+				assert.Equal(t, "ok", payload["status"])
+			},
+		},
+		{
+			name: "plain json message falls back to JSONCodec",
+			body: `{
+				"id": "550e8400-e29b-41d4-a716-446655440001",
+				"topic": "wallet.debited",
+				"payload": {"amount": 100},
+				"timestamp": "2024-01-02T03:04:05Z"
+			}`,
+			validate: func(t *testing.T, event *events.Event) {
+				assert.Equal(t, events.Topic(events.WalletDebitedEvent), event.Topic)
+			},
+		},
+		{
+			name:          "unsupported spec version",
+			body:          `{"specversion": "0.3", "id": "evt-1", "source": "partner", "type": "payment.created"}`,
+			expectedError: ErrMalformedMessage,
+		},
+		{
+			name:          "missing type",
+			body:          `{"specversion": "1.0", "id": "evt-1", "source": "partner"}`,
+			expectedError: ErrMalformedMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := NewCloudEventsCodec("payments-service").Decode([]byte(tt.body))
+
+			if tt.expectedError != nil {
+				assert.True(t, errors.Is(err, tt.expectedError), "expected %v, got %v", tt.expectedError, err)
+				return
+			}
+
+			require.NoError(t, err)
+			tt.validate(t, event)
+		})
+	}
+}
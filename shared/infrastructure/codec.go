@@ -0,0 +1,186 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+)
+
+var (
+	_ Codec = (*JSONCodec)(nil)
+
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// snsNotificationType is the Type of the envelope SNS wraps messages in when
+// raw message delivery is disabled on the subscription
+const snsNotificationType = "Notification"
+
+// Codec converts events to and from the body of SNS/SQS messages
+type Codec interface {
+	Encode(event *events.Event) ([]byte, error)
+	Decode(body []byte) (*events.Event, error)
+}
+
+// wireMessage is the JSON body published to SNS
+type wireMessage struct {
+	ID            string          `json:"id"`
+	AggregateID   string          `json:"aggregate_id,omitempty"`
+	Topic         string          `json:"topic"`
+	EventType     string          `json:"event_type,omitempty"`
+	Version       string          `json:"version,omitempty"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	CausationID   string          `json:"causation_id,omitempty"`
+	Metadata      events.Metadata `json:"metadata"`
+	Payload       json.RawMessage `json:"payload"`
+	Timestamp     time.Time       `json:"timestamp"`
+
+	// Data carries the payload of messages serialised as events.Event
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// snsEnvelope is the notification SNS delivers to SQS without raw delivery
+type snsEnvelope struct {
+	Type              string                          `json:"Type"`
+	MessageID         string                          `json:"MessageId"`
+	TopicArn          string                          `json:"TopicArn"`
+	Message           string                          `json:"Message"`
+	MessageAttributes map[string]snsEnvelopeAttribute `json:"MessageAttributes"`
+}
+
+type snsEnvelopeAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// JSONCodec encodes events as wireMessage JSON and decodes both raw and
+// SNS-enveloped bodies
+type JSONCodec struct{}
+
+// NewJSONCodec creates a new JSONCodec
+func NewJSONCodec() *JSONCodec {
+	return &JSONCodec{}
+}
+
+// Encode encodes an event into a message body
+func (c *JSONCodec) Encode(event *events.Event) ([]byte, error) {
+	payload, err := event.MarshalPayload()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal payload")
+	}
+
+	eventType := event.EventType
+	if eventType == "" {
+		eventType = event.Topic.String()
+	}
+
+	message := &wireMessage{
+		ID:            event.ID.String(),
+		AggregateID:   event.AggregateID.String(),
+		Topic:         event.Topic.String(),
+		EventType:     eventType,
+		Version:       event.Version,
+		CorrelationID: event.CorrelationID.String(),
+		CausationID:   event.CausationID.String(),
+		Metadata:      transportFreeMetadata(event.Metadata),
+		Payload:       payload,
+		Timestamp:     event.Timestamp,
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal message")
+	}
+
+	return body, nil
+}
+
+// Decode decodes a message body, unwrapping the SNS envelope when present
+func (c *JSONCodec) Decode(body []byte) (*events.Event, error) {
+	body, attributes, err := unwrapSNSEnvelope(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var message wireMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+
+	topic := message.Topic
+	if topic == "" {
+		topic = message.EventType
+	}
+
+	if topic == "" {
+		return nil, errors.Wrap(ErrMalformedMessage, "message has no topic")
+	}
+
+	eventType := message.EventType
+	if eventType == "" {
+		eventType = topic
+	}
+
+	version := message.Version
+	if version == "" {
+		version = events.DefaultSchemaVersion
+	}
+
+	payload := message.Payload
+	if len(payload) == 0 {
+		payload = message.Data
+	}
+
+	metadata := make(events.Metadata)
+	metadata.Merge(attributes)
+	metadata.Merge(message.Metadata)
+
+	return &events.Event{
+		ID:            models.ID(message.ID),
+		AggregateID:   models.ID(message.AggregateID),
+		Topic:         events.Topic(topic),
+		EventType:     eventType,
+		Version:       version,
+		Data:          payload,
+		Metadata:      metadata,
+		Timestamp:     message.Timestamp,
+		CorrelationID: models.ID(message.CorrelationID),
+		CausationID:   models.ID(message.CausationID),
+	}, nil
+}
+
+// unwrapSNSEnvelope returns the message inside an SNS notification envelope
+// and its message attributes, or body unchanged when it is not enveloped
+func unwrapSNSEnvelope(body []byte) ([]byte, events.Metadata, error) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+
+	if envelope.Type != snsNotificationType || envelope.Message == "" {
+		return body, nil, nil
+	}
+
+	attributes := make(events.Metadata, len(envelope.MessageAttributes))
+	for k, v := range envelope.MessageAttributes {
+		attributes[k] = v.Value
+	}
+
+	return []byte(envelope.Message), attributes, nil
+}
+
+// transportFreeMetadata drops the SQS receipt keys a consumer adds to
+// metadata so they are not forwarded when an event is republished
+func transportFreeMetadata(metadata events.Metadata) events.Metadata {
+	clean := make(events.Metadata, len(metadata))
+	for k, v := range metadata {
+		if k == SQSMessageIDKey || k == SQSReceiptHandleKey {
+			continue
+		}
+		clean[k] = v
+	}
+	return clean
+}
@@ -0,0 +1,248 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type codecTestPayload struct {
+	PaymentID models.ID    `json:"payment_id"`
+	Amount    models.Money `json:"amount"`
+}
+
+func newCodecTestEvent() *events.Event {
+	event := events.NewEvent(
+		models.ID("550e8400-e29b-41d4-a716-446655440001"),
+		events.WalletDebitedEvent,
+		codecTestPayload{
+			PaymentID: models.ID("550e8400-e29b-41d4-a716-446655440099"),
+			Amount:    models.NewMoney(5000, "USD"),
+		},
+	)
+	event.Timestamp = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return event.
+		WithCorrelationID(models.ID("550e8400-e29b-41d4-a716-446655440050")).
+		WithCausationID(models.ID("550e8400-e29b-41d4-a716-446655440051")).
+		WithMetadata("payment_id", "550e8400-e29b-41d4-a716-446655440099")
+}
+
+func wrapInSNSEnvelope(t *testing.T, body []byte, attributes map[string]string) []byte {
+	envelope := snsEnvelope{
+		Type:              snsNotificationType,
+		MessageID:         "9b2f1c3e-0000-0000-0000-000000000000",
+		TopicArn:          "arn:aws:sns:us-east-1:000000000000:payment-events",
+		Message:           string(body),
+		MessageAttributes: make(map[string]snsEnvelopeAttribute),
+	}
+	for k, v := range attributes {
+		envelope.MessageAttributes[k] = snsEnvelopeAttribute{Type: "String", Value: v}
+	}
+
+	wrapped, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	return wrapped
+}
+
+func TestJSONCodec_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name             string
+		transform        func(t *testing.T, body []byte) []byte
+		expectedMetadata events.Metadata
+	}{
+		{
+			name: "raw message delivery",
+			transform: func(t *testing.T, body []byte) []byte {
+				return body
+			},
+			expectedMetadata: events.Metadata{
+				"payment_id": "550e8400-e29b-41d4-a716-446655440099",
+			},
+		},
+		{
+			name: "sns notification envelope",
+			transform: func(t *testing.T, body []byte) []byte {
+				return wrapInSNSEnvelope(t, body, map[string]string{
+					"topic": events.WalletDebitedEvent,
+				})
+			},
+			expectedMetadata: events.Metadata{
+				"payment_id": "550e8400-e29b-41d4-a716-446655440099",
+				"topic":      events.WalletDebitedEvent,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := NewJSONCodec()
+			original := newCodecTestEvent()
+
+			body, err := codec.Encode(original)
+			require.NoError(t, err)
+
+			decoded, err := codec.Decode(tt.transform(t, body))
+			require.NoError(t, err)
+
+			assert.Equal(t, original.ID, decoded.ID)
+			assert.Equal(t, original.AggregateID, decoded.AggregateID)
+			assert.Equal(t, original.Topic, decoded.Topic)
+			assert.Equal(t, original.EventType, decoded.EventType)
+			assert.Equal(t, original.Version, decoded.Version)
+			assert.Equal(t, original.CorrelationID, decoded.CorrelationID)
+			assert.Equal(t, original.CausationID, decoded.CausationID)
+			assert.True(t, original.Timestamp.Equal(decoded.Timestamp))
+			assert.Equal(t, tt.expectedMetadata, decoded.Metadata)
+
+			var payload codecTestPayload
+			require.NoError(t, decoded.UnmarshalPayload(&payload))
+			assert.Equal(t, original.Data, payload)
+		})
+	}
+}
+
+func TestJSONCodec_Encode(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    func() *events.Event
+		validate func(t *testing.T, message wireMessage)
+	}{
+		{
+			name: "drops sqs receipt metadata",
+			event: func() *events.Event {
+				return newCodecTestEvent().
+					WithMetadata(SQSMessageIDKey, "message-id").
+					WithMetadata(SQSReceiptHandleKey, "receipt-handle")
+			},
+			validate: func(t *testing.T, message wireMessage) {
+				assert.NotContains(t, message.Metadata, SQSMessageIDKey)
+				assert.NotContains(t, message.Metadata, SQSReceiptHandleKey)
+				assert.Equal(t, "550e8400-e29b-41d4-a716-446655440099", message.Metadata["payment_id"])
+			},
+		},
+		{
+			name: "falls back to topic when event type is empty",
+			event: func() *events.Event {
+				event := newCodecTestEvent()
+				event.EventType = ""
+				return event
+			},
+			validate: func(t *testing.T, message wireMessage) {
+				assert.Equal(t, events.WalletDebitedEvent, message.EventType)
+			},
+		},
+		{
+			name: "keeps pre-encoded payloads",
+			event: func() *events.Event {
+				event := newCodecTestEvent()
+				event.Data = json.RawMessage(`{"payment_id":"abc"}`)
+				return event
+			},
+			validate: func(t *testing.T, message wireMessage) {
+				assert.JSONEq(t, `{"payment_id":"abc"}`, string(message.Payload))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := NewJSONCodec().Encode(tt.event())
+			require.NoError(t, err)
+
+			var message wireMessage
+			require.NoError(t, json.Unmarshal(body, &message))
+			tt.validate(t, message)
+		})
+	}
+}
+
+func TestJSONCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		expectedError error
+		validate      func(t *testing.T, event *events.Event)
+	}{
+		{
+			name: "event serialised as events.Event",
+			body: `{
+				"id": "550e8400-e29b-41d4-a716-446655440001",
+				"aggregate_id": "550e8400-e29b-41d4-a716-446655440002",
+				"topic": "payment.created",
+				"event_type": "payment.created",
+				"version": "1.0",
+				"data": {"payment_id": "550e8400-e29b-41d4-a716-446655440002"},
+				"metadata": {},
+				"timestamp": "2024-01-02T03:04:05Z",
+				"correlation_id": "550e8400-e29b-41d4-a716-446655440003"
+			}`,
+			validate: func(t *testing.T, event *events.Event) {
+				assert.Equal(t, events.Topic(events.PaymentCreatedEvent), event.Topic)
+				assert.Equal(t, models.ID("550e8400-e29b-41d4-a716-446655440002"), event.AggregateID)
+				assert.Equal(t, models.ID("550e8400-e29b-41d4-a716-446655440003"), event.CorrelationID)
+
+				var payload map[string]string
+				require.NoError(t, event.UnmarshalPayload(&payload))
+				assert.Equal(t, "550e8400-e29b-41d4-a716-446655440002", payload["payment_id"])
+			},
+		},
+		{
+			name: "legacy sns message without event type or version",
+			body: `{
+				"id": "550e8400-e29b-41d4-a716-446655440001",
+				"topic": "wallet.debited",
+				"payload": {"amount": 100},
+				"metadata": null,
+				"timestamp": "2024-01-02T03:04:05Z"
+			}`,
+			validate: func(t *testing.T, event *events.Event) {
+				assert.Equal(t, events.WalletDebitedEvent, event.EventType)
+				assert.Equal(t, "1.0", event.Version)
+				assert.NotNil(t, event.Metadata)
+			},
+		},
+		{
+			name: "event type without topic",
+			body: `{"id": "550e8400-e29b-41d4-a716-446655440001", "event_type": "wallet.credited", "payload": {}}`,
+			validate: func(t *testing.T, event *events.Event) {
+				assert.Equal(t, events.Topic(events.WalletCreditedEvent), event.Topic)
+			},
+		},
+		{
+			name:          "invalid json",
+			body:          `{not json`,
+			expectedError: ErrMalformedMessage,
+		},
+		{
+			name:          "missing topic",
+			body:          `{"id": "550e8400-e29b-41d4-a716-446655440001", "payload": {}}`,
+			expectedError: ErrMalformedMessage,
+		},
+		{
+			name:          "sns envelope with invalid message",
+			body:          `{"Type": "Notification", "Message": "not json"}`,
+			expectedError: ErrMalformedMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := NewJSONCodec().Decode([]byte(tt.body))
+
+			if tt.expectedError != nil {
+				assert.True(t, errors.Is(err, tt.expectedError))
+				assert.Nil(t, event)
+				return
+			}
+
+			require.NoError(t, err)
+			tt.validate(t, event)
+		})
+	}
+}
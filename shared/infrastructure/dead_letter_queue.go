@@ -0,0 +1,507 @@
+package infrastructure
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Message attributes added to dead-lettered messages
+const (
+	DLQFailureReasonKey = "dlq_failure_reason"
+	DLQSourceQueueKey   = "dlq_source_queue"
+	DLQReceiveCountKey  = "dlq_receive_count"
+	DLQFailedAtKey      = "dlq_failed_at"
+	// DLQMessageGroupKey keeps the FIFO message group of the original message
+	// so it is restored on redrive, even through a standard dead-letter queue
+	DLQMessageGroupKey = "dlq_message_group_id"
+)
+
+const (
+	// sqsMaxMessageAttributes is the SQS limit of message attributes per message
+	sqsMaxMessageAttributes = 10
+	// maxFailureReasonLength keeps the failure reason attribute small
+	maxFailureReasonLength = 1024
+	// sqsMaxVisibilityTimeout is the SQS limit of a message visibility
+	// timeout, in seconds
+	sqsMaxVisibilityTimeout = 43200
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetterMessage is a message received from a dead-letter queue
+type DeadLetterMessage struct {
+	MessageID     string
+	ReceiptHandle string
+	// GroupID is the FIFO message group of the original message
+	GroupID string
+	// Body is sent as is by Redrive and Replace, so it can be edited first
+	Body          string
+	Attributes    map[string]types.MessageAttributeValue
+	FailureReason string
+	SourceQueue   string
+	ReceiveCount  int
+	FailedAt      time.Time
+}
+
+// deadLetterAttributes returns the attributes of message plus the failure
+// details. Original attributes are dropped when they would exceed the SQS
+// limit; the failure details are always kept.
+func deadLetterAttributes(
+	message types.Message,
+	sourceQueueURL string,
+	reason error,
+	receiveCount int,
+) map[string]types.MessageAttributeValue {
+	failureReason := reason.Error()
+	if len(failureReason) > maxFailureReasonLength {
+		failureReason = failureReason[:maxFailureReasonLength]
+	}
+
+	attrs := map[string]types.MessageAttributeValue{
+		DLQFailureReasonKey: {DataType: aws.String("String"), StringValue: aws.String(failureReason)},
+		DLQSourceQueueKey:   {DataType: aws.String("String"), StringValue: aws.String(sourceQueueURL)},
+		DLQReceiveCountKey:  {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(receiveCount))},
+		DLQFailedAtKey:      {DataType: aws.String("String"), StringValue: aws.String(time.Now().UTC().Format(time.RFC3339))},
+	}
+
+	if group := message.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]; group != "" {
+		attrs[DLQMessageGroupKey] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(group)}
+	}
+
+	for k, v := range message.MessageAttributes {
+		if len(attrs) >= sqsMaxMessageAttributes {
+			break
+		}
+		if _, ok := attrs[k]; !ok {
+			attrs[k] = v
+		}
+	}
+
+	return attrs
+}
+
+// fifoMessageIDs returns the message group and deduplication IDs to send a
+// message to queueURL with. Both are nil unless the queue is FIFO; messages
+// without a group get one of their own.
+func fifoMessageIDs(queueURL, groupID, deduplicationID string) (*string, *string) {
+	if !strings.HasSuffix(queueURL, ".fifo") {
+		return nil, nil
+	}
+	if groupID == "" {
+		groupID = deduplicationID
+	}
+	return aws.String(groupID), aws.String(deduplicationID)
+}
+
+// DeadLetterQueue inspects and redrives the messages of an SQS dead-letter
+// queue. Messages are received to be inspected, so they stay hidden from
+// other readers until released, redriven or replaced.
+type DeadLetterQueue struct {
+	client         *sqs.Client
+	queueURL       string
+	sourceQueueURL string
+	options        *deadLetterQueueOptions
+}
+
+type deadLetterQueueOptions struct {
+	visibilityTimeout int32
+	waitTimeSeconds   int32
+}
+
+type DeadLetterQueueOption func(*deadLetterQueueOptions)
+
+// WithDeadLetterVisibilityTimeout sets how long received dead letters stay
+// hidden while they are inspected
+func WithDeadLetterVisibilityTimeout(timeout int32) DeadLetterQueueOption {
+	return func(o *deadLetterQueueOptions) {
+		o.visibilityTimeout = timeout
+	}
+}
+
+// NewDeadLetterQueue creates a new DeadLetterQueue. Messages are redriven to
+// the queue recorded when they were dead-lettered, or to sourceQueueURL for
+// messages moved by an SQS redrive policy.
+func NewDeadLetterQueue(
+	client *sqs.Client,
+	queueURL string,
+	sourceQueueURL string,
+	opts ...DeadLetterQueueOption,
+) *DeadLetterQueue {
+	options := &deadLetterQueueOptions{
+		visibilityTimeout: 60,
+		waitTimeSeconds:   1,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &DeadLetterQueue{
+		client:         client,
+		queueURL:       queueURL,
+		sourceQueueURL: sourceQueueURL,
+		options:        options,
+	}
+}
+
+// QueueURL returns the URL of the dead-letter queue
+func (q *DeadLetterQueue) QueueURL() string {
+	return q.queueURL
+}
+
+// Receive receives up to limit dead letters and hides them for the visibility
+// timeout
+func (q *DeadLetterQueue) Receive(ctx context.Context, limit int) ([]*DeadLetterMessage, error) {
+	var messages []*DeadLetterMessage
+
+	for len(messages) < limit {
+		batch := limit - len(messages)
+		if batch > maxBatchSize {
+			batch = maxBatchSize
+		}
+
+		output, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(q.queueURL),
+			MaxNumberOfMessages:   int32(batch),
+			WaitTimeSeconds:       q.options.waitTimeSeconds,
+			VisibilityTimeout:     q.options.visibilityTimeout,
+			AttributeNames:        []types.QueueAttributeName{"ApproximateReceiveCount", "MessageGroupId"},
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			return messages, errors.Wrap(err, "failed to receive dead letters")
+		}
+
+		if len(output.Messages) == 0 {
+			break
+		}
+
+		for _, message := range output.Messages {
+			messages = append(messages, newDeadLetterMessage(message))
+		}
+	}
+
+	return messages, nil
+}
+
+// Peek returns up to limit dead letters without keeping them hidden
+func (q *DeadLetterQueue) Peek(ctx context.Context, limit int) ([]*DeadLetterMessage, error) {
+	messages, err := q.Receive(ctx, limit)
+	if releaseErr := q.Release(ctx, messages...); err == nil {
+		err = releaseErr
+	}
+	return messages, err
+}
+
+// Find receives dead letters until the one with messageID is found. The
+// other received messages are released.
+func (q *DeadLetterQueue) Find(ctx context.Context, messageID string) (*DeadLetterMessage, error) {
+	var others []*DeadLetterMessage
+	defer func() {
+		_ = q.Release(ctx, others...)
+	}()
+
+	for {
+		messages, err := q.Receive(ctx, maxBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(messages) == 0 {
+			return nil, errors.Wrapf(ErrDeadLetterNotFound, "message %s", messageID)
+		}
+
+		var found *DeadLetterMessage
+		for _, message := range messages {
+			if message.MessageID == messageID && found == nil {
+				found = message
+				continue
+			}
+			others = append(others, message)
+		}
+
+		if found != nil {
+			return found, nil
+		}
+	}
+}
+
+// Release makes received dead letters visible again
+func (q *DeadLetterQueue) Release(ctx context.Context, messages ...*DeadLetterMessage) error {
+	for _, message := range messages {
+		_, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(q.queueURL),
+			ReceiptHandle:     aws.String(message.ReceiptHandle),
+			VisibilityTimeout: 0,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to release dead letter %s", message.MessageID)
+		}
+	}
+
+	return nil
+}
+
+// Redrive sends the dead letter back to its source queue without the failure
+// attributes and deletes it from the dead-letter queue
+func (q *DeadLetterQueue) Redrive(ctx context.Context, message *DeadLetterMessage) error {
+	target := message.SourceQueue
+	if target == "" {
+		target = q.sourceQueueURL
+	}
+	if target == "" {
+		return errors.Errorf("no source queue to redrive dead letter %s to", message.MessageID)
+	}
+
+	attrs := make(map[string]types.MessageAttributeValue, len(message.Attributes))
+	for k, v := range message.Attributes {
+		if !strings.HasPrefix(k, "dlq_") {
+			attrs[k] = v
+		}
+	}
+
+	groupID, deduplicationID := fifoMessageIDs(target, message.GroupID, message.MessageID)
+
+	_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(target),
+		MessageBody:            aws.String(message.Body),
+		MessageAttributes:      attrs,
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: deduplicationID,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to redrive dead letter %s", message.MessageID)
+	}
+
+	if err := q.delete(ctx, message); err != nil {
+		return err
+	}
+
+	telemetry.RecordCounter(ctx, "sqs_dlq_redriven_total", "Total dead letters redriven to their source queue", 1,
+		attribute.String("queue", queueName(q.queueURL)),
+	)
+
+	return nil
+}
+
+// RedriveAll redrives every dead letter and returns how many were redriven
+func (q *DeadLetterQueue) RedriveAll(ctx context.Context) (int, error) {
+	redriven := 0
+	for {
+		messages, err := q.Receive(ctx, maxBatchSize)
+		if err != nil {
+			return redriven, err
+		}
+
+		if len(messages) == 0 {
+			return redriven, nil
+		}
+
+		for i, message := range messages {
+			if err := q.Redrive(ctx, message); err != nil {
+				_ = q.Release(ctx, messages[i:]...)
+				return redriven, err
+			}
+			redriven++
+		}
+	}
+}
+
+// Replace stores the dead letter again with its current Body, keeping its
+// attributes, and deletes the received copy. It returns the new message ID.
+func (q *DeadLetterQueue) Replace(ctx context.Context, message *DeadLetterMessage) (string, error) {
+	groupID, deduplicationID := fifoMessageIDs(q.queueURL, message.GroupID, message.MessageID)
+
+	output, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(q.queueURL),
+		MessageBody:            aws.String(message.Body),
+		MessageAttributes:      message.Attributes,
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: deduplicationID,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to replace dead letter %s", message.MessageID)
+	}
+
+	if err := q.delete(ctx, message); err != nil {
+		return "", err
+	}
+
+	return aws.ToString(output.MessageId), nil
+}
+
+// Depth returns the approximate number of messages in the dead-letter queue
+func (q *DeadLetterQueue) Depth(ctx context.Context) (int, error) {
+	output, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(q.queueURL),
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+			types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		},
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get dead-letter queue attributes")
+	}
+
+	depth := 0
+	for _, name := range []types.QueueAttributeName{
+		types.QueueAttributeNameApproximateNumberOfMessages,
+		types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+	} {
+		count, err := strconv.Atoi(output.Attributes[string(name)])
+		if err == nil {
+			depth += count
+		}
+	}
+
+	return depth, nil
+}
+
+func (q *DeadLetterQueue) delete(ctx context.Context, message *DeadLetterMessage) error {
+	_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.queueURL),
+		ReceiptHandle: aws.String(message.ReceiptHandle),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete dead letter %s", message.MessageID)
+	}
+	return nil
+}
+
+func newDeadLetterMessage(message types.Message) *DeadLetterMessage {
+	dl := &DeadLetterMessage{
+		MessageID:     aws.ToString(message.MessageId),
+		ReceiptHandle: aws.ToString(message.ReceiptHandle),
+		Body:          aws.ToString(message.Body),
+		Attributes:    message.MessageAttributes,
+	}
+
+	if dl.Attributes == nil {
+		dl.Attributes = make(map[string]types.MessageAttributeValue)
+	}
+
+	dl.FailureReason = aws.ToString(dl.Attributes[DLQFailureReasonKey].StringValue)
+	dl.SourceQueue = aws.ToString(dl.Attributes[DLQSourceQueueKey].StringValue)
+	dl.ReceiveCount, _ = strconv.Atoi(aws.ToString(dl.Attributes[DLQReceiveCountKey].StringValue))
+	dl.FailedAt, _ = time.Parse(time.RFC3339, aws.ToString(dl.Attributes[DLQFailedAtKey].StringValue))
+
+	dl.GroupID = aws.ToString(dl.Attributes[DLQMessageGroupKey].StringValue)
+	if dl.GroupID == "" {
+		dl.GroupID = message.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+	}
+
+	return dl
+}
+
+// DeadLetterMonitor periodically records the depth of a service's
+// dead-letter queue as the sqs_dlq_depth gauge
+type DeadLetterMonitor struct {
+	mux     sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+
+	queue    *DeadLetterQueue
+	service  string
+	interval time.Duration
+}
+
+// NewDeadLetterMonitor creates a new DeadLetterMonitor
+func NewDeadLetterMonitor(queue *DeadLetterQueue, service string, interval time.Duration) *DeadLetterMonitor {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &DeadLetterMonitor{
+		queue:    queue,
+		service:  service,
+		interval: interval,
+	}
+}
+
+// Start starts recording the depth in the background
+func (m *DeadLetterMonitor) Start(ctx context.Context) error {
+	if m.running.Load() {
+		return nil
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go m.run(ctx, m.done)
+
+	m.running.Store(true)
+
+	return nil
+}
+
+// Stop stops the monitor
+func (m *DeadLetterMonitor) Stop(ctx context.Context) error {
+	if !m.running.Load() {
+		return nil
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.cancel()
+
+	select {
+	case <-m.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	m.cancel = nil
+	m.done = nil
+	m.running.Store(false)
+
+	return nil
+}
+
+func (m *DeadLetterMonitor) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		_ = m.RecordDepth(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecordDepth reads the depth of the dead-letter queue and records it
+func (m *DeadLetterMonitor) RecordDepth(ctx context.Context) error {
+	depth, err := m.queue.Depth(ctx)
+	if err != nil {
+		return err
+	}
+
+	telemetry.RecordGauge(ctx, "sqs_dlq_depth", "Messages in the dead-letter queue", float64(depth),
+		attribute.String("service", m.service),
+		attribute.String("queue", queueName(m.queue.QueueURL())),
+	)
+
+	return nil
+}
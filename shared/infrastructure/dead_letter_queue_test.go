@@ -0,0 +1,345 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testQueueURL           = "http://sqs.test/000000000000/wallet-events"
+	testDeadLetterQueueURL = "http://sqs.test/000000000000/wallet-events-dlq"
+)
+
+type fakeSQSMessage struct {
+	ID         string                                 `json:"MessageId"`
+	Receipt    string                                 `json:"ReceiptHandle"`
+	Body       string                                 `json:"Body"`
+	Attributes map[string]types.MessageAttributeValue `json:"MessageAttributes,omitempty"`
+	// System holds the system attributes, the FIFO message group
+	System          map[string]string `json:"Attributes,omitempty"`
+	DeduplicationID string            `json:"-"`
+	hidden          bool
+}
+
+// fakeSQS serves the subset of the SQS JSON protocol used by the subscriber
+// and DeadLetterQueue, keeping messages in memory
+type fakeSQS struct {
+	mux    sync.Mutex
+	queues map[string][]*fakeSQSMessage
+	calls  map[string]int
+	nextID int
+}
+
+func newFakeSQS(t *testing.T) (*fakeSQS, *sqs.Client) {
+	fake := &fakeSQS{
+		queues: make(map[string][]*fakeSQSMessage),
+		calls:  make(map[string]int),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := sqs.New(sqs.Options{
+		Region:                           "us-east-1",
+		BaseEndpoint:                     aws.String(server.URL),
+		Credentials:                      aws.AnonymousCredentials{},
+		DisableMessageChecksumValidation: true,
+	})
+
+	return fake, client
+}
+
+func (f *fakeSQS) add(queueURL, body string, attrs map[string]types.MessageAttributeValue) *fakeSQSMessage {
+	f.nextID++
+	message := &fakeSQSMessage{
+		ID:         fmt.Sprintf("message-%d", f.nextID),
+		Receipt:    fmt.Sprintf("receipt-%d", f.nextID),
+		Body:       body,
+		Attributes: attrs,
+	}
+	f.queues[queueURL] = append(f.queues[queueURL], message)
+	return message
+}
+
+func (f *fakeSQS) messages(queueURL string) []*fakeSQSMessage {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.queues[queueURL]
+}
+
+func (f *fakeSQS) callCount(action string) int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.calls[action]
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	var input struct {
+		QueueUrl               string
+		MessageBody            string
+		MessageAttributes      map[string]types.MessageAttributeValue
+		MessageGroupId         string
+		MessageDeduplicationId string
+		ReceiptHandle          string
+		VisibilityTimeout      int32
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.")
+	f.calls[action]++
+
+	var output interface{} = struct{}{}
+	switch action {
+	case "SendMessage":
+		message := f.add(input.QueueUrl, input.MessageBody, input.MessageAttributes)
+		if input.MessageGroupId != "" {
+			message.System = map[string]string{"MessageGroupId": input.MessageGroupId}
+		}
+		message.DeduplicationID = input.MessageDeduplicationId
+		output = map[string]string{"MessageId": message.ID}
+	case "ReceiveMessage":
+		var received []*fakeSQSMessage
+		for _, message := range f.queues[input.QueueUrl] {
+			if !message.hidden {
+				message.hidden = true
+				received = append(received, message)
+			}
+		}
+		output = map[string][]*fakeSQSMessage{"Messages": received}
+	case "ChangeMessageVisibility":
+		for _, message := range f.queues[input.QueueUrl] {
+			if message.Receipt == input.ReceiptHandle {
+				message.hidden = input.VisibilityTimeout > 0
+			}
+		}
+	case "DeleteMessage":
+		messages := f.queues[input.QueueUrl][:0]
+		for _, message := range f.queues[input.QueueUrl] {
+			if message.Receipt != input.ReceiptHandle {
+				messages = append(messages, message)
+			}
+		}
+		f.queues[input.QueueUrl] = messages
+	case "GetQueueAttributes":
+		visible, hidden := 0, 0
+		for _, message := range f.queues[input.QueueUrl] {
+			if message.hidden {
+				hidden++
+			} else {
+				visible++
+			}
+		}
+		output = map[string]map[string]string{"Attributes": {
+			"ApproximateNumberOfMessages":           fmt.Sprint(visible),
+			"ApproximateNumberOfMessagesNotVisible": fmt.Sprint(hidden),
+		}}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	_ = json.NewEncoder(w).Encode(output)
+}
+
+func stringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+func TestSQSEventSubscriber_DeadLetter(t *testing.T) {
+	tests := []struct {
+		name                string
+		receiveCount        string
+		handlerErr          error
+		expectedDeadLetter  bool
+		expectedInQueue     bool
+		expectedReasonMatch string
+	}{
+		{
+			name:                "failure below max receive count stays in the queue",
+			receiveCount:        "2",
+			handlerErr:          assert.AnError,
+			expectedInQueue:     true,
+			expectedReasonMatch: "",
+		},
+		{
+			name:                "failure at max receive count is dead-lettered",
+			receiveCount:        "3",
+			handlerErr:          assert.AnError,
+			expectedDeadLetter:  true,
+			expectedReasonMatch: assert.AnError.Error(),
+		},
+		{
+			name:            "open circuit at max receive count stays in the queue",
+			receiveCount:    "3",
+			handlerErr:      errors.Wrap(ErrCircuitOpen, "handler test"),
+			expectedInQueue: true,
+		},
+		{
+			name:         "success is deleted",
+			receiveCount: "3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeSQS(t)
+			stored := fake.add(testQueueURL, `{"id":"event-1"}`, map[string]types.MessageAttributeValue{
+				"topic": stringAttribute(events.PaymentCreatedEvent),
+			})
+
+			subscriber := NewSQSEventSubscriber(client, testQueueURL, nil,
+				WithDeadLetterQueue(testDeadLetterQueueURL, 3),
+			)
+
+			err := subscriber.clean(context.Background(), &sqsMessage{
+				Message: types.Message{
+					MessageId:         aws.String(stored.ID),
+					ReceiptHandle:     aws.String(stored.Receipt),
+					Body:              aws.String(stored.Body),
+					Attributes:        map[string]string{"ApproximateReceiveCount": tt.receiveCount},
+					MessageAttributes: stored.Attributes,
+				},
+				Err: tt.handlerErr,
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedInQueue, len(fake.messages(testQueueURL)) == 1)
+			if tt.expectedInQueue {
+				assert.True(t, fake.messages(testQueueURL)[0].hidden, "the message waits before it is retried")
+			}
+
+			deadLetters := fake.messages(testDeadLetterQueueURL)
+			if !tt.expectedDeadLetter {
+				assert.Empty(t, deadLetters)
+				return
+			}
+
+			require.Len(t, deadLetters, 1)
+			dl := newDeadLetterMessage(types.Message{
+				MessageId:         aws.String(deadLetters[0].ID),
+				Body:              aws.String(deadLetters[0].Body),
+				MessageAttributes: deadLetters[0].Attributes,
+			})
+			assert.Equal(t, stored.Body, dl.Body)
+			assert.Equal(t, tt.expectedReasonMatch, dl.FailureReason)
+			assert.Equal(t, testQueueURL, dl.SourceQueue)
+			assert.Equal(t, 3, dl.ReceiveCount)
+			assert.False(t, dl.FailedAt.IsZero())
+			assert.Equal(t, events.PaymentCreatedEvent, aws.ToString(dl.Attributes["topic"].StringValue))
+		})
+	}
+}
+
+func TestDeadLetterQueue_Redrive(t *testing.T) {
+	fake, client := newFakeSQS(t)
+	fake.add(testDeadLetterQueueURL, `{"id":"event-1"}`, nil)
+	failed := fake.add(testDeadLetterQueueURL, `{"id":"event-2"}`, map[string]types.MessageAttributeValue{
+		"topic":             stringAttribute(events.PaymentCreatedEvent),
+		DLQFailureReasonKey: stringAttribute("boom"),
+		DLQSourceQueueKey:   stringAttribute(testQueueURL),
+	})
+
+	queue := NewDeadLetterQueue(client, testDeadLetterQueueURL, "")
+
+	message, err := queue.Find(context.Background(), failed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "boom", message.FailureReason)
+
+	message.Body = `{"id":"event-2","fixed":true}`
+	require.NoError(t, queue.Redrive(context.Background(), message))
+
+	redriven := fake.messages(testQueueURL)
+	require.Len(t, redriven, 1)
+	assert.Equal(t, message.Body, redriven[0].Body)
+	assert.Equal(t, map[string]types.MessageAttributeValue{
+		"topic": stringAttribute(events.PaymentCreatedEvent),
+	}, redriven[0].Attributes)
+
+	remaining := fake.messages(testDeadLetterQueueURL)
+	require.Len(t, remaining, 1)
+	assert.False(t, remaining[0].hidden, "messages received while searching are released")
+
+	depth, err := queue.Depth(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, depth)
+
+	_, err = queue.Find(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+}
+
+func TestDeadLetterQueue_FIFO(t *testing.T) {
+	const (
+		fifoQueueURL           = "http://sqs.test/000000000000/payment-events.fifo"
+		fifoDeadLetterQueueURL = "http://sqs.test/000000000000/payment-events-dlq.fifo"
+	)
+
+	fake, client := newFakeSQS(t)
+	stored := fake.add(fifoQueueURL, `{"id":"event-1"}`, nil)
+
+	subscriber := NewSQSEventSubscriber(client, fifoQueueURL, nil,
+		WithDeadLetterQueue(fifoDeadLetterQueueURL, 1),
+	)
+
+	err := subscriber.clean(context.Background(), &sqsMessage{
+		Message: types.Message{
+			MessageId:     aws.String(stored.ID),
+			ReceiptHandle: aws.String(stored.Receipt),
+			Body:          aws.String(stored.Body),
+			Attributes: map[string]string{
+				"ApproximateReceiveCount": "1",
+				"MessageGroupId":          "payment-1",
+			},
+		},
+		Err: assert.AnError,
+	})
+	require.NoError(t, err)
+
+	deadLetters := fake.messages(fifoDeadLetterQueueURL)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, map[string]string{"MessageGroupId": "payment-1"}, deadLetters[0].System)
+	assert.Equal(t, stored.ID, deadLetters[0].DeduplicationID)
+
+	queue := NewDeadLetterQueue(client, fifoDeadLetterQueueURL, fifoQueueURL)
+
+	message, err := queue.Find(context.Background(), deadLetters[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "payment-1", message.GroupID)
+
+	replacedID, err := queue.Replace(context.Background(), message)
+	require.NoError(t, err)
+
+	replaced := fake.messages(fifoDeadLetterQueueURL)
+	require.Len(t, replaced, 1)
+	assert.Equal(t, map[string]string{"MessageGroupId": "payment-1"}, replaced[0].System)
+	assert.Equal(t, message.MessageID, replaced[0].DeduplicationID)
+
+	message, err = queue.Find(context.Background(), replacedID)
+	require.NoError(t, err)
+	require.NoError(t, queue.Redrive(context.Background(), message))
+
+	redriven := fake.messages(fifoQueueURL)
+	require.Len(t, redriven, 1)
+	assert.Equal(t, map[string]string{"MessageGroupId": "payment-1"}, redriven[0].System)
+	assert.Equal(t, replacedID, redriven[0].DeduplicationID)
+	assert.NotContains(t, redriven[0].Attributes, DLQMessageGroupKey)
+}
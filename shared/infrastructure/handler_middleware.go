@@ -0,0 +1,350 @@
+package infrastructure
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrCircuitOpen is returned without calling the handler while its circuit
+// breaker is open. Subscribers return the message to the queue after a delay
+// without counting it as a failed delivery.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// HandlerMiddleware decorates an EventHandler with a cross-cutting concern
+type HandlerMiddleware func(next EventHandler) EventHandler
+
+// ChainHandler wraps handler with middlewares. The first middleware is the
+// outermost one and sees the event first.
+func ChainHandler(handler EventHandler, middlewares ...HandlerMiddleware) EventHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// permanentError marks a failure that handling the same event again cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not retryable, e.g. an event that cannot be decoded
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, was marked with
+// Permanent, is a payload error or is a handler panic
+func IsPermanent(err error) bool {
+	var (
+		permanent  *permanentError
+		payloadErr *events.PayloadError
+		panicErr   *events.PanicError
+	)
+	return errors.As(err, &permanent) || errors.As(err, &payloadErr) || errors.As(err, &panicErr)
+}
+
+// RecoverMiddleware turns a panic in the handler into an *events.PanicError,
+// the error the Router returns for a panicking route
+func RecoverMiddleware() HandlerMiddleware {
+	return func(next EventHandler) EventHandler {
+		return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = &events.PanicError{Value: p, Stack: debug.Stack()}
+				}
+			}()
+
+			return next.Handle(ctx, event)
+		})
+	}
+}
+
+// SchemaValidationMiddleware rejects events of topics known to registry whose
+// payload does not match the topic's JSON Schema, before the handler runs.
+// The *events.PayloadError names the offending field; it is permanent, so
+// subscribers dead-letter the event with it as the failure reason.
+func SchemaValidationMiddleware(registry *events.Registry) HandlerMiddleware {
+	return func(next EventHandler) EventHandler {
+		return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) error {
+			if _, ok := registry.Lookup(event.Topic); !ok {
+				return next.Handle(ctx, event)
+			}
+
+			if _, err := registry.Decode(event); err != nil {
+				telemetry.RecordCounter(ctx, "events_rejected_total", "Total events rejected by schema validation", 1,
+					attribute.String("handler", next.HandlerID()),
+					attribute.String("event_type", event.Topic.String()),
+				)
+				return err
+			}
+
+			return next.Handle(ctx, event)
+		})
+	}
+}
+
+// TimeoutMiddleware cancels the handler context after timeout. Handlers stop
+// at their next context-aware call, such as a database query.
+func TimeoutMiddleware(timeout time.Duration) HandlerMiddleware {
+	return func(next EventHandler) EventHandler {
+		return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			return next.Handle(ctx, event)
+		})
+	}
+}
+
+// RetryMiddleware calls the handler up to maxAttempts times, waiting a
+// jittered exponential backoff between attempts. Permanent errors and an
+// open circuit are returned at once.
+func RetryMiddleware(maxAttempts int, baseBackoff, maxBackoff time.Duration) HandlerMiddleware {
+	return func(next EventHandler) EventHandler {
+		return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) error {
+			var err error
+			for attempt := 1; ; attempt++ {
+				if err = next.Handle(ctx, event); err == nil {
+					return nil
+				}
+
+				if attempt >= maxAttempts || IsPermanent(err) || errors.Is(err, ErrCircuitOpen) {
+					return err
+				}
+
+				telemetry.RecordCounter(ctx, "event_handler_retries_total", "Total event handler retries", 1,
+					attribute.String("handler", next.HandlerID()),
+					attribute.String("event_type", event.Topic.String()),
+				)
+
+				sleepContext(ctx, jitteredBackoff(baseBackoff, maxBackoff, attempt))
+				if ctx.Err() != nil {
+					return err
+				}
+			}
+		})
+	}
+}
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// circuitBreaker opens after threshold consecutive failures and lets a
+// single trial call through once cooldown has elapsed
+type circuitBreaker struct {
+	mux       sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     circuitClosed,
+		now:       time.Now,
+	}
+}
+
+// allow reports whether a call may go through and returns the state it
+// moved to, if any
+func (b *circuitBreaker) allow() (bool, string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false, ""
+		}
+		b.state = circuitHalfOpen
+		return true, circuitHalfOpen
+	case circuitHalfOpen:
+		// The trial call is in flight
+		return false, ""
+	default:
+		return true, ""
+	}
+}
+
+// record updates the breaker with the outcome of a call and returns the
+// state it moved to, if any
+func (b *circuitBreaker) record(failed bool) string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if !failed {
+		b.failures = 0
+		if b.state != circuitClosed {
+			b.state = circuitClosed
+			return circuitClosed
+		}
+		return ""
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+		b.state = circuitOpen
+		b.openedAt = b.now()
+		return circuitOpen
+	}
+
+	return ""
+}
+
+// abort ends a trial call without an outcome, so the next call after the
+// cooldown is a new trial
+func (b *circuitBreaker) abort() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+	}
+}
+
+// CircuitBreakerMiddleware keeps one circuit breaker per topic. It stops
+// calling the handler for a topic after threshold consecutive failures and
+// returns ErrCircuitOpen instead, so a failing dependency is not hammered by
+// every message while other topics keep flowing. After cooldown one event of
+// the topic is let through; its success closes the circuit. Permanent errors
+// are the event's fault and count as successes.
+func CircuitBreakerMiddleware(threshold int, cooldown time.Duration) HandlerMiddleware {
+	return func(next EventHandler) EventHandler {
+		var (
+			mux      sync.Mutex
+			breakers = make(map[events.Topic]*circuitBreaker)
+		)
+
+		breakerFor := func(topic events.Topic) *circuitBreaker {
+			mux.Lock()
+			defer mux.Unlock()
+
+			breaker, ok := breakers[topic]
+			if !ok {
+				breaker = newCircuitBreaker(threshold, cooldown)
+				breakers[topic] = breaker
+			}
+			return breaker
+		}
+
+		recordTransition := func(ctx context.Context, topic events.Topic, state string) {
+			if state == "" {
+				return
+			}
+			telemetry.RecordCounter(ctx, "event_handler_circuit_transitions_total", "Total event handler circuit breaker state changes", 1,
+				attribute.String("handler", next.HandlerID()),
+				attribute.String("event_type", topic.String()),
+				attribute.String("state", state),
+			)
+		}
+
+		return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) error {
+			breaker := breakerFor(event.Topic)
+
+			allowed, state := breaker.allow()
+			recordTransition(ctx, event.Topic, state)
+			if !allowed {
+				return errors.Wrapf(ErrCircuitOpen, "handler %s, topic %s", next.HandlerID(), event.Topic)
+			}
+
+			err := next.Handle(ctx, event)
+
+			// Calls cut short by shutdown say nothing about the dependency
+			if err != nil && ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				breaker.abort()
+				return err
+			}
+
+			recordTransition(ctx, event.Topic, breaker.record(err != nil && !IsPermanent(err)))
+			return err
+		})
+	}
+}
+
+// HandlerMetricsMiddleware records events_handled_total and
+// event_handle_duration_seconds per handler, event type and status
+func HandlerMetricsMiddleware() HandlerMiddleware {
+	return func(next EventHandler) EventHandler {
+		return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) error {
+			start := time.Now()
+			err := next.Handle(ctx, event)
+
+			status := "success"
+			switch {
+			case errors.Is(err, ErrCircuitOpen):
+				status = "rejected"
+			case err != nil:
+				status = "error"
+			}
+
+			telemetry.RecordCounter(ctx, "events_handled_total", "Total events handled", 1,
+				attribute.String("handler", next.HandlerID()),
+				attribute.String("event_type", event.Topic.String()),
+				attribute.String("status", status),
+			)
+			telemetry.RecordHistogram(ctx, "event_handle_duration_seconds", "Event handling duration", time.Since(start).Seconds(),
+				attribute.String("handler", next.HandlerID()),
+				attribute.String("event_type", event.Topic.String()),
+				attribute.String("status", status),
+			)
+
+			return err
+		})
+	}
+}
+
+// HandlerLoggingMiddleware logs failed events with their topic, ID and
+// correlation ID, and the stack of panics. A nil logger uses the standard
+// logger.
+func HandlerLoggingMiddleware(logger *log.Logger) HandlerMiddleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next EventHandler) EventHandler {
+		return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) error {
+			err := next.Handle(ctx, event)
+			if err == nil {
+				return nil
+			}
+
+			logger.Printf("handler %s failed for %s %s (correlation %s): %v",
+				next.HandlerID(), event.Topic, event.ID, event.CorrelationID, err)
+
+			var panicErr *events.PanicError
+			if errors.As(err, &panicErr) {
+				logger.Printf("%s", panicErr.Stack)
+			}
+
+			return err
+		})
+	}
+}
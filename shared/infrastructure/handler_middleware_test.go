@@ -0,0 +1,208 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainHandler(t *testing.T) {
+	var calls []string
+	tag := func(name string) HandlerMiddleware {
+		return func(next EventHandler) EventHandler {
+			return NewEventHandlerFunc(next.HandlerID(), func(ctx context.Context, event *events.Event) error {
+				calls = append(calls, name)
+				return next.Handle(ctx, event)
+			})
+		}
+	}
+
+	handler := ChainHandler(
+		NewEventHandlerFunc("handler", func(ctx context.Context, event *events.Event) error {
+			calls = append(calls, "handler")
+			return nil
+		}),
+		tag("outer"),
+		tag("inner"),
+	)
+
+	require.NoError(t, handler.Handle(context.Background(), events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)))
+	assert.Equal(t, []string{"outer", "inner", "handler"}, calls)
+	assert.Equal(t, "handler", handler.HandlerID())
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	tests := []struct {
+		name             string
+		errs             []error
+		panics           bool
+		expectedAttempts int
+		expectedErr      bool
+	}{
+		{
+			name:             "succeeds after transient failures",
+			errs:             []error{assert.AnError, assert.AnError},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up after max attempts",
+			errs:             []error{assert.AnError, assert.AnError, assert.AnError, assert.AnError},
+			expectedAttempts: 3,
+			expectedErr:      true,
+		},
+		{
+			name:             "permanent error is not retried",
+			errs:             []error{Permanent(assert.AnError)},
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+		{
+			name:             "recovered panic is not retried",
+			panics:           true,
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			handler := ChainHandler(
+				NewEventHandlerFunc("handler", func(ctx context.Context, event *events.Event) error {
+					attempts++
+					if tt.panics {
+						_ = event.Data.(map[string]interface{})["amount"]
+					}
+					if attempts <= len(tt.errs) {
+						return tt.errs[attempts-1]
+					}
+					return nil
+				}),
+				RetryMiddleware(3, time.Millisecond, time.Millisecond),
+				RecoverMiddleware(),
+			)
+
+			err := handler.Handle(context.Background(), events.NewEvent("payment-1", events.PaymentCreatedEvent, nil))
+
+			assert.Equal(t, tt.expectedAttempts, attempts)
+			assert.Equal(t, tt.expectedErr, err != nil)
+		})
+	}
+}
+
+type schemaTestPayload struct {
+	WalletID string       `json:"wallet_id" validate:"required"`
+	Amount   models.Money `json:"amount"`
+}
+
+func TestSchemaValidationMiddleware(t *testing.T) {
+	registry := events.NewRegistry().MustRegister(events.WalletCreditRequestedEvent, schemaTestPayload{})
+
+	tests := []struct {
+		name          string
+		event         *events.Event
+		expectedField string
+	}{
+		{
+			name: "valid payload",
+			event: events.NewEvent("payment-1", events.WalletCreditRequestedEvent,
+				json.RawMessage(`{"wallet_id":"wallet-1","amount":{"amount":100,"currency":"USD"}}`)),
+		},
+		{
+			name: "missing wallet id",
+			event: events.NewEvent("payment-1", events.WalletCreditRequestedEvent,
+				json.RawMessage(`{"amount":{"amount":100,"currency":"USD"}}`)),
+			expectedField: "wallet_id",
+		},
+		{
+			name: "empty currency",
+			event: events.NewEvent("payment-1", events.WalletCreditRequestedEvent,
+				json.RawMessage(`{"wallet_id":"wallet-1","amount":{"amount":100,"currency":""}}`)),
+			expectedField: "amount.currency",
+		},
+		{
+			name:  "topic unknown to the registry",
+			event: events.NewEvent("payment-1", events.PaymentCreatedEvent, json.RawMessage(`{}`)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := ChainHandler(
+				NewEventHandlerFunc("handler", func(ctx context.Context, event *events.Event) error {
+					calls++
+					return nil
+				}),
+				SchemaValidationMiddleware(registry),
+			)
+
+			err := handler.Handle(context.Background(), tt.event)
+
+			if tt.expectedField == "" {
+				require.NoError(t, err)
+				assert.Equal(t, 1, calls)
+				return
+			}
+
+			var payloadErr *events.PayloadError
+			require.ErrorAs(t, err, &payloadErr)
+			assert.Equal(t, tt.expectedField, payloadErr.Field)
+			assert.True(t, IsPermanent(err), "invalid events are dead-lettered")
+			assert.Zero(t, calls)
+		})
+	}
+}
+
+func TestCircuitBreakerMiddleware(t *testing.T) {
+	failing := true
+	calls := 0
+	handler := CircuitBreakerMiddleware(2, time.Minute)(
+		NewEventHandlerFunc("handler", func(ctx context.Context, event *events.Event) error {
+			calls++
+			if failing {
+				return assert.AnError
+			}
+			return nil
+		}),
+	)
+	event := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+
+	assert.ErrorIs(t, handler.Handle(context.Background(), event), assert.AnError)
+	assert.ErrorIs(t, handler.Handle(context.Background(), event), assert.AnError)
+	assert.ErrorIs(t, handler.Handle(context.Background(), event), ErrCircuitOpen)
+	assert.Equal(t, 2, calls, "the handler is not called while the circuit is open")
+
+	failing = false
+	other := events.NewEvent("payment-1", events.PaymentProcessingEvent, nil)
+	assert.NoError(t, handler.Handle(context.Background(), other), "other topics have their own circuit")
+	assert.Equal(t, 3, calls)
+	assert.ErrorIs(t, handler.Handle(context.Background(), event), ErrCircuitOpen)
+
+	breaker := newCircuitBreaker(2, time.Minute)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	breaker.record(true)
+	breaker.record(true)
+
+	allowed, _ := breaker.allow()
+	assert.False(t, allowed)
+
+	now = now.Add(time.Minute)
+	allowed, state := breaker.allow()
+	assert.True(t, allowed)
+	assert.Equal(t, circuitHalfOpen, state)
+
+	allowed, _ = breaker.allow()
+	assert.False(t, allowed, "a single trial call goes through while half open")
+
+	assert.Equal(t, circuitClosed, breaker.record(false))
+	allowed, _ = breaker.allow()
+	assert.True(t, allowed)
+}
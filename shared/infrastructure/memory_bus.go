@@ -0,0 +1,382 @@
+package infrastructure
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/pkg/errors"
+)
+
+var (
+	_ events.Publisher  = (*InMemoryBus)(nil)
+	_ events.Subscriber = (*InMemoryBus)(nil)
+
+	ErrBusClosed = errors.New("bus is closed")
+)
+
+// drainPollInterval is how often Drain checks for in-flight deliveries
+const drainPollInterval = 5 * time.Millisecond
+
+// Delivery describes one attempt to hand an event to a subscription
+type Delivery struct {
+	Event     *events.Event
+	Pattern   events.Topic
+	HandlerID string
+	Attempt   int
+	Err       error
+}
+
+// DeadLetter is an event a subscription failed to handle on every attempt
+type DeadLetter struct {
+	Event     *events.Event
+	HandlerID string
+	Attempts  int
+	Err       error
+}
+
+type memoryBusOptions struct {
+	async           bool
+	workers         int
+	maxDeliveries   int
+	redeliveryDelay time.Duration
+	codec           Codec
+	hooks           []func(Delivery)
+}
+
+type MemoryBusOption func(*memoryBusOptions)
+
+// WithAsyncDelivery delivers events on a pool of worker goroutines instead of
+// inside Publish. Use Drain to wait for delivery to finish.
+func WithAsyncDelivery(workers int) MemoryBusOption {
+	return func(o *memoryBusOptions) {
+		o.async = true
+		if workers > 0 {
+			o.workers = workers
+		}
+	}
+}
+
+// WithMaxDeliveries sets how many times a failing event is handed to a
+// subscription before it is dead-lettered
+func WithMaxDeliveries(maxDeliveries int) MemoryBusOption {
+	return func(o *memoryBusOptions) {
+		if maxDeliveries > 0 {
+			o.maxDeliveries = maxDeliveries
+		}
+	}
+}
+
+// WithRedeliveryDelay sets the wait before an async redelivery
+func WithRedeliveryDelay(delay time.Duration) MemoryBusOption {
+	return func(o *memoryBusOptions) {
+		o.redeliveryDelay = delay
+	}
+}
+
+// WithBusCodec sets the codec events are passed through on delivery so
+// handlers see the same payload shape as with SNS/SQS
+func WithBusCodec(codec Codec) MemoryBusOption {
+	return func(o *memoryBusOptions) {
+		o.codec = codec
+	}
+}
+
+// WithDeliveryHook registers a function called after every delivery attempt
+func WithDeliveryHook(hook func(Delivery)) MemoryBusOption {
+	return func(o *memoryBusOptions) {
+		o.hooks = append(o.hooks, hook)
+	}
+}
+
+// memorySubscription is the in-process counterpart of an SQS queue
+// subscribed to the topic
+type memorySubscription struct {
+	pattern events.Topic
+	handler events.EventHandler
+	id      string
+}
+
+type memoryDelivery struct {
+	subscription *memorySubscription
+	body         []byte
+	attempt      int
+}
+
+// InMemoryBus is an in-process events.Publisher and events.Subscriber for
+// local runs and tests. Every subscription whose topic pattern matches a
+// published event receives its own copy, and failed deliveries are retried
+// (at-least-once) before being dead-lettered.
+type InMemoryBus struct {
+	options *memoryBusOptions
+
+	mux           sync.Mutex
+	cond          *sync.Cond
+	subscriptions []*memorySubscription
+	queue         []*memoryDelivery
+	inflight      int
+	closed        bool
+	published     []*events.Event
+	deadLetters   []DeadLetter
+
+	workers sync.WaitGroup
+}
+
+// NewInMemoryBus creates a new InMemoryBus
+func NewInMemoryBus(opts ...MemoryBusOption) *InMemoryBus {
+	options := &memoryBusOptions{
+		workers:       1,
+		maxDeliveries: 3,
+		codec:         NewJSONCodec(),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	bus := &InMemoryBus{options: options}
+	bus.cond = sync.NewCond(&bus.mux)
+
+	if options.async {
+		for i := 0; i < options.workers; i++ {
+			bus.workers.Add(1)
+			go bus.work()
+		}
+	}
+
+	return bus
+}
+
+// Subscribe registers handler for events whose topic matches eventType. An
+// empty eventType subscribes to every event.
+func (b *InMemoryBus) Subscribe(ctx context.Context, eventType string, handler events.EventHandler) error {
+	pattern := events.Topic(eventType)
+	if pattern == "" {
+		pattern = "#"
+	}
+
+	id := "in-memory-subscriber"
+	if h, ok := handler.(EventHandler); ok {
+		id = h.HandlerID()
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+
+	b.subscriptions = append(b.subscriptions, &memorySubscription{
+		pattern: pattern,
+		handler: handler,
+		id:      id,
+	})
+	return nil
+}
+
+// Publish encodes the events and hands them to every matching subscription
+func (b *InMemoryBus) Publish(ctx context.Context, evts ...*events.Event) error {
+	var deliveries []*memoryDelivery
+
+	for _, event := range evts {
+		body, err := b.options.codec.Encode(event)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode event")
+		}
+
+		b.mux.Lock()
+		if b.closed {
+			b.mux.Unlock()
+			return ErrBusClosed
+		}
+
+		b.published = append(b.published, event.Clone())
+		for _, sub := range b.subscriptions {
+			if event.Topic.Matches(sub.pattern) {
+				deliveries = append(deliveries, &memoryDelivery{subscription: sub, body: body, attempt: 1})
+			}
+		}
+		b.mux.Unlock()
+	}
+
+	if b.options.async {
+		b.mux.Lock()
+		b.queue = append(b.queue, deliveries...)
+		b.inflight += len(deliveries)
+		b.cond.Broadcast()
+		b.mux.Unlock()
+		return nil
+	}
+
+	// Synchronous delivery retries inline, so a published event has been
+	// handled or dead-lettered by the time Publish returns
+	for _, d := range deliveries {
+		for !b.deliver(ctx, d) {
+			d.attempt++
+		}
+	}
+
+	return nil
+}
+
+// deliver hands d to its subscription once and reports whether it is done,
+// either handled or dead-lettered
+func (b *InMemoryBus) deliver(ctx context.Context, d *memoryDelivery) bool {
+	event, err := b.options.codec.Decode(d.body)
+	if err == nil {
+		err = d.subscription.handler.Handle(events.ContextWithEvent(ctx, event), event)
+	}
+
+	for _, hook := range b.options.hooks {
+		hook(Delivery{
+			Event:     event,
+			Pattern:   d.subscription.pattern,
+			HandlerID: d.subscription.id,
+			Attempt:   d.attempt,
+			Err:       err,
+		})
+	}
+
+	if err == nil {
+		return true
+	}
+
+	if d.attempt < b.options.maxDeliveries {
+		return false
+	}
+
+	b.mux.Lock()
+	b.deadLetters = append(b.deadLetters, DeadLetter{
+		Event:     event,
+		HandlerID: d.subscription.id,
+		Attempts:  d.attempt,
+		Err:       err,
+	})
+	b.mux.Unlock()
+	return true
+}
+
+// work delivers queued events until the bus is closed
+func (b *InMemoryBus) work() {
+	defer b.workers.Done()
+
+	for {
+		b.mux.Lock()
+		for len(b.queue) == 0 && !b.closed {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.mux.Unlock()
+			return
+		}
+		d := b.queue[0]
+		b.queue = b.queue[1:]
+		b.mux.Unlock()
+
+		if b.deliver(context.Background(), d) {
+			b.done()
+			continue
+		}
+
+		d.attempt++
+		time.AfterFunc(b.options.redeliveryDelay, func() {
+			b.mux.Lock()
+			defer b.mux.Unlock()
+
+			if b.closed {
+				b.inflight--
+				return
+			}
+			b.queue = append(b.queue, d)
+			b.cond.Broadcast()
+		})
+	}
+}
+
+func (b *InMemoryBus) done() {
+	b.mux.Lock()
+	b.inflight--
+	b.mux.Unlock()
+}
+
+// Drain blocks until every published event has been handled or
+// dead-lettered, including events published by the handlers themselves
+func (b *InMemoryBus) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		b.mux.Lock()
+		idle := b.inflight == 0
+		b.mux.Unlock()
+
+		if idle {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Published returns the events published so far, in order
+func (b *InMemoryBus) Published() []*events.Event {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	published := make([]*events.Event, len(b.published))
+	copy(published, b.published)
+	return published
+}
+
+// PublishedMatching returns the published events whose topic matches pattern
+func (b *InMemoryBus) PublishedMatching(pattern events.Topic) []*events.Event {
+	var matched []*events.Event
+	for _, event := range b.Published() {
+		if event.Topic.Matches(pattern) {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+// DeadLetters returns the deliveries that exhausted their attempts
+func (b *InMemoryBus) DeadLetters() []DeadLetter {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	deadLetters := make([]DeadLetter, len(b.deadLetters))
+	copy(deadLetters, b.deadLetters)
+	return deadLetters
+}
+
+// Reset forgets published events and dead letters, keeping subscriptions
+func (b *InMemoryBus) Reset() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.published = nil
+	b.deadLetters = nil
+}
+
+// Close stops the async workers and drops undelivered events
+func (b *InMemoryBus) Close() error {
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.inflight -= len(b.queue)
+	b.queue = nil
+	b.cond.Broadcast()
+	b.mux.Unlock()
+
+	b.workers.Wait()
+	return nil
+}
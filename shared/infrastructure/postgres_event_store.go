@@ -0,0 +1,313 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var _ events.EventStore = (*PostgresEventStore)(nil)
+
+const (
+	defaultEventPageSize = 100
+	maxEventPageSize     = 1000
+
+	// uniqueViolation is the PostgreSQL error code raised when the
+	// (aggregate_id, stream_version) constraint is hit by a concurrent writer
+	uniqueViolation = "23505"
+
+	// maxAppendAttempts bounds how often an append without an expected
+	// version is retried after losing the stream version to another writer
+	maxAppendAttempts = 5
+)
+
+// PostgresEventStore implements events.EventStore over the event_stream table
+type PostgresEventStore struct {
+	db *sqlx.DB
+}
+
+// NewPostgresEventStore creates a new PostgresEventStore
+func NewPostgresEventStore(db *sqlx.DB) *PostgresEventStore {
+	return &PostgresEventStore{db: db}
+}
+
+// postgresEvent represents an event_stream row
+type postgresEvent struct {
+	ID            string         `db:"id"`
+	AggregateID   string         `db:"aggregate_id"`
+	EventType     string         `db:"event_type"`
+	Version       string         `db:"version"`
+	Data          []byte         `db:"data"`
+	Metadata      []byte         `db:"metadata"`
+	Timestamp     time.Time      `db:"timestamp"`
+	CorrelationID sql.NullString `db:"correlation_id"`
+	CausationID   sql.NullString `db:"causation_id"`
+	StreamVersion int            `db:"stream_version"`
+}
+
+// SaveEvents appends events to the aggregate stream. expectedVersion is the
+// stream version the caller last saw (0 for a new stream); events.AnyVersion
+// skips the check and retries when a concurrent writer takes the next
+// version first. A mismatch returns *events.VersionConflictError.
+// When ctx carries a transaction the events join it instead of committing.
+func (s *PostgresEventStore) SaveEvents(ctx context.Context, aggregateID models.ID, evts []*events.Event, expectedVersion int) error {
+	if len(evts) == 0 {
+		return nil
+	}
+
+	if tx := TxFromContext(ctx); tx != nil {
+		return s.saveEvents(ctx, tx, aggregateID, evts, expectedVersion)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := s.saveEvents(ctx, tx, aggregateID, evts, expectedVersion); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit events")
+	}
+
+	return nil
+}
+
+// saveEvents appends events inside an existing transaction. Appends without
+// an expected version run under a savepoint so a unique violation, which
+// aborts the transaction, can be rolled back and the append retried on top
+// of the new stream version.
+func (s *PostgresEventStore) saveEvents(ctx context.Context, tx *sqlx.Tx, aggregateID models.ID, evts []*events.Event, expectedVersion int) error {
+	if expectedVersion != events.AnyVersion {
+		return s.appendEvents(ctx, tx, aggregateID, evts, expectedVersion)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT append_events`); err != nil {
+			return errors.Wrap(err, "failed to create savepoint")
+		}
+
+		err := s.appendEvents(ctx, tx, aggregateID, evts, expectedVersion)
+
+		var conflict *events.VersionConflictError
+		if errors.As(err, &conflict) && attempt < maxAppendAttempts {
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT append_events`); err != nil {
+				return errors.Wrap(err, "failed to roll back to savepoint")
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT append_events`); err != nil {
+			return errors.Wrap(err, "failed to release savepoint")
+		}
+		return nil
+	}
+}
+
+// appendEvents inserts events after the current stream version
+func (s *PostgresEventStore) appendEvents(ctx context.Context, tx *sqlx.Tx, aggregateID models.ID, evts []*events.Event, expectedVersion int) error {
+	var currentVersion int
+	err := tx.GetContext(ctx, &currentVersion,
+		`SELECT COALESCE(MAX(stream_version), 0) FROM event_stream WHERE aggregate_id = $1`,
+		aggregateID.String(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to read stream version")
+	}
+
+	if expectedVersion != events.AnyVersion && currentVersion != expectedVersion {
+		return &events.VersionConflictError{
+			AggregateID:     aggregateID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   currentVersion,
+		}
+	}
+
+	query := `
+		INSERT INTO event_stream (
+			id, aggregate_id, event_type, version, data, metadata,
+			timestamp, correlation_id, causation_id, stream_version
+		) VALUES (
+			:id, :aggregate_id, :event_type, :version, :data, :metadata,
+			:timestamp, :correlation_id, :causation_id, :stream_version
+		)`
+
+	for i, event := range evts {
+		pgEvent, err := s.toPostgres(aggregateID, event, currentVersion+i+1)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.NamedExecContext(ctx, query, pgEvent); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+				return &events.VersionConflictError{
+					AggregateID:     aggregateID,
+					ExpectedVersion: expectedVersion,
+					ActualVersion:   currentVersion + i + 1,
+				}
+			}
+			return errors.Wrap(err, "failed to insert event")
+		}
+	}
+
+	return nil
+}
+
+// GetEvents returns the full stream of an aggregate ordered by stream version
+func (s *PostgresEventStore) GetEvents(ctx context.Context, aggregateID models.ID) ([]*events.Event, error) {
+	query := `
+		SELECT id, aggregate_id, event_type, version, data, metadata,
+			   timestamp, correlation_id, causation_id, stream_version
+		FROM event_stream
+		WHERE aggregate_id = $1
+		ORDER BY stream_version ASC`
+
+	var pgEvents []postgresEvent
+	if err := s.db.SelectContext(ctx, &pgEvents, query, aggregateID.String()); err != nil {
+		return nil, errors.Wrap(err, "failed to find events by aggregate ID")
+	}
+
+	return s.toDomainList(pgEvents)
+}
+
+// GetEventsByType returns a page of events of the given type ordered by timestamp
+func (s *PostgresEventStore) GetEventsByType(ctx context.Context, eventType string, offset, limit int) ([]*events.Event, error) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	if limit <= 0 {
+		limit = defaultEventPageSize
+	}
+
+	if limit > maxEventPageSize {
+		limit = maxEventPageSize
+	}
+
+	query := `
+		SELECT id, aggregate_id, event_type, version, data, metadata,
+			   timestamp, correlation_id, causation_id, stream_version
+		FROM event_stream
+		WHERE event_type = $1
+		ORDER BY timestamp ASC, id ASC
+		LIMIT $2 OFFSET $3`
+
+	var pgEvents []postgresEvent
+	if err := s.db.SelectContext(ctx, &pgEvents, query, eventType, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "failed to find events by type")
+	}
+
+	return s.toDomainList(pgEvents)
+}
+
+// GetEventsByCorrelationID returns every event of a causal chain, across
+// aggregates and services, ordered by timestamp. events.BuildCausalTree
+// turns the result into a tree.
+func (s *PostgresEventStore) GetEventsByCorrelationID(ctx context.Context, correlationID models.ID) ([]*events.Event, error) {
+	query := `
+		SELECT id, aggregate_id, event_type, version, data, metadata,
+			   timestamp, correlation_id, causation_id, stream_version
+		FROM event_stream
+		WHERE correlation_id = $1
+		ORDER BY timestamp ASC, id ASC`
+
+	var pgEvents []postgresEvent
+	if err := s.db.SelectContext(ctx, &pgEvents, query, correlationID.String()); err != nil {
+		return nil, errors.Wrap(err, "failed to find events by correlation ID")
+	}
+
+	return s.toDomainList(pgEvents)
+}
+
+// toPostgres converts a domain event to an event_stream row
+func (s *PostgresEventStore) toPostgres(aggregateID models.ID, event *events.Event, streamVersion int) (*postgresEvent, error) {
+	data, err := event.MarshalPayload()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal event payload")
+	}
+
+	metadata := event.Metadata
+	if metadata == nil {
+		metadata = make(events.Metadata)
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal event metadata")
+	}
+
+	version := event.Version
+	if version == "" {
+		version = "1.0"
+	}
+
+	return &postgresEvent{
+		ID:          event.ID.String(),
+		AggregateID: aggregateID.String(),
+		EventType:   event.Topic.String(),
+		Version:     version,
+		Data:        data,
+		Metadata:    metadataJSON,
+		Timestamp:   event.Timestamp,
+		CorrelationID: sql.NullString{
+			String: event.CorrelationID.String(),
+			Valid:  event.CorrelationID != "",
+		},
+		CausationID: sql.NullString{
+			String: event.CausationID.String(),
+			Valid:  event.CausationID != "",
+		},
+		StreamVersion: streamVersion,
+	}, nil
+}
+
+// toDomain converts an event_stream row to a domain event
+func (s *PostgresEventStore) toDomain(pgEvent *postgresEvent) (*events.Event, error) {
+	metadata := make(events.Metadata)
+	if len(pgEvent.Metadata) > 0 {
+		if err := json.Unmarshal(pgEvent.Metadata, &metadata); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal event metadata")
+		}
+	}
+
+	return &events.Event{
+		ID:            models.ID(pgEvent.ID),
+		AggregateID:   models.ID(pgEvent.AggregateID),
+		Topic:         events.Topic(pgEvent.EventType),
+		EventType:     pgEvent.EventType,
+		Version:       pgEvent.Version,
+		Data:          json.RawMessage(pgEvent.Data),
+		Metadata:      metadata,
+		Timestamp:     pgEvent.Timestamp,
+		CorrelationID: models.ID(pgEvent.CorrelationID.String),
+		CausationID:   models.ID(pgEvent.CausationID.String),
+	}, nil
+}
+
+// toDomainList converts event_stream rows to domain events
+func (s *PostgresEventStore) toDomainList(pgEvents []postgresEvent) ([]*events.Event, error) {
+	result := make([]*events.Event, len(pgEvents))
+	for i := range pgEvents {
+		event, err := s.toDomain(&pgEvents[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = event
+	}
+
+	return result, nil
+}
@@ -0,0 +1,213 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockDB returns a sqlx handle backed by sqlmock. Unmet expectations fail
+// the test.
+func newMockDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	return sqlx.NewDb(db, "postgres"), mock
+}
+
+func TestPostgresEventStore_SaveEvents(t *testing.T) {
+	evts := []*events.Event{
+		events.NewEvent("wallet-1", events.WalletDebitedEvent, map[string]interface{}{"amount": 100}),
+		events.NewEvent("wallet-1", events.WalletDebitedEvent, map[string]interface{}{"amount": 200}),
+	}
+
+	tests := []struct {
+		name            string
+		expectedVersion int
+		currentVersion  int
+		insertErr       error
+		expectedErr     *events.VersionConflictError
+	}{
+		{
+			name:            "appends to a new stream",
+			expectedVersion: 0,
+			currentVersion:  0,
+		},
+		{
+			name:            "appends after the expected version",
+			expectedVersion: 3,
+			currentVersion:  3,
+		},
+		{
+			name:            "stale expected version",
+			expectedVersion: 2,
+			currentVersion:  3,
+			expectedErr:     &events.VersionConflictError{AggregateID: "wallet-1", ExpectedVersion: 2, ActualVersion: 3},
+		},
+		{
+			name:            "concurrent writer took the version",
+			expectedVersion: 3,
+			currentVersion:  3,
+			insertErr:       &pq.Error{Code: uniqueViolation},
+			expectedErr:     &events.VersionConflictError{AggregateID: "wallet-1", ExpectedVersion: 3, ActualVersion: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			store := NewPostgresEventStore(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT COALESCE\(MAX\(stream_version\), 0\) FROM event_stream`).
+				WithArgs("wallet-1").
+				WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(tt.currentVersion))
+
+			if tt.expectedErr == nil || tt.insertErr != nil {
+				insert := mock.ExpectExec(`INSERT INTO event_stream`).
+					WithArgs(evts[0].ID.String(), "wallet-1", evts[0].Topic.String(), "1.0",
+						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, tt.currentVersion+1)
+				if tt.insertErr != nil {
+					insert.WillReturnError(tt.insertErr)
+				} else {
+					insert.WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec(`INSERT INTO event_stream`).
+						WithArgs(evts[1].ID.String(), "wallet-1", evts[1].Topic.String(), "1.0",
+							sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, tt.currentVersion+2).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			}
+
+			if tt.expectedErr == nil {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := store.SaveEvents(context.Background(), "wallet-1", evts, tt.expectedVersion)
+
+			if tt.expectedErr != nil {
+				var conflict *events.VersionConflictError
+				require.ErrorAs(t, err, &conflict)
+				assert.Equal(t, tt.expectedErr, conflict)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPostgresEventStore_SaveEventsJoinsContextTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := NewPostgresEventStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(stream_version\), 0\) FROM event_stream`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(0))
+	mock.ExpectExec(`INSERT INTO event_stream`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	transactor := NewPostgresTransactor(db)
+	err := transactor.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		if err := store.SaveEvents(ctx, "wallet-1", []*events.Event{events.NewEvent("wallet-1", events.WalletDebitedEvent, nil)}, 0); err != nil {
+			return err
+		}
+		// The events are rolled back with the caller's transaction
+		return assert.AnError
+	})
+
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestPostgresEventStore_GetEvents(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := NewPostgresEventStore(db)
+
+	timestamp := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"id", "aggregate_id", "event_type", "version", "data", "metadata",
+		"timestamp", "correlation_id", "causation_id", "stream_version"}
+
+	mock.ExpectQuery(`FROM event_stream\s+WHERE aggregate_id = \$1\s+ORDER BY stream_version ASC`).
+		WithArgs("wallet-1").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("event-1", "wallet-1", "wallet.debited", "1.0", []byte(`{"amount":100}`), []byte(`{"source":"wallet"}`),
+				timestamp, "correlation-1", nil, 1).
+			AddRow("event-2", "wallet-1", "wallet.credited", "1.0", []byte(`{"amount":50}`), []byte(`{}`),
+				timestamp, "correlation-1", "event-1", 2))
+
+	evts, err := store.GetEvents(context.Background(), "wallet-1")
+	require.NoError(t, err)
+	require.Len(t, evts, 2)
+
+	assert.EqualValues(t, events.WalletDebitedEvent, evts[0].Topic)
+	assert.Equal(t, json.RawMessage(`{"amount":100}`), evts[0].Data)
+	assert.Equal(t, events.Metadata{"source": "wallet"}, evts[0].Metadata)
+	assert.Equal(t, timestamp, evts[0].Timestamp)
+	assert.Empty(t, evts[0].CausationID)
+	assert.EqualValues(t, "event-1", evts[1].CausationID)
+	assert.EqualValues(t, "correlation-1", evts[1].CorrelationID)
+}
+
+func TestPostgresEventStore_SaveEventsAnyVersionRetriesConflicts(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := NewPostgresEventStore(db)
+	event := events.NewEvent("wallet-1", events.WalletDebitedEvent, nil)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT append_events`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(stream_version\), 0\) FROM event_stream`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(3))
+	mock.ExpectExec(`INSERT INTO event_stream`).
+		WithArgs(event.ID.String(), "wallet-1", event.Topic.String(), "1.0",
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, 4).
+		WillReturnError(&pq.Error{Code: uniqueViolation})
+	// A concurrent publisher took version 4; the append moves after it
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT append_events`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SAVEPOINT append_events`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(stream_version\), 0\) FROM event_stream`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(4))
+	mock.ExpectExec(`INSERT INTO event_stream`).
+		WithArgs(event.ID.String(), "wallet-1", event.Topic.String(), "1.0",
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`RELEASE SAVEPOINT append_events`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	require.NoError(t, store.SaveEvents(context.Background(), "wallet-1", []*events.Event{event}, events.AnyVersion))
+}
+
+func TestPostgresEventStore_SaveEventsAnyVersionGivesUp(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := NewPostgresEventStore(db)
+
+	mock.ExpectBegin()
+	for attempt := 1; attempt <= maxAppendAttempts; attempt++ {
+		mock.ExpectExec(`SAVEPOINT append_events`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COALESCE\(MAX\(stream_version\), 0\) FROM event_stream`).
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(attempt))
+		mock.ExpectExec(`INSERT INTO event_stream`).WillReturnError(&pq.Error{Code: uniqueViolation})
+		if attempt < maxAppendAttempts {
+			mock.ExpectExec(`ROLLBACK TO SAVEPOINT append_events`).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+	mock.ExpectRollback()
+
+	err := store.SaveEvents(context.Background(), "wallet-1",
+		[]*events.Event{events.NewEvent("wallet-1", events.WalletDebitedEvent, nil)}, events.AnyVersion)
+
+	var conflict *events.VersionConflictError
+	assert.ErrorAs(t, err, &conflict)
+}
@@ -0,0 +1,211 @@
+package infrastructure
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PostgresInbox records processed (handler ID, event ID) pairs so redelivered
+// SQS messages are handled at most once per handler
+type PostgresInbox struct {
+	mux     sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	options *inboxOptions
+
+	db         *sqlx.DB
+	transactor Transactor
+}
+
+type inboxOptions struct {
+	retention       time.Duration
+	cleanupInterval time.Duration
+}
+
+type InboxOption func(*inboxOptions)
+
+// WithInboxRetention sets how long processed entries are kept. Redeliveries
+// older than the retention window are no longer detected as duplicates.
+func WithInboxRetention(retention time.Duration) InboxOption {
+	return func(o *inboxOptions) {
+		o.retention = retention
+	}
+}
+
+func WithInboxCleanupInterval(interval time.Duration) InboxOption {
+	return func(o *inboxOptions) {
+		o.cleanupInterval = interval
+	}
+}
+
+// NewPostgresInbox creates a new PostgresInbox
+func NewPostgresInbox(db *sqlx.DB, transactor Transactor, opts ...InboxOption) *PostgresInbox {
+	options := &inboxOptions{
+		retention:       7 * 24 * time.Hour,
+		cleanupInterval: time.Hour,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &PostgresInbox{
+		db:         db,
+		transactor: transactor,
+		options:    options,
+	}
+}
+
+// Wrap returns a handler that runs the given handler inside a transaction and
+// skips events it already processed
+func (i *PostgresInbox) Wrap(handler EventHandler) *IdempotentEventHandler {
+	return &IdempotentEventHandler{
+		inbox:   i,
+		handler: handler,
+	}
+}
+
+// Purge deletes entries older than the retention window and returns how many were removed
+func (i *PostgresInbox) Purge(ctx context.Context) (int64, error) {
+	res, err := i.db.ExecContext(ctx,
+		`DELETE FROM inbox WHERE processed_at < $1`,
+		time.Now().Add(-i.options.retention),
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge inbox")
+	}
+
+	return res.RowsAffected()
+}
+
+// Start starts the retention cleaner in the background
+func (i *PostgresInbox) Start(ctx context.Context) error {
+	if i.running.Load() {
+		return nil
+	}
+
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	i.cancel = cancel
+	i.done = make(chan struct{})
+
+	go i.startCleaner(ctx, i.done)
+
+	i.running.Store(true)
+
+	return nil
+}
+
+// Stop stops the retention cleaner
+func (i *PostgresInbox) Stop(ctx context.Context) error {
+	if !i.running.Load() {
+		return nil
+	}
+
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.cancel()
+
+	select {
+	case <-i.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	i.cancel = nil
+	i.done = nil
+	i.running.Store(false)
+
+	return nil
+}
+
+func (i *PostgresInbox) startCleaner(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(i.options.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := i.Purge(ctx); err != nil {
+				// Log error in production
+				continue
+			}
+		}
+	}
+}
+
+// claim inserts the inbox entry in the transaction carried by ctx. It returns
+// false when the entry already exists. A concurrent delivery of the same event
+// blocks on the primary key until the first transaction finishes.
+func (i *PostgresInbox) claim(ctx context.Context, handlerID string, event *events.Event) (bool, error) {
+	res, err := Executor(ctx, i.db).ExecContext(ctx, `
+		INSERT INTO inbox (handler_id, event_id, topic, processed_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (handler_id, event_id) DO NOTHING`,
+		handlerID,
+		event.ID.String(),
+		event.Topic.String(),
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record inbox entry")
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read inbox result")
+	}
+
+	return rows == 1, nil
+}
+
+// IdempotentEventHandler handles each event at most once per handler ID. The
+// inbox entry and the handler's writes share a transaction, so a failed
+// handler leaves no entry and the redelivery is processed again.
+type IdempotentEventHandler struct {
+	inbox   *PostgresInbox
+	handler EventHandler
+}
+
+func (h *IdempotentEventHandler) HandlerID() string {
+	return h.handler.HandlerID()
+}
+
+func (h *IdempotentEventHandler) Handle(ctx context.Context, event *events.Event) error {
+	return h.inbox.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Events without an ID cannot be deduplicated
+		if event.ID == "" {
+			return h.handler.Handle(ctx, event)
+		}
+
+		claimed, err := h.inbox.claim(ctx, h.handler.HandlerID(), event)
+		if err != nil {
+			return err
+		}
+
+		if !claimed {
+			telemetry.RecordCounter(ctx, "inbox_duplicates_dropped_total", "Total duplicate events skipped by the inbox", 1,
+				attribute.String("handler_id", h.handler.HandlerID()),
+				attribute.String("topic", event.Topic.String()),
+			)
+			return nil
+		}
+
+		return h.handler.Handle(ctx, event)
+	})
+}
@@ -0,0 +1,90 @@
+package infrastructure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotentEventHandler_Handle(t *testing.T) {
+	tests := []struct {
+		name          string
+		claimed       bool
+		handlerErr    error
+		expectedCalls int
+		commit        bool
+	}{
+		{
+			name:          "first delivery is handled",
+			claimed:       true,
+			expectedCalls: 1,
+			commit:        true,
+		},
+		{
+			name:          "duplicate delivery is skipped",
+			claimed:       false,
+			expectedCalls: 0,
+			commit:        true,
+		},
+		{
+			name:          "handler error rolls back the claim",
+			claimed:       true,
+			handlerErr:    assert.AnError,
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			inbox := NewPostgresInbox(db, NewPostgresTransactor(db))
+			event := events.NewEvent("wallet-1", events.WalletDebitedEvent, nil)
+
+			calls := 0
+			handler := inbox.Wrap(NewEventHandlerFunc("wallet.debit", func(ctx context.Context, event *events.Event) error {
+				calls++
+				// The handler's writes share the claim's transaction
+				assert.NotNil(t, TxFromContext(ctx))
+				return tt.handlerErr
+			}))
+
+			rowsAffected := int64(0)
+			if tt.claimed {
+				rowsAffected = 1
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`INSERT INTO inbox .+ ON CONFLICT \(handler_id, event_id\) DO NOTHING`).
+				WithArgs("wallet.debit", event.ID.String(), event.Topic.String()).
+				WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+			if tt.commit {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := handler.Handle(context.Background(), event)
+
+			assert.ErrorIs(t, err, tt.handlerErr)
+			assert.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
+
+func TestPostgresInbox_Purge(t *testing.T) {
+	db, mock := newMockDB(t)
+	inbox := NewPostgresInbox(db, NewPostgresTransactor(db), WithInboxRetention(time.Hour))
+
+	mock.ExpectExec(`DELETE FROM inbox WHERE processed_at < \$1`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	purged, err := inbox.Purge(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+}
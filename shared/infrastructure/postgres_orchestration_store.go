@@ -0,0 +1,235 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var _ saga.OrchestrationStore = (*PostgresOrchestrationStore)(nil)
+
+// PostgresOrchestrationStore implements saga.OrchestrationStore over the
+// orchestrated_sagas table. Writes join the transaction in ctx, if any.
+type PostgresOrchestrationStore struct {
+	db *sqlx.DB
+}
+
+// NewPostgresOrchestrationStore creates a new PostgresOrchestrationStore
+func NewPostgresOrchestrationStore(db *sqlx.DB) *PostgresOrchestrationStore {
+	return &PostgresOrchestrationStore{db: db}
+}
+
+const orchestratedSagaColumns = `id, saga_type, aggregate_id, status, step, attempt, command_id, reply_key,
+	deadline, completed_steps, compensations, failure_reason, data, version, created_at, updated_at`
+
+// postgresOrchestratedSaga represents an orchestrated_sagas row
+type postgresOrchestratedSaga struct {
+	ID             string         `db:"id"`
+	SagaType       string         `db:"saga_type"`
+	AggregateID    string         `db:"aggregate_id"`
+	Status         string         `db:"status"`
+	Step           int            `db:"step"`
+	Attempt        int            `db:"attempt"`
+	CommandID      sql.NullString `db:"command_id"`
+	ReplyKey       sql.NullString `db:"reply_key"`
+	Deadline       sql.NullTime   `db:"deadline"`
+	CompletedSteps pq.Int64Array  `db:"completed_steps"`
+	Compensations  int            `db:"compensations"`
+	FailureReason  sql.NullString `db:"failure_reason"`
+	Data           []byte         `db:"data"`
+	Version        int            `db:"version"`
+	CreatedAt      time.Time      `db:"created_at"`
+	UpdatedAt      time.Time      `db:"updated_at"`
+}
+
+func (r *postgresOrchestratedSaga) toSaga() *saga.OrchestratedSaga {
+	s := &saga.OrchestratedSaga{
+		ID:            models.ID(r.ID),
+		Type:          saga.SagaType(r.SagaType),
+		AggregateID:   models.ID(r.AggregateID),
+		Status:        saga.SagaStatus(r.Status),
+		Step:          r.Step,
+		Attempt:       r.Attempt,
+		CommandID:     models.ID(r.CommandID.String),
+		ReplyKey:      r.ReplyKey.String,
+		Compensations: r.Compensations,
+		FailureReason: r.FailureReason.String,
+		Data:          json.RawMessage(r.Data),
+		Version:       r.Version,
+		CreatedAt:     r.CreatedAt,
+		UpdatedAt:     r.UpdatedAt,
+	}
+	if r.Deadline.Valid {
+		deadline := r.Deadline.Time
+		s.Deadline = &deadline
+	}
+	for _, step := range r.CompletedSteps {
+		s.Completed = append(s.Completed, int(step))
+	}
+	return s
+}
+
+// completedSteps converts the completed steps of s to a Postgres array
+func completedSteps(s *saga.OrchestratedSaga) pq.Int64Array {
+	steps := make(pq.Int64Array, len(s.Completed))
+	for i, step := range s.Completed {
+		steps[i] = int64(step)
+	}
+	return steps
+}
+
+// Create inserts s, or returns saga.ErrSagaExists when its ID is taken
+func (st *PostgresOrchestrationStore) Create(ctx context.Context, s *saga.OrchestratedSaga) error {
+	res, err := Executor(ctx, st.db).ExecContext(ctx, `
+		INSERT INTO orchestrated_sagas (`+orchestratedSagaColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (id) DO NOTHING`,
+		s.ID.String(),
+		string(s.Type),
+		s.AggregateID.String(),
+		string(s.Status),
+		s.Step,
+		s.Attempt,
+		sql.NullString{String: s.CommandID.String(), Valid: s.CommandID != ""},
+		sql.NullString{String: s.ReplyKey, Valid: s.ReplyKey != ""},
+		s.Deadline,
+		completedSteps(s),
+		s.Compensations,
+		sql.NullString{String: s.FailureReason, Valid: s.FailureReason != ""},
+		[]byte(s.Data),
+		s.Version,
+		s.CreatedAt,
+		s.UpdatedAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert orchestrated saga")
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to read inserted orchestrated sagas")
+	}
+	if inserted == 0 {
+		return errors.Wrapf(saga.ErrSagaExists, "saga %s", s.ID)
+	}
+
+	return nil
+}
+
+// Get returns the saga with id
+func (st *PostgresOrchestrationStore) Get(ctx context.Context, id models.ID) (*saga.OrchestratedSaga, error) {
+	var row postgresOrchestratedSaga
+	err := Executor(ctx, st.db).GetContext(ctx, &row, `
+		SELECT `+orchestratedSagaColumns+`
+		FROM orchestrated_sagas
+		WHERE id = $1`,
+		id.String(),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrapf(saga.ErrSagaNotFound, "saga %s", id)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query orchestrated saga")
+	}
+
+	return row.toSaga(), nil
+}
+
+// FindAwaiting returns the oldest saga awaiting a reply under replyKey
+func (st *PostgresOrchestrationStore) FindAwaiting(ctx context.Context, replyKey string) (*saga.OrchestratedSaga, error) {
+	var row postgresOrchestratedSaga
+	err := Executor(ctx, st.db).GetContext(ctx, &row, `
+		SELECT `+orchestratedSagaColumns+`
+		FROM orchestrated_sagas
+		WHERE reply_key = $1
+		ORDER BY created_at
+		LIMIT 1`,
+		replyKey,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrapf(saga.ErrSagaNotFound, "reply key %s", replyKey)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query awaiting saga")
+	}
+
+	return row.toSaga(), nil
+}
+
+// FindExpired returns up to limit sagas whose deadline passed at now,
+// locking them so concurrent runs skip them
+func (st *PostgresOrchestrationStore) FindExpired(ctx context.Context, now time.Time, limit int) ([]*saga.OrchestratedSaga, error) {
+	var rows []postgresOrchestratedSaga
+	err := Executor(ctx, st.db).SelectContext(ctx, &rows, `
+		SELECT `+orchestratedSagaColumns+`
+		FROM orchestrated_sagas
+		WHERE deadline IS NOT NULL AND deadline < $1
+		ORDER BY deadline
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`,
+		now, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query expired sagas")
+	}
+
+	sagas := make([]*saga.OrchestratedSaga, 0, len(rows))
+	for i := range rows {
+		sagas = append(sagas, rows[i].toSaga())
+	}
+	return sagas, nil
+}
+
+// Update saves s if its version is unchanged and increments the version
+func (st *PostgresOrchestrationStore) Update(ctx context.Context, s *saga.OrchestratedSaga) error {
+	res, err := Executor(ctx, st.db).ExecContext(ctx, `
+		UPDATE orchestrated_sagas SET
+			status = $3,
+			step = $4,
+			attempt = $5,
+			command_id = $6,
+			reply_key = $7,
+			deadline = $8,
+			completed_steps = $9,
+			compensations = $10,
+			failure_reason = $11,
+			data = $12,
+			updated_at = $13,
+			version = version + 1
+		WHERE id = $1 AND version = $2`,
+		s.ID.String(),
+		s.Version,
+		string(s.Status),
+		s.Step,
+		s.Attempt,
+		sql.NullString{String: s.CommandID.String(), Valid: s.CommandID != ""},
+		sql.NullString{String: s.ReplyKey, Valid: s.ReplyKey != ""},
+		s.Deadline,
+		completedSteps(s),
+		s.Compensations,
+		sql.NullString{String: s.FailureReason, Valid: s.FailureReason != ""},
+		[]byte(s.Data),
+		s.UpdatedAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to update orchestrated saga")
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to read updated orchestrated sagas")
+	}
+	if updated == 0 {
+		return errors.Wrapf(saga.ErrSagaConflict, "saga %s at version %d", s.ID, s.Version)
+	}
+
+	s.Version++
+	return nil
+}
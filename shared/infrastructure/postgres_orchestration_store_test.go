@@ -0,0 +1,188 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var orchestratedSagaColumnNames = []string{
+	"id", "saga_type", "aggregate_id", "status", "step", "attempt", "command_id", "reply_key",
+	"deadline", "completed_steps", "compensations", "failure_reason", "data", "version", "created_at", "updated_at",
+}
+
+func TestPostgresOrchestrationStore_Create(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deadline := created.Add(time.Minute)
+	s := &saga.OrchestratedSaga{
+		ID:          "saga-1",
+		Type:        saga.SagaTypeRefund,
+		AggregateID: "payment-1",
+		Status:      saga.SagaStatusInProgress,
+		Attempt:     1,
+		CommandID:   "command-1",
+		ReplyKey:    "saga-1",
+		Deadline:    &deadline,
+		Data:        json.RawMessage(`{"amount":100}`),
+		CreatedAt:   created,
+		UpdatedAt:   created,
+	}
+
+	tests := []struct {
+		name        string
+		inserted    int64
+		expectedErr error
+	}{
+		{
+			name:     "new saga",
+			inserted: 1,
+		},
+		{
+			name:        "taken ID",
+			inserted:    0,
+			expectedErr: saga.ErrSagaExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			store := NewPostgresOrchestrationStore(db)
+
+			mock.ExpectExec(`INSERT INTO orchestrated_sagas .+ ON CONFLICT \(id\) DO NOTHING`).
+				WithArgs("saga-1", "refund", "payment-1", "in_progress", 0, 1,
+					sql.NullString{String: "command-1", Valid: true}, sql.NullString{String: "saga-1", Valid: true},
+					&deadline, pq.Int64Array{}, 0, sql.NullString{}, []byte(`{"amount":100}`), 0, created, created).
+				WillReturnResult(sqlmock.NewResult(0, tt.inserted))
+
+			err := store.Create(context.Background(), s)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPostgresOrchestrationStore_FindAwaiting(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := NewPostgresOrchestrationStore(db)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deadline := created.Add(time.Minute)
+
+	mock.ExpectQuery(`SELECT .+ FROM orchestrated_sagas WHERE reply_key = \$1 ORDER BY created_at LIMIT 1`).
+		WithArgs("provider-refund:saga-1").
+		WillReturnRows(sqlmock.NewRows(orchestratedSagaColumnNames).
+			AddRow("saga-1", "refund", "payment-1", "compensating", 0, 2, "command-2", "provider-refund:saga-1",
+				deadline, "{0,1}", 1, "provider down", []byte(`{"amount":100}`), 3, created, deadline))
+	mock.ExpectQuery(`SELECT .+ FROM orchestrated_sagas WHERE reply_key = \$1`).
+		WithArgs("saga-2").
+		WillReturnError(sql.ErrNoRows)
+
+	s, err := store.FindAwaiting(context.Background(), "provider-refund:saga-1")
+	require.NoError(t, err)
+	assert.Equal(t, &saga.OrchestratedSaga{
+		ID:            "saga-1",
+		Type:          saga.SagaTypeRefund,
+		AggregateID:   "payment-1",
+		Status:        saga.SagaStatusCompensating,
+		Step:          0,
+		Attempt:       2,
+		CommandID:     "command-2",
+		ReplyKey:      "provider-refund:saga-1",
+		Deadline:      &deadline,
+		Completed:     []int{0, 1},
+		Compensations: 1,
+		FailureReason: "provider down",
+		Data:          json.RawMessage(`{"amount":100}`),
+		Version:       3,
+		CreatedAt:     created,
+		UpdatedAt:     deadline,
+	}, s)
+
+	_, err = store.FindAwaiting(context.Background(), "saga-2")
+	assert.ErrorIs(t, err, saga.ErrSagaNotFound)
+}
+
+func TestPostgresOrchestrationStore_FindExpired(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := NewPostgresOrchestrationStore(db)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := created.Add(time.Hour)
+
+	mock.ExpectQuery(`SELECT .+ FROM orchestrated_sagas WHERE deadline IS NOT NULL AND deadline < \$1 ORDER BY deadline LIMIT \$2 FOR UPDATE SKIP LOCKED`).
+		WithArgs(now, 10).
+		WillReturnRows(sqlmock.NewRows(orchestratedSagaColumnNames).
+			AddRow("saga-1", "refund", "payment-1", "in_progress", 0, 1, "command-1", "saga-1",
+				created.Add(time.Minute), "{}", 0, nil, []byte(`{}`), 1, created, created))
+
+	sagas, err := store.FindExpired(context.Background(), now, 10)
+	require.NoError(t, err)
+	require.Len(t, sagas, 1)
+	assert.Equal(t, models.ID("saga-1"), sagas[0].ID)
+	assert.Empty(t, sagas[0].Completed)
+	assert.Empty(t, sagas[0].FailureReason)
+}
+
+func TestPostgresOrchestrationStore_Update(t *testing.T) {
+	updated := time.Date(2024, 5, 1, 12, 5, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		rows            int64
+		expectedErr     error
+		expectedVersion int
+	}{
+		{
+			name:            "unchanged saga",
+			rows:            1,
+			expectedVersion: 3,
+		},
+		{
+			name:            "saga changed concurrently",
+			rows:            0,
+			expectedErr:     saga.ErrSagaConflict,
+			expectedVersion: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			store := NewPostgresOrchestrationStore(db)
+			s := &saga.OrchestratedSaga{
+				ID:        "saga-1",
+				Status:    saga.SagaStatusCompleted,
+				Step:      1,
+				Attempt:   1,
+				Completed: []int{0, 1},
+				Data:      json.RawMessage(`{}`),
+				Version:   2,
+				UpdatedAt: updated,
+			}
+
+			mock.ExpectExec(`UPDATE orchestrated_sagas SET .+ version = version \+ 1 WHERE id = \$1 AND version = \$2`).
+				WithArgs("saga-1", 2, "completed", 1, 1, sql.NullString{}, sql.NullString{}, (*time.Time)(nil),
+					pq.Int64Array{0, 1}, 0, sql.NullString{}, []byte(`{}`), updated).
+				WillReturnResult(sqlmock.NewResult(0, tt.rows))
+
+			err := store.Update(context.Background(), s)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedVersion, s.Version)
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var _ events.Publisher = (*OutboxPublisher)(nil)
+
+// outboxRecord represents an outbox row
+type outboxRecord struct {
+	Seq           int64          `db:"seq"`
+	ID            string         `db:"id"`
+	Source        string         `db:"source"`
+	AggregateID   string         `db:"aggregate_id"`
+	Topic         string         `db:"topic"`
+	Version       string         `db:"version"`
+	Data          []byte         `db:"data"`
+	Metadata      []byte         `db:"metadata"`
+	CorrelationID sql.NullString `db:"correlation_id"`
+	CausationID   sql.NullString `db:"causation_id"`
+	OccurredAt    time.Time      `db:"occurred_at"`
+	CreatedAt     time.Time      `db:"created_at"`
+	Attempts      int            `db:"attempts"`
+}
+
+// OutboxPublisher implements events.Publisher by writing events to the outbox
+// table. When ctx carries a transaction the rows are committed atomically
+// with the aggregate changes made in that transaction.
+type OutboxPublisher struct {
+	db     *sqlx.DB
+	source string
+}
+
+// NewOutboxPublisher creates a new OutboxPublisher. source identifies the
+// service owning the rows so each service relays only its own events.
+func NewOutboxPublisher(db *sqlx.DB, source string) *OutboxPublisher {
+	return &OutboxPublisher{
+		db:     db,
+		source: source,
+	}
+}
+
+// Publish stores events in the outbox
+func (p *OutboxPublisher) Publish(ctx context.Context, evts ...*events.Event) error {
+	query := `
+		INSERT INTO outbox (
+			id, source, aggregate_id, topic, version, data, metadata,
+			correlation_id, causation_id, occurred_at
+		) VALUES (
+			:id, :source, :aggregate_id, :topic, :version, :data, :metadata,
+			:correlation_id, :causation_id, :occurred_at
+		)`
+
+	executor := Executor(ctx, p.db)
+	for _, event := range evts {
+		record, err := p.toRecord(event)
+		if err != nil {
+			return err
+		}
+
+		if _, err := executor.NamedExecContext(ctx, query, record); err != nil {
+			return errors.Wrap(err, "failed to insert outbox event")
+		}
+	}
+
+	return nil
+}
+
+// toRecord converts a domain event to an outbox row
+func (p *OutboxPublisher) toRecord(event *events.Event) (*outboxRecord, error) {
+	data, err := event.MarshalPayload()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal event payload")
+	}
+
+	metadata := event.Metadata
+	if metadata == nil {
+		metadata = make(events.Metadata)
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal event metadata")
+	}
+
+	version := event.Version
+	if version == "" {
+		version = "1.0"
+	}
+
+	occurredAt := event.Timestamp
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+
+	return &outboxRecord{
+		ID:          event.ID.String(),
+		Source:      p.source,
+		AggregateID: event.AggregateID.String(),
+		Topic:       event.Topic.String(),
+		Version:     version,
+		Data:        data,
+		Metadata:    metadataJSON,
+		CorrelationID: sql.NullString{
+			String: event.CorrelationID.String(),
+			Valid:  event.CorrelationID != "",
+		},
+		CausationID: sql.NullString{
+			String: event.CausationID.String(),
+			Valid:  event.CausationID != "",
+		},
+		OccurredAt: occurredAt,
+	}, nil
+}
+
+// OutboxRelay polls the outbox and forwards pending events to a publisher.
+// Only the oldest pending event of each aggregate is picked per batch, so
+// events of the same aggregate are delivered in the order they were written.
+type OutboxRelay struct {
+	mux     sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	options *outboxRelayOptions
+
+	db        *sqlx.DB
+	source    string
+	publisher events.Publisher
+}
+
+type outboxRelayOptions struct {
+	pollInterval time.Duration
+	batchSize    int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+}
+
+type OutboxRelayOption func(*outboxRelayOptions)
+
+func WithOutboxPollInterval(interval time.Duration) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.pollInterval = interval
+	}
+}
+
+func WithOutboxBatchSize(size int) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.batchSize = size
+	}
+}
+
+func WithOutboxBackoff(base, maxBackoff time.Duration) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.baseBackoff = base
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// NewOutboxRelay creates a new OutboxRelay
+func NewOutboxRelay(
+	db *sqlx.DB,
+	source string,
+	publisher events.Publisher,
+	opts ...OutboxRelayOption,
+) *OutboxRelay {
+	options := &outboxRelayOptions{
+		pollInterval: time.Second,
+		batchSize:    maxBatchSize,
+		baseBackoff:  time.Second,
+		maxBackoff:   5 * time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &OutboxRelay{
+		db:        db,
+		source:    source,
+		publisher: publisher,
+		options:   options,
+	}
+}
+
+// Start starts relaying in the background
+func (r *OutboxRelay) Start(ctx context.Context) error {
+	if r.running.Load() {
+		return nil
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go r.run(ctx, r.done)
+
+	r.running.Store(true)
+
+	return nil
+}
+
+// Stop stops the relay and waits for the in-flight batch to finish
+func (r *OutboxRelay) Stop(ctx context.Context) error {
+	if !r.running.Load() {
+		return nil
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.cancel = nil
+	r.done = nil
+	r.running.Store(false)
+
+	return nil
+}
+
+func (r *OutboxRelay) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(r.options.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		relayed, err := r.RelayBatch(ctx)
+
+		// Keep draining while there is work, otherwise wait for the next tick
+		if err == nil && relayed > 0 && ctx.Err() == nil {
+			continue
+		}
+
+		r.recordBacklog(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayBatch publishes one batch of pending events and returns how many were published
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT o.seq, o.id, o.source, o.aggregate_id, o.topic, o.version, o.data,
+			   o.metadata, o.correlation_id, o.causation_id, o.occurred_at, o.created_at, o.attempts
+		FROM outbox o
+		WHERE o.source = $1
+		  AND o.published_at IS NULL
+		  AND o.next_attempt_at <= NOW()
+		  AND NOT EXISTS (
+			SELECT 1 FROM outbox p
+			WHERE p.source = o.source
+			  AND p.aggregate_id = o.aggregate_id
+			  AND p.published_at IS NULL
+			  AND p.seq < o.seq
+		  )
+		ORDER BY o.seq ASC
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`
+
+	var records []outboxRecord
+	if err := tx.SelectContext(ctx, &records, query, r.source, r.options.batchSize); err != nil {
+		return 0, errors.Wrap(err, "failed to select pending outbox events")
+	}
+
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	evts := make([]*events.Event, len(records))
+	for i := range records {
+		event, err := r.toDomain(&records[i])
+		if err != nil {
+			return 0, err
+		}
+		evts[i] = event
+	}
+
+	// A *PublishError lists the events that failed; the others were
+	// published. Any other error fails the whole batch.
+	publishErr := r.publisher.Publish(ctx, evts...)
+	var partialErr *PublishError
+	partial := errors.As(publishErr, &partialErr)
+
+	var published, failed []outboxRecord
+	for i, record := range records {
+		if publishErr != nil && (!partial || partialErr.Failed(evts[i].ID)) {
+			failed = append(failed, record)
+		} else {
+			published = append(published, record)
+		}
+	}
+
+	if len(failed) > 0 {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE outbox
+			SET attempts = attempts + 1,
+				last_error = $2,
+				next_attempt_at = NOW() + LEAST($3 * POWER(2, attempts), $4) * INTERVAL '1 millisecond'
+			WHERE seq = ANY($1)`,
+			pq.Array(outboxSeqs(failed)),
+			publishErr.Error(),
+			r.options.baseBackoff.Milliseconds(),
+			r.options.maxBackoff.Milliseconds(),
+		)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to schedule outbox retry")
+		}
+	}
+
+	if len(published) > 0 {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE outbox
+			SET published_at = NOW(), attempts = attempts + 1, last_error = NULL
+			WHERE seq = ANY($1)`,
+			pq.Array(outboxSeqs(published)),
+		)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to mark outbox events as published")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "failed to commit relayed outbox events")
+	}
+
+	now := time.Now()
+	for _, record := range published {
+		telemetry.RecordHistogram(ctx, "outbox_relay_lag_seconds", "Time between outbox write and publish", now.Sub(record.CreatedAt).Seconds(),
+			attribute.String("source", r.source),
+			attribute.String("topic", record.Topic),
+		)
+	}
+
+	if len(published) > 0 {
+		telemetry.RecordCounter(ctx, "outbox_events_relayed_total", "Total outbox events relayed", int64(len(published)),
+			attribute.String("source", r.source),
+			attribute.String("status", "published"),
+		)
+	}
+
+	if len(failed) > 0 {
+		telemetry.RecordCounter(ctx, "outbox_events_relayed_total", "Total outbox events relayed", int64(len(failed)),
+			attribute.String("source", r.source),
+			attribute.String("status", "failed"),
+		)
+
+		return len(published), errors.Wrap(publishErr, "failed to publish outbox events")
+	}
+
+	return len(published), nil
+}
+
+// outboxSeqs returns the sequence numbers of records
+func outboxSeqs(records []outboxRecord) []int64 {
+	seqs := make([]int64, len(records))
+	for i, record := range records {
+		seqs[i] = record.Seq
+	}
+	return seqs
+}
+
+// recordBacklog records the age of the oldest pending event
+func (r *OutboxRelay) recordBacklog(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	var oldest sql.NullTime
+	err := r.db.GetContext(ctx, &oldest,
+		`SELECT MIN(created_at) FROM outbox WHERE source = $1 AND published_at IS NULL`,
+		r.source,
+	)
+	if err != nil {
+		return
+	}
+
+	age := 0.0
+	if oldest.Valid {
+		age = time.Since(oldest.Time).Seconds()
+	}
+
+	telemetry.RecordGauge(ctx, "outbox_oldest_pending_age_seconds", "Age of the oldest unpublished outbox event", age,
+		attribute.String("source", r.source),
+	)
+}
+
+// toDomain converts an outbox row to a domain event
+func (r *OutboxRelay) toDomain(record *outboxRecord) (*events.Event, error) {
+	metadata := make(events.Metadata)
+	if len(record.Metadata) > 0 {
+		if err := json.Unmarshal(record.Metadata, &metadata); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal outbox metadata")
+		}
+	}
+
+	return &events.Event{
+		ID:            models.ID(record.ID),
+		AggregateID:   models.ID(record.AggregateID),
+		Topic:         events.Topic(record.Topic),
+		EventType:     record.Topic,
+		Version:       record.Version,
+		Data:          json.RawMessage(record.Data),
+		Metadata:      metadata,
+		Timestamp:     record.OccurredAt,
+		CorrelationID: models.ID(record.CorrelationID.String),
+		CausationID:   models.ID(record.CausationID.String),
+	}, nil
+}
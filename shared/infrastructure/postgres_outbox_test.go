@@ -0,0 +1,168 @@
+package infrastructure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var outboxColumns = []string{"seq", "id", "source", "aggregate_id", "topic", "version", "data",
+	"metadata", "correlation_id", "causation_id", "occurred_at", "created_at", "attempts"}
+
+func TestOutboxRelay_RelayBatch(t *testing.T) {
+	tests := []struct {
+		name              string
+		publishErr        func(evts []*events.Event) error
+		expectedPublished string
+		expectedFailed    string
+		expectedErr       bool
+	}{
+		{
+			name:              "all events published",
+			expectedPublished: "{1,2}",
+		},
+		{
+			name:           "publisher fails the whole batch",
+			publishErr:     func([]*events.Event) error { return assert.AnError },
+			expectedFailed: "{1,2}",
+			expectedErr:    true,
+		},
+		{
+			name: "partial publish failure",
+			publishErr: func(evts []*events.Event) error {
+				publishErr := &PublishError{}
+				publishErr.add(evts[1], "InternalError: try again")
+				return publishErr
+			},
+			expectedPublished: "{1}",
+			expectedFailed:    "{2}",
+			expectedErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			var published []*events.Event
+			publisher := events.PublisherFunc(func(ctx context.Context, evts ...*events.Event) error {
+				published = evts
+				if tt.publishErr != nil {
+					return tt.publishErr(evts)
+				}
+				return nil
+			})
+			relay := NewOutboxRelay(db, "wallet", publisher)
+
+			now := time.Now()
+			mock.ExpectBegin()
+			// Only the oldest pending row of each aggregate is claimed, and
+			// rows locked by another relay are skipped
+			mock.ExpectQuery(`AND p\.seq < o\.seq\s+\)\s+ORDER BY o\.seq ASC\s+LIMIT \$2\s+FOR UPDATE SKIP LOCKED`).
+				WithArgs("wallet", maxBatchSize).
+				WillReturnRows(sqlmock.NewRows(outboxColumns).
+					AddRow(1, "event-1", "wallet", "wallet-1", "wallet.debited", "1.0", []byte(`{}`), []byte(`{}`), "correlation-1", nil, now, now, 0).
+					AddRow(2, "event-2", "wallet", "wallet-2", "wallet.credited", "1.0", []byte(`{}`), []byte(`{}`), nil, nil, now, now, 2))
+
+			if tt.expectedFailed != "" {
+				// Failed rows are retried after base·2^attempts, capped
+				mock.ExpectExec(`SET attempts = attempts \+ 1,\s+last_error = \$2,\s+next_attempt_at = NOW\(\) \+ LEAST\(\$3 \* POWER\(2, attempts\), \$4\)`).
+					WithArgs(tt.expectedFailed, sqlmock.AnyArg(), int64(1000), int64(300000)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tt.expectedPublished != "" {
+				mock.ExpectExec(`SET published_at = NOW\(\)`).
+					WithArgs(tt.expectedPublished).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+
+			relayed, err := relay.RelayBatch(context.Background())
+
+			require.Len(t, published, 2)
+			assert.Equal(t, models.ID("event-1"), published[0].ID)
+			assert.Equal(t, models.ID("correlation-1"), published[0].CorrelationID)
+			assert.Equal(t, len(tt.expectedPublished)/2, relayed)
+			assert.Equal(t, tt.expectedErr, err != nil)
+		})
+	}
+}
+
+func TestOutboxRelay_RelayBatchEmpty(t *testing.T) {
+	db, mock := newMockDB(t)
+	relay := NewOutboxRelay(db, "wallet", events.PublisherFunc(func(ctx context.Context, evts ...*events.Event) error {
+		t.Fatal("nothing to publish")
+		return nil
+	}))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM outbox o`).WillReturnRows(sqlmock.NewRows(outboxColumns))
+	mock.ExpectRollback()
+
+	relayed, err := relay.RelayBatch(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, relayed)
+}
+
+func TestPostgresTransactor_WithinTransaction(t *testing.T) {
+	tests := []struct {
+		name        string
+		fn          func(ctx context.Context) error
+		commit      bool
+		expectedErr error
+	}{
+		{
+			name:   "commits on success",
+			fn:     func(ctx context.Context) error { return nil },
+			commit: true,
+		},
+		{
+			name:        "rolls back on error",
+			fn:          func(ctx context.Context) error { return assert.AnError },
+			expectedErr: assert.AnError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			transactor := NewPostgresTransactor(db)
+
+			mock.ExpectBegin()
+			if tt.commit {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := transactor.WithinTransaction(context.Background(), func(ctx context.Context) error {
+				require.NotNil(t, TxFromContext(ctx))
+
+				// Nested calls join the outer transaction
+				return transactor.WithinTransaction(ctx, tt.fn)
+			})
+
+			assert.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestPostgresTransactor_RollsBackOnPanic(t *testing.T) {
+	db, mock := newMockDB(t)
+	transactor := NewPostgresTransactor(db)
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	assert.Panics(t, func() {
+		_ = transactor.WithinTransaction(context.Background(), func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+}
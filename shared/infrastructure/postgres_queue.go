@@ -0,0 +1,576 @@
+package infrastructure
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	_ events.Publisher  = (*PostgresEventPublisher)(nil)
+	_ events.Subscriber = (*PostgresEventSubscriber)(nil)
+)
+
+// EventQueueChannel is the NOTIFY channel used to wake consumers. The
+// payload is the consumer group that received new events.
+const EventQueueChannel = "event_queue"
+
+// queueRecord represents an event_queue row claimed by a consumer
+type queueRecord struct {
+	Seq      int64  `db:"seq"`
+	EventID  string `db:"event_id"`
+	Topic    string `db:"topic"`
+	Body     string `db:"body"`
+	Attempts int    `db:"attempts"`
+}
+
+type postgresQueueOptions struct {
+	codec             Codec
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+	baseRetryDelay    time.Duration
+	maxRetryDelay     time.Duration
+	batchSize         int
+	maxAttempts       int
+	circuitOpenDelay  time.Duration
+}
+
+type PostgresQueueOption func(*postgresQueueOptions)
+
+// WithQueueCodec sets the codec used for the stored message body
+func WithQueueCodec(codec Codec) PostgresQueueOption {
+	return func(o *postgresQueueOptions) {
+		o.codec = codec
+	}
+}
+
+// WithQueueVisibilityTimeout sets how long a claimed event stays hidden from
+// other consumers of the group. An event whose consumer dies before
+// acknowledging it is redelivered once the timeout expires.
+func WithQueueVisibilityTimeout(timeout time.Duration) PostgresQueueOption {
+	return func(o *postgresQueueOptions) {
+		if timeout > 0 {
+			o.visibilityTimeout = timeout
+		}
+	}
+}
+
+// WithQueuePollInterval sets how often the queue is polled when no
+// notification arrives
+func WithQueuePollInterval(interval time.Duration) PostgresQueueOption {
+	return func(o *postgresQueueOptions) {
+		if interval > 0 {
+			o.pollInterval = interval
+		}
+	}
+}
+
+// WithQueueRetryBackoff sets the wait before a failed event is visible
+// again: base·2^(attempts-1), capped at maxDelay
+func WithQueueRetryBackoff(base, maxDelay time.Duration) PostgresQueueOption {
+	return func(o *postgresQueueOptions) {
+		o.baseRetryDelay = base
+		o.maxRetryDelay = maxDelay
+	}
+}
+
+func WithQueueBatchSize(size int) PostgresQueueOption {
+	return func(o *postgresQueueOptions) {
+		if size > 0 {
+			o.batchSize = size
+		}
+	}
+}
+
+// WithQueueMaxAttempts sets how many deliveries an event gets before it is
+// dead-lettered
+func WithQueueMaxAttempts(attempts int) PostgresQueueOption {
+	return func(o *postgresQueueOptions) {
+		if attempts > 0 {
+			o.maxAttempts = attempts
+		}
+	}
+}
+
+// WithQueueCircuitOpenDelay sets how long an event rejected by an open
+// circuit breaker waits before it is delivered again
+func WithQueueCircuitOpenDelay(delay time.Duration) PostgresQueueOption {
+	return func(o *postgresQueueOptions) {
+		if delay > 0 {
+			o.circuitOpenDelay = delay
+		}
+	}
+}
+
+func newPostgresQueueOptions(opts []PostgresQueueOption) *postgresQueueOptions {
+	options := &postgresQueueOptions{
+		codec:             NewJSONCodec(),
+		visibilityTimeout: 30 * time.Second,
+		pollInterval:      5 * time.Second,
+		baseRetryDelay:    time.Second,
+		maxRetryDelay:     5 * time.Minute,
+		batchSize:         maxBatchSize,
+		maxAttempts:       5,
+		circuitOpenDelay:  30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
+
+// PostgresEventPublisher implements events.Publisher on top of the
+// event_queue table. Each event is copied to every consumer group subscribed
+// to its topic. When ctx carries a transaction the copies and notifications
+// are committed with it.
+type PostgresEventPublisher struct {
+	db      *sqlx.DB
+	options *postgresQueueOptions
+}
+
+// NewPostgresEventPublisher creates a new PostgresEventPublisher
+func NewPostgresEventPublisher(db *sqlx.DB, opts ...PostgresQueueOption) *PostgresEventPublisher {
+	return &PostgresEventPublisher{
+		db:      db,
+		options: newPostgresQueueOptions(opts),
+	}
+}
+
+// Publish enqueues events for the matching consumer groups and notifies them
+func (p *PostgresEventPublisher) Publish(ctx context.Context, evts ...*events.Event) error {
+	query := `
+		INSERT INTO event_queue (consumer_group, event_id, topic, body)
+		SELECT DISTINCT consumer_group, $1::text, $2::text, $3::text
+		FROM event_subscriptions
+		WHERE '.' || $2::text ~ pattern_regex
+		RETURNING consumer_group`
+
+	executor := Executor(ctx, p.db)
+	for _, event := range evts {
+		body, err := p.options.codec.Encode(event)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode event")
+		}
+
+		var groups []string
+		if err := executor.SelectContext(ctx, &groups, query, event.ID.String(), event.Topic.String(), string(body)); err != nil {
+			return errors.Wrap(err, "failed to enqueue event")
+		}
+
+		for _, group := range groups {
+			if _, err := executor.ExecContext(ctx, `SELECT pg_notify($1, $2)`, EventQueueChannel, group); err != nil {
+				return errors.Wrap(err, "failed to notify consumer group")
+			}
+		}
+
+		if len(groups) == 0 {
+			// No consumer group subscribes to the topic
+			log.Printf("event queue: no subscription for %s, event %s dropped", event.Topic, event.ID)
+			telemetry.RecordCounter(ctx, "event_queue_unrouted_total", "Total events published with no subscribed consumer group", 1,
+				attribute.String("topic", event.Topic.String()),
+			)
+			continue
+		}
+
+		telemetry.RecordCounter(ctx, "event_queue_enqueued_total", "Total events enqueued to the Postgres transport", int64(len(groups)),
+			attribute.String("topic", event.Topic.String()),
+		)
+	}
+
+	return nil
+}
+
+// Close is a no-op; the database is owned by the caller
+func (p *PostgresEventPublisher) Close() error {
+	return nil
+}
+
+// PostgresEventSubscriber implements events.Subscriber on top of the
+// event_queue table. Consumers of the same group compete for its events with
+// FOR UPDATE SKIP LOCKED, are woken by LISTEN/NOTIFY and fall back to polling
+// when a notification is missed. Delivery is at-least-once.
+type PostgresEventSubscriber struct {
+	mux     sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	options *postgresQueueOptions
+
+	db       *sqlx.DB
+	dsn      string
+	group    string
+	router   *events.Router
+	listener *pq.Listener
+}
+
+// NewPostgresEventSubscriber creates a subscriber consuming the events of
+// group. dsn is used for the dedicated LISTEN connection; when empty the
+// subscriber only polls.
+func NewPostgresEventSubscriber(db *sqlx.DB, dsn, group string, opts ...PostgresQueueOption) *PostgresEventSubscriber {
+	return &PostgresEventSubscriber{
+		db:      db,
+		dsn:     dsn,
+		group:   group,
+		router:  events.NewRouter(group),
+		options: newPostgresQueueOptions(opts),
+	}
+}
+
+// Subscribe registers the group for events whose topic matches eventType and
+// starts consuming. An empty eventType subscribes to every event. Events
+// published before the first Subscribe of a group are not delivered to it.
+func (s *PostgresEventSubscriber) Subscribe(ctx context.Context, eventType string, handler events.EventHandler) error {
+	pattern := events.Topic(eventType)
+	if pattern == "" {
+		pattern = "#"
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO event_subscriptions (consumer_group, pattern, pattern_regex)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (consumer_group, pattern) DO UPDATE SET pattern_regex = EXCLUDED.pattern_regex`,
+		s.group, pattern.String(), patternRegex(pattern),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to register subscription")
+	}
+
+	s.router.Register(pattern, handler)
+
+	return s.Start(ctx)
+}
+
+// Start starts consuming in the background
+func (s *PostgresEventSubscriber) Start(ctx context.Context) error {
+	if s.running.Load() {
+		return nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.dsn != "" {
+		s.listener = pq.NewListener(s.dsn, 100*time.Millisecond, time.Minute, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("event queue listener: %v", err)
+			}
+		})
+		if err := s.listener.Listen(EventQueueChannel); err != nil {
+			s.listener.Close()
+			s.listener = nil
+			return errors.Wrap(err, "failed to listen for event queue notifications")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go s.run(ctx, s.done)
+
+	s.running.Store(true)
+
+	return nil
+}
+
+// Stop stops consuming and waits for the in-flight batch to finish
+func (s *PostgresEventSubscriber) Stop(ctx context.Context) error {
+	if !s.running.Load() {
+		return nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.cancel()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if s.listener != nil {
+		if err := s.listener.Close(); err != nil {
+			return errors.Wrap(err, "failed to close event queue listener")
+		}
+		s.listener = nil
+	}
+
+	s.cancel = nil
+	s.done = nil
+	s.running.Store(false)
+
+	return nil
+}
+
+// Close stops the subscriber
+func (s *PostgresEventSubscriber) Close() error {
+	return s.Stop(context.Background())
+}
+
+func (s *PostgresEventSubscriber) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.options.pollInterval)
+	defer ticker.Stop()
+
+	var notify <-chan *pq.Notification
+	if s.listener != nil {
+		notify = s.listener.Notify
+	}
+
+	for {
+		consumed, err := s.ConsumeBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("event queue consumer %s: %v", s.group, err)
+		}
+
+		// Keep draining while there is work, otherwise wait for a
+		// notification or the next tick
+		if err == nil && consumed > 0 && ctx.Err() == nil {
+			continue
+		}
+
+		s.recordDepth(ctx)
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break wait
+			case n := <-notify:
+				// A nil notification means the listener reconnected and
+				// may have missed some
+				if n == nil || n.Extra == s.group {
+					break wait
+				}
+			}
+		}
+	}
+}
+
+// ConsumeBatch claims up to the batch size of visible events, handles them in
+// order and returns how many were claimed. Each event is settled on its own:
+// one that fails is rescheduled without holding back the rest of the batch.
+func (s *PostgresEventSubscriber) ConsumeBatch(ctx context.Context) (int, error) {
+	query := `
+		UPDATE event_queue q
+		SET attempts = q.attempts + 1,
+			visible_at = NOW() + $3 * INTERVAL '1 millisecond'
+		FROM (
+			SELECT seq FROM event_queue
+			WHERE consumer_group = $1
+			  AND dead_lettered_at IS NULL
+			  AND visible_at <= NOW()
+			ORDER BY seq ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) claimed
+		WHERE q.seq = claimed.seq
+		RETURNING q.seq, q.event_id, q.topic, q.body, q.attempts`
+
+	var records []queueRecord
+	err := s.db.SelectContext(ctx, &records, query,
+		s.group,
+		s.options.batchSize,
+		s.options.visibilityTimeout.Milliseconds(),
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to claim queued events")
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+
+	var (
+		firstErr error
+		failed   int
+	)
+	for i := range records {
+		if ctx.Err() != nil {
+			// Events not handled before shutdown are visible again at once
+			// instead of after the visibility timeout
+			s.release(context.WithoutCancel(ctx), records[i:])
+			break
+		}
+
+		if err := s.handle(ctx, &records[i]); err != nil {
+			log.Printf("event queue consumer %s: event %s: %v", s.group, records[i].EventID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+		}
+	}
+
+	if firstErr != nil {
+		return len(records), errors.Wrapf(firstErr, "%d of %d queued events could not be settled", failed, len(records))
+	}
+
+	return len(records), nil
+}
+
+// release makes claimed events visible again without counting the attempt
+func (s *PostgresEventSubscriber) release(ctx context.Context, records []queueRecord) {
+	seqs := make([]int64, len(records))
+	for i, record := range records {
+		seqs[i] = record.Seq
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE event_queue
+		SET attempts = attempts - 1, visible_at = NOW()
+		WHERE seq = ANY($1)`,
+		pq.Array(seqs),
+	)
+	if err != nil {
+		log.Printf("event queue consumer %s: failed to release events: %v", s.group, err)
+	}
+}
+
+// handle delivers one claimed event and acknowledges, reschedules or
+// dead-letters it
+func (s *PostgresEventSubscriber) handle(ctx context.Context, record *queueRecord) error {
+	event, err := s.options.codec.Decode([]byte(record.Body))
+	if err != nil {
+		// A body that cannot be decoded will never succeed
+		return s.deadLetter(ctx, record, err)
+	}
+
+	handleErr := s.router.Handle(events.ContextWithEvent(ctx, event), event)
+
+	// The outcome is recorded even when shutdown cancelled ctx meanwhile, so
+	// a handled event is not delivered again
+	ctx = context.WithoutCancel(ctx)
+
+	if handleErr == nil {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM event_queue WHERE seq = $1`, record.Seq); err != nil {
+			return errors.Wrap(err, "failed to acknowledge queued event")
+		}
+		s.recordDelivery(ctx, record, "success")
+		return nil
+	}
+
+	if errors.Is(handleErr, ErrCircuitOpen) {
+		// The handler did not run, so the delivery is not counted as an
+		// attempt and cannot dead-letter the event
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE event_queue
+			SET attempts = attempts - 1,
+				visible_at = NOW() + $2 * INTERVAL '1 millisecond'
+			WHERE seq = $1`,
+			record.Seq,
+			s.options.circuitOpenDelay.Milliseconds(),
+		)
+		if err != nil {
+			return errors.Wrap(err, "failed to defer queued event")
+		}
+
+		s.recordDelivery(ctx, record, "deferred")
+		return nil
+	}
+
+	// Permanent failures, such as invalid payloads and panics, never succeed
+	// on retry
+	if record.Attempts >= s.options.maxAttempts || IsPermanent(handleErr) {
+		return s.deadLetter(ctx, record, handleErr)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE event_queue
+		SET last_error = $2,
+			visible_at = NOW() + LEAST($3 * POWER(2, attempts - 1), $4) * INTERVAL '1 millisecond'
+		WHERE seq = $1`,
+		record.Seq,
+		handleErr.Error(),
+		s.options.baseRetryDelay.Milliseconds(),
+		s.options.maxRetryDelay.Milliseconds(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule queued event retry")
+	}
+
+	s.recordDelivery(ctx, record, "failed")
+	return nil
+}
+
+// deadLetter keeps the event in the queue but stops delivering it
+func (s *PostgresEventSubscriber) deadLetter(ctx context.Context, record *queueRecord, cause error) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE event_queue
+		SET last_error = $2, dead_lettered_at = NOW()
+		WHERE seq = $1`,
+		record.Seq,
+		cause.Error(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to dead-letter queued event")
+	}
+
+	s.recordDelivery(ctx, record, "dead_lettered")
+	return nil
+}
+
+func (s *PostgresEventSubscriber) recordDelivery(ctx context.Context, record *queueRecord, status string) {
+	telemetry.RecordCounter(ctx, "event_queue_deliveries_total", "Total Postgres transport deliveries", 1,
+		attribute.String("consumer_group", s.group),
+		attribute.String("topic", record.Topic),
+		attribute.String("status", status),
+	)
+}
+
+// recordDepth records how many events are waiting for the group
+func (s *PostgresEventSubscriber) recordDepth(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	var depth int64
+	err := s.db.GetContext(ctx, &depth,
+		`SELECT COUNT(*) FROM event_queue WHERE consumer_group = $1 AND dead_lettered_at IS NULL`,
+		s.group,
+	)
+	if err != nil {
+		return
+	}
+
+	telemetry.RecordGauge(ctx, "event_queue_depth", "Events waiting in the Postgres transport", float64(depth),
+		attribute.String("consumer_group", s.group),
+	)
+}
+
+// patternRegex translates a topic pattern into a POSIX regular expression
+// matched against the topic prefixed with "." ("*" matches one word, "#"
+// zero or more), so Postgres can route events the way Topic.Matches does
+func patternRegex(pattern events.Topic) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, word := range strings.Split(pattern.String(), ".") {
+		switch word {
+		case "#":
+			b.WriteString(`(\.[^.]+)*`)
+		case "*":
+			b.WriteString(`\.[^.]+`)
+		default:
+			b.WriteString(`\.` + regexp.QuoteMeta(word))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
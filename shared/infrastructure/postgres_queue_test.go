@@ -0,0 +1,244 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternRegex(t *testing.T) {
+	tests := []struct {
+		pattern  events.Topic
+		topic    events.Topic
+		expected bool
+	}{
+		{pattern: "payment.created", topic: "payment.created", expected: true},
+		{pattern: "payment.created", topic: "payment.failed", expected: false},
+		{pattern: "payment.*", topic: "payment.created", expected: true},
+		{pattern: "payment.*", topic: "payment.payment_operation.completed", expected: false},
+		{pattern: "payment.#", topic: "payment.payment_operation.completed", expected: true},
+		{pattern: "payment.#", topic: "payment", expected: true},
+		{pattern: "#", topic: "wallet.debited", expected: true},
+		{pattern: "#.completed", topic: "payment.payment_operation.completed", expected: true},
+		{pattern: "*.debit.*", topic: "wallet.debit.requested", expected: true},
+		{pattern: "*.debit.*", topic: "wallet.debited", expected: false},
+		{pattern: "wallet.#.requested", topic: "wallet.requested", expected: true},
+		{pattern: "payment.created", topic: "paymentxcreated", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.pattern)+" "+string(tt.topic), func(t *testing.T) {
+			re, err := regexp.Compile(patternRegex(tt.pattern))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected, re.MatchString("."+tt.topic.String()))
+			assert.Equal(t, tt.topic.Matches(tt.pattern), re.MatchString("."+tt.topic.String()),
+				"regex must agree with Topic.Matches")
+		})
+	}
+}
+
+func TestPatternRegex_QuotesLiteralWords(t *testing.T) {
+	re, err := regexp.Compile(patternRegex("payment+v2.created"))
+	require.NoError(t, err)
+
+	assert.True(t, re.MatchString(".payment+v2.created"))
+	assert.False(t, re.MatchString(".paymentttv2.created"))
+}
+
+// queueRow returns an event_queue row holding the JSON encoding of event
+func queueRow(t *testing.T, seq int64, event *events.Event, attempts int) []driver.Value {
+	body, err := NewJSONCodec().Encode(event)
+	require.NoError(t, err)
+	return []driver.Value{seq, event.ID.String(), event.Topic.String(), string(body), attempts}
+}
+
+var queueColumns = []string{"seq", "event_id", "topic", "body", "attempts"}
+
+func TestPostgresEventSubscriber_ConsumeBatch(t *testing.T) {
+	first := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+	second := events.NewEvent("payment-2", events.PaymentCreatedEvent, nil)
+	third := events.NewEvent("payment-3", events.PaymentCreatedEvent, nil)
+
+	tests := []struct {
+		name        string
+		failing     map[models.ID]error
+		expect      func(mock sqlmock.Sqlmock)
+		expectedErr bool
+	}{
+		{
+			name: "acknowledges every handled event",
+			expect: func(mock sqlmock.Sqlmock) {
+				for _, seq := range []int64{1, 2, 3} {
+					mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(seq).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			},
+		},
+		{
+			name:    "failed event is rescheduled alone",
+			failing: map[models.ID]error{second.ID: assert.AnError},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+				// Retried after base·2^(attempts-1), capped
+				mock.ExpectExec(`visible_at = NOW\(\) \+ LEAST\(\$3 \* POWER\(2, attempts - 1\), \$4\)`).
+					WithArgs(int64(2), sqlmock.AnyArg(), int64(1000), int64(300000)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:    "event out of attempts is dead-lettered",
+			failing: map[models.ID]error{third.ID: assert.AnError},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`SET last_error = \$2, dead_lettered_at = NOW\(\)`).
+					WithArgs(int64(3), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:    "event rejected by an open circuit is deferred without an attempt",
+			failing: map[models.ID]error{third.ID: errors.Wrap(ErrCircuitOpen, "handler test")},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`SET attempts = attempts - 1,\s+visible_at = NOW\(\) \+ \$2`).
+					WithArgs(int64(3), int64(30000)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "failed acknowledgement does not stop the batch",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(1)).WillReturnError(assert.AnError)
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			subscriber := NewPostgresEventSubscriber(db, "", "payments", WithQueueMaxAttempts(3))
+
+			var handled []models.ID
+			subscriber.router.RegisterFunc("payment.#", func(ctx context.Context, event *events.Event) error {
+				handled = append(handled, event.ID)
+				return tt.failing[event.ID]
+			})
+
+			mock.ExpectQuery(`UPDATE event_queue q`).
+				WithArgs("payments", maxBatchSize, int64(30000)).
+				WillReturnRows(sqlmock.NewRows(queueColumns).
+					AddRow(queueRow(t, 3, third, 3)...).
+					AddRow(queueRow(t, 1, first, 1)...).
+					AddRow(queueRow(t, 2, second, 1)...))
+			tt.expect(mock)
+
+			consumed, err := subscriber.ConsumeBatch(context.Background())
+
+			assert.Equal(t, 3, consumed)
+			assert.Equal(t, tt.expectedErr, err != nil)
+			assert.Equal(t, []models.ID{first.ID, second.ID, third.ID}, handled, "events are handled in queue order")
+		})
+	}
+}
+
+func TestPostgresEventSubscriber_ConsumeBatchReleasesOnShutdown(t *testing.T) {
+	db, mock := newMockDB(t)
+	subscriber := NewPostgresEventSubscriber(db, "", "payments")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber.router.RegisterFunc("payment.#", func(context.Context, *events.Event) error {
+		cancel()
+		return nil
+	})
+
+	mock.ExpectQuery(`UPDATE event_queue q`).
+		WillReturnRows(sqlmock.NewRows(queueColumns).
+			AddRow(queueRow(t, 1, events.NewEvent("payment-1", events.PaymentCreatedEvent, nil), 1)...).
+			AddRow(queueRow(t, 2, events.NewEvent("payment-2", events.PaymentCreatedEvent, nil), 1)...).
+			AddRow(queueRow(t, 3, events.NewEvent("payment-3", events.PaymentCreatedEvent, nil), 1)...))
+	// The handled event is acknowledged despite the cancellation and the
+	// others are visible again at once
+	mock.ExpectExec(`DELETE FROM event_queue WHERE seq = \$1`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SET attempts = attempts - 1, visible_at = NOW\(\)`).WithArgs("{2,3}").WillReturnResult(sqlmock.NewResult(0, 2))
+
+	consumed, err := subscriber.ConsumeBatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, consumed)
+}
+
+func TestPostgresEventSubscriber_DeadLettersInvalidPayload(t *testing.T) {
+	db, mock := newMockDB(t)
+	subscriber := NewPostgresEventSubscriber(db, "", "wallet", WithQueueMaxAttempts(5))
+
+	calls := 0
+	registry := events.NewRegistry().MustRegister(events.WalletCreditRequestedEvent, schemaTestPayload{})
+	subscriber.router.Register("wallet.#", ChainHandler(
+		NewEventHandlerFunc("handler", func(ctx context.Context, event *events.Event) error {
+			calls++
+			return nil
+		}),
+		SchemaValidationMiddleware(registry),
+	))
+
+	invalid := events.NewEvent("payment-1", events.WalletCreditRequestedEvent,
+		json.RawMessage(`{"amount":{"amount":100,"currency":"USD"}}`))
+	mock.ExpectQuery(`UPDATE event_queue q`).
+		WillReturnRows(sqlmock.NewRows(queueColumns).AddRow(queueRow(t, 1, invalid, 1)...))
+	// Dead-lettered on its first attempt with the offending field as reason
+	mock.ExpectExec(`SET last_error = \$2, dead_lettered_at = NOW\(\)`).
+		WithArgs(int64(1), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	consumed, err := subscriber.ConsumeBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, consumed)
+	assert.Zero(t, calls)
+}
+
+func TestPostgresEventPublisher_Publish(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []string
+	}{
+		{name: "notifies each subscribed group", groups: []string{"payments", "wallet"}},
+		{name: "event without subscribers is dropped", groups: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			publisher := NewPostgresEventPublisher(db)
+			event := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+
+			rows := sqlmock.NewRows([]string{"consumer_group"})
+			for _, group := range tt.groups {
+				rows.AddRow(group)
+			}
+			mock.ExpectQuery(`INSERT INTO event_queue`).
+				WithArgs(event.ID.String(), event.Topic.String(), sqlmock.AnyArg()).
+				WillReturnRows(rows)
+			for _, group := range tt.groups {
+				mock.ExpectExec(`SELECT pg_notify`).WithArgs(EventQueueChannel, group).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			require.NoError(t, publisher.Publish(context.Background(), event))
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var _ saga.Log = (*PostgresSagaLog)(nil)
+
+// PostgresSagaLog implements saga.Log over the saga_steps table, keeping the
+// derived instance of every saga in saga_instances for queries by aggregate
+// and status
+type PostgresSagaLog struct {
+	db *sqlx.DB
+}
+
+// NewPostgresSagaLog creates a new PostgresSagaLog
+func NewPostgresSagaLog(db *sqlx.DB) *PostgresSagaLog {
+	return &PostgresSagaLog{db: db}
+}
+
+// postgresSagaStep represents a saga_steps row
+type postgresSagaStep struct {
+	EventID       string         `db:"event_id"`
+	CorrelationID string         `db:"correlation_id"`
+	Topic         string         `db:"topic"`
+	AggregateID   string         `db:"aggregate_id"`
+	Reason        sql.NullString `db:"reason"`
+	OccurredAt    time.Time      `db:"occurred_at"`
+	RecordedAt    time.Time      `db:"recorded_at"`
+}
+
+func (r *postgresSagaStep) toStep() saga.Step {
+	return saga.Step{
+		EventID:     models.ID(r.EventID),
+		Topic:       events.Topic(r.Topic),
+		AggregateID: models.ID(r.AggregateID),
+		Reason:      r.Reason.String,
+		OccurredAt:  r.OccurredAt,
+		RecordedAt:  r.RecordedAt,
+	}
+}
+
+// Record adds step to its saga and refreshes the saga's instance. Steps of a
+// saga are recorded one at a time under an advisory lock on its correlation
+// ID. When ctx carries a transaction the step joins it instead of
+// committing.
+func (l *PostgresSagaLog) Record(ctx context.Context, correlationID models.ID, step saga.Step) (saga.SagaStatus, *saga.Instance, error) {
+	if tx := TxFromContext(ctx); tx != nil {
+		return l.record(ctx, tx, correlationID, step)
+	}
+
+	tx, err := l.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	before, instance, err := l.record(ctx, tx, correlationID, step)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, errors.Wrap(err, "failed to commit saga step")
+	}
+
+	return before, instance, nil
+}
+
+func (l *PostgresSagaLog) record(ctx context.Context, tx *sqlx.Tx, correlationID models.ID, step saga.Step) (saga.SagaStatus, *saga.Instance, error) {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, correlationID.String()); err != nil {
+		return "", nil, errors.Wrap(err, "failed to lock saga")
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO saga_steps (event_id, correlation_id, topic, aggregate_id, reason, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (correlation_id, event_id) DO NOTHING`,
+		step.EventID.String(),
+		correlationID.String(),
+		step.Topic.String(),
+		step.AggregateID.String(),
+		sql.NullString{String: step.Reason, Valid: step.Reason != ""},
+		step.OccurredAt,
+	)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to insert saga step")
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to read inserted saga steps")
+	}
+	if inserted == 0 {
+		return "", nil, nil
+	}
+
+	var before saga.SagaStatus
+	err = tx.GetContext(ctx, &before, `SELECT status FROM saga_instances WHERE correlation_id = $1`, correlationID.String())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", nil, errors.Wrap(err, "failed to read saga status")
+	}
+
+	steps, err := l.steps(ctx, tx, correlationID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	instance := saga.Replay(correlationID, steps)
+	if err := l.saveInstance(ctx, tx, instance); err != nil {
+		return "", nil, err
+	}
+
+	return before, instance, nil
+}
+
+// saveInstance upserts the saga_instances row of instance
+func (l *PostgresSagaLog) saveInstance(ctx context.Context, tx *sqlx.Tx, instance *saga.Instance) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO saga_instances (
+			correlation_id, saga_type, aggregate_id, status, current_step,
+			started_at, updated_at, finished_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (correlation_id) DO UPDATE SET
+			saga_type = EXCLUDED.saga_type,
+			aggregate_id = EXCLUDED.aggregate_id,
+			status = EXCLUDED.status,
+			current_step = EXCLUDED.current_step,
+			started_at = EXCLUDED.started_at,
+			updated_at = EXCLUDED.updated_at,
+			finished_at = EXCLUDED.finished_at`,
+		instance.CorrelationID.String(),
+		string(instance.Type),
+		instance.AggregateID.String(),
+		string(instance.Status),
+		instance.CurrentStep,
+		instance.StartedAt,
+		instance.UpdatedAt,
+		instance.FinishedAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to save saga instance")
+	}
+	return nil
+}
+
+// steps returns the recorded steps of a saga
+func (l *PostgresSagaLog) steps(ctx context.Context, q sqlx.QueryerContext, correlationID models.ID) ([]saga.Step, error) {
+	var rows []postgresSagaStep
+	err := sqlx.SelectContext(ctx, q, &rows, `
+		SELECT event_id, correlation_id, topic, aggregate_id, reason, occurred_at, recorded_at
+		FROM saga_steps
+		WHERE correlation_id = $1
+		ORDER BY occurred_at, recorded_at`,
+		correlationID.String(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query saga steps")
+	}
+
+	steps := make([]saga.Step, 0, len(rows))
+	for i := range rows {
+		steps = append(steps, rows[i].toStep())
+	}
+	return steps, nil
+}
+
+// Get returns the saga of correlationID, replayed from its steps
+func (l *PostgresSagaLog) Get(ctx context.Context, correlationID models.ID) (*saga.Instance, error) {
+	steps, err := l.steps(ctx, l.db, correlationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(steps) == 0 {
+		return nil, errors.Wrapf(saga.ErrSagaNotFound, "correlation ID %s", correlationID)
+	}
+
+	return saga.Replay(correlationID, steps), nil
+}
+
+// FindByAggregate returns the sagas started for aggregateID, oldest first
+func (l *PostgresSagaLog) FindByAggregate(ctx context.Context, aggregateID models.ID) ([]*saga.Instance, error) {
+	var rows []postgresSagaStep
+	err := l.db.SelectContext(ctx, &rows, `
+		SELECT s.event_id, s.correlation_id, s.topic, s.aggregate_id, s.reason, s.occurred_at, s.recorded_at
+		FROM saga_steps s
+		JOIN saga_instances i ON i.correlation_id = s.correlation_id
+		WHERE i.aggregate_id = $1
+		ORDER BY i.started_at, s.correlation_id, s.occurred_at, s.recorded_at`,
+		aggregateID.String(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query saga steps")
+	}
+
+	var instances []*saga.Instance
+	for start := 0; start < len(rows); {
+		end := start
+		var steps []saga.Step
+		for ; end < len(rows) && rows[end].CorrelationID == rows[start].CorrelationID; end++ {
+			steps = append(steps, rows[end].toStep())
+		}
+
+		instances = append(instances, saga.Replay(models.ID(rows[start].CorrelationID), steps))
+		start = end
+	}
+
+	return instances, nil
+}
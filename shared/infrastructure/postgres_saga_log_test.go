@@ -0,0 +1,150 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sagaStepColumns = []string{"event_id", "correlation_id", "topic", "aggregate_id", "reason", "occurred_at", "recorded_at"}
+
+func TestPostgresSagaLog_Record(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	step := saga.Step{
+		EventID:     "event-2",
+		Topic:       events.WalletDebitedEvent,
+		AggregateID: "wallet-1",
+		OccurredAt:  start.Add(time.Second),
+	}
+
+	tests := []struct {
+		name           string
+		inserted       int64
+		previousStatus string
+		expectedBefore saga.SagaStatus
+		expectedStatus saga.SagaStatus
+	}{
+		{
+			name:           "new step refreshes the instance",
+			inserted:       1,
+			previousStatus: string(saga.SagaStatusStarted),
+			expectedBefore: saga.SagaStatusStarted,
+			expectedStatus: saga.SagaStatusInProgress,
+		},
+		{
+			name:           "first step of a saga",
+			inserted:       1,
+			expectedStatus: saga.SagaStatusInProgress,
+		},
+		{
+			name:     "recorded step is skipped",
+			inserted: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			log := NewPostgresSagaLog(db)
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`SELECT pg_advisory_xact_lock\(hashtext\(\$1\)\)`).
+				WithArgs("correlation-1").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO saga_steps .+ ON CONFLICT \(correlation_id, event_id\) DO NOTHING`).
+				WithArgs("event-2", "correlation-1", events.WalletDebitedEvent, "wallet-1", sql.NullString{}, step.OccurredAt).
+				WillReturnResult(sqlmock.NewResult(0, tt.inserted))
+
+			if tt.inserted > 0 {
+				statusQuery := mock.ExpectQuery(`SELECT status FROM saga_instances WHERE correlation_id = \$1`).
+					WithArgs("correlation-1")
+				if tt.previousStatus != "" {
+					statusQuery.WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tt.previousStatus))
+				} else {
+					statusQuery.WillReturnError(sql.ErrNoRows)
+				}
+
+				mock.ExpectQuery(`SELECT .+ FROM saga_steps WHERE correlation_id = \$1 ORDER BY occurred_at, recorded_at`).
+					WithArgs("correlation-1").
+					WillReturnRows(sqlmock.NewRows(sagaStepColumns).
+						AddRow("event-1", "correlation-1", events.PaymentCreatedEvent, "payment-1", nil, start, start).
+						AddRow("event-2", "correlation-1", events.WalletDebitedEvent, "wallet-1", nil, step.OccurredAt, step.OccurredAt))
+
+				mock.ExpectExec(`INSERT INTO saga_instances .+ ON CONFLICT \(correlation_id\) DO UPDATE`).
+					WithArgs("correlation-1", string(saga.SagaTypePayment), "payment-1", string(tt.expectedStatus),
+						"wallet debit", start, step.OccurredAt, nil).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+
+			before, instance, err := log.Record(context.Background(), "correlation-1", step)
+			require.NoError(t, err)
+
+			if tt.inserted == 0 {
+				assert.Nil(t, instance)
+				return
+			}
+
+			require.NotNil(t, instance)
+			assert.Equal(t, tt.expectedBefore, before)
+			assert.Equal(t, tt.expectedStatus, instance.Status)
+			assert.Len(t, instance.Steps, 2)
+		})
+	}
+}
+
+func TestPostgresSagaLog_Get(t *testing.T) {
+	db, mock := newMockDB(t)
+	log := NewPostgresSagaLog(db)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT .+ FROM saga_steps WHERE correlation_id = \$1`).
+		WithArgs("correlation-1").
+		WillReturnRows(sqlmock.NewRows(sagaStepColumns).
+			AddRow("event-1", "correlation-1", events.PaymentRefundInitiatedEvent, "payment-1", "customer request", start, start).
+			AddRow("event-2", "correlation-1", events.PaymentOperationFailedEvent, "operation-1", "provider down", start.Add(3*time.Second), start))
+	mock.ExpectQuery(`SELECT .+ FROM saga_steps WHERE correlation_id = \$1`).
+		WithArgs("correlation-2").
+		WillReturnRows(sqlmock.NewRows(sagaStepColumns))
+
+	instance, err := log.Get(context.Background(), "correlation-1")
+	require.NoError(t, err)
+	assert.Equal(t, saga.SagaTypeRefund, instance.Type)
+	assert.Equal(t, saga.SagaStatusFailed, instance.Status)
+	assert.Equal(t, models.ID("payment-1"), instance.AggregateID)
+	require.Len(t, instance.Steps, 2)
+	assert.Equal(t, "provider down", instance.Steps[1].Reason)
+	assert.Equal(t, 3*time.Second, instance.Steps[1].Duration)
+
+	_, err = log.Get(context.Background(), "correlation-2")
+	assert.ErrorIs(t, err, saga.ErrSagaNotFound)
+}
+
+func TestPostgresSagaLog_FindByAggregate(t *testing.T) {
+	db, mock := newMockDB(t)
+	log := NewPostgresSagaLog(db)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT .+ FROM saga_steps s JOIN saga_instances i .+ WHERE i.aggregate_id = \$1`).
+		WithArgs("payment-1").
+		WillReturnRows(sqlmock.NewRows(sagaStepColumns).
+			AddRow("event-1", "correlation-1", events.PaymentCreatedEvent, "payment-1", nil, start, start).
+			AddRow("event-2", "correlation-1", events.PaymentCompletedEvent, "payment-1", nil, start.Add(time.Second), start).
+			AddRow("event-3", "correlation-2", events.PaymentRefundInitiatedEvent, "payment-1", nil, start.Add(time.Hour), start))
+
+	instances, err := log.FindByAggregate(context.Background(), "payment-1")
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	assert.Equal(t, saga.SagaTypePayment, instances[0].Type)
+	assert.Equal(t, saga.SagaStatusCompleted, instances[0].Status)
+	assert.Equal(t, saga.SagaTypeRefund, instances[1].Type)
+	assert.Equal(t, saga.SagaStatusStarted, instances[1].Status)
+}
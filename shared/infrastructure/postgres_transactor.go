@@ -0,0 +1,130 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// SQLExecutor is the subset of sqlx shared by *sqlx.DB and *sqlx.Tx
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// Context key for the active transaction
+type txContextKey struct{}
+
+// Executor returns the transaction stored in ctx, or db when there is none.
+// Repositories use it so their writes join the caller's transaction.
+func Executor(ctx context.Context, db *sqlx.DB) SQLExecutor {
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx
+	}
+	return db
+}
+
+// TxFromContext extracts the active transaction from context
+func TxFromContext(ctx context.Context) *sqlx.Tx {
+	if tx, ok := ctx.Value(txContextKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return nil
+}
+
+// Transactor runs a unit of work inside a database transaction
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// PostgresTransactor implements Transactor using PostgreSQL
+type PostgresTransactor struct {
+	db *sqlx.DB
+}
+
+// NewPostgresTransactor creates a new PostgresTransactor
+func NewPostgresTransactor(db *sqlx.DB) *PostgresTransactor {
+	return &PostgresTransactor{db: db}
+}
+
+// WithinTransaction runs fn with a transaction injected into its context.
+// Nested calls join the outer transaction. The transaction is committed
+// when fn returns nil and rolled back otherwise.
+func (t *PostgresTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if TxFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	tx, err := t.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Wrapf(err, "rollback failed: %v", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+// TransactionalEventHandler runs the wrapped handler inside a transaction so
+// that repository writes and outbox rows are committed together
+type TransactionalEventHandler struct {
+	transactor Transactor
+	handler    EventHandler
+}
+
+// NewTransactionalEventHandler creates a new TransactionalEventHandler
+func NewTransactionalEventHandler(transactor Transactor, handler EventHandler) *TransactionalEventHandler {
+	return &TransactionalEventHandler{
+		transactor: transactor,
+		handler:    handler,
+	}
+}
+
+func (h *TransactionalEventHandler) HandlerID() string {
+	return h.handler.HandlerID()
+}
+
+func (h *TransactionalEventHandler) Handle(ctx context.Context, event *events.Event) error {
+	return h.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		return h.handler.Handle(ctx, event)
+	})
+}
+
+// TryAdvisoryLock takes the transaction-level advisory lock named key in the
+// transaction carried by ctx and reports whether it was free. The lock is
+// released when the transaction ends, so work guarded by it runs on a single
+// replica at a time.
+func TryAdvisoryLock(ctx context.Context, key string) (bool, error) {
+	tx := TxFromContext(ctx)
+	if tx == nil {
+		return false, errors.New("advisory lock requires a transaction")
+	}
+
+	var locked bool
+	if err := tx.GetContext(ctx, &locked, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, key); err != nil {
+		return false, errors.Wrap(err, "failed to take advisory lock")
+	}
+
+	return locked, nil
+}
@@ -0,0 +1,77 @@
+package infrastructure
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PublishTracingMiddleware starts a producer span per event and writes its
+// trace context into the event metadata (see TracingPublisher)
+func PublishTracingMiddleware() events.PublisherMiddleware {
+	return func(next events.Publisher) events.Publisher {
+		return NewTracingPublisher(next)
+	}
+}
+
+// PublishMetricsMiddleware records events_published_total and
+// event_publish_duration_seconds per event type and status. Events listed in
+// a *PublishError are counted as failed and the others as published.
+func PublishMetricsMiddleware() events.PublisherMiddleware {
+	return func(next events.Publisher) events.Publisher {
+		return events.PublisherFunc(func(ctx context.Context, evts ...*events.Event) error {
+			start := time.Now()
+			err := next.Publish(ctx, evts...)
+			duration := time.Since(start)
+
+			var publishErr *PublishError
+			partial := errors.As(err, &publishErr)
+
+			for _, event := range evts {
+				status := "success"
+				if err != nil && (!partial || publishErr.Failed(event.ID)) {
+					status = "error"
+				}
+
+				telemetry.RecordCounter(ctx, "events_published_total", "Total events published", 1,
+					attribute.String("event_type", event.Topic.String()),
+					attribute.String("status", status),
+				)
+				telemetry.RecordHistogram(ctx, "event_publish_duration_seconds", "Event publishing duration", duration.Seconds(),
+					attribute.String("event_type", event.Topic.String()),
+					attribute.String("status", status),
+				)
+			}
+
+			return err
+		})
+	}
+}
+
+// PublishLoggingMiddleware logs calls that failed with the topics and IDs of
+// their events. A nil logger uses the standard logger.
+func PublishLoggingMiddleware(logger *log.Logger) events.PublisherMiddleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next events.Publisher) events.Publisher {
+		return events.PublisherFunc(func(ctx context.Context, evts ...*events.Event) error {
+			err := next.Publish(ctx, evts...)
+			if err != nil {
+				described := make([]string, len(evts))
+				for i, event := range evts {
+					described[i] = event.Topic.String() + " " + event.ID.String()
+				}
+				logger.Printf("failed to publish [%s]: %v", strings.Join(described, ", "), err)
+			}
+			return err
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package infrastructure
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/draftea/payment-system/shared/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ScheduledJob runs a function every interval on a single replica. Each run
+// happens in a transaction holding the advisory lock named after the job;
+// replicas that find the lock taken skip the run.
+type ScheduledJob struct {
+	mux     sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+
+	name       string
+	interval   time.Duration
+	transactor Transactor
+	fn         func(ctx context.Context) error
+}
+
+// NewScheduledJob creates a ScheduledJob running fn every interval
+func NewScheduledJob(name string, interval time.Duration, transactor Transactor, fn func(ctx context.Context) error) *ScheduledJob {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &ScheduledJob{
+		name:       name,
+		interval:   interval,
+		transactor: transactor,
+		fn:         fn,
+	}
+}
+
+// Start starts running the job in the background
+func (j *ScheduledJob) Start(ctx context.Context) error {
+	if j.running.Load() {
+		return nil
+	}
+
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	j.cancel = cancel
+	j.done = make(chan struct{})
+
+	go j.run(ctx, j.done)
+
+	j.running.Store(true)
+
+	return nil
+}
+
+// Stop stops the job, waiting for a run in progress
+func (j *ScheduledJob) Stop(ctx context.Context) error {
+	if !j.running.Load() {
+		return nil
+	}
+
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	j.cancel()
+
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	j.cancel = nil
+	j.done = nil
+	j.running.Store(false)
+
+	return nil
+}
+
+func (j *ScheduledJob) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := j.RunOnce(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("scheduled job %s failed: %v", j.name, err)
+			}
+		}
+	}
+}
+
+// RunOnce runs the job unless another replica holds its lock, and reports
+// whether it ran. The job's writes commit with the run.
+func (j *ScheduledJob) RunOnce(ctx context.Context) (bool, error) {
+	ran := false
+	err := j.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		locked, err := TryAdvisoryLock(ctx, "scheduled-job:"+j.name)
+		if err != nil || !locked {
+			return err
+		}
+
+		ran = true
+		return j.fn(ctx)
+	})
+
+	status := "success"
+	switch {
+	case err != nil:
+		status = "error"
+	case !ran:
+		status = "skipped"
+	}
+	telemetry.RecordCounter(ctx, "scheduled_job_runs_total", "Total scheduled job runs", 1,
+		attribute.String("job", j.name),
+		attribute.String("status", status),
+	)
+
+	return ran, err
+}
@@ -0,0 +1,71 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledJob_RunOnce(t *testing.T) {
+	tests := []struct {
+		name        string
+		locked      bool
+		jobErr      error
+		expectedRan bool
+		expectedErr string
+	}{
+		{
+			name:        "runs holding the lock",
+			locked:      true,
+			expectedRan: true,
+		},
+		{
+			name:   "skips when another replica holds the lock",
+			locked: false,
+		},
+		{
+			name:        "job error rolls back the run",
+			locked:      true,
+			jobErr:      errors.New("boom"),
+			expectedRan: true,
+			expectedErr: "boom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock\(hashtext\(\$1\)\)`).
+				WithArgs("scheduled-job:watchdog").
+				WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(tt.locked))
+			if tt.jobErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			calls := 0
+			job := NewScheduledJob("watchdog", 0, NewPostgresTransactor(db), func(ctx context.Context) error {
+				calls++
+				assert.NotNil(t, TxFromContext(ctx))
+				return tt.jobErr
+			})
+
+			ran, err := job.RunOnce(context.Background())
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedRan, ran)
+			assert.Equal(t, map[bool]int{true: 1, false: 0}[tt.expectedRan], calls)
+		})
+	}
+}
@@ -15,7 +15,7 @@ type SNSPublisherAdapter struct {
 }
 
 // NewSNSPublisherAdapter creates a new SNS publisher adapter
-func NewSNSPublisherAdapter(topicArn string) (*SNSPublisherAdapter, error) {
+func NewSNSPublisherAdapter(topicArn string, opts ...SNSPublisherOption) (*SNSPublisherAdapter, error) {
 	// Load AWS config (works with LocalStack when AWS_ENDPOINT_URL is set)
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
@@ -26,7 +26,7 @@ func NewSNSPublisherAdapter(topicArn string) (*SNSPublisherAdapter, error) {
 	snsClient := sns.NewFromConfig(cfg)
 
 	// Create SNS publisher
-	snsPublisher := NewSNSEventPublisher(snsClient, topicArn)
+	snsPublisher := NewSNSEventPublisher(snsClient, topicArn, opts...)
 
 	return &SNSPublisherAdapter{
 		snsPublisher: snsPublisher,
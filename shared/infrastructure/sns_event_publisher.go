@@ -2,148 +2,522 @@ package infrastructure
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sns/types"
 	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/telemetry"
 	"github.com/pkg/errors"
-	"golang.org/x/sync/errgroup"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var _ events.Publisher = (*SNSEventPublisher)(nil)
 
-const maxBatchSize = 10
+const (
+	maxBatchSize = 10
 
-type snsMessage struct {
-	ID        string          `json:"id"`
-	Metadata  events.Metadata `json:"metadata"`
-	Topic     string          `json:"topic"`
-	Payload   json.RawMessage `json:"payload"`
-	Timestamp time.Time       `json:"timestamp"`
+	// snsMaxMessageAttributes is the SNS limit of message attributes per
+	// message; an entry over it is rejected
+	snsMaxMessageAttributes = 10
+)
+
+// messageAttributeKeys are the metadata keys sent as SNS message attributes
+// next to "topic", which filter policies match. The codec carries every
+// metadata key in the message body, so only keys read before decoding are
+// sent as attributes.
+var messageAttributeKeys = []string{
+	telemetry.TraceParentKey,
+	telemetry.TraceStateKey,
 }
 
-// SNSEventPublisher implements EventPublisher using AWS SNS
+// PublishError lists the events that could not be published, after retries
+// for retryable failures. The other events of the call were published.
+type PublishError struct {
+	FailedEventIDs []models.ID
+	// Reasons holds the last failure of each event
+	Reasons map[models.ID]string
+}
+
+func (e *PublishError) Error() string {
+	failures := make([]string, len(e.FailedEventIDs))
+	for i, id := range e.FailedEventIDs {
+		failures[i] = fmt.Sprintf("%s (%s)", id, e.Reasons[id])
+	}
+	return fmt.Sprintf("failed to publish %d events: %s", len(e.FailedEventIDs), strings.Join(failures, ", "))
+}
+
+func (e *PublishError) add(event *events.Event, reason string) {
+	if e.Reasons == nil {
+		e.Reasons = make(map[models.ID]string)
+	}
+	e.FailedEventIDs = append(e.FailedEventIDs, event.ID)
+	e.Reasons[event.ID] = reason
+}
+
+// Failed reports whether the event with id is among the failed events
+func (e *PublishError) Failed(id models.ID) bool {
+	_, ok := e.Reasons[id]
+	return ok
+}
+
+// SNSEventPublisher implements EventPublisher using AWS SNS. On FIFO topics
+// each event is sent in the message group of its aggregate, so subscribers
+// receive the events of an aggregate in publish order.
 type SNSEventPublisher struct {
 	client   *sns.Client
 	topicArn string
+	codec    Codec
+	fifo     bool
+
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+
+	payloadStore        PayloadStore
+	claimCheckThreshold int
+}
+
+type SNSPublisherOption func(*SNSEventPublisher)
+
+// WithPublisherCodec sets the codec used to encode message bodies
+func WithPublisherCodec(codec Codec) SNSPublisherOption {
+	return func(p *SNSEventPublisher) {
+		p.codec = codec
+	}
+}
+
+// WithFIFOTopic overrides FIFO detection, which is based on the ".fifo"
+// suffix of the topic ARN
+func WithFIFOTopic(fifo bool) SNSPublisherOption {
+	return func(p *SNSEventPublisher) {
+		p.fifo = fifo
+	}
+}
+
+// WithPublishRetries sets how many times an entry that failed with a
+// retryable error is sent, and the bounds of the jittered exponential
+// backoff between attempts
+func WithPublishRetries(maxAttempts int, baseBackoff, maxBackoff time.Duration) SNSPublisherOption {
+	return func(p *SNSEventPublisher) {
+		p.maxAttempts = maxAttempts
+		p.baseBackoff = baseBackoff
+		p.maxBackoff = maxBackoff
+	}
+}
+
+// WithClaimCheck stores message bodies larger than threshold bytes in store
+// and publishes a reference instead, for subscribers configured with the
+// same store. A threshold of 0 uses 192 KiB.
+func WithClaimCheck(store PayloadStore, threshold int) SNSPublisherOption {
+	return func(p *SNSEventPublisher) {
+		p.payloadStore = store
+		p.claimCheckThreshold = threshold
+		if threshold <= 0 {
+			p.claimCheckThreshold = defaultClaimCheckThreshold
+		}
+	}
 }
 
 // NewSNSEventPublisher creates a new SNSEventPublisher
-func NewSNSEventPublisher(client *sns.Client, topicArn string) *SNSEventPublisher {
-	return &SNSEventPublisher{
+func NewSNSEventPublisher(client *sns.Client, topicArn string, opts ...SNSPublisherOption) *SNSEventPublisher {
+	publisher := &SNSEventPublisher{
 		client:   client,
 		topicArn: topicArn,
+		codec:    NewJSONCodec(),
+		fifo:     strings.HasSuffix(topicArn, ".fifo"),
+
+		maxAttempts: 5,
+		baseBackoff: 100 * time.Millisecond,
+		maxBackoff:  5 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(publisher)
 	}
+
+	return publisher
 }
 
-// Publish publishes events to SNS
+// Publish publishes events to SNS. When some events cannot be published the
+// others still are, and a *PublishError lists the failed ones.
 func (p *SNSEventPublisher) Publish(ctx context.Context, evts ...*events.Event) error {
 	if len(evts) == 0 {
 		return nil
 	}
 
-	// Split into batches
-	batchEvents := splitToChunks(evts, maxBatchSize)
+	batches, err := p.batches(ctx, evts)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(batches))
 
-	gr, ctx := errgroup.WithContext(ctx)
+	// Batches to a FIFO topic are sent one after the other to keep the
+	// order of events within a message group
+	if p.fifo {
+		for i, batch := range batches {
+			if errs[i] = p.batchPublish(ctx, batch); errs[i] != nil {
+				notSent := &PublishError{}
+				for _, rest := range batches[i+1:] {
+					for _, event := range rest.events {
+						notSent.add(event, "not sent after an earlier batch failed")
+					}
+				}
+				if len(notSent.FailedEventIDs) > 0 {
+					errs = append(errs, notSent)
+				}
+				break
+			}
+		}
+		return mergePublishErrors(errs)
+	}
 
-	for _, eventBatch := range batchEvents {
-		eventBatch := eventBatch
-		gr.Go(func() error {
-			return p.batchPublish(ctx, eventBatch)
-		})
+	// Batches are independent, so one failing does not cancel the others
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = p.batchPublish(ctx, batch)
+		}()
 	}
+	wg.Wait()
 
-	return gr.Wait()
+	return mergePublishErrors(errs)
 }
 
-func (p *SNSEventPublisher) batchPublish(ctx context.Context, events []*events.Event) error {
-	requests := make([]types.PublishBatchRequestEntry, len(events))
+// publishBatch is a PublishBatch request within the SNS limits of entries
+// and total size
+type publishBatch struct {
+	events  []*events.Event
+	entries []types.PublishBatchRequestEntry
+	size    int
+}
 
-	for i, event := range events {
-		payload, err := event.MarshalPayload()
+// batches builds the entries of evts and splits them into batches, keeping
+// their order
+func (p *SNSEventPublisher) batches(ctx context.Context, evts []*events.Event) ([]*publishBatch, error) {
+	var batches []*publishBatch
+	var batch *publishBatch
+
+	for _, event := range evts {
+		entry, err := p.entry(ctx, event)
 		if err != nil {
-			return errors.Wrap(err, "failed to marshal payload")
+			return nil, err
 		}
 
-		message := &snsMessage{
-			ID:        event.ID.String(),
-			Metadata:  event.Metadata,
-			Topic:     string(event.Topic),
-			Payload:   payload,
-			Timestamp: event.Timestamp,
+		size := entrySize(entry)
+		if batch == nil || len(batch.entries) == maxBatchSize || batch.size+size > snsMaxPayloadSize {
+			batch = &publishBatch{}
+			batches = append(batches, batch)
 		}
 
-		msgJson, err := json.Marshal(message)
+		batch.events = append(batch.events, event)
+		batch.entries = append(batch.entries, entry)
+		batch.size += size
+	}
+
+	return batches, nil
+}
+
+// batchPublish sends a batch, retrying entries that failed with a retryable
+// error, and returns a *PublishError for the entries that never succeeded
+func (p *SNSEventPublisher) batchPublish(ctx context.Context, batch *publishBatch) error {
+	evts := batch.events
+	pending := batch.entries
+	byID := make(map[string]*events.Event, len(evts))
+	for i, event := range evts {
+		byID[aws.ToString(pending[i].Id)] = event
+	}
+
+	reasons := make(map[string]string)
+	for attempt := 1; len(pending) > 0; attempt++ {
+		res, err := p.client.PublishBatch(
+			ctx,
+			&sns.PublishBatchInput{
+				TopicArn:                   &p.topicArn,
+				PublishBatchRequestEntries: pending,
+			},
+		)
 		if err != nil {
-			return errors.Wrap(err, "failed to marshal message")
+			// The SDK retryer has already retried the request itself
+			for _, entry := range pending {
+				reasons[aws.ToString(entry.Id)] = errors.Wrap(err, "failed to publish batch to SNS").Error()
+			}
+			break
 		}
 
-		attrs := map[string]types.MessageAttributeValue{
-			"topic": {
-				DataType:    aws.String("String"),
-				StringValue: aws.String(string(event.Topic)),
-			},
+		for _, entry := range res.Successful {
+			p.recordEvent(ctx, "sns_events_published_total", "Total events published to SNS", byID[aws.ToString(entry.Id)])
 		}
 
-		for k, v := range event.Metadata {
-			if k == "sqs_message_id" || k == "sqs_receipt_handle" {
+		var retry []types.PublishBatchRequestEntry
+		for _, failure := range res.Failed {
+			id := aws.ToString(failure.Id)
+			reason := fmt.Sprintf("%s: %s", aws.ToString(failure.Code), aws.ToString(failure.Message))
+
+			if isRetryableSNSFailure(failure) && attempt < p.maxAttempts {
+				for _, entry := range pending {
+					if aws.ToString(entry.Id) == id {
+						retry = append(retry, entry)
+					}
+				}
+				p.recordEvent(ctx, "sns_events_retried_total", "Total event publications to SNS retried", byID[id])
 				continue
 			}
 
-			attrs[k] = types.MessageAttributeValue{
-				DataType:    aws.String("String"),
-				StringValue: aws.String(v),
+			reasons[id] = reason
+		}
+		if p.fifo && len(retry) > 0 {
+			retry = p.groupRetry(pending, retry, reasons, attempt)
+		}
+
+		pending = retry
+		if len(pending) > 0 {
+			sleepContext(ctx, jitteredBackoff(p.baseBackoff, p.maxBackoff, attempt))
+			if ctx.Err() != nil {
+				for _, entry := range pending {
+					reasons[aws.ToString(entry.Id)] = ctx.Err().Error()
+				}
+				break
 			}
 		}
+	}
 
-		requests[i] = types.PublishBatchRequestEntry{
-			Id:                aws.String(event.ID.String()),
-			Message:           aws.String(string(msgJson)),
-			MessageAttributes: attrs,
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	publishErr := &PublishError{}
+	for _, event := range evts {
+		if reason, ok := reasons[event.ID.String()]; ok {
+			publishErr.add(event, reason)
+			p.recordEvent(ctx, "sns_events_failed_total", "Total events that could not be published to SNS", event)
 		}
 	}
 
-	res, err := p.client.PublishBatch(
-		ctx,
-		&sns.PublishBatchInput{
-			TopicArn:                   &p.topicArn,
-			PublishBatchRequestEntries: requests,
-		},
+	return publishErr
+}
+
+// groupRetry returns the entries to resend to a FIFO topic after the failed
+// entries of retry: each failed entry and every later entry of its message
+// group, in order, so the group is not delivered out of order. Later entries
+// that were already published get a new deduplication ID, otherwise SNS would
+// drop them as duplicates instead of delivering them after the retried one.
+func (p *SNSEventPublisher) groupRetry(
+	pending, retry []types.PublishBatchRequestEntry,
+	reasons map[string]string,
+	attempt int,
+) []types.PublishBatchRequestEntry {
+	failed := make(map[string]bool, len(retry))
+	for _, entry := range retry {
+		failed[aws.ToString(entry.Id)] = true
+	}
+
+	var resend []types.PublishBatchRequestEntry
+	retrying := make(map[string]bool)
+	for _, entry := range pending {
+		id := aws.ToString(entry.Id)
+		group := aws.ToString(entry.MessageGroupId)
+		if failed[id] {
+			retrying[group] = true
+			resend = append(resend, entry)
+			continue
+		}
+		if !retrying[group] {
+			continue
+		}
+		if _, ok := reasons[id]; ok {
+			continue
+		}
+
+		entry.MessageDeduplicationId = aws.String(fmt.Sprintf("%s-%d", contentDeduplicationID([]byte(aws.ToString(entry.Message))), attempt))
+		resend = append(resend, entry)
+	}
+
+	return resend
+}
+
+// jitteredBackoff returns a random delay up to base·2^(attempt-1), capped at
+// max, before the attempt after the given one
+func jitteredBackoff(base, max time.Duration, attempt int) time.Duration {
+	delay := base << (attempt - 1)
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay))) + 1
+}
+
+func (p *SNSEventPublisher) recordEvent(ctx context.Context, name, description string, event *events.Event) {
+	if event == nil {
+		return
+	}
+
+	telemetry.RecordCounter(ctx, name, description, 1,
+		attribute.String("topic", event.Topic.String()),
 	)
+}
+
+// isRetryableSNSFailure reports whether a failed entry may succeed if sent
+// again: server-side faults and throttling
+func isRetryableSNSFailure(failure types.BatchResultErrorEntry) bool {
+	if !failure.SenderFault {
+		return true
+	}
+
+	switch aws.ToString(failure.Code) {
+	case "Throttled", "Throttling", "ThrottlingException", "ThrottledException", "KMSThrottling":
+		return true
+	}
+
+	return false
+}
+
+// mergePublishErrors combines the errors of several batches. Errors other
+// than *PublishError are returned as is.
+func mergePublishErrors(errs []error) error {
+	var merged *PublishError
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		var publishErr *PublishError
+		if !errors.As(err, &publishErr) {
+			return err
+		}
+
+		if merged == nil {
+			merged = &PublishError{Reasons: make(map[models.ID]string)}
+		}
+		for _, id := range publishErr.FailedEventIDs {
+			merged.FailedEventIDs = append(merged.FailedEventIDs, id)
+			merged.Reasons[id] = publishErr.Reasons[id]
+		}
+	}
+
+	if merged == nil {
+		return nil
+	}
+	return merged
+}
+
+// entry builds the batch entry of an event
+func (p *SNSEventPublisher) entry(ctx context.Context, event *events.Event) (types.PublishBatchRequestEntry, error) {
+	msgJson, err := p.codec.Encode(event)
 	if err != nil {
-		return errors.Wrap(err, "failed to publish batch to SNS")
+		return types.PublishBatchRequestEntry{}, errors.Wrap(err, "failed to encode message")
 	}
 
-	// Log metrics (simplified version without DataDog)
-	for _, event := range events {
-		success := true
-		for _, entry := range res.Failed {
-			if event.ID.String() == *entry.Id {
-				success = false
-				break
+	attrs := map[string]types.MessageAttributeValue{
+		"topic": {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(string(event.Topic)),
+		},
+	}
+
+	// Events that did not go through a TracingPublisher continue the
+	// trace of the publishing context
+	metadata := map[string]string(event.Metadata)
+	if metadata[telemetry.TraceParentKey] == "" {
+		metadata = make(map[string]string)
+		telemetry.InjectTraceContext(ctx, metadata)
+	}
+
+	for _, k := range messageAttributeKeys {
+		if v := metadata[k]; v != "" {
+			attrs[k] = types.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(v),
 			}
 		}
+	}
+
+	entry := types.PublishBatchRequestEntry{
+		Id:                aws.String(event.ID.String()),
+		Message:           aws.String(string(msgJson)),
+		MessageAttributes: attrs,
+	}
+
+	if p.fifo {
+		entry.MessageGroupId = aws.String(messageGroupID(event))
+		entry.MessageDeduplicationId = aws.String(contentDeduplicationID(msgJson))
+	}
+
+	if p.payloadStore != nil && entrySize(entry) > p.claimCheckThreshold {
+		if err := p.claimCheck(ctx, event, &entry); err != nil {
+			return types.PublishBatchRequestEntry{}, err
+		}
+	}
 
-		// In a production system, you would use proper metrics here
-		_ = success
+	return entry, nil
+}
+
+// claimCheck moves the body of entry to the payload store and replaces it
+// with a stub holding the reference. The key ends with a hash of the body, so
+// the copies of an event published in several schema versions, which share
+// its topic and ID, do not overwrite each other.
+func (p *SNSEventPublisher) claimCheck(ctx context.Context, event *events.Event, entry *types.PublishBatchRequestEntry) error {
+	body := []byte(aws.ToString(entry.Message))
+	key := event.Topic.String() + "/" + event.ID.String() + "/" + contentDeduplicationID(body)
+	ref, err := p.payloadStore.Put(ctx, key, body)
+	if err != nil {
+		return errors.Wrap(err, "failed to offload message body")
 	}
 
+	stub, err := json.Marshal(&claimCheckStub{ClaimCheck: ref})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode claim check")
+	}
+
+	entry.Message = aws.String(string(stub))
+	entry.MessageAttributes[ClaimCheckKey] = types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(ref),
+	}
+
+	telemetry.RecordCounter(ctx, "sns_payloads_offloaded_total", "Total message bodies offloaded to the payload store", 1,
+		attribute.String("topic", event.Topic.String()),
+	)
+
 	return nil
 }
 
-// splitToChunks splits slice into chunks of specified size
-func splitToChunks[T any](slice []T, chunkSize int) [][]T {
-	var chunks [][]T
-	for i := 0; i < len(slice); i += chunkSize {
-		end := i + chunkSize
-		if end > len(slice) {
-			end = len(slice)
-		}
-		chunks = append(chunks, slice[i:end])
+// entrySize is the size SNS counts against its message limit: the body and
+// the name, type and value of each attribute
+func entrySize(entry types.PublishBatchRequestEntry) int {
+	size := len(aws.ToString(entry.Message))
+	for name, attr := range entry.MessageAttributes {
+		size += len(name) + len(aws.ToString(attr.DataType)) + len(aws.ToString(attr.StringValue)) + len(attr.BinaryValue)
+	}
+	return size
+}
+
+// messageGroupID orders events per aggregate; events without one are ordered
+// per topic
+func messageGroupID(event *events.Event) string {
+	if event.AggregateID != "" {
+		return event.AggregateID.String()
 	}
-	return chunks
-}
\ No newline at end of file
+	return event.Topic.String()
+}
+
+// contentDeduplicationID is the SHA-256 of the message body, so an event
+// published twice within the deduplication interval is delivered once
+func contentDeduplicationID(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
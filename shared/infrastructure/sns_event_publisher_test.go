@@ -0,0 +1,287 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNSEventPublisher_Entry(t *testing.T) {
+	tests := []struct {
+		name            string
+		topicArn        string
+		opts            []SNSPublisherOption
+		event           *events.Event
+		expectedGroupID string
+	}{
+		{
+			name:     "standard topic",
+			topicArn: "arn:aws:sns:us-east-1:000000000000:payment-events",
+			event:    newCodecTestEvent(),
+		},
+		{
+			name:            "fifo topic groups by aggregate",
+			topicArn:        "arn:aws:sns:us-east-1:000000000000:payment-events.fifo",
+			event:           newCodecTestEvent(),
+			expectedGroupID: "550e8400-e29b-41d4-a716-446655440001",
+		},
+		{
+			name:            "fifo topic groups events without aggregate by topic",
+			topicArn:        "arn:aws:sns:us-east-1:000000000000:payment-events",
+			opts:            []SNSPublisherOption{WithFIFOTopic(true)},
+			event:           events.NewEvent("", events.PaymentCreatedEvent, nil),
+			expectedGroupID: events.PaymentCreatedEvent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := NewSNSEventPublisher(nil, tt.topicArn, tt.opts...)
+
+			entry, err := publisher.entry(context.Background(), tt.event)
+			require.NoError(t, err)
+
+			if tt.expectedGroupID == "" {
+				assert.Nil(t, entry.MessageGroupId)
+				assert.Nil(t, entry.MessageDeduplicationId)
+				return
+			}
+
+			assert.Equal(t, tt.expectedGroupID, aws.ToString(entry.MessageGroupId))
+			assert.Equal(t, contentDeduplicationID([]byte(aws.ToString(entry.Message))), aws.ToString(entry.MessageDeduplicationId))
+
+			again, err := publisher.entry(context.Background(), tt.event)
+			require.NoError(t, err)
+			assert.Equal(t, entry.MessageDeduplicationId, again.MessageDeduplicationId, "the same content deduplicates")
+		})
+	}
+}
+
+type fakeSNSFailure struct {
+	Code        string
+	SenderFault bool
+}
+
+// fakeSNS serves PublishBatch over the SNS query protocol. Entries fail with
+// the queued failures of their ID, one per attempt, then succeed.
+type fakeSNS struct {
+	mux       sync.Mutex
+	failures  map[string][]fakeSNSFailure
+	attempts  map[string]int
+	published []string
+}
+
+func newFakeSNS(t *testing.T, failures map[string][]fakeSNSFailure) (*fakeSNS, *sns.Client) {
+	fake := &fakeSNS{
+		failures: failures,
+		attempts: make(map[string]int),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := sns.New(sns.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	return fake, client
+}
+
+func (f *fakeSNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "PublishBatch" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	type entry struct {
+		Id          string
+		Code        string `xml:",omitempty"`
+		Message     string `xml:",omitempty"`
+		SenderFault bool   `xml:",omitempty"`
+	}
+	var result struct {
+		XMLName    xml.Name `xml:"PublishBatchResponse"`
+		Successful []entry  `xml:"PublishBatchResult>Successful>member"`
+		Failed     []entry  `xml:"PublishBatchResult>Failed>member"`
+	}
+
+	for i := 1; r.Form.Has("PublishBatchRequestEntries.member." + strconv.Itoa(i) + ".Id"); i++ {
+		id := r.Form.Get("PublishBatchRequestEntries.member." + strconv.Itoa(i) + ".Id")
+		attempt := f.attempts[id]
+		f.attempts[id]++
+
+		if attempt < len(f.failures[id]) {
+			failure := f.failures[id][attempt]
+			result.Failed = append(result.Failed, entry{Id: id, Code: failure.Code, Message: "failed", SenderFault: failure.SenderFault})
+			continue
+		}
+
+		f.published = append(f.published, id)
+		result.Successful = append(result.Successful, entry{Id: id})
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func TestSNSEventPublisher_Retries(t *testing.T) {
+	tests := []struct {
+		name              string
+		failures          []fakeSNSFailure
+		expectedAttempts  int
+		expectedPublished bool
+		expectedReason    string
+	}{
+		{
+			name:              "published at first attempt",
+			expectedAttempts:  1,
+			expectedPublished: true,
+		},
+		{
+			name: "throttled entry is retried",
+			failures: []fakeSNSFailure{
+				{Code: "Throttled", SenderFault: true},
+				{Code: "InternalError"},
+			},
+			expectedAttempts:  3,
+			expectedPublished: true,
+		},
+		{
+			name: "sender fault is not retried",
+			failures: []fakeSNSFailure{
+				{Code: "InvalidParameter", SenderFault: true},
+			},
+			expectedAttempts: 1,
+			expectedReason:   "InvalidParameter: failed",
+		},
+		{
+			name: "gives up after max attempts",
+			failures: []fakeSNSFailure{
+				{Code: "InternalError"},
+				{Code: "InternalError"},
+				{Code: "InternalError"},
+				{Code: "InternalError"},
+			},
+			expectedAttempts: 3,
+			expectedReason:   "InternalError: failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+			healthy := events.NewEvent("payment-2", events.PaymentCreatedEvent, nil)
+
+			fake, client := newFakeSNS(t, map[string][]fakeSNSFailure{
+				failing.ID.String(): tt.failures,
+			})
+			publisher := NewSNSEventPublisher(client, "arn:aws:sns:us-east-1:000000000000:payment-events",
+				WithPublishRetries(3, time.Millisecond, 5*time.Millisecond),
+			)
+
+			err := publisher.Publish(context.Background(), failing, healthy)
+
+			assert.Equal(t, tt.expectedAttempts, fake.attempts[failing.ID.String()])
+			assert.Equal(t, 1, fake.attempts[healthy.ID.String()])
+			assert.Contains(t, fake.published, healthy.ID.String())
+
+			if tt.expectedPublished {
+				require.NoError(t, err)
+				assert.Contains(t, fake.published, failing.ID.String())
+				return
+			}
+
+			var publishErr *PublishError
+			require.ErrorAs(t, err, &publishErr)
+			assert.Equal(t, []models.ID{failing.ID}, publishErr.FailedEventIDs)
+			assert.Equal(t, tt.expectedReason, publishErr.Reasons[failing.ID])
+			assert.True(t, publishErr.Failed(failing.ID))
+			assert.False(t, publishErr.Failed(healthy.ID))
+		})
+	}
+}
+
+func TestSNSEventPublisher_FIFOStopsAfterFailedBatch(t *testing.T) {
+	evts := make([]*events.Event, maxBatchSize+2)
+	for i := range evts {
+		evts[i] = events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+	}
+
+	fake, client := newFakeSNS(t, map[string][]fakeSNSFailure{
+		evts[0].ID.String(): {{Code: "InvalidParameter", SenderFault: true}},
+	})
+	publisher := NewSNSEventPublisher(client, "arn:aws:sns:us-east-1:000000000000:payment-events.fifo")
+
+	err := publisher.Publish(context.Background(), evts...)
+
+	var publishErr *PublishError
+	require.ErrorAs(t, err, &publishErr)
+	assert.Len(t, publishErr.FailedEventIDs, 3)
+	assert.Len(t, fake.published, maxBatchSize-1)
+	assert.Equal(t, "not sent after an earlier batch failed", publishErr.Reasons[evts[maxBatchSize].ID])
+}
+
+func TestSNSEventPublisher_EntryAttributeLimit(t *testing.T) {
+	publisher := NewSNSEventPublisher(nil, "arn:aws:sns:us-east-1:000000000000:payment-events")
+
+	event := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil).
+		WithMetadata(telemetry.TraceParentKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01").
+		WithMetadata(telemetry.TraceStateKey, "vendor=value")
+	for i := 0; i < 2*snsMaxMessageAttributes; i++ {
+		event.WithMetadata("key_"+strconv.Itoa(i), "value")
+	}
+
+	entry, err := publisher.entry(context.Background(), event)
+	require.NoError(t, err)
+
+	assert.LessOrEqual(t, len(entry.MessageAttributes), snsMaxMessageAttributes)
+	assert.Equal(t, events.PaymentCreatedEvent, aws.ToString(entry.MessageAttributes["topic"].StringValue))
+	assert.Contains(t, entry.MessageAttributes, telemetry.TraceParentKey)
+	assert.Contains(t, entry.MessageAttributes, telemetry.TraceStateKey)
+
+	// The rest of the metadata travels in the body
+	decoded, err := NewJSONCodec().Decode([]byte(aws.ToString(entry.Message)))
+	require.NoError(t, err)
+	assert.Equal(t, "value", decoded.Metadata["key_15"])
+}
+
+func TestSNSEventPublisher_FIFORetryResendsRestOfGroup(t *testing.T) {
+	first := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+	second := events.NewEvent("payment-1", events.PaymentCompletedEvent, nil)
+	third := events.NewEvent("payment-1", events.PaymentRefundedEvent, nil)
+	other := events.NewEvent("payment-2", events.PaymentCreatedEvent, nil)
+
+	fake, client := newFakeSNS(t, map[string][]fakeSNSFailure{
+		second.ID.String(): {{Code: "Throttled", SenderFault: true}},
+	})
+	publisher := NewSNSEventPublisher(client, "arn:aws:sns:us-east-1:000000000000:payment-events.fifo",
+		WithPublishRetries(3, time.Millisecond, 5*time.Millisecond),
+	)
+
+	err := publisher.Publish(context.Background(), first, second, third, other)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		first.ID.String(), third.ID.String(), other.ID.String(),
+		second.ID.String(), third.ID.String(),
+	}, fake.published, "the group is published again in order from its failed entry")
+	assert.Equal(t, 1, fake.attempts[first.ID.String()])
+	assert.Equal(t, 1, fake.attempts[other.ID.String()])
+}
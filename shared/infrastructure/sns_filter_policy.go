@@ -0,0 +1,156 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/pkg/errors"
+)
+
+// ErrSubscriptionNotFound is returned when the topic has no subscription for
+// the endpoint
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// TopicFilterPolicy returns an SNS filter policy on the "topic" message
+// attribute that accepts the given topics. Exact topics are matched as
+// values; wildcard patterns are matched by the prefix before their first
+// wildcard, which may let through a few topics the router then ignores. An
+// empty policy, meaning no filter, is returned when a pattern matches every
+// topic or no topics are given.
+func TopicFilterPolicy(topics []events.Topic) (string, error) {
+	exact := make(map[string]bool)
+	prefixes := make(map[string]bool)
+
+	for _, topic := range topics {
+		if !topic.IsPattern() {
+			exact[topic.String()] = true
+			continue
+		}
+
+		words := strings.Split(topic.String(), ".")
+		literal := 0
+		for literal < len(words) && words[literal] != "*" && words[literal] != "#" {
+			literal++
+		}
+		if literal == 0 {
+			return "", nil
+		}
+		prefix := strings.Join(words[:literal], ".")
+		prefixes[prefix+"."] = true
+
+		// "#" matches zero words, so "payment.#" also matches "payment"
+		if onlyHashes(words[literal:]) {
+			exact[prefix] = true
+		}
+	}
+
+	var values []string
+	for value := range exact {
+		if !hasAnyPrefix(value, prefixes) {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+
+	var sortedPrefixes []string
+	for prefix := range prefixes {
+		sortedPrefixes = append(sortedPrefixes, prefix)
+	}
+	sort.Strings(sortedPrefixes)
+
+	conditions := make([]interface{}, 0, len(values)+len(sortedPrefixes))
+	for _, value := range values {
+		conditions = append(conditions, value)
+	}
+	for _, prefix := range sortedPrefixes {
+		conditions = append(conditions, map[string]string{"prefix": prefix})
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	policy, err := json.Marshal(map[string][]interface{}{"topic": conditions})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal filter policy")
+	}
+
+	return string(policy), nil
+}
+
+func onlyHashes(words []string) bool {
+	for _, word := range words {
+		if word != "#" {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyPrefix(value string, prefixes map[string]bool) bool {
+	for prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyFilterPolicy sets the filter policy of the subscription of endpoint
+// (e.g. an SQS queue ARN) to topicArn. An empty policy removes the filter so
+// the endpoint receives every message.
+func ApplyFilterPolicy(ctx context.Context, client *sns.Client, topicArn, endpoint, policy string) error {
+	subscriptionArn, err := findSubscription(ctx, client, topicArn, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if policy != "" {
+		_, err = client.SetSubscriptionAttributes(ctx, &sns.SetSubscriptionAttributesInput{
+			SubscriptionArn: aws.String(subscriptionArn),
+			AttributeName:   aws.String("FilterPolicyScope"),
+			AttributeValue:  aws.String("MessageAttributes"),
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to set filter policy scope")
+		}
+	}
+
+	_, err = client.SetSubscriptionAttributes(ctx, &sns.SetSubscriptionAttributesInput{
+		SubscriptionArn: aws.String(subscriptionArn),
+		AttributeName:   aws.String("FilterPolicy"),
+		AttributeValue:  aws.String(policy),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to set filter policy")
+	}
+
+	return nil
+}
+
+// findSubscription returns the ARN of the subscription of endpoint to topicArn
+func findSubscription(ctx context.Context, client *sns.Client, topicArn, endpoint string) (string, error) {
+	paginator := sns.NewListSubscriptionsByTopicPaginator(client, &sns.ListSubscriptionsByTopicInput{
+		TopicArn: aws.String(topicArn),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to list subscriptions")
+		}
+
+		for _, subscription := range page.Subscriptions {
+			if aws.ToString(subscription.Endpoint) == endpoint {
+				return aws.ToString(subscription.SubscriptionArn), nil
+			}
+		}
+	}
+
+	return "", errors.Wrapf(ErrSubscriptionNotFound, "%s on %s", endpoint, topicArn)
+}
@@ -0,0 +1,48 @@
+package infrastructure
+
+import (
+	"testing"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicFilterPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		topics         []events.Topic
+		expectedPolicy string
+	}{
+		{
+			name:           "exact topics",
+			topics:         []events.Topic{events.WalletDebitedEvent, events.PaymentCreatedEvent, events.WalletDebitedEvent},
+			expectedPolicy: `{"topic":["payment.created","wallet.debited"]}`,
+		},
+		{
+			name:           "trailing hash matches the prefix and the literal",
+			topics:         []events.Topic{"payment.#", "wallet.debited"},
+			expectedPolicy: `{"topic":["payment","wallet.debited",{"prefix":"payment."}]}`,
+		},
+		{
+			name:           "topics covered by a prefix are dropped",
+			topics:         []events.Topic{"payment.*.failed", events.PaymentCreatedEvent},
+			expectedPolicy: `{"topic":[{"prefix":"payment."}]}`,
+		},
+		{
+			name:   "leading wildcard matches everything",
+			topics: []events.Topic{"*.failed", events.PaymentCreatedEvent},
+		},
+		{
+			name: "no topics",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := TopicFilterPolicy(tt.topics)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPolicy, policy)
+		})
+	}
+}
@@ -14,23 +14,38 @@ type SQSSubscriberAdapter struct {
 	sqsSubscriber *SQSEventSubscriber
 	isRunning     bool
 	queueURL      string
+	options       []SQSSubscriberOption
 }
 
 // NewSQSSubscriberAdapter creates a new SQS subscriber adapter
-func NewSQSSubscriberAdapter(queueURL string) (*SQSSubscriberAdapter, error) {
+func NewSQSSubscriberAdapter(queueURL string, opts ...SQSSubscriberOption) (*SQSSubscriberAdapter, error) {
 	return &SQSSubscriberAdapter{
 		sqsSubscriber: nil, // Will be created when Subscribe is called
 		isRunning:     false,
 		queueURL:      queueURL,
+		options:       opts,
 	}, nil
 }
 
+// NewSQSClient creates an SQS client from the default AWS config
+func NewSQSClient(ctx context.Context) (*sqs.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load AWS config")
+	}
+
+	return sqs.NewFromConfig(cfg), nil
+}
+
 // eventHandlerAdapter adapts events.EventHandler to work with SQS EventHandler
 type eventHandlerAdapter struct {
 	handler events.EventHandler
 }
 
 func (a *eventHandlerAdapter) HandlerID() string {
+	if h, ok := a.handler.(EventHandler); ok {
+		return h.HandlerID()
+	}
 	// Use a default handler ID since the original interface doesn't provide one
 	return "event-handler-adapter"
 }
@@ -46,20 +61,23 @@ func (s *SQSSubscriberAdapter) Subscribe(ctx context.Context, eventType string,
 		return errors.New("subscriber is already running")
 	}
 
-	// Load AWS config
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	// Create SQS client
+	sqsClient, err := NewSQSClient(context.Background())
 	if err != nil {
-		return errors.Wrap(err, "failed to load AWS config")
+		return err
 	}
 
-	// Create SQS client
-	sqsClient := sqs.NewFromConfig(cfg)
-
-	// Create adapted handler
+	// Create adapted handler; a non-empty eventType is a topic pattern that
+	// limits which events reach the handler
 	adaptedHandler := &eventHandlerAdapter{handler: handler}
+	if eventType != "" {
+		adaptedHandler = &eventHandlerAdapter{
+			handler: events.NewRouter(adaptedHandler.HandlerID()).Register(events.Topic(eventType), handler),
+		}
+	}
 
 	// Create SQS subscriber using the configured queue URL
-	s.sqsSubscriber = NewSQSEventSubscriber(sqsClient, s.queueURL, adaptedHandler)
+	s.sqsSubscriber = NewSQSEventSubscriber(sqsClient, s.queueURL, adaptedHandler, s.options...)
 
 	// Start the subscriber
 	if err := s.sqsSubscriber.Start(ctx); err != nil {
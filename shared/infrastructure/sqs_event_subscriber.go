@@ -2,8 +2,10 @@ package infrastructure
 
 import (
 	"context"
-	"encoding/json"
+	"hash/fnv"
+	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,7 +14,11 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -24,6 +30,55 @@ type sqsMessage struct {
 	Message types.Message
 	Event   *events.Event
 	Err     error
+	// Skipped is set when an earlier message of the same group failed in the
+	// same receive, so this one is returned to the queue unhandled
+	Skipped bool
+	// hold is how long a skipped message stays hidden so that the failed
+	// message of its group is retried first
+	hold int32
+	// Interrupted is set when Stop cancelled or never started the handler;
+	// the message is returned to the queue for another instance
+	Interrupted bool
+
+	batch *receiveBatch
+}
+
+// receiveBatch tracks the message groups that failed within one receive and
+// how long their remaining messages must stay hidden
+type receiveBatch struct {
+	mux    sync.Mutex
+	failed map[string]int32
+}
+
+func (b *receiveBatch) fail(group string, hold int32) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.failed[group] = hold
+}
+
+func (b *receiveBatch) hasFailed(group string) (int32, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	hold, ok := b.failed[group]
+	return hold, ok
+}
+
+// messageGroup returns the FIFO message group of the message, or its
+// aggregate on standard queues
+func (m *sqsMessage) messageGroup() string {
+	if group := m.Message.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]; group != "" {
+		return group
+	}
+	return m.Event.AggregateID.String()
+}
+
+// receiveCount returns the ApproximateReceiveCount of the message
+func (m *sqsMessage) receiveCount() int {
+	receiveCount, err := strconv.Atoi(m.Message.Attributes["ApproximateReceiveCount"])
+	if err != nil {
+		return 1
+	}
+	return receiveCount
 }
 
 // EventHandler wraps the Event Handler interface
@@ -53,12 +108,20 @@ func (h *EventHandlerFunc) Handle(ctx context.Context, event *events.Event) erro
 	return h.fn(ctx, event)
 }
 
-// SQSEventSubscriber implements event subscription using AWS SQS
+// SQSEventSubscriber implements event subscription using AWS SQS. Readers
+// receive messages, workers handle them and cleaners ack or nack them; each
+// stage closes the channel of the next one when it exits, so Stop can drain
+// the pipeline.
 type SQSEventSubscriber struct {
 	mux              sync.RWMutex
 	inboundMessages  chan *sqsMessage
 	outboundMessages chan *sqsMessage
-	cancel           context.CancelFunc
+	groupMessages    []chan *sqsMessage
+	cancelReaders    context.CancelFunc
+	cancelHandlers   context.CancelFunc
+	draining         chan struct{}
+	readersDone      chan struct{}
+	done             chan struct{}
 	running          atomic.Bool
 	options          *sqsSubscriberOptions
 
@@ -82,6 +145,14 @@ type sqsSubscriberOptions struct {
 	receiveCountRange              int32
 	visibilityTimeoutOffset        int32
 	maxVisibilityTimeout           int32
+	codec                          Codec
+	deadLetterQueueURL             string
+	maxReceiveCount                int32
+	groupOrdering                  bool
+	heartbeatInterval              time.Duration
+	drainTimeout                   time.Duration
+	circuitOpenDelay               time.Duration
+	payloadStore                   PayloadStore
 }
 
 type SQSSubscriberOption func(*sqsSubscriberOptions)
@@ -104,6 +175,66 @@ func WithVisibilityTimeout(timeout int32) SQSSubscriberOption {
 	}
 }
 
+// WithCodec sets the codec used to decode message bodies
+func WithCodec(codec Codec) SQSSubscriberOption {
+	return func(o *sqsSubscriberOptions) {
+		o.codec = codec
+	}
+}
+
+// WithDeadLetterQueue moves messages that failed maxReceiveCount times, and
+// messages that cannot be decoded, to the queue at queueURL with the failure
+// reason attached
+func WithDeadLetterQueue(queueURL string, maxReceiveCount int32) SQSSubscriberOption {
+	return func(o *sqsSubscriberOptions) {
+		o.deadLetterQueueURL = queueURL
+		o.maxReceiveCount = maxReceiveCount
+	}
+}
+
+// WithGroupOrdering handles the messages of a group one at a time, in the
+// order they were received, while different groups are handled in parallel.
+// Groups are FIFO message groups, or aggregates on standard queues. It is
+// enabled by default for queues whose URL ends in ".fifo".
+func WithGroupOrdering(enabled bool) SQSSubscriberOption {
+	return func(o *sqsSubscriberOptions) {
+		o.groupOrdering = enabled
+	}
+}
+
+// WithVisibilityHeartbeat sets how often the visibility of a message is
+// extended while its handler runs; by default every half visibility timeout.
+// A negative interval disables the heartbeat.
+func WithVisibilityHeartbeat(interval time.Duration) SQSSubscriberOption {
+	return func(o *sqsSubscriberOptions) {
+		o.heartbeatInterval = interval
+	}
+}
+
+// WithCircuitOpenDelay sets how long a message rejected by an open circuit
+// breaker stays hidden before it is received again; by default one
+// visibility timeout
+func WithCircuitOpenDelay(delay time.Duration) SQSSubscriberOption {
+	return func(o *sqsSubscriberOptions) {
+		o.circuitOpenDelay = delay
+	}
+}
+
+// WithPayloadStore fetches the bodies that publishers offloaded to store
+// and hands the full event to the handler
+func WithPayloadStore(store PayloadStore) SQSSubscriberOption {
+	return func(o *sqsSubscriberOptions) {
+		o.payloadStore = store
+	}
+}
+
+// WithDrainTimeout bounds how long Stop waits for in-flight handlers
+func WithDrainTimeout(timeout time.Duration) SQSSubscriberOption {
+	return func(o *sqsSubscriberOptions) {
+		o.drainTimeout = timeout
+	}
+}
+
 // NewSQSEventSubscriber creates a new SQS event subscriber
 func NewSQSEventSubscriber(
 	client *sqs.Client,
@@ -126,12 +257,19 @@ func NewSQSEventSubscriber(
 		receiveCountRange:              3,
 		visibilityTimeoutOffset:        30,
 		maxVisibilityTimeout:           900, // 15 minutes
+		codec:                          NewJSONCodec(),
+		groupOrdering:                  strings.HasSuffix(queueURL, ".fifo"),
+		drainTimeout:                   20 * time.Second,
 	}
 
 	for _, opt := range opts {
 		opt(options)
 	}
 
+	if options.heartbeatInterval == 0 {
+		options.heartbeatInterval = time.Duration(options.visibilityTimeout) * time.Second / 2
+	}
+
 	return &SQSEventSubscriber{
 		client:           client,
 		queueURL:         queueURL,
@@ -151,41 +289,88 @@ func (s *SQSEventSubscriber) Start(ctx context.Context) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	if s.cancel != nil {
-		s.cancel()
-	}
-
-	if s.inboundMessages != nil {
-		close(s.inboundMessages)
-	}
+	// Acks and nacks outlive ctx so messages are settled while stopping
+	cleanCtx := context.WithoutCancel(ctx)
+	handlerCtx, cancelHandlers := context.WithCancel(ctx)
+	readerCtx, cancelReaders := context.WithCancel(handlerCtx)
 
-	if s.outboundMessages != nil {
-		close(s.outboundMessages)
-	}
+	outbound := make(chan *sqsMessage, 10)
+	draining := make(chan struct{})
+	readersDone := make(chan struct{})
+	done := make(chan struct{})
 
-	ctx, cancel := context.WithCancel(ctx)
 	s.inboundMessages = make(chan *sqsMessage, 10)
-	s.outboundMessages = make(chan *sqsMessage, 10)
-	s.cancel = cancel
+	s.outboundMessages = outbound
+	s.groupMessages = nil
+	s.cancelReaders = cancelReaders
+	s.cancelHandlers = cancelHandlers
+	s.draining = draining
+	s.readersDone = readersDone
+	s.done = done
+
+	// In group ordering mode every group is always routed to the same
+	// worker, which handles its messages one at a time
+	inputs := []chan *sqsMessage{s.inboundMessages}
+	if s.options.groupOrdering {
+		inputs = nil
+	}
 
+	var readers, workers, cleaners sync.WaitGroup
 	for i := 0; i < int(s.options.workers); i++ {
-		go s.startWorker(ctx)
+		messages := s.inboundMessages
+		if s.options.groupOrdering {
+			messages = make(chan *sqsMessage, 10)
+			s.groupMessages = append(s.groupMessages, messages)
+			inputs = append(inputs, messages)
+		}
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.startWorker(handlerCtx, messages, outbound, draining)
+		}()
 	}
 
 	for i := 0; i < int(s.options.readers); i++ {
-		go s.startReader(ctx)
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			s.startReader(readerCtx)
+		}()
 	}
 
 	for i := 0; i < int(s.options.cleaners); i++ {
-		go s.startCleaner(ctx)
+		cleaners.Add(1)
+		go func() {
+			defer cleaners.Done()
+			s.startCleaner(cleanCtx, outbound)
+		}()
 	}
 
+	go func() {
+		readers.Wait()
+		for _, messages := range inputs {
+			close(messages)
+		}
+		close(readersDone)
+
+		workers.Wait()
+		close(outbound)
+
+		cleaners.Wait()
+		cancelHandlers()
+		close(done)
+	}()
+
 	s.running.Store(true)
 
 	return nil
 }
 
-// Stop stops the SQS subscriber
+// Stop stops reading and waits for in-flight handlers, up to the drain
+// timeout or the ctx deadline, before their messages are acked or nacked.
+// Received messages whose handler has not started are returned to the queue.
+// Handlers still running at the deadline are cancelled.
 func (s *SQSEventSubscriber) Stop(ctx context.Context) error {
 	if !s.running.Load() {
 		return nil
@@ -194,71 +379,96 @@ func (s *SQSEventSubscriber) Stop(ctx context.Context) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	if s.cancel != nil {
-		s.cancel()
-	}
+	ctx, cancel := context.WithTimeout(ctx, s.options.drainTimeout)
+	defer cancel()
 
-	if s.inboundMessages != nil {
-		close(s.inboundMessages)
-	}
+	// Readers return promptly once cancelled; messages they received but
+	// did not hand to a worker are released
+	s.cancelReaders()
+	close(s.draining)
+	<-s.readersDone
+
+	var err error
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		// Cancelled handlers fail fast and their messages are released
+		s.cancelHandlers()
+		err = errors.Wrap(ctx.Err(), "in-flight handlers did not finish")
 
-	if s.outboundMessages != nil {
-		close(s.outboundMessages)
+		select {
+		case <-s.done:
+		case <-time.After(stopGracePeriod):
+		}
 	}
 
-	s.cancel = nil
+	s.cancelReaders = nil
+	s.cancelHandlers = nil
+	s.draining = nil
+	s.readersDone = nil
+	s.done = nil
 	s.inboundMessages = nil
 	s.outboundMessages = nil
+	s.groupMessages = nil
 
 	s.running.Store(false)
 
-	return nil
+	return err
 }
 
-func (s *SQSEventSubscriber) startWorker(ctx context.Context) {
-	for {
+// stopGracePeriod is how long Stop waits for cancelled handlers to return so
+// their messages are released
+const stopGracePeriod = 5 * time.Second
+
+func (s *SQSEventSubscriber) startWorker(
+	ctx context.Context,
+	messages <-chan *sqsMessage,
+	outbound chan<- *sqsMessage,
+	draining <-chan struct{},
+) {
+	for message := range messages {
 		select {
-		case <-ctx.Done():
-			return
-		case message := <-s.inboundMessages:
-			if message == nil {
-				continue
-			}
+		case <-draining:
+			message.Interrupted = true
+		default:
 			s.handle(ctx, message)
+			if message.Err != nil && ctx.Err() != nil {
+				message.Interrupted = true
+			}
 		}
+
+		outbound <- message
 	}
 }
 
 func (s *SQSEventSubscriber) startReader(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if err := s.read(ctx); err != nil {
-				time.Sleep(s.options.sleepTimeAfterError)
-			}
+	for ctx.Err() == nil {
+		if err := s.read(ctx); err != nil {
+			sleepContext(ctx, s.options.sleepTimeAfterError)
 		}
 	}
 }
 
-func (s *SQSEventSubscriber) startCleaner(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case message := <-s.outboundMessages:
-			if message == nil {
-				continue
-			}
-			if err := s.clean(ctx, message); err != nil {
-				// Log error in production
-				continue
-			}
+func (s *SQSEventSubscriber) startCleaner(ctx context.Context, outbound <-chan *sqsMessage) {
+	for message := range outbound {
+		if err := s.clean(ctx, message); err != nil {
+			// Log error in production
+			continue
 		}
 	}
 }
 
+// sleepContext sleeps for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
 func (s *SQSEventSubscriber) read(ctx context.Context) error {
 	output, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
 		QueueUrl:            aws.String(s.queueURL),
@@ -268,6 +478,7 @@ func (s *SQSEventSubscriber) read(ctx context.Context) error {
 		AttributeNames: []types.QueueAttributeName{
 			"ApproximateReceiveCount",
 			"ApproximateFirstReceiveTimestamp",
+			"MessageGroupId",
 		},
 		MessageAttributeNames: []string{"All"},
 	})
@@ -276,14 +487,41 @@ func (s *SQSEventSubscriber) read(ctx context.Context) error {
 	}
 
 	if len(output.Messages) == 0 {
-		time.Sleep(s.options.sleepTimeAfterEmptyReceive)
+		sleepContext(ctx, s.options.sleepTimeAfterEmptyReceive)
 		return nil
 	}
 
-	for _, message := range output.Messages {
+	batch := &receiveBatch{failed: make(map[string]int32)}
+	for i, message := range output.Messages {
+		body, err := s.inlinePayload(ctx, []byte(aws.ToString(message.Body)))
+		if err != nil && !errors.Is(err, ErrPayloadNotFound) && !errors.Is(err, ErrMalformedMessage) {
+			// The store may be back by the next receive; the message is
+			// received again once its visibility timeout expires
+			log.Printf("%s: failed to fetch payload of message %s: %v", s.options.name, aws.ToString(message.MessageId), err)
+			telemetry.RecordCounter(ctx, "sqs_payload_fetch_failures_total", "Total claim-checked payloads that could not be fetched", 1,
+				attribute.String("subscriber", s.options.name),
+			)
+			continue
+		}
+
 		var event *events.Event
-		if err := json.Unmarshal([]byte(*message.Body), &event); err != nil {
-			continue // Skip malformed messages
+		if err == nil {
+			event, err = s.options.codec.Decode(body)
+		}
+		if err != nil {
+			telemetry.RecordCounter(ctx, "sqs_messages_malformed_total", "Total SQS messages that could not be decoded", 1,
+				attribute.String("subscriber", s.options.name),
+			)
+
+			// Malformed messages never succeed, so they go straight to the
+			// DLQ, or are deleted when there is none. If the move fails they
+			// are left for the queue's redrive policy.
+			if s.options.deadLetterQueueURL != "" {
+				_ = s.deadLetter(ctx, message, err, "malformed")
+			} else {
+				s.discard(ctx, message, err)
+			}
+			continue
 		}
 
 		if event.Metadata == nil {
@@ -296,17 +534,22 @@ func (s *SQSEventSubscriber) read(ctx context.Context) error {
 		}
 
 		for k, v := range message.MessageAttributes {
-			if v.StringValue != nil {
+			if v.StringValue != nil && k != ClaimCheckKey {
 				event.Metadata.Set(k, *v.StringValue)
 			}
 		}
 
-		select {
-		case s.inboundMessages <- &sqsMessage{
+		msg := &sqsMessage{
 			Message: message,
 			Event:   event,
-		}:
+			batch:   batch,
+		}
+
+		select {
+		case s.workerMessages(msg) <- msg:
 		case <-ctx.Done():
+			// Stopping: return what was not handed to a worker
+			s.release(context.WithoutCancel(ctx), output.Messages[i:])
 			return ctx.Err()
 		}
 	}
@@ -314,47 +557,255 @@ func (s *SQSEventSubscriber) read(ctx context.Context) error {
 	return nil
 }
 
+// inlinePayload returns the offloaded body when body is a claim-check stub,
+// and body itself otherwise
+func (s *SQSEventSubscriber) inlinePayload(ctx context.Context, body []byte) ([]byte, error) {
+	ref, ok := claimCheckRef(body)
+	if !ok {
+		return body, nil
+	}
+
+	if s.options.payloadStore == nil {
+		return nil, errors.Wrapf(ErrMalformedMessage, "claim check %s without a payload store", ref)
+	}
+
+	return s.options.payloadStore.Get(ctx, ref)
+}
+
+// workerMessages returns the channel of the worker that handles message
+func (s *SQSEventSubscriber) workerMessages(message *sqsMessage) chan *sqsMessage {
+	if len(s.groupMessages) == 0 {
+		return s.inboundMessages
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(message.messageGroup()))
+	return s.groupMessages[hash.Sum32()%uint32(len(s.groupMessages))]
+}
+
+// handle runs the handler for message and records the outcome in it
 func (s *SQSEventSubscriber) handle(ctx context.Context, message *sqsMessage) {
-	s.mux.RLock()
 	handler := s.handler
-	s.mux.RUnlock()
 
+	// A later message of a group that failed must wait for the failed one
+	// to be retried
+	if s.options.groupOrdering && message.batch != nil {
+		if hold, failed := message.batch.hasFailed(message.messageGroup()); failed {
+			message.Skipped = true
+			message.hold = hold
+			return
+		}
+	}
+
+	receiveCount := message.receiveCount()
+
+	// Continue the producer's trace from the traceparent message attribute
+	spanCtx, span := telemetry.StartSpan(
+		telemetry.ExtractTraceContext(ctx, message.Event.Metadata),
+		message.Event.Topic.String()+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(telemetry.MessagingSystemKey, "aws_sqs"),
+			attribute.String(telemetry.MessagingOperationTypeKey, "process"),
+			attribute.String(telemetry.MessagingDestinationNameKey, queueName(s.queueURL)),
+			attribute.String(telemetry.MessagingMessageIDKey, aws.ToString(message.Message.MessageId)),
+			attribute.Int(telemetry.MessagingSQSReceiveCountKey, receiveCount),
+		),
+	)
+
+	stopHeartbeat := s.startHeartbeat(ctx, message)
 	if handler == nil {
 		message.Err = errors.New("no handler configured")
 	} else {
-		message.Err = handler.Handle(ctx, message.Event)
+		message.Err = handler.Handle(events.ContextWithEvent(spanCtx, message.Event), message.Event)
 	}
+	stopHeartbeat()
 
-	select {
-	case s.outboundMessages <- message:
-	case <-ctx.Done():
+	if message.Err != nil {
+		span.RecordError(message.Err)
+		span.SetStatus(codes.Error, message.Err.Error())
+
+		if s.options.groupOrdering && message.batch != nil {
+			message.batch.fail(message.messageGroup(), s.groupHold(message))
+		}
 	}
+	span.End()
 }
 
-func (s *SQSEventSubscriber) clean(ctx context.Context, message *sqsMessage) error {
-	if message.Err != nil {
-		if s.options.extendVisibilityTimeoutOnError {
-			receiveCount, err := strconv.Atoi(message.Message.Attributes["ApproximateReceiveCount"])
+// startHeartbeat keeps extending the visibility of message while its handler
+// runs, so long handlers are not redelivered. The returned func stops it.
+func (s *SQSEventSubscriber) startHeartbeat(ctx context.Context, message *sqsMessage) func() {
+	if s.options.heartbeatInterval <= 0 || s.client == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(s.options.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			status := "extended"
+			_, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          &s.queueURL,
+				ReceiptHandle:     message.Message.ReceiptHandle,
+				VisibilityTimeout: s.options.visibilityTimeout,
+			})
 			if err != nil {
-				receiveCount = 1
+				status = "failed"
 			}
 
-			visibilityTimeout := s.options.visibilityTimeout
-			visibilityTimeout += (int32(receiveCount) / s.options.receiveCountRange) * s.options.visibilityTimeoutOffset
+			telemetry.RecordCounter(ctx, "sqs_visibility_heartbeats_total", "Total visibility extensions of messages being handled", 1,
+				attribute.String("subscriber", s.options.name),
+				attribute.String("status", status),
+			)
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// release makes messages visible again so they are received right away
+func (s *SQSEventSubscriber) release(ctx context.Context, messages []types.Message) {
+	for _, message := range messages {
+		_, _ = s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &s.queueURL,
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: 0,
+		})
+	}
+}
+
+// deadLetters reports whether a failure at receiveCount moves the message to
+// the dead-letter queue
+func (s *SQSEventSubscriber) deadLetters(receiveCount int) bool {
+	return s.options.deadLetterQueueURL != "" && int32(receiveCount) >= s.options.maxReceiveCount
+}
+
+// retryVisibility returns how long a message that failed at receiveCount
+// stays hidden before it is retried
+func (s *SQSEventSubscriber) retryVisibility(receiveCount int) int32 {
+	visibilityTimeout := s.options.visibilityTimeout
+	if !s.options.extendVisibilityTimeoutOnError {
+		return visibilityTimeout
+	}
+
+	visibilityTimeout += (int32(receiveCount) / s.options.receiveCountRange) * s.options.visibilityTimeoutOffset
+	if visibilityTimeout > s.options.maxVisibilityTimeout {
+		visibilityTimeout = s.options.maxVisibilityTimeout
+	}
+	return visibilityTimeout
+}
+
+// circuitOpenVisibility returns how long a message rejected by an open
+// circuit breaker stays hidden
+func (s *SQSEventSubscriber) circuitOpenVisibility() int32 {
+	if s.options.circuitOpenDelay <= 0 {
+		return s.options.visibilityTimeout
+	}
 
-			if visibilityTimeout > s.options.maxVisibilityTimeout {
-				visibilityTimeout = s.options.maxVisibilityTimeout
+	visibilityTimeout := int32(s.options.circuitOpenDelay / time.Second)
+	if visibilityTimeout > sqsMaxVisibilityTimeout {
+		visibilityTimeout = sqsMaxVisibilityTimeout
+	}
+	return visibilityTimeout
+}
+
+// failureVisibility returns how long message stays hidden after it failed,
+// or -1 when it is dead-lettered, or discarded without a DLQ, instead.
+// Permanent failures never succeed on retry, so they are removed at once. A
+// message rejected by an open circuit breaker was not handled, so it is never
+// dead-lettered.
+func (s *SQSEventSubscriber) failureVisibility(message *sqsMessage) int32 {
+	if errors.Is(message.Err, ErrCircuitOpen) {
+		return s.circuitOpenVisibility()
+	}
+	if IsPermanent(message.Err) || s.deadLetters(message.receiveCount()) {
+		return -1
+	}
+	return s.retryVisibility(message.receiveCount())
+}
+
+// groupHold returns how long the rest of a group stays hidden after message
+// failed. FIFO queues lock the group themselves and a dead-lettered message
+// no longer blocks it. On standard queues the rest waits for the failed
+// message's retry plus one visibility timeout to handle it.
+func (s *SQSEventSubscriber) groupHold(message *sqsMessage) int32 {
+	visibility := s.failureVisibility(message)
+	if strings.HasSuffix(s.queueURL, ".fifo") || visibility < 0 {
+		return 0
+	}
+
+	hold := visibility + s.options.visibilityTimeout
+	if hold > sqsMaxVisibilityTimeout {
+		hold = sqsMaxVisibilityTimeout
+	}
+	return hold
+}
+
+func (s *SQSEventSubscriber) clean(ctx context.Context, message *sqsMessage) error {
+	if message.Skipped || message.Interrupted {
+		// Skipped messages stay hidden until the failed message of their
+		// group is retried; on FIFO queues the group is locked until then,
+		// so they are released right away. Interrupted ones are released
+		// for another instance.
+		_, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &s.queueURL,
+			ReceiptHandle:     message.Message.ReceiptHandle,
+			VisibilityTimeout: message.hold,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to release message")
+		}
+		return nil
+	}
+
+	if message.Err != nil {
+		visibility := s.failureVisibility(message)
+		circuitOpen := errors.Is(message.Err, ErrCircuitOpen)
+
+		if visibility < 0 {
+			if !IsPermanent(message.Err) {
+				return s.deadLetter(ctx, message.Message, message.Err, "max_receive_count")
+			}
+			if s.options.deadLetterQueueURL == "" {
+				s.discard(ctx, message.Message, message.Err)
+				return nil
 			}
+			return s.deadLetter(ctx, message.Message, message.Err, "permanent")
+		}
 
-			_, err = s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		if circuitOpen || s.options.extendVisibilityTimeoutOnError {
+			_, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
 				QueueUrl:          &s.queueURL,
 				ReceiptHandle:     message.Message.ReceiptHandle,
-				VisibilityTimeout: visibilityTimeout,
+				VisibilityTimeout: visibility,
 			})
 			if err != nil {
 				return errors.Wrap(err, "failed to extend visibility timeout")
 			}
 		}
+
+		if circuitOpen {
+			telemetry.RecordCounter(ctx, "sqs_messages_deferred_total", "Total SQS messages returned unhandled while a circuit breaker is open", 1,
+				attribute.String("subscriber", s.options.name),
+			)
+		}
 		return nil
 	}
 
@@ -369,4 +820,69 @@ func (s *SQSEventSubscriber) clean(ctx context.Context, message *sqsMessage) err
 	}
 
 	return nil
+}
+
+// deadLetter sends the message to the DLQ with the failure reason and removes
+// it from the queue
+func (s *SQSEventSubscriber) deadLetter(ctx context.Context, message types.Message, reason error, cause string) error {
+	receiveCount, err := strconv.Atoi(message.Attributes["ApproximateReceiveCount"])
+	if err != nil {
+		receiveCount = 1
+	}
+
+	groupID, deduplicationID := fifoMessageIDs(
+		s.options.deadLetterQueueURL,
+		message.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)],
+		aws.ToString(message.MessageId),
+	)
+
+	_, err = s.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(s.options.deadLetterQueueURL),
+		MessageBody:            message.Body,
+		MessageAttributes:      deadLetterAttributes(message, s.queueURL, reason, receiveCount),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: deduplicationID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to send message to dead-letter queue")
+	}
+
+	_, err = s.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &s.queueURL,
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete dead-lettered message from SQS")
+	}
+
+	telemetry.RecordCounter(ctx, "sqs_messages_dead_lettered_total", "Total SQS messages moved to the dead-letter queue", 1,
+		attribute.String("subscriber", s.options.name),
+		attribute.String("reason", cause),
+	)
+
+	return nil
+}
+
+// discard deletes a message that cannot be handled when there is no
+// dead-letter queue to keep it, so it is not received forever
+func (s *SQSEventSubscriber) discard(ctx context.Context, message types.Message, reason error) {
+	log.Printf("%s: discarding message %s without a dead-letter queue: %v", s.options.name, aws.ToString(message.MessageId), reason)
+
+	_, err := s.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &s.queueURL,
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		log.Printf("%s: failed to delete message %s: %v", s.options.name, aws.ToString(message.MessageId), err)
+		return
+	}
+
+	telemetry.RecordCounter(ctx, "sqs_messages_discarded_total", "Total SQS messages that cannot be handled deleted without a dead-letter queue", 1,
+		attribute.String("subscriber", s.options.name),
+	)
+}
+
+// queueName returns the queue name, the last segment of its URL
+func queueName(queueURL string) string {
+	return queueURL[strings.LastIndex(queueURL, "/")+1:]
 }
\ No newline at end of file
@@ -0,0 +1,334 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQSEventSubscriber_WorkerMessages(t *testing.T) {
+	s := NewSQSEventSubscriber(nil, "http://sqs.test/000000000000/wallet-events.fifo", nil)
+	for i := 0; i < 4; i++ {
+		s.groupMessages = append(s.groupMessages, make(chan *sqsMessage, 1))
+	}
+
+	message := func(group string, aggregateID models.ID) *sqsMessage {
+		return &sqsMessage{
+			Message: types.Message{Attributes: map[string]string{"MessageGroupId": group}},
+			Event:   events.NewEvent(aggregateID, events.PaymentCreatedEvent, nil),
+		}
+	}
+
+	assert.Equal(t, s.workerMessages(message("payment-1", "ignored")), s.workerMessages(message("payment-1", "other")))
+	assert.Equal(t, s.workerMessages(message("", "payment-2")), s.workerMessages(message("payment-2", "")),
+		"standard queue messages are grouped by aggregate")
+}
+
+func TestSQSEventSubscriber_GroupOrdering(t *testing.T) {
+	tests := []struct {
+		name                  string
+		queueURL              string
+		expectedSkippedHidden bool
+	}{
+		{
+			name:                  "standard queue keeps the rest of the group hidden",
+			queueURL:              testQueueURL,
+			expectedSkippedHidden: true,
+		},
+		{
+			name:     "FIFO queue releases the rest of the locked group",
+			queueURL: testQueueURL + ".fifo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeSQS(t)
+
+			var handled []models.ID
+			handler := NewEventHandlerFunc("test", func(ctx context.Context, event *events.Event) error {
+				handled = append(handled, event.ID)
+				if event.Topic == events.PaymentProcessingEvent {
+					return assert.AnError
+				}
+				return nil
+			})
+
+			s := NewSQSEventSubscriber(client, tt.queueURL, handler, WithGroupOrdering(true))
+
+			batch := &receiveBatch{failed: make(map[string]int32)}
+			newMessage := func(aggregateID models.ID, topic string) *sqsMessage {
+				stored := fake.add(tt.queueURL, "{}", nil)
+				stored.hidden = true
+				return &sqsMessage{
+					Message: types.Message{
+						MessageId:     aws.String(stored.ID),
+						ReceiptHandle: aws.String(stored.Receipt),
+						Attributes:    map[string]string{"ApproximateReceiveCount": "1"},
+					},
+					Event: events.NewEvent(aggregateID, topic, nil),
+					batch: batch,
+				}
+			}
+
+			processing := newMessage("payment-1", events.PaymentProcessingEvent)
+			completed := newMessage("payment-1", events.PaymentOperationCompletedEvent)
+			other := newMessage("payment-2", events.PaymentCreatedEvent)
+
+			for _, message := range []*sqsMessage{processing, completed, other} {
+				s.handle(context.Background(), message)
+				require.NoError(t, s.clean(context.Background(), message))
+			}
+
+			assert.Equal(t, []models.ID{processing.Event.ID, other.Event.ID}, handled)
+			assert.Error(t, processing.Err)
+			assert.True(t, completed.Skipped)
+			assert.False(t, other.Skipped)
+
+			remaining := fake.messages(tt.queueURL)
+			require.Len(t, remaining, 2, "the handled message of the other group is deleted")
+			assert.True(t, remaining[0].hidden, "the failed message waits for its retry")
+			assert.Equal(t, tt.expectedSkippedHidden, remaining[1].hidden)
+		})
+	}
+}
+
+func TestSQSEventSubscriber_GroupHold(t *testing.T) {
+	s := NewSQSEventSubscriber(nil, testQueueURL, nil,
+		WithVisibilityTimeout(30),
+		WithDeadLetterQueue(testDeadLetterQueueURL, 5),
+		WithCircuitOpenDelay(time.Minute),
+	)
+
+	failed := func(receiveCount string, err error) *sqsMessage {
+		return &sqsMessage{
+			Message: types.Message{Attributes: map[string]string{"ApproximateReceiveCount": receiveCount}},
+			Err:     err,
+		}
+	}
+
+	// The rest of the group outlasts the failed message's retry visibility
+	assert.Equal(t, s.retryVisibility(1)+30, s.groupHold(failed("1", assert.AnError)))
+	assert.Equal(t, s.retryVisibility(4)+30, s.groupHold(failed("4", assert.AnError)))
+	assert.Zero(t, s.groupHold(failed("5", assert.AnError)), "a dead-lettered message no longer blocks its group")
+	assert.Equal(t, int32(90), s.groupHold(failed("5", ErrCircuitOpen)), "a rejected message waits for the circuit")
+}
+
+func TestSQSEventSubscriber_Stop(t *testing.T) {
+	tests := []struct {
+		name             string
+		handler          func(ctx context.Context, release <-chan struct{}) error
+		expectedError    bool
+		expectedInQueue  bool
+		expectedReleased bool
+	}{
+		{
+			name: "waits for in-flight handler and acks",
+			handler: func(ctx context.Context, release <-chan struct{}) error {
+				<-release
+				return nil
+			},
+		},
+		{
+			name: "cancels handler at the deadline and releases the message",
+			handler: func(ctx context.Context, release <-chan struct{}) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			expectedError:    true,
+			expectedInQueue:  true,
+			expectedReleased: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeSQS(t)
+			body, err := NewJSONCodec().Encode(events.NewEvent("payment-1", events.PaymentCreatedEvent, nil))
+			require.NoError(t, err)
+			fake.mux.Lock()
+			fake.add(testQueueURL, string(body), nil)
+			fake.mux.Unlock()
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			handler := NewEventHandlerFunc("test", func(ctx context.Context, event *events.Event) error {
+				close(started)
+				return tt.handler(ctx, release)
+			})
+
+			s := NewSQSEventSubscriber(client, testQueueURL, handler,
+				WithWorkers(2),
+				WithDrainTimeout(200*time.Millisecond),
+				WithVisibilityHeartbeat(-1),
+			)
+			require.NoError(t, s.Start(context.Background()))
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler did not start")
+			}
+
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- s.Stop(context.Background())
+			}()
+
+			if !tt.expectedError {
+				select {
+				case <-stopped:
+					t.Fatal("Stop returned while a handler was running")
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(release)
+			}
+
+			select {
+			case err := <-stopped:
+				if tt.expectedError {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("Stop did not return")
+			}
+
+			remaining := fake.messages(testQueueURL)
+			if !tt.expectedInQueue {
+				assert.Empty(t, remaining)
+				return
+			}
+			require.Len(t, remaining, 1)
+			assert.Equal(t, !tt.expectedReleased, remaining[0].hidden)
+		})
+	}
+}
+
+func TestSQSEventSubscriber_Heartbeat(t *testing.T) {
+	fake, client := newFakeSQS(t)
+	fake.mux.Lock()
+	stored := fake.add(testQueueURL, "{}", nil)
+	stored.hidden = true
+	fake.mux.Unlock()
+
+	handler := NewEventHandlerFunc("test", func(ctx context.Context, event *events.Event) error {
+		time.Sleep(120 * time.Millisecond)
+		return nil
+	})
+	s := NewSQSEventSubscriber(client, testQueueURL, handler, WithVisibilityHeartbeat(20*time.Millisecond))
+
+	message := &sqsMessage{
+		Message: types.Message{
+			MessageId:     aws.String(stored.ID),
+			ReceiptHandle: aws.String(stored.Receipt),
+		},
+		Event: events.NewEvent("payment-1", events.PaymentCreatedEvent, nil),
+	}
+	s.handle(context.Background(), message)
+	require.NoError(t, message.Err)
+
+	heartbeats := fake.callCount("ChangeMessageVisibility")
+	assert.GreaterOrEqual(t, heartbeats, 2, "visibility is extended while the handler runs")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, heartbeats, fake.callCount("ChangeMessageVisibility"), "the heartbeat stops with the handler")
+}
+
+func TestSQSEventSubscriber_MalformedMessage(t *testing.T) {
+	tests := []struct {
+		name               string
+		opts               []SQSSubscriberOption
+		expectedDeadLetter bool
+	}{
+		{
+			name:               "moved to the dead-letter queue",
+			opts:               []SQSSubscriberOption{WithDeadLetterQueue(testDeadLetterQueueURL, 3)},
+			expectedDeadLetter: true,
+		},
+		{
+			name: "deleted without a dead-letter queue",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeSQS(t)
+			fake.mux.Lock()
+			fake.add(testQueueURL, "not an event", nil)
+			fake.mux.Unlock()
+
+			s := NewSQSEventSubscriber(client, testQueueURL, nil, tt.opts...)
+			require.NoError(t, s.read(context.Background()))
+
+			assert.Empty(t, fake.messages(testQueueURL), "the message is not received again")
+			assert.Equal(t, tt.expectedDeadLetter, len(fake.messages(testDeadLetterQueueURL)) == 1)
+		})
+	}
+}
+
+func TestSQSEventSubscriber_InvalidPayload(t *testing.T) {
+	tests := []struct {
+		name               string
+		opts               []SQSSubscriberOption
+		expectedDeadLetter bool
+	}{
+		{
+			name:               "moved to the dead-letter queue on the first receive",
+			opts:               []SQSSubscriberOption{WithDeadLetterQueue(testDeadLetterQueueURL, 5)},
+			expectedDeadLetter: true,
+		},
+		{
+			name: "deleted without a dead-letter queue",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeSQS(t)
+			body, err := NewJSONCodec().Encode(events.NewEvent("payment-1", events.WalletCreditRequestedEvent,
+				json.RawMessage(`{"amount":{"amount":100,"currency":"USD"}}`)))
+			require.NoError(t, err)
+			fake.mux.Lock()
+			fake.add(testQueueURL, string(body), nil)
+			fake.mux.Unlock()
+
+			calls := 0
+			registry := events.NewRegistry().MustRegister(events.WalletCreditRequestedEvent, schemaTestPayload{})
+			handler := ChainHandler(
+				NewEventHandlerFunc("handler", func(ctx context.Context, event *events.Event) error {
+					calls++
+					return nil
+				}),
+				SchemaValidationMiddleware(registry),
+			)
+
+			s := NewSQSEventSubscriber(client, testQueueURL, handler, tt.opts...)
+			require.NoError(t, s.read(context.Background()))
+			message := <-s.inboundMessages
+			s.handle(context.Background(), message)
+			require.NoError(t, s.clean(context.Background(), message))
+
+			assert.True(t, IsPermanent(message.Err))
+			assert.Zero(t, calls)
+			assert.Empty(t, fake.messages(testQueueURL), "the message is not received again")
+			deadLettered := fake.messages(testDeadLetterQueueURL)
+			if !tt.expectedDeadLetter {
+				assert.Empty(t, deadLettered)
+				return
+			}
+			require.Len(t, deadLettered, 1)
+			assert.Equal(t, string(body), deadLettered[0].Body)
+			assert.Contains(t, aws.ToString(deadLettered[0].Attributes[DLQFailureReasonKey].StringValue), "wallet_id")
+		})
+	}
+}
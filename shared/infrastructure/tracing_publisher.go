@@ -0,0 +1,58 @@
+package infrastructure
+
+import (
+	"context"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ events.Publisher = (*TracingPublisher)(nil)
+
+// TracingPublisher starts a producer span per event and writes its W3C trace
+// context into the event metadata. Metadata is kept by the outbox and sent
+// as SNS message attributes, so consumers continue the same trace.
+type TracingPublisher struct {
+	next events.Publisher
+}
+
+// NewTracingPublisher creates a new TracingPublisher
+func NewTracingPublisher(next events.Publisher) *TracingPublisher {
+	return &TracingPublisher{next: next}
+}
+
+// Publish injects the trace context and forwards the events
+func (p *TracingPublisher) Publish(ctx context.Context, evts ...*events.Event) error {
+	spans := make([]trace.Span, len(evts))
+	for i, event := range evts {
+		spanCtx, span := telemetry.StartSpan(ctx, event.Topic.String()+" publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(
+				attribute.String(telemetry.MessagingOperationTypeKey, "publish"),
+				attribute.String(telemetry.MessagingDestinationNameKey, event.Topic.String()),
+				attribute.String(telemetry.MessagingMessageIDKey, event.ID.String()),
+			),
+		)
+
+		if event.Metadata == nil {
+			event.Metadata = make(events.Metadata)
+		}
+		telemetry.InjectTraceContext(spanCtx, event.Metadata)
+		spans[i] = span
+	}
+
+	err := p.next.Publish(ctx, evts...)
+
+	for _, span := range spans {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	return err
+}
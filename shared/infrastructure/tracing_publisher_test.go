@@ -0,0 +1,138 @@
+package infrastructure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type capturingPublisher struct {
+	published []*events.Event
+}
+
+func (p *capturingPublisher) Publish(ctx context.Context, evts ...*events.Event) error {
+	p.published = append(p.published, evts...)
+	return nil
+}
+
+// setupTestTracing installs a recording tracer provider and the W3C
+// propagator, restoring the globals when the test ends
+func setupTestTracing(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+		_ = provider.Shutdown(context.Background())
+	})
+
+	return recorder
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracingPublisher_Publish(t *testing.T) {
+	recorder := setupTestTracing(t)
+
+	next := &capturingPublisher{}
+	event := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+
+	require.NoError(t, NewTracingPublisher(next).Publish(context.Background(), event))
+
+	require.Len(t, next.published, 1)
+	traceParent := next.published[0].Metadata[telemetry.TraceParentKey]
+	require.NotEmpty(t, traceParent)
+
+	span := findSpan(recorder.Ended(), events.PaymentCreatedEvent+" publish")
+	require.NotNil(t, span)
+	assert.Equal(t, trace.SpanKindProducer, span.SpanKind())
+	assert.Contains(t, traceParent, span.SpanContext().TraceID().String())
+	assert.Contains(t, traceParent, span.SpanContext().SpanID().String())
+}
+
+func TestSQSEventSubscriber_HandleContinuesTrace(t *testing.T) {
+	tests := []struct {
+		name           string
+		handlerErr     error
+		expectedStatus codes.Code
+	}{
+		{
+			name:           "handled",
+			expectedStatus: codes.Unset,
+		},
+		{
+			name:           "handler failure",
+			handlerErr:     assert.AnError,
+			expectedStatus: codes.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := setupTestTracing(t)
+
+			next := &capturingPublisher{}
+			event := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+			require.NoError(t, NewTracingPublisher(next).Publish(context.Background(), event))
+
+			var handlerSpan trace.SpanContext
+			subscriber := NewSQSEventSubscriber(nil,
+				"https://sqs.us-east-1.amazonaws.com/000000000000/wallet-events",
+				NewEventHandlerFunc("test", func(ctx context.Context, event *events.Event) error {
+					handlerSpan = trace.SpanContextFromContext(ctx)
+					return tt.handlerErr
+				}),
+			)
+
+			subscriber.handle(context.Background(), &sqsMessage{
+				Message: types.Message{
+					MessageId:  aws.String("message-1"),
+					Attributes: map[string]string{"ApproximateReceiveCount": "3"},
+				},
+				Event: next.published[0],
+			})
+
+			producer := findSpan(recorder.Ended(), events.PaymentCreatedEvent+" publish")
+			consumer := findSpan(recorder.Ended(), events.PaymentCreatedEvent+" process")
+			require.NotNil(t, producer)
+			require.NotNil(t, consumer)
+
+			assert.Equal(t, producer.SpanContext().TraceID(), consumer.SpanContext().TraceID())
+			assert.Equal(t, producer.SpanContext().SpanID(), consumer.Parent().SpanID())
+			assert.Equal(t, consumer.SpanContext().SpanID(), handlerSpan.SpanID())
+			assert.Equal(t, trace.SpanKindConsumer, consumer.SpanKind())
+			assert.Equal(t, tt.expectedStatus, consumer.Status().Code)
+
+			assert.Contains(t, consumer.Attributes(), attribute.String(telemetry.MessagingSystemKey, "aws_sqs"))
+			assert.Contains(t, consumer.Attributes(), attribute.String(telemetry.MessagingDestinationNameKey, "wallet-events"))
+			assert.Contains(t, consumer.Attributes(), attribute.String(telemetry.MessagingMessageIDKey, "message-1"))
+			assert.Contains(t, consumer.Attributes(), attribute.Int(telemetry.MessagingSQSReceiveCountKey, 3))
+		})
+	}
+}
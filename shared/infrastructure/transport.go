@@ -0,0 +1,26 @@
+package infrastructure
+
+import "github.com/draftea/payment-system/shared/events"
+
+var (
+	_ TransportPublisher  = (*SNSPublisherAdapter)(nil)
+	_ TransportPublisher  = (*PostgresEventPublisher)(nil)
+	_ TransportPublisher  = (*InMemoryBus)(nil)
+	_ TransportSubscriber = (*SQSSubscriberAdapter)(nil)
+	_ TransportSubscriber = (*PostgresEventSubscriber)(nil)
+	_ TransportSubscriber = (*InMemoryBus)(nil)
+)
+
+// TransportPublisher is an events.Publisher owning broker resources that are
+// released on shutdown
+type TransportPublisher interface {
+	events.Publisher
+	Close() error
+}
+
+// TransportSubscriber is an events.Subscriber owning broker resources that
+// are released on shutdown
+type TransportSubscriber interface {
+	events.Subscriber
+	Close() error
+}
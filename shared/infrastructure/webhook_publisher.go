@@ -0,0 +1,203 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/telemetry"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var _ events.Publisher = (*WebhookPublisher)(nil)
+
+// CloudEventsMode selects how a CloudEvent is carried by a message
+type CloudEventsMode string
+
+const (
+	// CloudEventsStructured carries the whole event as the body
+	CloudEventsStructured CloudEventsMode = "structured"
+	// CloudEventsBinary carries the data as the body and the context
+	// attributes as ce- headers
+	CloudEventsBinary CloudEventsMode = "binary"
+)
+
+// cloudEventsHeaderPrefix prefixes context attribute headers in binary mode
+const cloudEventsHeaderPrefix = "ce-"
+
+// NewCloudEventsRequest builds a POST request carrying event as a CloudEvent
+// using the HTTP protocol binding
+func NewCloudEventsRequest(
+	ctx context.Context,
+	url string,
+	event *events.Event,
+	codec *CloudEventsCodec,
+	mode CloudEventsMode,
+) (*http.Request, error) {
+	var (
+		body    []byte
+		headers = make(http.Header)
+	)
+
+	switch mode {
+	case CloudEventsStructured, "":
+		encoded, err := codec.Encode(event)
+		if err != nil {
+			return nil, err
+		}
+		body = encoded
+		headers.Set("Content-Type", CloudEventsContentType)
+	case CloudEventsBinary:
+		attributes, data, err := codec.EncodeBinary(event)
+		if err != nil {
+			return nil, err
+		}
+		body = data
+		for name, value := range attributes {
+			if name == "datacontenttype" {
+				headers.Set("Content-Type", value)
+				continue
+			}
+			headers.Set(cloudEventsHeaderPrefix+name, value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cloud events mode %q", mode)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	req.Header = headers
+
+	return req, nil
+}
+
+// ReadCloudEventsRequest decodes a CloudEvent received over HTTP in either
+// structured or binary mode
+func ReadCloudEventsRequest(r *http.Request, codec *CloudEventsCodec) (*events.Event, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read request body")
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == CloudEventsContentType {
+		var ce CloudEvent
+		if err := ce.UnmarshalJSON(body); err != nil {
+			return nil, err
+		}
+		return codec.FromCloudEvent(&ce)
+	}
+
+	attributes := make(map[string]string)
+	for key, values := range r.Header {
+		name := strings.ToLower(key)
+		if strings.HasPrefix(name, cloudEventsHeaderPrefix) && len(values) > 0 {
+			attributes[strings.TrimPrefix(name, cloudEventsHeaderPrefix)] = values[0]
+		}
+	}
+
+	if _, ok := attributes["specversion"]; !ok {
+		return nil, errors.Wrap(ErrInvalidCloudEvent, "request is neither structured nor binary mode")
+	}
+
+	if contentType != "" {
+		attributes["datacontenttype"] = contentType
+	}
+
+	return codec.DecodeBinary(attributes, body)
+}
+
+// WebhookPublisher implements events.Publisher by POSTing each event as a
+// CloudEvent to an HTTP endpoint. Any non-2xx response is an error.
+type WebhookPublisher struct {
+	url    string
+	codec  *CloudEventsCodec
+	mode   CloudEventsMode
+	client *http.Client
+}
+
+type WebhookOption func(*WebhookPublisher)
+
+// WithWebhookMode sets the CloudEvents mode (structured by default)
+func WithWebhookMode(mode CloudEventsMode) WebhookOption {
+	return func(p *WebhookPublisher) {
+		p.mode = mode
+	}
+}
+
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(p *WebhookPublisher) {
+		p.client = client
+	}
+}
+
+// NewWebhookPublisher creates a new WebhookPublisher
+func NewWebhookPublisher(url string, codec *CloudEventsCodec, opts ...WebhookOption) *WebhookPublisher {
+	publisher := &WebhookPublisher{
+		url:    url,
+		codec:  codec,
+		mode:   CloudEventsStructured,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, opt := range opts {
+		opt(publisher)
+	}
+
+	return publisher
+}
+
+// Publish delivers the events one request at a time, stopping at the first
+// failure
+func (p *WebhookPublisher) Publish(ctx context.Context, evts ...*events.Event) error {
+	for _, event := range evts {
+		if err := p.deliver(ctx, event); err != nil {
+			telemetry.RecordCounter(ctx, "webhook_deliveries_total", "Total webhook deliveries", 1,
+				attribute.String("topic", event.Topic.String()),
+				attribute.String("status", "failed"),
+			)
+			return err
+		}
+
+		telemetry.RecordCounter(ctx, "webhook_deliveries_total", "Total webhook deliveries", 1,
+			attribute.String("topic", event.Topic.String()),
+			attribute.String("status", "delivered"),
+		)
+	}
+
+	return nil
+}
+
+func (p *WebhookPublisher) deliver(ctx context.Context, event *events.Event) error {
+	req, err := NewCloudEventsRequest(ctx, p.url, event, p.codec, p.mode)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to deliver event %s", event.ID)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded to event %s with status %d", event.ID, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Close is a no-op; idle connections belong to the HTTP client
+func (p *WebhookPublisher) Close() error {
+	return nil
+}
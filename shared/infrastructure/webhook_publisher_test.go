@@ -0,0 +1,79 @@
+package infrastructure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPublisher_Publish(t *testing.T) {
+	tests := []struct {
+		name                string
+		mode                CloudEventsMode
+		status              int
+		expectedContentType string
+		expectedError       bool
+	}{
+		{
+			name:                "structured mode",
+			mode:                CloudEventsStructured,
+			status:              http.StatusAccepted,
+			expectedContentType: CloudEventsContentType,
+		},
+		{
+			name:                "binary mode",
+			mode:                CloudEventsBinary,
+			status:              http.StatusOK,
+			expectedContentType: "application/json",
+		},
+		{
+			name:                "non-2xx response",
+			mode:                CloudEventsStructured,
+			status:              http.StatusServiceUnavailable,
+			expectedContentType: CloudEventsContentType,
+			expectedError:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := NewCloudEventsCodec("payments-service")
+
+			var received *events.Event
+			var contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType = r.Header.Get("Content-Type")
+				event, err := ReadCloudEventsRequest(r, codec)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				received = event
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			original := newCodecTestEvent()
+			err := NewWebhookPublisher(server.URL, codec, WithWebhookMode(tt.mode)).Publish(context.Background(), original)
+
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectedContentType, contentType)
+			require.NotNil(t, received)
+			assert.Equal(t, original.ID, received.ID)
+			assert.Equal(t, original.Topic, received.Topic)
+			assert.Equal(t, original.AggregateID, received.AggregateID)
+			assert.Equal(t, original.CorrelationID, received.CorrelationID)
+			assert.Equal(t, original.CausationID, received.CausationID)
+		})
+	}
+}
@@ -69,8 +69,8 @@ func (v Version) Update() Version {
 
 // Money represents monetary amount
 type Money struct {
-	Amount   int64  `json:"amount"`   // Amount in cents
-	Currency string `json:"currency"` // Currency code (USD, EUR, etc.)
+	Amount   int64  `json:"amount"`                             // Amount in cents
+	Currency string `json:"currency" validate:"required,len=3"` // Currency code (USD, EUR, etc.)
 }
 
 // NewMoney creates a new money value
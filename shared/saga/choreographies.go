@@ -0,0 +1,117 @@
+package saga
+
+import "github.com/draftea/payment-system/shared/events"
+
+// Participants of the choreographies
+const (
+	ParticipantPayments = "payments-service"
+	ParticipantWallet   = "wallet-service"
+	ParticipantProvider = "external provider"
+)
+
+// PaymentChoreography declares the payment saga as the services run it: the
+// payment is charged to a wallet or through a provider operation, and the
+// watchdog raises payments stuck on the way so their wallet debit or charge
+// is undone. The wallet is debited and credited back with movements, an
+// expense and an income, both requested on wallet.movement.creation.requested.
+func PaymentChoreography() *Choreography {
+	c := NewChoreography(string(SagaTypePayment), events.PaymentCreatedEvent)
+
+	c.Step("process payment method", ParticipantPayments).
+		On(events.PaymentCreatedEvent).
+		Emits(events.PaymentProcessingEvent, events.WalletMovementCreationRequestedEvent, events.PaymentOperationCreatedEvent).
+		FailsWith(events.PaymentFailedEvent).
+		WithoutCompensation("a payment that does not go through is failed by the operation result")
+
+	c.Step("debit wallet", ParticipantWallet).
+		On(events.WalletMovementCreationRequestedEvent).
+		Emits(events.WalletDebitedEvent, events.WalletMovementCreatedEvent).
+		FailsWith(events.InsufficientFundsEvent).
+		CompensatedBy(events.WalletMovementCreationRequestedEvent)
+
+	c.Step("record wallet debit", ParticipantPayments).
+		On(events.WalletDebitedEvent, events.InsufficientFundsEvent).
+		Emits(events.PaymentOperationCompletedEvent).
+		FailsWith(events.PaymentOperationFailedEvent).
+		WithoutCompensation("records the wallet's outcome as a payment operation")
+
+	c.Step("provider operation", ParticipantProvider).
+		On(events.PaymentOperationCreatedEvent).
+		Emits(events.ExternalProviderUpdateEvent).
+		WithoutCompensation("charges of inconsistent payments are refunded through a new operation")
+
+	c.Step("process provider update", ParticipantPayments).
+		On(events.ExternalProviderUpdateEvent).
+		Emits(events.PaymentOperationCompletedEvent).
+		FailsWith(events.PaymentOperationFailedEvent).
+		WithoutCompensation("records the provider's outcome as a payment operation")
+
+	c.Step("apply operation result", ParticipantPayments).
+		On(events.PaymentOperationCompletedEvent, events.PaymentOperationFailedEvent).
+		Emits(events.PaymentCompletedEvent).
+		FailsWith(events.PaymentFailedEvent, events.PaymentCancelledEvent).
+		AsPivot()
+
+	c.Step("detect stuck payment", ParticipantPayments).
+		OnSchedule().
+		Emits(events.PaymentInconsistentStateEvent).
+		WithoutCompensation("only reports the payment")
+
+	c.Step("resolve inconsistency", ParticipantPayments).
+		On(events.PaymentInconsistentStateEvent).
+		Emits(events.PaymentInconsistentOperationStartedEvent, events.PaymentCancelledEvent,
+			events.WalletMovementCreationRequestedEvent, events.PaymentOperationCreatedEvent,
+			events.PaymentInconsistentOperationProcessedEvent).
+		WithoutCompensation("requests the compensations of the payment")
+
+	c.Step("credit wallet", ParticipantWallet).
+		On(events.WalletMovementCreationRequestedEvent).
+		Emits(events.WalletCreditedEvent, events.WalletMovementCreatedEvent).
+		Compensates("debit wallet")
+
+	return c
+}
+
+// RefundChoreography declares the refund saga as the services run it: the
+// amount is credited back to the wallet or refunded through a provider
+// operation, and refunds stuck on the way are flagged for manual review.
+func RefundChoreography() *Choreography {
+	c := NewChoreography(string(SagaTypeRefund), events.PaymentRefundInitiatedEvent)
+
+	c.Step("process refund", ParticipantPayments).
+		On(events.PaymentRefundInitiatedEvent).
+		Emits(events.WalletMovementCreationRequestedEvent, events.PaymentOperationCreatedEvent, events.PaymentOperationProcessingEvent).
+		WithoutCompensation("only requests the refund")
+
+	c.Step("credit wallet", ParticipantWallet).
+		On(events.WalletMovementCreationRequestedEvent).
+		Emits(events.WalletCreditedEvent, events.WalletMovementCreatedEvent)
+
+	c.Step("provider operation", ParticipantProvider).
+		On(events.PaymentOperationCreatedEvent).
+		Emits(events.ExternalProviderUpdateEvent).
+		WithoutCompensation("the provider reports a refund it could not make")
+
+	c.Step("process provider update", ParticipantPayments).
+		On(events.ExternalProviderUpdateEvent).
+		Emits(events.PaymentOperationCompletedEvent).
+		FailsWith(events.PaymentOperationFailedEvent)
+
+	c.Step("apply refund result", ParticipantPayments).
+		On(events.WalletMovementCreatedEvent, events.PaymentOperationCompletedEvent, events.PaymentOperationFailedEvent)
+
+	c.Step("detect stuck refund", ParticipantPayments).
+		OnSchedule().
+		Emits(events.PaymentInconsistentStateEvent)
+
+	c.Step("flag for manual review", ParticipantPayments).
+		On(events.PaymentInconsistentStateEvent).
+		Emits(events.PaymentInconsistentOperationStartedEvent, events.PaymentInconsistentOperationProcessedEvent)
+
+	return c
+}
+
+// Choreographies returns the choreographies of every tracked saga type
+func Choreographies() []*Choreography {
+	return []*Choreography{PaymentChoreography(), RefundChoreography()}
+}
@@ -0,0 +1,475 @@
+package saga
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/draftea/payment-system/shared/events"
+)
+
+var (
+	// ErrInvalidChoreography reports a malformed declaration
+	ErrInvalidChoreography = errors.New("invalid choreography")
+	// ErrUnreachableStep reports a step whose triggers nothing reachable publishes
+	ErrUnreachableStep = errors.New("unreachable step")
+	// ErrMissingCompensation reports a step that a later failure leaves
+	// without a way to undo its effect
+	ErrMissingCompensation = errors.New("missing compensation")
+	// ErrChoreographyCycle reports steps that trigger each other in a loop
+	ErrChoreographyCycle = errors.New("choreography cycle")
+)
+
+// Choreography declares a saga carried out by services reacting to each
+// other's events, with no coordinator: the topic each step reacts to, the
+// topics it publishes and how its effect is undone. Steps are declared with
+// a builder:
+//
+//	c := saga.NewChoreography("payment", events.PaymentCreatedEvent)
+//	c.Step("debit wallet", "wallet-service").
+//		On(events.WalletDebitRequestedEvent).
+//		Emits(events.WalletDebitedEvent).
+//		FailsWith(events.InsufficientFundsEvent).
+//		CompensatedBy(events.WalletCreditRequestedEvent)
+//	c.Step("credit wallet", "wallet-service").
+//		On(events.WalletCreditRequestedEvent).
+//		Emits(events.WalletCreditedEvent).
+//		Compensates("debit wallet")
+type Choreography struct {
+	Name string
+	// Start is the topic that opens the saga
+	Start events.Topic
+	Steps []*ChoreographyStep
+}
+
+// ChoreographyStep is a participant's reaction to the topics that trigger it
+type ChoreographyStep struct {
+	Name        string
+	Participant string
+	Triggers    []events.Topic
+	// Scheduled steps run on a timer instead of reacting to a topic
+	Scheduled bool
+	Produces  []events.Topic
+	// Failures are the topics the step publishes when it could not act
+	Failures []events.Topic
+	// CompensationTopic requests the undo of the step's effect
+	CompensationTopic events.Topic
+	// Undoes names the step whose effect this step compensates
+	Undoes string
+	// Pivot marks the go/no-go step: failures after it compensate nothing
+	// before it
+	Pivot bool
+	// NoCompensationReason explains why the step needs no compensation
+	NoCompensationReason string
+}
+
+// NewChoreography creates an empty choreography opened by start
+func NewChoreography(name string, start events.Topic) *Choreography {
+	return &Choreography{Name: name, Start: start}
+}
+
+// Step declares a step of participant and returns it for configuration
+func (c *Choreography) Step(name, participant string) *ChoreographyStep {
+	step := &ChoreographyStep{Name: name, Participant: participant}
+	c.Steps = append(c.Steps, step)
+	return step
+}
+
+// On sets the topics that trigger the step
+func (s *ChoreographyStep) On(topics ...events.Topic) *ChoreographyStep {
+	s.Triggers = append(s.Triggers, topics...)
+	return s
+}
+
+// OnSchedule runs the step on a timer, such as a watchdog
+func (s *ChoreographyStep) OnSchedule() *ChoreographyStep {
+	s.Scheduled = true
+	return s
+}
+
+// Emits adds the topics the step publishes when it acts
+func (s *ChoreographyStep) Emits(topics ...events.Topic) *ChoreographyStep {
+	s.Produces = append(s.Produces, topics...)
+	return s
+}
+
+// FailsWith adds the topics the step publishes when it could not act
+func (s *ChoreographyStep) FailsWith(topics ...events.Topic) *ChoreographyStep {
+	s.Failures = append(s.Failures, topics...)
+	return s
+}
+
+// CompensatedBy sets the topic that requests the undo of the step's effect
+func (s *ChoreographyStep) CompensatedBy(topic events.Topic) *ChoreographyStep {
+	s.CompensationTopic = topic
+	return s
+}
+
+// Compensates marks the step as the undo of the named step
+func (s *ChoreographyStep) Compensates(step string) *ChoreographyStep {
+	s.Undoes = step
+	return s
+}
+
+// AsPivot marks the step as the saga's pivot
+func (s *ChoreographyStep) AsPivot() *ChoreographyStep {
+	s.Pivot = true
+	return s
+}
+
+// WithoutCompensation declares that the step has no effect to undo
+func (s *ChoreographyStep) WithoutCompensation(reason string) *ChoreographyStep {
+	s.NoCompensationReason = reason
+	return s
+}
+
+// outputs returns every topic the step publishes
+func (s *ChoreographyStep) outputs() []events.Topic {
+	return append(append([]events.Topic{}, s.Produces...), s.Failures...)
+}
+
+// triggeredBy reports whether topic triggers the step
+func (s *ChoreographyStep) triggeredBy(topic events.Topic) bool {
+	for _, trigger := range s.Triggers {
+		if trigger == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationError lists every problem found in a choreography
+type ValidationError struct {
+	Choreography string
+	Problems     []error
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.Error()
+	}
+	return fmt.Sprintf("choreography %s: %s", e.Choreography, strings.Join(problems, "; "))
+}
+
+// Unwrap allows errors.Is against each problem
+func (e *ValidationError) Unwrap() []error {
+	return e.Problems
+}
+
+// Validate checks that every step is reachable from the start topic or a
+// schedule, that no steps trigger each other in a loop, and that every step
+// a later step can fail after is compensated or says why it needs not be.
+// It returns a *ValidationError listing every problem.
+func (c *Choreography) Validate() error {
+	problems := c.validateDeclaration()
+	if len(problems) == 0 {
+		problems = append(problems, c.validateReachability()...)
+		problems = append(problems, c.validateCycles()...)
+		problems = append(problems, c.validateCompensations()...)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Choreography: c.Name, Problems: problems}
+}
+
+func (c *Choreography) validateDeclaration() []error {
+	var problems []error
+	names := make(map[string]bool, len(c.Steps))
+	for _, step := range c.Steps {
+		if step.Name == "" {
+			problems = append(problems, fmt.Errorf("%w: step without a name", ErrInvalidChoreography))
+			continue
+		}
+		if names[step.Name] {
+			problems = append(problems, fmt.Errorf("%w: step %q declared twice", ErrInvalidChoreography, step.Name))
+		}
+		names[step.Name] = true
+
+		switch {
+		case step.Scheduled && len(step.Triggers) > 0:
+			problems = append(problems, fmt.Errorf("%w: step %q is both scheduled and triggered", ErrInvalidChoreography, step.Name))
+		case !step.Scheduled && len(step.Triggers) == 0:
+			problems = append(problems, fmt.Errorf("%w: step %q has no trigger", ErrInvalidChoreography, step.Name))
+		}
+	}
+
+	for _, step := range c.Steps {
+		if step.Undoes != "" && !names[step.Undoes] {
+			problems = append(problems, fmt.Errorf("%w: step %q compensates unknown step %q", ErrInvalidChoreography, step.Name, step.Undoes))
+		}
+	}
+
+	if len(c.consumers(c.Start)) == 0 {
+		problems = append(problems, fmt.Errorf("%w: no step is triggered by the start topic %s", ErrInvalidChoreography, c.Start))
+	}
+
+	return problems
+}
+
+// validateReachability walks the topics published from the start topic and
+// the scheduled steps
+func (c *Choreography) validateReachability() []error {
+	reached := c.reachable()
+
+	var problems []error
+	for _, step := range c.Steps {
+		if !reached[step] {
+			problems = append(problems, fmt.Errorf("%w: step %q is triggered by %s, which no reachable step publishes",
+				ErrUnreachableStep, step.Name, joinTopics(step.Triggers)))
+		}
+	}
+	return problems
+}
+
+// validateCycles reports each loop of steps once, in declaration order
+func (c *Choreography) validateCycles() []error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[*ChoreographyStep]int, len(c.Steps))
+	reported := make(map[string]bool)
+	var stack []*ChoreographyStep
+	var problems []error
+
+	var visit func(step *ChoreographyStep)
+	visit = func(step *ChoreographyStep) {
+		state[step] = visiting
+		stack = append(stack, step)
+
+		for _, next := range c.next(step) {
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				cycle := cycleFrom(stack, next)
+				if key := cycleKey(cycle); !reported[key] {
+					reported[key] = true
+					names := make([]string, 0, len(cycle)+1)
+					for _, s := range cycle {
+						names = append(names, s.Name)
+					}
+					names = append(names, next.Name)
+					problems = append(problems, fmt.Errorf("%w: %s", ErrChoreographyCycle, strings.Join(names, " -> ")))
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[step] = visited
+	}
+
+	for _, step := range c.Steps {
+		if state[step] == unvisited {
+			visit(step)
+		}
+	}
+	return problems
+}
+
+// validateCompensations requires a compensation of every step that a later
+// failure can leave half done. Failures are looked for downstream of the
+// step, up to and including the pivot. Compensation steps, steps after the
+// pivot, which are retried rather than undone, and steps declared without
+// compensation are exempt.
+func (c *Choreography) validateCompensations() []error {
+	published := make(map[events.Topic]bool)
+	for step := range c.reachable() {
+		for _, topic := range step.outputs() {
+			published[topic] = true
+		}
+	}
+
+	retriable := c.afterPivot()
+
+	var problems []error
+	for _, step := range c.Steps {
+		if step.CompensationTopic != "" {
+			if !c.hasCompensation(step) {
+				problems = append(problems, fmt.Errorf("%w: step %q is compensated by %s, which no step compensating it consumes",
+					ErrMissingCompensation, step.Name, step.CompensationTopic))
+			} else if !published[step.CompensationTopic] {
+				problems = append(problems, fmt.Errorf("%w: step %q is compensated by %s, which no reachable step publishes",
+					ErrMissingCompensation, step.Name, step.CompensationTopic))
+			}
+			continue
+		}
+
+		if step.Undoes != "" || step.Pivot || retriable[step] || step.NoCompensationReason != "" {
+			continue
+		}
+
+		if failing := c.failingAfter(step); failing != nil {
+			problems = append(problems, fmt.Errorf("%w: step %q has no compensation, but %q can fail after it",
+				ErrMissingCompensation, step.Name, failing.Name))
+		}
+	}
+	return problems
+}
+
+// hasCompensation reports whether a step compensating step consumes its
+// compensation topic
+func (c *Choreography) hasCompensation(step *ChoreographyStep) bool {
+	for _, consumer := range c.consumers(step.CompensationTopic) {
+		if consumer.Undoes == step.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// failingAfter returns the first step downstream of step that can fail,
+// without walking into compensations or past a pivot
+func (c *Choreography) failingAfter(step *ChoreographyStep) *ChoreographyStep {
+	seen := map[*ChoreographyStep]bool{step: true}
+	queue := c.next(step)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen[current] || current.Undoes != "" {
+			continue
+		}
+		seen[current] = true
+
+		if len(current.Failures) > 0 {
+			return current
+		}
+		if !current.Pivot {
+			queue = append(queue, c.next(current)...)
+		}
+	}
+	return nil
+}
+
+// afterPivot returns the steps downstream of a pivot, compensations aside
+func (c *Choreography) afterPivot() map[*ChoreographyStep]bool {
+	after := make(map[*ChoreographyStep]bool)
+	var queue []*ChoreographyStep
+	for _, step := range c.Steps {
+		if step.Pivot {
+			queue = append(queue, c.next(step)...)
+		}
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if after[current] || current.Undoes != "" {
+			continue
+		}
+		after[current] = true
+		queue = append(queue, c.next(current)...)
+	}
+	return after
+}
+
+// reachable returns the steps reached from the start topic and the
+// scheduled steps
+func (c *Choreography) reachable() map[*ChoreographyStep]bool {
+	reached := make(map[*ChoreographyStep]bool, len(c.Steps))
+	queue := []events.Topic{c.Start}
+	for _, step := range c.Steps {
+		if step.Scheduled {
+			reached[step] = true
+			queue = append(queue, step.outputs()...)
+		}
+	}
+
+	published := make(map[events.Topic]bool)
+	for len(queue) > 0 {
+		topic := queue[0]
+		queue = queue[1:]
+		if published[topic] {
+			continue
+		}
+		published[topic] = true
+
+		for _, consumer := range c.consumers(topic) {
+			if !reached[consumer] {
+				reached[consumer] = true
+				queue = append(queue, consumer.outputs()...)
+			}
+		}
+	}
+	return reached
+}
+
+// consumers returns the steps triggered by topic, in declaration order
+func (c *Choreography) consumers(topic events.Topic) []*ChoreographyStep {
+	var steps []*ChoreographyStep
+	for _, step := range c.Steps {
+		if step.triggeredBy(topic) {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// next returns the steps triggered by what step publishes
+func (c *Choreography) next(step *ChoreographyStep) []*ChoreographyStep {
+	var steps []*ChoreographyStep
+	seen := make(map[*ChoreographyStep]bool)
+	for _, topic := range step.outputs() {
+		for _, consumer := range c.consumers(topic) {
+			if !seen[consumer] {
+				seen[consumer] = true
+				steps = append(steps, consumer)
+			}
+		}
+	}
+	return steps
+}
+
+// Topics returns every topic the choreography consumes or publishes, sorted
+func (c *Choreography) Topics() []events.Topic {
+	set := map[events.Topic]bool{c.Start: true}
+	for _, step := range c.Steps {
+		for _, topic := range append(step.outputs(), step.Triggers...) {
+			set[topic] = true
+		}
+		if step.CompensationTopic != "" {
+			set[step.CompensationTopic] = true
+		}
+	}
+
+	topics := make([]events.Topic, 0, len(set))
+	for topic := range set {
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i] < topics[j] })
+	return topics
+}
+
+// cycleFrom returns the part of stack from step to its top
+func cycleFrom(stack []*ChoreographyStep, step *ChoreographyStep) []*ChoreographyStep {
+	for i, s := range stack {
+		if s == step {
+			return stack[i:]
+		}
+	}
+	return nil
+}
+
+// cycleKey identifies a cycle regardless of the step it was entered from
+func cycleKey(cycle []*ChoreographyStep) string {
+	names := make([]string, len(cycle))
+	for i, step := range cycle {
+		names[i] = step.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\x00")
+}
+
+func joinTopics(topics []events.Topic) string {
+	names := make([]string, len(topics))
+	for i, topic := range topics {
+		names[i] = string(topic)
+	}
+	return strings.Join(names, ", ")
+}
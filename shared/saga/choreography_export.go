@@ -0,0 +1,207 @@
+package saga
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/draftea/payment-system/shared/events"
+)
+
+// flowEdgeKind tells how a topic links two nodes of a rendered flow
+type flowEdgeKind int
+
+const (
+	flowEdgeEmitted flowEdgeKind = iota
+	flowEdgeFailure
+	flowEdgeCompensation
+)
+
+// flowEdge is a topic published by one node and consumed by another
+type flowEdge struct {
+	from, to string
+	topic    events.Topic
+	kind     flowEdgeKind
+}
+
+// flowEnd is a topic no step consumes, rendered as a node of its own
+type flowEnd struct {
+	id    string
+	topic events.Topic
+}
+
+const (
+	flowStartNode    = "start"
+	flowScheduleNode = "schedule"
+)
+
+// stepNode returns the node ID of the i-th step
+func stepNode(i int) string {
+	return fmt.Sprintf("s%d", i)
+}
+
+// flow lists the edges of the choreography and the topics it ends with, in
+// declaration order
+func (c *Choreography) flow() ([]flowEdge, []flowEnd) {
+	ids := make(map[*ChoreographyStep]string, len(c.Steps))
+	for i, step := range c.Steps {
+		ids[step] = stepNode(i)
+	}
+
+	var edges []flowEdge
+	link := func(from string, topic events.Topic, kind flowEdgeKind) bool {
+		consumers := c.consumers(topic)
+		for _, consumer := range consumers {
+			edgeKind := kind
+			if consumer.Undoes != "" {
+				edgeKind = flowEdgeCompensation
+			}
+			edges = append(edges, flowEdge{from: from, to: ids[consumer], topic: topic, kind: edgeKind})
+		}
+		return len(consumers) > 0
+	}
+
+	link(flowStartNode, c.Start, flowEdgeEmitted)
+
+	var ends []flowEnd
+	endIDs := make(map[events.Topic]string)
+	end := func(from string, topic events.Topic, kind flowEdgeKind) {
+		id, ok := endIDs[topic]
+		if !ok {
+			id = fmt.Sprintf("e%d", len(ends))
+			endIDs[topic] = id
+			ends = append(ends, flowEnd{id: id, topic: topic})
+		}
+		edges = append(edges, flowEdge{from: from, to: id, topic: topic, kind: kind})
+	}
+
+	for _, step := range c.Steps {
+		from := ids[step]
+		if step.Scheduled {
+			edges = append(edges, flowEdge{from: flowScheduleNode, to: from, kind: flowEdgeEmitted})
+		}
+		for _, topic := range step.Produces {
+			if !link(from, topic, flowEdgeEmitted) {
+				end(from, topic, flowEdgeEmitted)
+			}
+		}
+		for _, topic := range step.Failures {
+			if !link(from, topic, flowEdgeFailure) {
+				end(from, topic, flowEdgeFailure)
+			}
+		}
+	}
+
+	return edges, ends
+}
+
+// hasScheduledSteps reports whether a step runs on a timer
+func (c *Choreography) hasScheduledSteps() bool {
+	for _, step := range c.Steps {
+		if step.Scheduled {
+			return true
+		}
+	}
+	return false
+}
+
+// Mermaid renders the choreography as a Mermaid flowchart. Failures are thick
+// edges, compensations dashed, and the pivot a hexagon.
+func (c *Choreography) Mermaid() string {
+	var b strings.Builder
+	edges, ends := c.flow()
+
+	b.WriteString("flowchart TD\n")
+	fmt.Fprintf(&b, "    %s([%q])\n", flowStartNode, string(c.Start))
+	if c.hasScheduledSteps() {
+		fmt.Fprintf(&b, "    %s((%q))\n", flowScheduleNode, "schedule")
+	}
+
+	var compensations []string
+	for i, step := range c.Steps {
+		label := fmt.Sprintf("%s<br/><i>%s</i>", step.Name, step.Participant)
+		if step.Pivot {
+			fmt.Fprintf(&b, "    %s{{%q}}\n", stepNode(i), label)
+		} else {
+			fmt.Fprintf(&b, "    %s[%q]\n", stepNode(i), label)
+		}
+		if step.Undoes != "" {
+			compensations = append(compensations, stepNode(i))
+		}
+	}
+	for _, end := range ends {
+		fmt.Fprintf(&b, "    %s([%q])\n", end.id, string(end.topic))
+	}
+
+	for _, edge := range edges {
+		arrow := "-->"
+		switch edge.kind {
+		case flowEdgeFailure:
+			arrow = "==>"
+		case flowEdgeCompensation:
+			arrow = "-.->"
+		}
+
+		if edge.topic == "" {
+			fmt.Fprintf(&b, "    %s %s %s\n", edge.from, arrow, edge.to)
+		} else {
+			fmt.Fprintf(&b, "    %s %s|%s| %s\n", edge.from, arrow, edge.topic, edge.to)
+		}
+	}
+
+	if len(compensations) > 0 {
+		b.WriteString("    classDef compensation stroke-dasharray: 5 5\n")
+		fmt.Fprintf(&b, "    class %s compensation\n", strings.Join(compensations, ","))
+	}
+
+	return b.String()
+}
+
+// DOT renders the choreography as a Graphviz digraph, with failures in red
+// and compensations dashed
+func (c *Choreography) DOT() string {
+	var b strings.Builder
+	edges, ends := c.flow()
+
+	fmt.Fprintf(&b, "digraph %q {\n", c.Name)
+	b.WriteString("    node [shape=box];\n")
+	fmt.Fprintf(&b, "    %s [label=%q, shape=oval];\n", flowStartNode, string(c.Start))
+	if c.hasScheduledSteps() {
+		fmt.Fprintf(&b, "    %s [label=%q, shape=circle];\n", flowScheduleNode, "schedule")
+	}
+
+	for i, step := range c.Steps {
+		attrs := fmt.Sprintf("label=%q", step.Name+"\n"+step.Participant)
+		if step.Pivot {
+			attrs += ", shape=hexagon"
+		}
+		if step.Undoes != "" {
+			attrs += ", style=dashed"
+		}
+		fmt.Fprintf(&b, "    %s [%s];\n", stepNode(i), attrs)
+	}
+	for _, end := range ends {
+		fmt.Fprintf(&b, "    %s [label=%q, shape=oval];\n", end.id, string(end.topic))
+	}
+
+	for _, edge := range edges {
+		var attrs []string
+		if edge.topic != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", string(edge.topic)))
+		}
+		switch edge.kind {
+		case flowEdgeFailure:
+			attrs = append(attrs, "color=red")
+		case flowEdgeCompensation:
+			attrs = append(attrs, "style=dashed")
+		}
+
+		if len(attrs) == 0 {
+			fmt.Fprintf(&b, "    %s -> %s;\n", edge.from, edge.to)
+		} else {
+			fmt.Fprintf(&b, "    %s -> %s [%s];\n", edge.from, edge.to, strings.Join(attrs, ", "))
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
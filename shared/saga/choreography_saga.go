@@ -42,21 +42,22 @@ func (h *PaymentInitiatedHandler) Handle(ctx context.Context, event *events.Even
 	}
 
 	// Extract payment data
-	data := event.Data.(map[string]interface{})
-	paymentMethod := data["payment_method"].(map[string]interface{})
+	data, err := events.DecodePayload[PaymentInitiatedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode payment initiated payload: %w", err)
+	}
 
 	// If payment method is wallet, request wallet debit
-	if paymentMethod["type"] == "wallet" {
+	if data.PaymentMethod.Type == "wallet" {
 		walletDebitEvent := events.NewEvent(
-			models.ID(paymentMethod["wallet_id"].(string)),
+			data.PaymentMethod.WalletID,
 			events.WalletDebitRequestedEvent,
-			map[string]interface{}{
-				"wallet_id":  paymentMethod["wallet_id"],
-				"payment_id": data["payment_id"],
-				"user_id":    data["user_id"],
-				"amount":     data["amount"],
-				"currency":   data["currency"],
-				"reference":  fmt.Sprintf("Payment %s", data["payment_id"]),
+			WalletMovementRequestedPayload{
+				WalletID:  data.PaymentMethod.WalletID,
+				PaymentID: data.PaymentID,
+				UserID:    data.UserID,
+				Amount:    data.Amount,
+				Reference: fmt.Sprintf("Payment %s", data.PaymentID),
 			},
 		).WithCorrelationID(event.AggregateID)
 
@@ -66,12 +67,11 @@ func (h *PaymentInitiatedHandler) Handle(ctx context.Context, event *events.Even
 	// For non-wallet payments, go directly to gateway processing
 	gatewayEvent := events.NewEvent(
 		event.AggregateID,
-		"gateway.processing.requested",
-		map[string]interface{}{
-			"payment_id": data["payment_id"],
-			"amount":     data["amount"],
-			"currency":   data["currency"],
-			"gateway":    "stripe", // Default gateway
+		GatewayProcessingRequestedEvent,
+		GatewayProcessingRequestedPayload{
+			PaymentID: data.PaymentID,
+			Amount:    data.Amount,
+			Gateway:   "stripe", // Default gateway
 		},
 	).WithCorrelationID(event.AggregateID)
 
@@ -93,18 +93,19 @@ func (h *WalletDebitedHandler) Handle(ctx context.Context, event *events.Event)
 	}
 
 	// Extract data
-	data := event.Data.(map[string]interface{})
-	paymentID := data["payment_id"].(string)
+	data, err := events.DecodePayload[WalletDebitedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode wallet debited payload: %w", err)
+	}
 
 	// Wallet debited successfully, now process with gateway
 	gatewayEvent := events.NewEvent(
-		models.ID(paymentID),
-		"gateway.processing.requested",
-		map[string]interface{}{
-			"payment_id": paymentID,
-			"amount":     data["amount"],
-			"currency":   data["currency"],
-			"gateway":    "stripe",
+		data.PaymentID,
+		GatewayProcessingRequestedEvent,
+		GatewayProcessingRequestedPayload{
+			PaymentID: data.PaymentID,
+			Amount:    data.Amount,
+			Gateway:   "stripe",
 		},
 	).WithCorrelationID(event.CorrelationID)
 
@@ -126,17 +127,19 @@ func (h *InsufficientFundsHandler) Handle(ctx context.Context, event *events.Eve
 	}
 
 	// Extract data
-	data := event.Data.(map[string]interface{})
-	paymentID := data["payment_id"].(string)
+	data, err := events.DecodePayload[InsufficientFundsPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode insufficient funds payload: %w", err)
+	}
 
 	// Fail the payment due to insufficient funds
 	paymentFailEvent := events.NewEvent(
-		models.ID(paymentID),
-		"payment.failure.requested",
-		map[string]interface{}{
-			"payment_id": paymentID,
-			"reason":     "Insufficient funds in wallet",
-			"error_code": "INSUFFICIENT_FUNDS",
+		data.PaymentID,
+		PaymentFailureRequestedEvent,
+		PaymentFailureRequestedPayload{
+			PaymentID: data.PaymentID,
+			Reason:    "Insufficient funds in wallet",
+			ErrorCode: "INSUFFICIENT_FUNDS",
 		},
 	).WithCorrelationID(event.CorrelationID)
 
@@ -153,23 +156,24 @@ func NewGatewayProcessingCompletedHandler(eventPublisher events.Publisher) *Gate
 }
 
 func (h *GatewayProcessingCompletedHandler) Handle(ctx context.Context, event *events.Event) error {
-	if event.EventType != "gateway.processing.completed" {
+	if event.EventType != GatewayProcessingCompletedEvent {
 		return nil
 	}
 
 	// Extract data
-	data := event.Data.(map[string]interface{})
-	paymentID := data["payment_id"].(string)
-	gatewayTransactionID := data["gateway_transaction_id"].(string)
+	data, err := events.DecodePayload[GatewayProcessingCompletedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode gateway processing completed payload: %w", err)
+	}
 
 	// Complete the payment
 	paymentCompleteEvent := events.NewEvent(
-		models.ID(paymentID),
-		"payment.completion.requested",
-		map[string]interface{}{
-			"payment_id":             paymentID,
-			"gateway_transaction_id": gatewayTransactionID,
-			"transaction_id":         models.GenerateUUID().String(),
+		data.PaymentID,
+		PaymentCompletionRequestedEvent,
+		PaymentCompletionRequestedPayload{
+			PaymentID:            data.PaymentID,
+			GatewayTransactionID: data.GatewayTransactionID,
+			TransactionID:        models.GenerateUUID().String(),
 		},
 	).WithCorrelationID(event.CorrelationID)
 
@@ -186,27 +190,27 @@ func NewGatewayProcessingFailedHandler(eventPublisher events.Publisher) *Gateway
 }
 
 func (h *GatewayProcessingFailedHandler) Handle(ctx context.Context, event *events.Event) error {
-	if event.EventType != "gateway.processing.failed" {
+	if event.EventType != GatewayProcessingFailedEvent {
 		return nil
 	}
 
 	// Extract data
-	data := event.Data.(map[string]interface{})
-	paymentID := data["payment_id"].(string)
-	gatewayError := data["error"].(string)
+	data, err := events.DecodePayload[GatewayProcessingFailedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode gateway processing failed payload: %w", err)
+	}
 
 	// Check if we need to compensate wallet debit
-	if walletID, exists := data["wallet_id"]; exists {
+	if data.WalletID != "" {
 		// Compensate wallet debit by crediting back
 		walletCreditEvent := events.NewEvent(
-			models.ID(walletID.(string)),
-			"wallet.credit.requested",
-			map[string]interface{}{
-				"wallet_id":  walletID,
-				"payment_id": paymentID,
-				"amount":     data["amount"],
-				"currency":   data["currency"],
-				"reference":  fmt.Sprintf("Payment %s compensation", paymentID),
+			data.WalletID,
+			events.WalletCreditRequestedEvent,
+			WalletMovementRequestedPayload{
+				WalletID:  data.WalletID,
+				PaymentID: data.PaymentID,
+				Amount:    data.Amount,
+				Reference: fmt.Sprintf("Payment %s compensation", data.PaymentID),
 			},
 		).WithCorrelationID(event.CorrelationID)
 
@@ -217,12 +221,12 @@ func (h *GatewayProcessingFailedHandler) Handle(ctx context.Context, event *even
 
 	// Fail the payment
 	paymentFailEvent := events.NewEvent(
-		models.ID(paymentID),
-		"payment.failure.requested",
-		map[string]interface{}{
-			"payment_id": paymentID,
-			"reason":     fmt.Sprintf("Gateway processing failed: %s", gatewayError),
-			"error_code": "GATEWAY_FAILED",
+		data.PaymentID,
+		PaymentFailureRequestedEvent,
+		PaymentFailureRequestedPayload{
+			PaymentID: data.PaymentID,
+			Reason:    fmt.Sprintf("Gateway processing failed: %s", data.Error),
+			ErrorCode: "GATEWAY_FAILED",
 		},
 	).WithCorrelationID(event.CorrelationID)
 
@@ -241,22 +245,25 @@ func NewPaymentCompletionRequestedHandler(eventPublisher events.Publisher) *Paym
 }
 
 func (h *PaymentCompletionRequestedHandler) Handle(ctx context.Context, event *events.Event) error {
-	if event.EventType != "payment.completion.requested" {
+	if event.EventType != PaymentCompletionRequestedEvent {
 		return nil
 	}
 
 	// Extract data
-	data := event.Data.(map[string]interface{})
+	data, err := events.DecodePayload[PaymentCompletionRequestedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode payment completion requested payload: %w", err)
+	}
 
 	// Publish payment completed event
 	paymentCompletedEvent := events.NewEvent(
 		event.AggregateID,
 		events.PaymentCompletedEvent,
-		map[string]interface{}{
-			"payment_id":             data["payment_id"],
-			"transaction_id":         data["transaction_id"],
-			"gateway_transaction_id": data["gateway_transaction_id"],
-			"completed_at":           fmt.Sprintf("%d", event.Timestamp.Unix()),
+		PaymentCompletedPayload{
+			PaymentID:            data.PaymentID,
+			TransactionID:        data.TransactionID,
+			GatewayTransactionID: data.GatewayTransactionID,
+			CompletedAt:          fmt.Sprintf("%d", event.Timestamp.Unix()),
 		},
 	).WithCorrelationID(event.CorrelationID)
 
@@ -273,22 +280,25 @@ func NewPaymentFailureRequestedHandler(eventPublisher events.Publisher) *Payment
 }
 
 func (h *PaymentFailureRequestedHandler) Handle(ctx context.Context, event *events.Event) error {
-	if event.EventType != "payment.failure.requested" {
+	if event.EventType != PaymentFailureRequestedEvent {
 		return nil
 	}
 
 	// Extract data
-	data := event.Data.(map[string]interface{})
+	data, err := events.DecodePayload[PaymentFailureRequestedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode payment failure requested payload: %w", err)
+	}
 
 	// Publish payment failed event
 	paymentFailedEvent := events.NewEvent(
 		event.AggregateID,
 		events.PaymentFailedEvent,
-		map[string]interface{}{
-			"payment_id": data["payment_id"],
-			"reason":     data["reason"],
-			"error_code": data["error_code"],
-			"failed_at":  fmt.Sprintf("%d", event.Timestamp.Unix()),
+		PaymentFailedPayload{
+			PaymentID: data.PaymentID,
+			Reason:    data.Reason,
+			ErrorCode: data.ErrorCode,
+			FailedAt:  fmt.Sprintf("%d", event.Timestamp.Unix()),
 		},
 	).WithCorrelationID(event.CorrelationID)
 
@@ -328,7 +338,7 @@ func NewWalletCreditRequestedHandler(eventPublisher events.Publisher) *WalletCre
 }
 
 func (h *WalletCreditRequestedHandler) Handle(ctx context.Context, event *events.Event) error {
-	if event.EventType != "wallet.credit.requested" {
+	if event.EventType != events.WalletCreditRequestedEvent {
 		return nil
 	}
 
@@ -340,35 +350,32 @@ func (h *WalletCreditRequestedHandler) Handle(ctx context.Context, event *events
 
 // ChoreographyEventRouter routes events to appropriate handlers
 type ChoreographyEventRouter struct {
-	handlers map[string][]events.EventHandler
+	router *events.Router
 }
 
 // NewChoreographyEventRouter creates a new event router for choreography
 func NewChoreographyEventRouter() *ChoreographyEventRouter {
 	return &ChoreographyEventRouter{
-		handlers: make(map[string][]events.EventHandler),
+		router: events.NewRouter("choreography-event-router"),
 	}
 }
 
-// RegisterHandler registers an event handler for a specific event type
+// RegisterHandler registers an event handler for an event type pattern
 func (r *ChoreographyEventRouter) RegisterHandler(eventType string, handler events.EventHandler) {
-	r.handlers[eventType] = append(r.handlers[eventType], handler)
+	r.router.Register(events.Topic(eventType), handler)
 }
 
 // Route routes an event to all registered handlers
 func (r *ChoreographyEventRouter) Route(ctx context.Context, event *events.Event) error {
-	handlers, exists := r.handlers[event.EventType]
-	if !exists {
+	if !r.router.HasRoute(event) {
 		fmt.Printf("No handlers registered for event type: %s\n", event.EventType)
 		return nil
 	}
 
-	for _, handler := range handlers {
-		if err := handler.Handle(ctx, event); err != nil {
-			fmt.Printf("Handler failed for event %s: %v\n", event.EventType, err)
-			// In a production system, you might want to publish a failure event
-			// or implement retry logic
-		}
+	if err := r.router.Handle(ctx, event); err != nil {
+		fmt.Printf("Handler failed for event %s: %v\n", event.EventType, err)
+		// In a production system, you might want to publish a failure event
+		// or implement retry logic
 	}
 
 	return nil
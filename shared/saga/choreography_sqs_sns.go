@@ -10,7 +10,7 @@ import (
 
 // SQSChoreographyEventRouter routes events to appropriate handlers using SQS/SNS
 type SQSChoreographyEventRouter struct {
-	handlers map[string][]SQSEventHandler
+	router *events.Router
 }
 
 // SQSEventHandler interface for handling events
@@ -34,34 +34,32 @@ func (h *SQSEventHandlerFunc) Handle(ctx context.Context, event *events.Event) e
 // NewSQSChoreographyEventRouter creates a new event router for SQS/SNS choreography
 func NewSQSChoreographyEventRouter() *SQSChoreographyEventRouter {
 	return &SQSChoreographyEventRouter{
-		handlers: make(map[string][]SQSEventHandler),
+		router: events.NewRouter("choreography-event-router"),
 	}
 }
 
-// RegisterHandler registers an event handler for a specific event type
-func (r *SQSChoreographyEventRouter) RegisterHandler(eventType string, handler SQSEventHandler) {
-	r.handlers[eventType] = append(r.handlers[eventType], handler)
+// RegisterHandler registers an event handler for a topic pattern such as
+// "payment.created" or "wallet.#"
+func (r *SQSChoreographyEventRouter) RegisterHandler(pattern string, handler SQSEventHandler) {
+	r.router.Register(events.Topic(pattern), handler)
 }
 
 // HandlerID implements the infrastructure.EventHandler interface
 func (r *SQSChoreographyEventRouter) HandlerID() string {
-	return "choreography-event-router"
+	return r.router.HandlerID()
 }
 
 // Handle implements the infrastructure.EventHandler interface
 func (r *SQSChoreographyEventRouter) Handle(ctx context.Context, event *events.Event) error {
-	handlers, exists := r.handlers[event.Topic.String()]
-	if !exists {
+	if !r.router.HasRoute(event) {
 		fmt.Printf("No handlers registered for event type: %s\n", event.Topic.String())
 		return nil
 	}
 
-	for _, handler := range handlers {
-		if err := handler.Handle(ctx, event); err != nil {
-			fmt.Printf("Handler failed for event %s: %v\n", event.Topic.String(), err)
-			// In a production system, you might want to publish a failure event
-			// or implement retry logic
-		}
+	if err := r.router.Handle(ctx, event); err != nil {
+		fmt.Printf("Handler failed for event %s: %v\n", event.Topic.String(), err)
+		// In a production system, you might want to publish a failure event
+		// or implement retry logic
 	}
 
 	return nil
@@ -84,28 +82,22 @@ func (h *SQSPaymentInitiatedHandler) Handle(ctx context.Context, event *events.E
 	}
 
 	// Extract payment data
-	var data map[string]interface{}
-	if err := event.UnmarshalPayload(&data); err != nil {
-		return fmt.Errorf("failed to unmarshal payload: %w", err)
-	}
-
-	paymentMethod, ok := data["payment_method"].(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("invalid payment method in payload")
+	data, err := events.DecodePayload[PaymentInitiatedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode payment initiated payload: %w", err)
 	}
 
 	// If payment method is wallet, request wallet debit
-	if paymentMethod["type"] == "wallet" {
+	if data.PaymentMethod.Type == "wallet" {
 		walletDebitEvent := events.NewEvent(
-			models.ID(paymentMethod["wallet_id"].(string)),
+			data.PaymentMethod.WalletID,
 			events.WalletDebitRequestedEvent,
-			map[string]interface{}{
-				"wallet_id":  paymentMethod["wallet_id"],
-				"payment_id": data["payment_id"],
-				"user_id":    data["user_id"],
-				"amount":     data["amount"],
-				"currency":   data["currency"],
-				"reference":  fmt.Sprintf("Payment %s", data["payment_id"]),
+			WalletMovementRequestedPayload{
+				WalletID:  data.PaymentMethod.WalletID,
+				PaymentID: data.PaymentID,
+				UserID:    data.UserID,
+				Amount:    data.Amount,
+				Reference: fmt.Sprintf("Payment %s", data.PaymentID),
 			},
 		)
 
@@ -114,13 +106,12 @@ func (h *SQSPaymentInitiatedHandler) Handle(ctx context.Context, event *events.E
 
 	// For non-wallet payments, go directly to gateway processing
 	gatewayEvent := events.NewEvent(
-		models.ID(data["payment_id"].(string)),
-		"gateway.processing.requested",
-		map[string]interface{}{
-			"payment_id": data["payment_id"],
-			"amount":     data["amount"],
-			"currency":   data["currency"],
-			"gateway":    "stripe", // Default gateway
+		data.PaymentID,
+		GatewayProcessingRequestedEvent,
+		GatewayProcessingRequestedPayload{
+			PaymentID: data.PaymentID,
+			Amount:    data.Amount,
+			Gateway:   "stripe", // Default gateway
 		},
 	)
 
@@ -142,25 +133,19 @@ func (h *SQSWalletDebitedHandler) Handle(ctx context.Context, event *events.Even
 	}
 
 	// Extract data
-	var data map[string]interface{}
-	if err := event.UnmarshalPayload(&data); err != nil {
-		return fmt.Errorf("failed to unmarshal payload: %w", err)
-	}
-
-	paymentID, ok := data["payment_id"].(string)
-	if !ok {
-		return fmt.Errorf("payment_id not found in payload")
+	data, err := events.DecodePayload[WalletDebitedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode wallet debited payload: %w", err)
 	}
 
 	// Wallet debited successfully, now process with gateway
 	gatewayEvent := events.NewEvent(
-		models.ID(paymentID),
-		"gateway.processing.requested",
-		map[string]interface{}{
-			"payment_id": paymentID,
-			"amount":     data["amount"],
-			"currency":   data["currency"],
-			"gateway":    "stripe",
+		data.PaymentID,
+		GatewayProcessingRequestedEvent,
+		GatewayProcessingRequestedPayload{
+			PaymentID: data.PaymentID,
+			Amount:    data.Amount,
+			Gateway:   "stripe",
 		},
 	)
 
@@ -182,24 +167,19 @@ func (h *SQSInsufficientFundsHandler) Handle(ctx context.Context, event *events.
 	}
 
 	// Extract data
-	var data map[string]interface{}
-	if err := event.UnmarshalPayload(&data); err != nil {
-		return fmt.Errorf("failed to unmarshal payload: %w", err)
-	}
-
-	paymentID, ok := data["payment_id"].(string)
-	if !ok {
-		return fmt.Errorf("payment_id not found in payload")
+	data, err := events.DecodePayload[InsufficientFundsPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode insufficient funds payload: %w", err)
 	}
 
 	// Fail the payment due to insufficient funds
 	paymentFailEvent := events.NewEvent(
-		models.ID(paymentID),
-		"payment.failure.requested",
-		map[string]interface{}{
-			"payment_id": paymentID,
-			"reason":     "Insufficient funds in wallet",
-			"error_code": "INSUFFICIENT_FUNDS",
+		data.PaymentID,
+		PaymentFailureRequestedEvent,
+		PaymentFailureRequestedPayload{
+			PaymentID: data.PaymentID,
+			Reason:    "Insufficient funds in wallet",
+			ErrorCode: "INSUFFICIENT_FUNDS",
 		},
 	)
 
@@ -216,34 +196,29 @@ func NewSQSGatewayProcessingCompletedHandler(eventPublisher events.Publisher) *S
 }
 
 func (h *SQSGatewayProcessingCompletedHandler) Handle(ctx context.Context, event *events.Event) error {
-	if event.Topic.String() != "gateway.processing.completed" {
+	if event.Topic.String() != GatewayProcessingCompletedEvent {
 		return nil
 	}
 
 	// Extract data
-	var data map[string]interface{}
-	if err := event.UnmarshalPayload(&data); err != nil {
-		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	data, err := events.DecodePayload[GatewayProcessingCompletedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode gateway processing completed payload: %w", err)
 	}
 
-	paymentID, ok := data["payment_id"].(string)
-	if !ok {
-		return fmt.Errorf("payment_id not found in payload")
-	}
-
-	gatewayTransactionID, ok := data["gateway_transaction_id"].(string)
-	if !ok {
+	gatewayTransactionID := data.GatewayTransactionID
+	if gatewayTransactionID == "" {
 		gatewayTransactionID = models.GenerateUUID().String()
 	}
 
 	// Complete the payment
 	paymentCompleteEvent := events.NewEvent(
-		models.ID(paymentID),
-		"payment.completion.requested",
-		map[string]interface{}{
-			"payment_id":             paymentID,
-			"gateway_transaction_id": gatewayTransactionID,
-			"transaction_id":         models.GenerateUUID().String(),
+		data.PaymentID,
+		PaymentCompletionRequestedEvent,
+		PaymentCompletionRequestedPayload{
+			PaymentID:            data.PaymentID,
+			GatewayTransactionID: gatewayTransactionID,
+			TransactionID:        models.GenerateUUID().String(),
 		},
 	)
 
@@ -260,38 +235,32 @@ func NewSQSGatewayProcessingFailedHandler(eventPublisher events.Publisher) *SQSG
 }
 
 func (h *SQSGatewayProcessingFailedHandler) Handle(ctx context.Context, event *events.Event) error {
-	if event.Topic.String() != "gateway.processing.failed" {
+	if event.Topic.String() != GatewayProcessingFailedEvent {
 		return nil
 	}
 
 	// Extract data
-	var data map[string]interface{}
-	if err := event.UnmarshalPayload(&data); err != nil {
-		return fmt.Errorf("failed to unmarshal payload: %w", err)
-	}
-
-	paymentID, ok := data["payment_id"].(string)
-	if !ok {
-		return fmt.Errorf("payment_id not found in payload")
+	data, err := events.DecodePayload[GatewayProcessingFailedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode gateway processing failed payload: %w", err)
 	}
 
-	gatewayError, ok := data["error"].(string)
-	if !ok {
+	gatewayError := data.Error
+	if gatewayError == "" {
 		gatewayError = "Unknown gateway error"
 	}
 
 	// Check if we need to compensate wallet debit
-	if walletID, exists := data["wallet_id"]; exists {
+	if data.WalletID != "" {
 		// Compensate wallet debit by crediting back
 		walletCreditEvent := events.NewEvent(
-			models.ID(walletID.(string)),
-			"wallet.credit.requested",
-			map[string]interface{}{
-				"wallet_id":  walletID,
-				"payment_id": paymentID,
-				"amount":     data["amount"],
-				"currency":   data["currency"],
-				"reference":  fmt.Sprintf("Payment %s compensation", paymentID),
+			data.WalletID,
+			events.WalletCreditRequestedEvent,
+			WalletMovementRequestedPayload{
+				WalletID:  data.WalletID,
+				PaymentID: data.PaymentID,
+				Amount:    data.Amount,
+				Reference: fmt.Sprintf("Payment %s compensation", data.PaymentID),
 			},
 		)
 
@@ -302,12 +271,12 @@ func (h *SQSGatewayProcessingFailedHandler) Handle(ctx context.Context, event *e
 
 	// Fail the payment
 	paymentFailEvent := events.NewEvent(
-		models.ID(paymentID),
-		"payment.failure.requested",
-		map[string]interface{}{
-			"payment_id": paymentID,
-			"reason":     fmt.Sprintf("Gateway processing failed: %s", gatewayError),
-			"error_code": "GATEWAY_FAILED",
+		data.PaymentID,
+		PaymentFailureRequestedEvent,
+		PaymentFailureRequestedPayload{
+			PaymentID: data.PaymentID,
+			Reason:    fmt.Sprintf("Gateway processing failed: %s", gatewayError),
+			ErrorCode: "GATEWAY_FAILED",
 		},
 	)
 
@@ -324,21 +293,16 @@ func NewMockGatewayService(eventPublisher events.Publisher) *MockGatewayService
 }
 
 func (s *MockGatewayService) Handle(ctx context.Context, event *events.Event) error {
-	if event.Topic.String() != "gateway.processing.requested" {
+	if event.Topic.String() != GatewayProcessingRequestedEvent {
 		return nil
 	}
 
 	fmt.Printf("Mock Gateway: Processing payment request: %+v\n", event)
 
 	// Extract data
-	var data map[string]interface{}
-	if err := event.UnmarshalPayload(&data); err != nil {
-		return fmt.Errorf("failed to unmarshal payload: %w", err)
-	}
-
-	paymentID, ok := data["payment_id"].(string)
-	if !ok {
-		return fmt.Errorf("payment_id not found in payload")
+	data, err := events.DecodePayload[GatewayProcessingRequestedPayload](payloadRegistry, event)
+	if err != nil {
+		return fmt.Errorf("failed to decode gateway processing requested payload: %w", err)
 	}
 
 	// Simulate processing (90% success rate)
@@ -347,16 +311,16 @@ func (s *MockGatewayService) Handle(ctx context.Context, event *events.Event) er
 
 	// Simulate success for demo
 	gatewayCompleteEvent := events.NewEvent(
-		models.ID(paymentID),
-		"gateway.processing.completed",
-		map[string]interface{}{
-			"payment_id":             paymentID,
-			"gateway_transaction_id": gatewayTransactionID,
-			"gateway":                "stripe",
-			"status":                 "success",
+		data.PaymentID,
+		GatewayProcessingCompletedEvent,
+		GatewayProcessingCompletedPayload{
+			PaymentID:            data.PaymentID,
+			GatewayTransactionID: gatewayTransactionID,
+			Gateway:              data.Gateway,
+			Status:               "success",
 		},
 	)
 
-	fmt.Printf("Mock Gateway: Payment processed successfully: %s\n", paymentID)
+	fmt.Printf("Mock Gateway: Payment processed successfully: %s\n", data.PaymentID)
 	return s.eventPublisher.Publish(ctx, gatewayCompleteEvent)
 }
@@ -0,0 +1,245 @@
+package saga
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// walletChoreography debits a wallet, and credits it back when completing
+// the payment fails
+func walletChoreography() *Choreography {
+	c := NewChoreography("wallet payment", events.PaymentCreatedEvent)
+	c.Step("request debit", ParticipantPayments).
+		On(events.PaymentCreatedEvent).
+		Emits(events.WalletDebitRequestedEvent).
+		WithoutCompensation("only requests the debit")
+	c.Step("debit wallet", ParticipantWallet).
+		On(events.WalletDebitRequestedEvent).
+		Emits(events.WalletDebitedEvent).
+		FailsWith(events.InsufficientFundsEvent).
+		CompensatedBy(events.WalletCreditRequestedEvent)
+	c.Step("complete payment", ParticipantPayments).
+		On(events.WalletDebitedEvent).
+		Emits(events.PaymentCompletedEvent).
+		FailsWith(events.WalletCreditRequestedEvent).
+		AsPivot()
+	c.Step("credit wallet", ParticipantWallet).
+		On(events.WalletCreditRequestedEvent).
+		Emits(events.WalletCreditedEvent).
+		Compensates("debit wallet")
+	return c
+}
+
+func TestChoreography_Validate(t *testing.T) {
+	tests := []struct {
+		name             string
+		modify           func(c *Choreography)
+		expectedErr      error
+		expectedMessages []string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *Choreography) {},
+		},
+		{
+			name: "unreachable step",
+			modify: func(c *Choreography) {
+				c.Step("freeze wallet", ParticipantWallet).
+					On(events.WalletFrozenEvent).
+					Emits(events.WalletUnfrozenEvent)
+			},
+			expectedErr: ErrUnreachableStep,
+			expectedMessages: []string{
+				`unreachable step: step "freeze wallet" is triggered by wallet.frozen, which no reachable step publishes`,
+			},
+		},
+		{
+			name: "scheduled steps are reachable",
+			modify: func(c *Choreography) {
+				c.Steps[2].Failures = nil
+				c.Step("detect stuck payment", ParticipantPayments).
+					OnSchedule().
+					Emits(events.WalletCreditRequestedEvent).
+					WithoutCompensation("only reports the payment")
+			},
+		},
+		{
+			name: "step without compensation",
+			modify: func(c *Choreography) {
+				c.Steps[1].CompensationTopic = ""
+			},
+			expectedErr: ErrMissingCompensation,
+			expectedMessages: []string{
+				`missing compensation: step "debit wallet" has no compensation, but "complete payment" can fail after it`,
+			},
+		},
+		{
+			name: "compensation nobody handles",
+			modify: func(c *Choreography) {
+				c.Steps[3].Undoes = ""
+			},
+			expectedErr: ErrMissingCompensation,
+			expectedMessages: []string{
+				`missing compensation: step "debit wallet" is compensated by wallet.credit.requested, which no step compensating it consumes`,
+			},
+		},
+		{
+			name: "compensation never requested",
+			modify: func(c *Choreography) {
+				c.Steps[2].Failures = []events.Topic{events.PaymentFailedEvent}
+			},
+			expectedErr: ErrMissingCompensation,
+			expectedMessages: []string{
+				`missing compensation: step "debit wallet" is compensated by wallet.credit.requested, which no reachable step publishes`,
+			},
+		},
+		{
+			name: "failures after the pivot need no compensation",
+			modify: func(c *Choreography) {
+				c.Step("record completion", ParticipantPayments).
+					On(events.PaymentCompletedEvent).
+					Emits(events.PaymentRefundInitiatedEvent)
+				c.Step("refund", ParticipantPayments).
+					On(events.PaymentRefundInitiatedEvent).
+					FailsWith(events.PaymentRefundFailedEvent)
+			},
+		},
+		{
+			name: "cycle",
+			modify: func(c *Choreography) {
+				c.Step("retry payment", ParticipantPayments).
+					On(events.WalletCreditedEvent).
+					Emits(events.PaymentCreatedEvent).
+					WithoutCompensation("only retries")
+			},
+			expectedErr: ErrChoreographyCycle,
+			expectedMessages: []string{
+				`choreography cycle: request debit -> debit wallet -> complete payment -> credit wallet -> retry payment -> request debit`,
+			},
+		},
+		{
+			name: "invalid declaration",
+			modify: func(c *Choreography) {
+				c.Step("debit wallet", ParticipantWallet).On(events.PaymentCreatedEvent)
+				c.Step("idle", ParticipantPayments)
+				c.Step("undo", ParticipantPayments).On(events.PaymentCompletedEvent).Compensates("missing")
+			},
+			expectedErr: ErrInvalidChoreography,
+			expectedMessages: []string{
+				`invalid choreography: step "debit wallet" declared twice`,
+				`invalid choreography: step "idle" has no trigger`,
+				`invalid choreography: step "undo" compensates unknown step "missing"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := walletChoreography()
+			tt.modify(c)
+
+			err := c.Validate()
+			if tt.expectedErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *ValidationError
+			require.True(t, errors.As(err, &validationErr), "expected a ValidationError, got %v", err)
+			assert.Equal(t, "wallet payment", validationErr.Choreography)
+			assert.ErrorIs(t, err, tt.expectedErr)
+
+			messages := make([]string, len(validationErr.Problems))
+			for i, problem := range validationErr.Problems {
+				messages[i] = problem.Error()
+			}
+			for _, expected := range tt.expectedMessages {
+				assert.Contains(t, messages, expected)
+			}
+		})
+	}
+}
+
+func TestChoreographies_Validate(t *testing.T) {
+	for _, c := range Choreographies() {
+		t.Run(c.Name, func(t *testing.T) {
+			assert.NoError(t, c.Validate())
+		})
+	}
+}
+
+func TestChoreographies_TrackedBySagaLog(t *testing.T) {
+	definitions := make(map[SagaType]Definition)
+	for _, definition := range Definitions() {
+		definitions[definition.Type] = definition
+	}
+
+	for _, c := range Choreographies() {
+		t.Run(c.Name, func(t *testing.T) {
+			definition, ok := definitions[SagaType(c.Name)]
+			require.True(t, ok, "no saga definition for %s", c.Name)
+			assert.Equal(t, definition.Start, c.Start)
+
+			for _, topic := range c.Topics() {
+				assert.Contains(t, definition.Steps, topic, "the %s saga log does not track %s", c.Name, topic)
+			}
+		})
+	}
+}
+
+func TestChoreography_Mermaid(t *testing.T) {
+	c := walletChoreography()
+	c.Step("detect stuck payment", ParticipantPayments).
+		OnSchedule().
+		Emits(events.WalletCreditRequestedEvent)
+
+	assert.Equal(t, `flowchart TD
+    start(["payment.created"])
+    schedule(("schedule"))
+    s0["request debit<br/><i>payments-service</i>"]
+    s1["debit wallet<br/><i>wallet-service</i>"]
+    s2{{"complete payment<br/><i>payments-service</i>"}}
+    s3["credit wallet<br/><i>wallet-service</i>"]
+    s4["detect stuck payment<br/><i>payments-service</i>"]
+    e0(["wallet.insufficient.funds"])
+    e1(["payment.completed"])
+    e2(["wallet.credited"])
+    start -->|payment.created| s0
+    s0 -->|wallet.debit.requested| s1
+    s1 -->|wallet.debited| s2
+    s1 ==>|wallet.insufficient.funds| e0
+    s2 -->|payment.completed| e1
+    s2 -.->|wallet.credit.requested| s3
+    s3 -->|wallet.credited| e2
+    schedule --> s4
+    s4 -.->|wallet.credit.requested| s3
+    classDef compensation stroke-dasharray: 5 5
+    class s3 compensation
+`, c.Mermaid())
+}
+
+func TestChoreography_DOT(t *testing.T) {
+	assert.Equal(t, `digraph "wallet payment" {
+    node [shape=box];
+    start [label="payment.created", shape=oval];
+    s0 [label="request debit\npayments-service"];
+    s1 [label="debit wallet\nwallet-service"];
+    s2 [label="complete payment\npayments-service", shape=hexagon];
+    s3 [label="credit wallet\nwallet-service", style=dashed];
+    e0 [label="wallet.insufficient.funds", shape=oval];
+    e1 [label="payment.completed", shape=oval];
+    e2 [label="wallet.credited", shape=oval];
+    start -> s0 [label="payment.created"];
+    s0 -> s1 [label="wallet.debit.requested"];
+    s1 -> s2 [label="wallet.debited"];
+    s1 -> e0 [label="wallet.insufficient.funds", color=red];
+    s2 -> e1 [label="payment.completed"];
+    s2 -> s3 [label="wallet.credit.requested", style=dashed];
+    s3 -> e2 [label="wallet.credited"];
+}
+`, walletChoreography().DOT())
+}
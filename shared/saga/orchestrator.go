@@ -0,0 +1,598 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+)
+
+var (
+	// ErrUnknownOrchestration is returned when starting a saga type the
+	// orchestrator has no orchestration for
+	ErrUnknownOrchestration = errors.New("unknown orchestration")
+	// ErrSagaExists is returned by an OrchestrationStore creating a saga
+	// whose ID is taken
+	ErrSagaExists = errors.New("saga already exists")
+	// ErrSagaConflict is returned by an OrchestrationStore updating a saga
+	// that changed since it was read
+	ErrSagaConflict = errors.New("saga changed concurrently")
+)
+
+const (
+	// DefaultStepTimeout is how long a step without a Timeout awaits its reply
+	DefaultStepTimeout = 5 * time.Minute
+	// DefaultCompensationAttempts is how many times a compensation without
+	// MaxAttempts is issued before the saga is failed
+	DefaultCompensationAttempts = 3
+)
+
+// Command is the event a step publishes to ask a participant to act
+type Command struct {
+	Event *events.Event
+	// ReplyKey identifies the replies to the command when they do not carry
+	// the saga's correlation ID; it must match what the step's ReplyKey
+	// returns for them
+	ReplyKey string
+}
+
+// OrchestratedStep is a command the orchestrator issues and the replies it
+// awaits. Replies are matched by the saga's correlation ID and the command's
+// ID as their causation ID, or by ReplyKey for participants that reply
+// outside the saga's causal chain.
+type OrchestratedStep struct {
+	Name string
+	// Command builds the command from the saga's state. A nil command skips
+	// the step, or the compensation when there is nothing to undo.
+	Command func(s *OrchestratedSaga) (*Command, error)
+	// Success and Failure are the reply topics
+	Success []events.Topic
+	Failure []events.Topic
+	// ReplyKey returns the key of a reply, when it is one to this step
+	ReplyKey func(reply *events.Event) (string, bool)
+	// OnReply records what a successful reply carries in the saga's data
+	OnReply func(s *OrchestratedSaga, reply *events.Event) error
+	// Timeout is how long the reply is awaited
+	Timeout time.Duration
+	// OnTimeout builds the event raising the step for review when its reply
+	// is overdue, for steps a participant may still carry out. The saga then
+	// keeps awaiting the reply without a deadline instead of failing the
+	// step. It does not apply to compensations.
+	OnTimeout func(s *OrchestratedSaga) (*events.Event, error)
+	// MaxAttempts bounds how many times a compensation is issued
+	MaxAttempts int
+	// Compensation undoes the step when a later one fails
+	Compensation *OrchestratedStep
+}
+
+func (s *OrchestratedStep) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultStepTimeout
+}
+
+func (s *OrchestratedStep) maxAttempts() int {
+	if s.MaxAttempts > 0 {
+		return s.MaxAttempts
+	}
+	return DefaultCompensationAttempts
+}
+
+// awaits reports whether topic is a reply to the step, and a successful one
+func (s *OrchestratedStep) awaits(topic events.Topic) (awaited, success bool) {
+	for _, t := range s.Success {
+		if t == topic {
+			return true, true
+		}
+	}
+	for _, t := range s.Failure {
+		if t == topic {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// Orchestration declares the steps of an orchestrated saga type, run in
+// order and compensated in reverse order
+type Orchestration struct {
+	Type  SagaType
+	Steps []*OrchestratedStep
+	// Finish builds the event announcing the saga's outcome once it is
+	// completed, compensated or failed; nil publishes nothing
+	Finish func(s *OrchestratedSaga) (*events.Event, error)
+}
+
+// OrchestratedSaga is the persisted state of an orchestrated saga. Its ID is
+// the correlation ID of every command it issues.
+type OrchestratedSaga struct {
+	ID          models.ID
+	Type        SagaType
+	AggregateID models.ID
+	Status      SagaStatus
+	// Step is the index of the step awaiting its reply or, while
+	// compensating, of the step whose compensation is awaited
+	Step int
+	// Attempt counts the commands issued for the awaited step
+	Attempt int
+	// CommandID is the command awaiting its reply
+	CommandID models.ID
+	// ReplyKey finds the saga from the replies to the command
+	ReplyKey string
+	// Deadline is when the reply times out; nil once the saga ends or its
+	// step was raised on timeout
+	Deadline *time.Time
+	// Completed lists the steps whose success reply arrived, the ones
+	// compensated on failure
+	Completed []int
+	// Compensations counts the steps undone
+	Compensations int
+	FailureReason string
+	// Data is the saga's state as its steps see it
+	Data      json.RawMessage
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// completed reports whether the step at index i succeeded
+func (s *OrchestratedSaga) completed(i int) bool {
+	for _, step := range s.Completed {
+		if step == i {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode unmarshals the saga's data into v
+func (s *OrchestratedSaga) Decode(v interface{}) error {
+	if err := json.Unmarshal(s.Data, v); err != nil {
+		return fmt.Errorf("failed to decode data of saga %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// Encode replaces the saga's data with v
+func (s *OrchestratedSaga) Encode(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode data of saga %s: %w", s.ID, err)
+	}
+	s.Data = data
+	return nil
+}
+
+// OrchestrationStore persists orchestrated sagas. Writes join the
+// transaction in ctx, if any, so commands are published with the state that
+// awaits their replies.
+type OrchestrationStore interface {
+	// Create saves a new saga or returns ErrSagaExists
+	Create(ctx context.Context, s *OrchestratedSaga) error
+	// Get returns the saga or ErrSagaNotFound
+	Get(ctx context.Context, id models.ID) (*OrchestratedSaga, error)
+	// FindAwaiting returns the oldest saga awaiting a reply under replyKey,
+	// with or without a deadline, or ErrSagaNotFound
+	FindAwaiting(ctx context.Context, replyKey string) (*OrchestratedSaga, error)
+	// FindExpired returns up to limit sagas whose reply deadline passed at
+	// now, earliest deadline first
+	FindExpired(ctx context.Context, now time.Time, limit int) ([]*OrchestratedSaga, error)
+	// Update saves s if it is still at s.Version and increments the version,
+	// or returns ErrSagaConflict
+	Update(ctx context.Context, s *OrchestratedSaga) error
+}
+
+// Orchestrator runs orchestrated sagas: it issues the command of each step,
+// moves on when the reply arrives and, when a step fails or times out,
+// issues the compensations of the completed steps in reverse order. It is
+// the event handler of the reply topics.
+type Orchestrator struct {
+	store          OrchestrationStore
+	publisher      events.Publisher
+	orchestrations map[SagaType]*Orchestration
+	now            func() time.Time
+}
+
+// NewOrchestrator creates an Orchestrator running orchestrations, keeping
+// their state in store and publishing commands through publisher
+func NewOrchestrator(store OrchestrationStore, publisher events.Publisher, orchestrations ...*Orchestration) *Orchestrator {
+	byType := make(map[SagaType]*Orchestration, len(orchestrations))
+	for _, orchestration := range orchestrations {
+		byType[orchestration.Type] = orchestration
+	}
+
+	return &Orchestrator{
+		store:          store,
+		publisher:      publisher,
+		orchestrations: byType,
+		now:            time.Now,
+	}
+}
+
+// HandlerID returns the unique identifier for this event handler
+func (o *Orchestrator) HandlerID() string {
+	return "saga-orchestrator"
+}
+
+// Topics returns the reply topics of every step and compensation
+func (o *Orchestrator) Topics() []events.Topic {
+	seen := make(map[events.Topic]bool)
+	var topics []events.Topic
+	add := func(step *OrchestratedStep) {
+		for _, topic := range append(append([]events.Topic(nil), step.Success...), step.Failure...) {
+			if !seen[topic] {
+				seen[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+
+	for _, orchestration := range o.orchestrations {
+		for _, step := range orchestration.Steps {
+			add(step)
+			if step.Compensation != nil {
+				add(step.Compensation)
+			}
+		}
+	}
+
+	sort.Slice(topics, func(i, j int) bool { return topics[i] < topics[j] })
+	return topics
+}
+
+// Start creates a saga of sagaType with id and data and issues the command
+// of its first step. Starting a saga whose ID is taken returns ErrSagaExists,
+// so redelivered requests start it once.
+func (o *Orchestrator) Start(ctx context.Context, sagaType SagaType, id, aggregateID models.ID, data interface{}) (*OrchestratedSaga, error) {
+	orchestration, ok := o.orchestrations[sagaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownOrchestration, sagaType)
+	}
+
+	now := o.now()
+	s := &OrchestratedSaga{
+		ID:          id,
+		Type:        sagaType,
+		AggregateID: aggregateID,
+		Status:      SagaStatusStarted,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.Encode(data); err != nil {
+		return nil, err
+	}
+
+	commands, err := o.forward(orchestration, s, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// The saga is saved before its command is published so the reply
+	// always finds it
+	if err := o.store.Create(ctx, s); err != nil {
+		return nil, fmt.Errorf("failed to create %s saga %s: %w", sagaType, id, err)
+	}
+
+	if err := o.publish(ctx, commands); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Handle advances the saga awaiting the reply. Replies no saga awaits, such
+// as replies to a step that failed on timeout, are ignored.
+func (o *Orchestrator) Handle(ctx context.Context, reply *events.Event) error {
+	s, orchestration, step, err := o.awaiting(ctx, reply)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return nil
+	}
+
+	_, success := step.awaits(reply.Topic)
+
+	var commands []*events.Event
+	switch {
+	case success:
+		if step.OnReply != nil {
+			if err := step.OnReply(s, reply); err != nil {
+				return fmt.Errorf("failed to record reply %s of step %q: %w", reply.Topic, step.Name, err)
+			}
+		}
+		commands, err = o.succeed(orchestration, s)
+	default:
+		reason := failureReason(reply)
+		if reason == "" {
+			reason = reply.Topic.String()
+		}
+		commands, err = o.fail(orchestration, s, fmt.Sprintf("step %q failed: %s", step.Name, reason))
+	}
+	if err != nil {
+		return err
+	}
+
+	return o.save(ctx, s, commands)
+}
+
+// ExpireTimeouts fails the awaited step of up to limit sagas whose reply is
+// overdue at now, or raises it when the step has OnTimeout, and returns them.
+// Sagas that a reply advanced in the meantime are skipped.
+func (o *Orchestrator) ExpireTimeouts(ctx context.Context, now time.Time, limit int) ([]*OrchestratedSaga, error) {
+	sagas, err := o.store.FindExpired(ctx, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired sagas: %w", err)
+	}
+
+	var expired []*OrchestratedSaga
+	for _, s := range sagas {
+		orchestration, ok := o.orchestrations[s.Type]
+		if !ok {
+			continue
+		}
+
+		step := o.current(orchestration, s)
+		if step == nil {
+			continue
+		}
+
+		var commands []*events.Event
+		if step.OnTimeout != nil && s.Status != SagaStatusCompensating {
+			commands, err = o.raise(s, step)
+		} else {
+			commands, err = o.fail(orchestration, s, fmt.Sprintf("step %q timed out after %s", step.Name, step.timeout()))
+		}
+		if err != nil {
+			return expired, err
+		}
+
+		if err := o.save(ctx, s, commands); err != nil {
+			if errors.Is(err, ErrSagaConflict) {
+				continue
+			}
+			return expired, err
+		}
+		expired = append(expired, s)
+	}
+
+	return expired, nil
+}
+
+// awaiting returns the saga awaiting reply, with its orchestration and the
+// step the reply answers, or a nil saga when none does
+func (o *Orchestrator) awaiting(ctx context.Context, reply *events.Event) (*OrchestratedSaga, *Orchestration, *OrchestratedStep, error) {
+	var keys []string
+	if reply.CorrelationID != "" {
+		keys = append(keys, reply.CorrelationID.String())
+	}
+	for _, orchestration := range o.orchestrations {
+		for _, step := range orchestration.Steps {
+			for _, candidate := range []*OrchestratedStep{step, step.Compensation} {
+				if candidate == nil || candidate.ReplyKey == nil {
+					continue
+				}
+				if awaited, _ := candidate.awaits(reply.Topic); !awaited {
+					continue
+				}
+				if key, ok := candidate.ReplyKey(reply); ok {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+
+	for _, key := range keys {
+		s, err := o.store.FindAwaiting(ctx, key)
+		if errors.Is(err, ErrSagaNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to find saga awaiting %s: %w", key, err)
+		}
+
+		orchestration, ok := o.orchestrations[s.Type]
+		if !ok {
+			continue
+		}
+
+		step := o.current(orchestration, s)
+		if step == nil {
+			continue
+		}
+		if awaited, _ := step.awaits(reply.Topic); !awaited {
+			continue
+		}
+		// Replies in the saga's causal chain answer the latest command only
+		if step.ReplyKey == nil && reply.CausationID != s.CommandID {
+			continue
+		}
+
+		return s, orchestration, step, nil
+	}
+
+	return nil, nil, nil, nil
+}
+
+// current returns the step or compensation the saga awaits
+func (o *Orchestrator) current(orchestration *Orchestration, s *OrchestratedSaga) *OrchestratedStep {
+	if s.Status.IsTerminal() || s.Step < 0 || s.Step >= len(orchestration.Steps) {
+		return nil
+	}
+
+	step := orchestration.Steps[s.Step]
+	if s.Status == SagaStatusCompensating {
+		return step.Compensation
+	}
+	return step
+}
+
+// succeed moves the saga past the awaited step or compensation
+func (o *Orchestrator) succeed(orchestration *Orchestration, s *OrchestratedSaga) ([]*events.Event, error) {
+	if s.Status == SagaStatusCompensating {
+		s.Compensations++
+		return o.compensate(orchestration, s, s.Step-1)
+	}
+	s.Completed = append(s.Completed, s.Step)
+	return o.forward(orchestration, s, s.Step+1)
+}
+
+// fail compensates the steps before a failed step. A failed compensation is
+// issued again until it runs out of attempts, and then the saga fails with
+// the remaining compensations left for manual review.
+func (o *Orchestrator) fail(orchestration *Orchestration, s *OrchestratedSaga, reason string) ([]*events.Event, error) {
+	if s.Status != SagaStatusCompensating {
+		s.FailureReason = reason
+		return o.compensate(orchestration, s, s.Step-1)
+	}
+
+	compensation := orchestration.Steps[s.Step].Compensation
+	if s.Attempt >= compensation.maxAttempts() {
+		s.FailureReason = fmt.Sprintf("%s; compensation gave up after %d attempts: %s", s.FailureReason, s.Attempt, reason)
+		return o.finish(orchestration, s, SagaStatusFailed)
+	}
+
+	command, err := compensation.Command(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build command of compensation %q: %w", compensation.Name, err)
+	}
+	if command == nil {
+		return o.compensate(orchestration, s, s.Step-1)
+	}
+
+	s.Attempt++
+	return []*events.Event{o.await(s, compensation, command)}, nil
+}
+
+// raise leaves the saga awaiting the reply of a step that timed out, without
+// a deadline, and returns the event raising the step
+func (o *Orchestrator) raise(s *OrchestratedSaga, step *OrchestratedStep) ([]*events.Event, error) {
+	event, err := step.OnTimeout(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to raise timed out step %q: %w", step.Name, err)
+	}
+
+	s.Deadline = nil
+	if event == nil {
+		return nil, nil
+	}
+	return []*events.Event{event.WithCorrelationID(s.ID)}, nil
+}
+
+// forward issues the command of the first step from index from that has
+// one, or completes the saga when none is left
+func (o *Orchestrator) forward(orchestration *Orchestration, s *OrchestratedSaga, from int) ([]*events.Event, error) {
+	for i := from; i < len(orchestration.Steps); i++ {
+		step := orchestration.Steps[i]
+		command, err := step.Command(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build command of step %q: %w", step.Name, err)
+		}
+		if command == nil {
+			continue
+		}
+
+		s.Status = SagaStatusInProgress
+		s.Step = i
+		s.Attempt = 1
+		return []*events.Event{o.await(s, step, command)}, nil
+	}
+
+	s.Step = len(orchestration.Steps)
+	return o.finish(orchestration, s, SagaStatusCompleted)
+}
+
+// compensate issues the compensation of the latest completed step from
+// index from down that has one, or ends the saga when none is left:
+// compensated if it undid any step, failed otherwise
+func (o *Orchestrator) compensate(orchestration *Orchestration, s *OrchestratedSaga, from int) ([]*events.Event, error) {
+	for i := from; i >= 0; i-- {
+		compensation := orchestration.Steps[i].Compensation
+		if compensation == nil || !s.completed(i) {
+			continue
+		}
+
+		command, err := compensation.Command(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build command of compensation %q: %w", compensation.Name, err)
+		}
+		if command == nil {
+			continue
+		}
+
+		s.Status = SagaStatusCompensating
+		s.Step = i
+		s.Attempt = 1
+		return []*events.Event{o.await(s, compensation, command)}, nil
+	}
+
+	if s.Compensations > 0 {
+		return o.finish(orchestration, s, SagaStatusCompensated)
+	}
+	return o.finish(orchestration, s, SagaStatusFailed)
+}
+
+// await makes the saga await the reply to command and returns its event,
+// correlated to the saga
+func (o *Orchestrator) await(s *OrchestratedSaga, step *OrchestratedStep, command *Command) *events.Event {
+	event := command.Event.WithCorrelationID(s.ID)
+
+	s.CommandID = event.ID
+	s.ReplyKey = command.ReplyKey
+	if s.ReplyKey == "" {
+		s.ReplyKey = s.ID.String()
+	}
+	deadline := o.now().Add(step.timeout())
+	s.Deadline = &deadline
+
+	return event
+}
+
+// finish ends the saga with status and returns the event announcing it
+func (o *Orchestrator) finish(orchestration *Orchestration, s *OrchestratedSaga, status SagaStatus) ([]*events.Event, error) {
+	s.Status = status
+	s.CommandID = ""
+	s.ReplyKey = ""
+	s.Deadline = nil
+
+	if orchestration.Finish == nil {
+		return nil, nil
+	}
+
+	event, err := orchestration.Finish(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build outcome of %s saga %s: %w", s.Type, s.ID, err)
+	}
+	if event == nil {
+		return nil, nil
+	}
+
+	return []*events.Event{event.WithCorrelationID(s.ID)}, nil
+}
+
+// save updates the saga and then publishes the events it issued
+func (o *Orchestrator) save(ctx context.Context, s *OrchestratedSaga, evts []*events.Event) error {
+	s.UpdatedAt = o.now()
+	if err := o.store.Update(ctx, s); err != nil {
+		return fmt.Errorf("failed to update %s saga %s: %w", s.Type, s.ID, err)
+	}
+
+	return o.publish(ctx, evts)
+}
+
+func (o *Orchestrator) publish(ctx context.Context, evts []*events.Event) error {
+	if len(evts) == 0 {
+		return nil
+	}
+
+	if err := o.publisher.Publish(ctx, evts...); err != nil {
+		return fmt.Errorf("failed to publish saga commands: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,479 @@
+package saga
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memOrchestrationStore keeps orchestrated sagas in memory
+type memOrchestrationStore struct {
+	mux   sync.Mutex
+	sagas map[models.ID]OrchestratedSaga
+}
+
+func newMemOrchestrationStore() *memOrchestrationStore {
+	return &memOrchestrationStore{sagas: make(map[models.ID]OrchestratedSaga)}
+}
+
+func (s *memOrchestrationStore) Create(ctx context.Context, saga *OrchestratedSaga) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.sagas[saga.ID]; ok {
+		return ErrSagaExists
+	}
+	s.sagas[saga.ID] = *saga
+	return nil
+}
+
+func (s *memOrchestrationStore) Get(ctx context.Context, id models.ID) (*OrchestratedSaga, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	saga, ok := s.sagas[id]
+	if !ok {
+		return nil, ErrSagaNotFound
+	}
+	return &saga, nil
+}
+
+func (s *memOrchestrationStore) FindAwaiting(ctx context.Context, replyKey string) (*OrchestratedSaga, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var found *OrchestratedSaga
+	for _, saga := range s.sagas {
+		if saga.ReplyKey == replyKey && (found == nil || saga.CreatedAt.Before(found.CreatedAt)) {
+			saga := saga
+			found = &saga
+		}
+	}
+	if found == nil {
+		return nil, ErrSagaNotFound
+	}
+	return found, nil
+}
+
+func (s *memOrchestrationStore) FindExpired(ctx context.Context, now time.Time, limit int) ([]*OrchestratedSaga, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var expired []*OrchestratedSaga
+	for _, saga := range s.sagas {
+		if saga.Deadline != nil && saga.Deadline.Before(now) {
+			saga := saga
+			expired = append(expired, &saga)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Deadline.Before(*expired[j].Deadline) })
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+	return expired, nil
+}
+
+func (s *memOrchestrationStore) Update(ctx context.Context, saga *OrchestratedSaga) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if stored, ok := s.sagas[saga.ID]; !ok || stored.Version != saga.Version {
+		return ErrSagaConflict
+	}
+	saga.Version++
+	s.sagas[saga.ID] = *saga
+	return nil
+}
+
+// transferData is the state of the test transfer saga
+type transferData struct {
+	Skip    map[string]bool `json:"skip,omitempty"`
+	Results []string        `json:"results,omitempty"`
+}
+
+// transferStep issues name.requested and awaits name.done or name.failed;
+// its compensation issues undo.name.requested and awaits undo.name.done
+func transferStep(name string) *OrchestratedStep {
+	command := func(prefix string) func(s *OrchestratedSaga) (*Command, error) {
+		return func(s *OrchestratedSaga) (*Command, error) {
+			var data transferData
+			if err := s.Decode(&data); err != nil {
+				return nil, err
+			}
+			if data.Skip[prefix+name] {
+				return nil, nil
+			}
+			return &Command{Event: events.NewEvent(s.AggregateID, prefix+name+".requested", nil)}, nil
+		}
+	}
+	record := func(s *OrchestratedSaga, reply *events.Event) error {
+		var data transferData
+		if err := s.Decode(&data); err != nil {
+			return err
+		}
+		data.Results = append(data.Results, reply.Topic.String())
+		return s.Encode(data)
+	}
+
+	return &OrchestratedStep{
+		Name:    name,
+		Command: command(""),
+		Success: []events.Topic{events.Topic(name + ".done")},
+		Failure: []events.Topic{events.Topic(name + ".failed")},
+		OnReply: record,
+		Timeout: time.Minute,
+		Compensation: &OrchestratedStep{
+			Name:        "undo " + name,
+			Command:     command("undo."),
+			Success:     []events.Topic{events.Topic("undo." + name + ".done")},
+			Failure:     []events.Topic{events.Topic("undo." + name + ".failed")},
+			OnReply:     record,
+			Timeout:     time.Minute,
+			MaxAttempts: 2,
+		},
+	}
+}
+
+func transferOrchestration() *Orchestration {
+	return &Orchestration{
+		Type:  "transfer",
+		Steps: []*OrchestratedStep{transferStep("reserve"), transferStep("debit"), transferStep("credit")},
+		Finish: func(s *OrchestratedSaga) (*events.Event, error) {
+			return events.NewEvent(s.AggregateID, "transfer."+string(s.Status), map[string]interface{}{
+				"reason": s.FailureReason,
+			}), nil
+		},
+	}
+}
+
+// orchestratorHarness runs the transfer saga against an in-memory store
+type orchestratorHarness struct {
+	t            *testing.T
+	store        *memOrchestrationStore
+	publisher    *recordingPublisher
+	orchestrator *Orchestrator
+}
+
+func newOrchestratorHarness(t *testing.T) *orchestratorHarness {
+	store := newMemOrchestrationStore()
+	publisher := &recordingPublisher{}
+	return &orchestratorHarness{
+		t:            t,
+		store:        store,
+		publisher:    publisher,
+		orchestrator: NewOrchestrator(store, publisher, transferOrchestration()),
+	}
+}
+
+// lastCommand returns the latest published event
+func (h *orchestratorHarness) lastCommand() *events.Event {
+	require.NotEmpty(h.t, h.publisher.events)
+	return h.publisher.events[len(h.publisher.events)-1]
+}
+
+// reply handles a reply with topic to the latest command
+func (h *orchestratorHarness) reply(topic string) {
+	event := events.NewEvent("transfer-1", topic, map[string]interface{}{"reason": topic + " reason"}).CausedBy(h.lastCommand())
+	require.NoError(h.t, h.orchestrator.Handle(context.Background(), event))
+}
+
+// topics returns the topics of the published events
+func (h *orchestratorHarness) topics() []string {
+	topics := make([]string, len(h.publisher.events))
+	for i, event := range h.publisher.events {
+		topics[i] = event.Topic.String()
+	}
+	return topics
+}
+
+func (h *orchestratorHarness) saga() *OrchestratedSaga {
+	s, err := h.store.Get(context.Background(), "saga-1")
+	require.NoError(h.t, err)
+	return s
+}
+
+func TestOrchestrator_Run(t *testing.T) {
+	tests := []struct {
+		name                  string
+		data                  transferData
+		replies               []string
+		expectedTopics        []string
+		expectedStatus        SagaStatus
+		expectedCompensations int
+		expectedReason        string
+	}{
+		{
+			name:    "completes",
+			replies: []string{"reserve.done", "debit.done", "credit.done"},
+			expectedTopics: []string{
+				"reserve.requested", "debit.requested", "credit.requested", "transfer.completed",
+			},
+			expectedStatus: SagaStatusCompleted,
+		},
+		{
+			name:    "compensates the completed steps in reverse order",
+			replies: []string{"reserve.done", "debit.done", "credit.failed", "undo.debit.done", "undo.reserve.done"},
+			expectedTopics: []string{
+				"reserve.requested", "debit.requested", "credit.requested",
+				"undo.debit.requested", "undo.reserve.requested", "transfer.compensated",
+			},
+			expectedStatus:        SagaStatusCompensated,
+			expectedCompensations: 2,
+			expectedReason:        `step "credit" failed: credit.failed reason`,
+		},
+		{
+			name:    "skips steps and compensations without a command",
+			data:    transferData{Skip: map[string]bool{"debit": true, "undo.reserve": true}},
+			replies: []string{"reserve.done", "credit.failed"},
+			expectedTopics: []string{
+				"reserve.requested", "credit.requested", "transfer.failed",
+			},
+			expectedStatus: SagaStatusFailed,
+			expectedReason: `step "credit" failed: credit.failed reason`,
+		},
+		{
+			name:    "failed first step has nothing to compensate",
+			replies: []string{"reserve.failed"},
+			expectedTopics: []string{
+				"reserve.requested", "transfer.failed",
+			},
+			expectedStatus: SagaStatusFailed,
+			expectedReason: `step "reserve" failed: reserve.failed reason`,
+		},
+		{
+			name:    "failed compensation is retried until it runs out of attempts",
+			replies: []string{"reserve.done", "debit.failed", "undo.reserve.failed", "undo.reserve.failed"},
+			expectedTopics: []string{
+				"reserve.requested", "debit.requested",
+				"undo.reserve.requested", "undo.reserve.requested", "transfer.failed",
+			},
+			expectedStatus: SagaStatusFailed,
+			expectedReason: `step "debit" failed: debit.failed reason; compensation gave up after 2 attempts: ` +
+				`step "undo reserve" failed: undo.reserve.failed reason`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newOrchestratorHarness(t)
+
+			s, err := h.orchestrator.Start(context.Background(), "transfer", "saga-1", "transfer-1", tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, SagaStatusInProgress, s.Status)
+
+			for _, reply := range tt.replies {
+				h.reply(reply)
+			}
+
+			assert.Equal(t, tt.expectedTopics, h.topics())
+			for _, event := range h.publisher.events {
+				assert.Equal(t, models.ID("saga-1"), event.CorrelationID, "%s is not correlated to the saga", event.Topic)
+			}
+
+			s = h.saga()
+			assert.Equal(t, tt.expectedStatus, s.Status)
+			assert.Equal(t, tt.expectedCompensations, s.Compensations)
+			assert.Equal(t, tt.expectedReason, s.FailureReason)
+			assert.Nil(t, s.Deadline)
+			assert.Empty(t, s.ReplyKey)
+		})
+	}
+}
+
+func TestOrchestrator_RecordsReplies(t *testing.T) {
+	h := newOrchestratorHarness(t)
+	_, err := h.orchestrator.Start(context.Background(), "transfer", "saga-1", "transfer-1", transferData{})
+	require.NoError(t, err)
+
+	h.reply("reserve.done")
+	h.reply("debit.failed")
+	h.reply("undo.reserve.done")
+
+	var data transferData
+	require.NoError(t, h.saga().Decode(&data))
+	assert.Equal(t, []string{"reserve.done", "undo.reserve.done"}, data.Results)
+}
+
+func TestOrchestrator_IgnoresStaleReplies(t *testing.T) {
+	h := newOrchestratorHarness(t)
+	ctx := context.Background()
+	_, err := h.orchestrator.Start(ctx, "transfer", "saga-1", "transfer-1", transferData{})
+	require.NoError(t, err)
+	reserve := h.lastCommand()
+	h.reply("reserve.done")
+
+	// A redelivered reply to the previous command
+	redelivered := events.NewEvent("transfer-1", "reserve.done", nil).CausedBy(reserve)
+	require.NoError(t, h.orchestrator.Handle(ctx, redelivered))
+	// A reply of another saga
+	other := events.NewEvent("transfer-1", "debit.done", nil).WithCorrelationID("saga-2")
+	require.NoError(t, h.orchestrator.Handle(ctx, other))
+
+	assert.Equal(t, []string{"reserve.requested", "debit.requested"}, h.topics())
+	s := h.saga()
+	assert.Equal(t, 1, s.Step)
+	assert.Equal(t, SagaStatusInProgress, s.Status)
+}
+
+func TestOrchestrator_MatchesRepliesByKey(t *testing.T) {
+	store := newMemOrchestrationStore()
+	publisher := &recordingPublisher{}
+	orchestrator := NewOrchestrator(store, publisher, &Orchestration{
+		Type: "charge",
+		Steps: []*OrchestratedStep{{
+			Name: "charge card",
+			Command: func(s *OrchestratedSaga) (*Command, error) {
+				return &Command{
+					Event:    events.NewEvent(s.AggregateID, "charge.requested", nil),
+					ReplyKey: "card:" + s.AggregateID.String(),
+				}, nil
+			},
+			Success: []events.Topic{"charge.done"},
+			ReplyKey: func(reply *events.Event) (string, bool) {
+				return "card:" + reply.AggregateID.String(), true
+			},
+		}},
+	})
+
+	ctx := context.Background()
+	_, err := orchestrator.Start(ctx, "charge", "saga-1", "payment-1", nil)
+	require.NoError(t, err)
+
+	// The provider replies outside the saga's causal chain
+	require.NoError(t, orchestrator.Handle(ctx, events.NewEvent("payment-2", "charge.done", nil)))
+	s, err := store.Get(ctx, "saga-1")
+	require.NoError(t, err)
+	assert.Equal(t, SagaStatusInProgress, s.Status)
+
+	require.NoError(t, orchestrator.Handle(ctx, events.NewEvent("payment-1", "charge.done", nil)))
+	s, err = store.Get(ctx, "saga-1")
+	require.NoError(t, err)
+	assert.Equal(t, SagaStatusCompleted, s.Status)
+}
+
+func TestOrchestrator_ExpireTimeouts(t *testing.T) {
+	h := newOrchestratorHarness(t)
+	ctx := context.Background()
+	_, err := h.orchestrator.Start(ctx, "transfer", "saga-1", "transfer-1", transferData{})
+	require.NoError(t, err)
+	h.reply("reserve.done")
+
+	expired, err := h.orchestrator.ExpireTimeouts(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+
+	// The debit times out, and so does every attempt to undo the reservation
+	for i := 0; i < 3; i++ {
+		expired, err = h.orchestrator.ExpireTimeouts(ctx, time.Now().Add(2*time.Minute*time.Duration(i+1)), 10)
+		require.NoError(t, err)
+		require.Len(t, expired, 1)
+	}
+
+	assert.Equal(t, []string{
+		"reserve.requested", "debit.requested",
+		"undo.reserve.requested", "undo.reserve.requested", "transfer.failed",
+	}, h.topics())
+
+	s := h.saga()
+	assert.Equal(t, SagaStatusFailed, s.Status)
+	assert.Equal(t, `step "debit" timed out after 1m0s; compensation gave up after 2 attempts: `+
+		`step "undo reserve" timed out after 1m0s`, s.FailureReason)
+
+	expired, err = h.orchestrator.ExpireTimeouts(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+}
+
+func TestOrchestrator_RaisesTimedOutSteps(t *testing.T) {
+	store := newMemOrchestrationStore()
+	publisher := &recordingPublisher{}
+	orchestrator := NewOrchestrator(store, publisher, &Orchestration{
+		Type: "payout",
+		Steps: []*OrchestratedStep{{
+			Name: "pay out",
+			Command: func(s *OrchestratedSaga) (*Command, error) {
+				return &Command{Event: events.NewEvent(s.AggregateID, "payout.requested", nil)}, nil
+			},
+			Success: []events.Topic{"payout.done"},
+			Timeout: time.Minute,
+			OnTimeout: func(s *OrchestratedSaga) (*events.Event, error) {
+				return events.NewEvent(s.AggregateID, "payout.stuck", nil), nil
+			},
+		}},
+		Finish: func(s *OrchestratedSaga) (*events.Event, error) {
+			return events.NewEvent(s.AggregateID, "payout."+string(s.Status), nil), nil
+		},
+	})
+
+	ctx := context.Background()
+	_, err := orchestrator.Start(ctx, "payout", "saga-1", "payout-1", nil)
+	require.NoError(t, err)
+	command := publisher.events[0]
+
+	expired, err := orchestrator.ExpireTimeouts(ctx, time.Now().Add(2*time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+
+	s, err := store.Get(ctx, "saga-1")
+	require.NoError(t, err)
+	assert.Equal(t, SagaStatusInProgress, s.Status)
+	assert.Equal(t, command.ID, s.CommandID)
+	assert.Nil(t, s.Deadline)
+
+	// Raised once: the saga has no deadline left to miss
+	expired, err = orchestrator.ExpireTimeouts(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+
+	// The reply arriving late still completes the saga
+	require.NoError(t, orchestrator.Handle(ctx, events.NewEvent("payout-1", "payout.done", nil).CausedBy(command)))
+
+	var topics []string
+	for _, event := range publisher.events {
+		topics = append(topics, event.Topic.String())
+		assert.Equal(t, models.ID("saga-1"), event.CorrelationID, "%s is not correlated to the saga", event.Topic)
+	}
+	assert.Equal(t, []string{"payout.requested", "payout.stuck", "payout.completed"}, topics)
+
+	s, err = store.Get(ctx, "saga-1")
+	require.NoError(t, err)
+	assert.Equal(t, SagaStatusCompleted, s.Status)
+}
+
+func TestOrchestrator_Start(t *testing.T) {
+	h := newOrchestratorHarness(t)
+	ctx := context.Background()
+
+	_, err := h.orchestrator.Start(ctx, "transfer", "saga-1", "transfer-1", transferData{})
+	require.NoError(t, err)
+
+	_, err = h.orchestrator.Start(ctx, "transfer", "saga-1", "transfer-1", transferData{})
+	assert.ErrorIs(t, err, ErrSagaExists)
+
+	_, err = h.orchestrator.Start(ctx, "unknown", "saga-2", "transfer-1", transferData{})
+	assert.ErrorIs(t, err, ErrUnknownOrchestration)
+
+	assert.Equal(t, []string{"reserve.requested"}, h.topics())
+	s := h.saga()
+	assert.Equal(t, h.lastCommand().ID, s.CommandID)
+	assert.Equal(t, "saga-1", s.ReplyKey)
+	require.NotNil(t, s.Deadline)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *s.Deadline, 5*time.Second)
+}
+
+func TestOrchestrator_Topics(t *testing.T) {
+	h := newOrchestratorHarness(t)
+
+	assert.Equal(t, []events.Topic{
+		"credit.done", "credit.failed", "debit.done", "debit.failed", "reserve.done", "reserve.failed",
+		"undo.credit.done", "undo.credit.failed", "undo.debit.done", "undo.debit.failed",
+		"undo.reserve.done", "undo.reserve.failed",
+	}, h.orchestrator.Topics())
+}
@@ -0,0 +1,135 @@
+package saga
+
+import (
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+)
+
+// Topics exchanged only between the choreography handlers
+const (
+	GatewayProcessingRequestedEvent = "gateway.processing.requested"
+	GatewayProcessingCompletedEvent = "gateway.processing.completed"
+	GatewayProcessingFailedEvent    = "gateway.processing.failed"
+	PaymentCompletionRequestedEvent = "payment.completion.requested"
+	PaymentFailureRequestedEvent    = "payment.failure.requested"
+)
+
+// payloadRegistry decodes the payloads consumed by the choreography handlers
+var payloadRegistry = NewPayloadRegistry()
+
+// NewPayloadRegistry creates the payload registry for the choreography topics
+func NewPayloadRegistry() *events.Registry {
+	return events.NewRegistry().
+		MustRegister(events.PaymentCreatedEvent, PaymentInitiatedPayload{}).
+		MustRegister(events.WalletDebitRequestedEvent, WalletMovementRequestedPayload{}).
+		MustRegister(events.WalletCreditRequestedEvent, WalletMovementRequestedPayload{}).
+		MustRegister(events.WalletDebitedEvent, WalletDebitedPayload{}).
+		MustRegister(events.InsufficientFundsEvent, InsufficientFundsPayload{}).
+		MustRegister(GatewayProcessingRequestedEvent, GatewayProcessingRequestedPayload{}).
+		MustRegister(GatewayProcessingCompletedEvent, GatewayProcessingCompletedPayload{}).
+		MustRegister(GatewayProcessingFailedEvent, GatewayProcessingFailedPayload{}).
+		MustRegister(PaymentCompletionRequestedEvent, PaymentCompletionRequestedPayload{}).
+		MustRegister(PaymentFailureRequestedEvent, PaymentFailureRequestedPayload{})
+}
+
+// PaymentMethodPayload describes how a payment is funded
+type PaymentMethodPayload struct {
+	Type     string    `json:"type" validate:"required"`
+	WalletID models.ID `json:"wallet_id,omitempty"`
+}
+
+// PaymentInitiatedPayload is the payload of payment.created
+type PaymentInitiatedPayload struct {
+	PaymentID     models.ID            `json:"payment_id" validate:"required"`
+	UserID        models.ID            `json:"user_id"`
+	Amount        models.Money         `json:"amount"`
+	PaymentMethod PaymentMethodPayload `json:"payment_method"`
+}
+
+// WalletMovementRequestedPayload is the payload of wallet debit and credit requests
+type WalletMovementRequestedPayload struct {
+	WalletID  models.ID    `json:"wallet_id" validate:"required"`
+	PaymentID models.ID    `json:"payment_id" validate:"required"`
+	UserID    models.ID    `json:"user_id,omitempty"`
+	Amount    models.Money `json:"amount"`
+	Reference string       `json:"reference"`
+}
+
+// WalletDebitedPayload is the payload of wallet.debited
+type WalletDebitedPayload struct {
+	WalletID  models.ID    `json:"wallet_id"`
+	PaymentID models.ID    `json:"payment_id" validate:"required"`
+	Amount    models.Money `json:"amount"`
+}
+
+// InsufficientFundsPayload is the payload of wallet.insufficient.funds
+type InsufficientFundsPayload struct {
+	WalletID  models.ID `json:"wallet_id"`
+	PaymentID models.ID `json:"payment_id" validate:"required"`
+}
+
+// GatewayProcessingRequestedPayload is the payload of gateway.processing.requested
+type GatewayProcessingRequestedPayload struct {
+	PaymentID models.ID    `json:"payment_id" validate:"required"`
+	Amount    models.Money `json:"amount"`
+	Gateway   string       `json:"gateway"`
+}
+
+// GatewayProcessingCompletedPayload is the payload of gateway.processing.completed
+type GatewayProcessingCompletedPayload struct {
+	PaymentID            models.ID `json:"payment_id" validate:"required"`
+	GatewayTransactionID string    `json:"gateway_transaction_id"`
+	Gateway              string    `json:"gateway"`
+	Status               string    `json:"status"`
+}
+
+// GatewayProcessingFailedPayload is the payload of gateway.processing.failed.
+// WalletID is set when the payment debited a wallet that must be credited back.
+type GatewayProcessingFailedPayload struct {
+	PaymentID models.ID    `json:"payment_id" validate:"required"`
+	WalletID  models.ID    `json:"wallet_id,omitempty"`
+	Amount    models.Money `json:"amount"`
+	Error     string       `json:"error"`
+}
+
+// PaymentCompletionRequestedPayload is the payload of payment.completion.requested
+type PaymentCompletionRequestedPayload struct {
+	PaymentID            models.ID `json:"payment_id" validate:"required"`
+	TransactionID        string    `json:"transaction_id"`
+	GatewayTransactionID string    `json:"gateway_transaction_id"`
+}
+
+// PaymentFailureRequestedPayload is the payload of payment.failure.requested
+type PaymentFailureRequestedPayload struct {
+	PaymentID models.ID `json:"payment_id" validate:"required"`
+	Reason    string    `json:"reason"`
+	ErrorCode string    `json:"error_code"`
+}
+
+// PaymentCompletedPayload is the payload of payment.completed
+type PaymentCompletedPayload struct {
+	PaymentID            models.ID `json:"payment_id"`
+	TransactionID        string    `json:"transaction_id"`
+	GatewayTransactionID string    `json:"gateway_transaction_id"`
+	CompletedAt          string    `json:"completed_at"`
+}
+
+// PaymentFailedPayload is the payload of payment.failed
+type PaymentFailedPayload struct {
+	PaymentID models.ID `json:"payment_id"`
+	Reason    string    `json:"reason"`
+	ErrorCode string    `json:"error_code"`
+	FailedAt  string    `json:"failed_at"`
+}
+
+// SagaStatusChangedPayload is the payload of saga.started, saga.completed,
+// saga.failed and saga.compensated
+type SagaStatusChangedPayload struct {
+	CorrelationID models.ID  `json:"correlation_id" validate:"required"`
+	SagaType      SagaType   `json:"saga_type"`
+	Status        SagaStatus `json:"status" validate:"required"`
+	Step          string     `json:"step"`
+	StartedAt     time.Time  `json:"started_at"`
+}
@@ -3,7 +3,8 @@ package saga
 // This file contains shared saga interfaces and types for choreography pattern.
 // Orchestration-based saga implementation has been removed in favor of choreography.
 
-// SagaStatus represents the current status of a saga (used for tracking only)
+// SagaStatus represents the current status of a saga, as derived by the saga
+// log from the steps it recorded
 type SagaStatus string
 
 const (
@@ -11,8 +12,17 @@ const (
 	SagaStatusInProgress SagaStatus = "in_progress"
 	SagaStatusCompleted  SagaStatus = "completed"
 	SagaStatusFailed     SagaStatus = "failed"
+	// SagaStatusCompensating is a saga waiting for its compensations
+	SagaStatusCompensating SagaStatus = "compensating"
+	// SagaStatusCompensated is a saga whose compensations all succeeded
+	SagaStatusCompensated SagaStatus = "compensated"
 )
 
+// IsTerminal reports whether a saga in this status takes no further steps
+func (s SagaStatus) IsTerminal() bool {
+	return s == SagaStatusCompleted || s == SagaStatusFailed || s == SagaStatusCompensated
+}
+
 // Note: In choreography pattern, there is no central orchestrator.
 // Each service listens for events and publishes new events as part of the business flow.
-// Compensation is handled by individual services when they receive failure events.
\ No newline at end of file
+// Compensation is handled by individual services when they receive failure events.
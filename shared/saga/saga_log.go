@@ -0,0 +1,407 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+)
+
+// ErrSagaNotFound is returned by a Log for a correlation ID without steps
+var ErrSagaNotFound = errors.New("saga not found")
+
+// SagaType names the business transaction a saga carries out
+type SagaType string
+
+const (
+	SagaTypePayment SagaType = "payment"
+	SagaTypeRefund  SagaType = "refund"
+)
+
+// StepOutcome is what a step says about the saga's progress
+type StepOutcome string
+
+const (
+	// StepRequested asks a participant to act
+	StepRequested StepOutcome = "requested"
+	// StepSucceeded reports that a participant acted
+	StepSucceeded StepOutcome = "succeeded"
+	// StepFailed reports that a participant could not act
+	StepFailed StepOutcome = "failed"
+)
+
+// StepDefinition describes what an event means for a saga
+type StepDefinition struct {
+	Name    string
+	Outcome StepOutcome
+	// Compensation marks steps that undo the effect of an earlier one
+	Compensation bool
+	// Ends is the status the saga reaches with this step, if any
+	Ends SagaStatus
+}
+
+// Definition lists the steps of a saga type by topic. The Start topic opens
+// a saga; every other event sharing its correlation ID is one of its steps.
+type Definition struct {
+	Type  SagaType
+	Start events.Topic
+	Steps map[events.Topic]StepDefinition
+}
+
+// PaymentSagaDefinition describes the payment saga: the payment is charged to
+// a wallet or an external provider and ends completed or failed. Wallet
+// credits issued for an inconsistent payment compensate its debit. Debits
+// and credits are both requested as wallet movements; the wallet.debit and
+// wallet.credit requests are kept for sagas recorded before.
+func PaymentSagaDefinition() Definition {
+	return Definition{
+		Type:  SagaTypePayment,
+		Start: events.PaymentCreatedEvent,
+		Steps: map[events.Topic]StepDefinition{
+			events.PaymentCreatedEvent:                        {Name: "payment created", Outcome: StepSucceeded},
+			events.PaymentProcessingEvent:                     {Name: "payment processing", Outcome: StepSucceeded},
+			events.WalletDebitRequestedEvent:                  {Name: "wallet debit", Outcome: StepRequested},
+			events.WalletDebitedEvent:                         {Name: "wallet debit", Outcome: StepSucceeded},
+			events.InsufficientFundsEvent:                     {Name: "wallet debit", Outcome: StepFailed},
+			events.WalletMovementCreationRequestedEvent:       {Name: "wallet movement", Outcome: StepRequested},
+			events.WalletMovementCreatedEvent:                 {Name: "wallet movement", Outcome: StepSucceeded},
+			events.PaymentOperationCreatedEvent:               {Name: "provider operation", Outcome: StepRequested},
+			events.PaymentOperationProcessingEvent:            {Name: "provider operation processing", Outcome: StepSucceeded},
+			events.ExternalProviderUpdateEvent:                {Name: "provider update", Outcome: StepSucceeded},
+			events.PaymentOperationCompletedEvent:             {Name: "provider operation", Outcome: StepSucceeded},
+			events.PaymentOperationFailedEvent:                {Name: "provider operation", Outcome: StepFailed},
+			GatewayProcessingRequestedEvent:                   {Name: "gateway processing", Outcome: StepRequested},
+			GatewayProcessingCompletedEvent:                   {Name: "gateway processing", Outcome: StepSucceeded},
+			GatewayProcessingFailedEvent:                      {Name: "gateway processing", Outcome: StepFailed},
+			PaymentCompletionRequestedEvent:                   {Name: "payment completion", Outcome: StepRequested},
+			PaymentFailureRequestedEvent:                      {Name: "payment failure", Outcome: StepRequested},
+			events.PaymentInconsistentStateEvent:              {Name: "inconsistent state", Outcome: StepFailed},
+			events.PaymentInconsistentOperationStartedEvent:   {Name: "inconsistency resolution", Outcome: StepRequested},
+			events.PaymentInconsistentOperationProcessedEvent: {Name: "inconsistency resolution", Outcome: StepSucceeded},
+			events.WalletCreditRequestedEvent:                 {Name: "wallet credit", Outcome: StepRequested, Compensation: true},
+			events.WalletCreditedEvent:                        {Name: "wallet credit", Outcome: StepSucceeded, Compensation: true},
+			events.PaymentCompletedEvent:                      {Name: "payment completed", Outcome: StepSucceeded, Ends: SagaStatusCompleted},
+			events.PaymentFailedEvent:                         {Name: "payment failed", Outcome: StepFailed, Ends: SagaStatusFailed},
+			events.PaymentCancelledEvent:                      {Name: "payment cancelled", Outcome: StepFailed, Ends: SagaStatusFailed},
+		},
+	}
+}
+
+// RefundSagaDefinition describes the refund saga: the amount is credited back
+// to the wallet, or refunded through a provider operation
+func RefundSagaDefinition() Definition {
+	return Definition{
+		Type:  SagaTypeRefund,
+		Start: events.PaymentRefundInitiatedEvent,
+		Steps: map[events.Topic]StepDefinition{
+			events.PaymentRefundInitiatedEvent:          {Name: "refund initiated", Outcome: StepSucceeded},
+			events.WalletMovementCreationRequestedEvent: {Name: "wallet credit", Outcome: StepRequested},
+			events.WalletCreditedEvent:                  {Name: "wallet credit", Outcome: StepSucceeded, Ends: SagaStatusCompleted},
+			events.WalletMovementCreatedEvent:           {Name: "wallet credit", Outcome: StepSucceeded},
+			events.PaymentOperationCreatedEvent:         {Name: "provider refund", Outcome: StepRequested},
+			events.PaymentOperationProcessingEvent:      {Name: "provider refund processing", Outcome: StepSucceeded},
+			events.ExternalProviderUpdateEvent:          {Name: "provider update", Outcome: StepSucceeded},
+			events.PaymentOperationCompletedEvent:       {Name: "provider refund", Outcome: StepSucceeded, Ends: SagaStatusCompleted},
+			events.PaymentOperationFailedEvent:          {Name: "provider refund", Outcome: StepFailed, Ends: SagaStatusFailed},
+			events.PaymentRefundCompletedEvent:          {Name: "refund completed", Outcome: StepSucceeded, Ends: SagaStatusCompleted},
+			events.PaymentRefundFailedEvent:             {Name: "refund failed", Outcome: StepFailed, Ends: SagaStatusFailed},
+			// Raised by the watchdog for a refund without progress
+			events.PaymentInconsistentStateEvent:              {Name: "inconsistent state", Outcome: StepFailed},
+			events.PaymentInconsistentOperationStartedEvent:   {Name: "inconsistency resolution", Outcome: StepRequested},
+			events.PaymentInconsistentOperationProcessedEvent: {Name: "inconsistency resolution", Outcome: StepSucceeded},
+		},
+	}
+}
+
+// Definitions returns the definitions of every tracked saga type
+func Definitions() []Definition {
+	return []Definition{PaymentSagaDefinition(), RefundSagaDefinition()}
+}
+
+// Step is an event recorded in a saga. The log stores the event fields; the
+// definition fields and Duration are filled in by Replay.
+type Step struct {
+	EventID     models.ID
+	Topic       events.Topic
+	AggregateID models.ID
+	// Reason is the failure reason or error message carried by the payload
+	Reason     string
+	OccurredAt time.Time
+	RecordedAt time.Time
+
+	Name         string
+	Outcome      StepOutcome
+	Compensation bool
+	// Duration is the time since the previous step: how long the hop took
+	Duration time.Duration
+}
+
+// Instance is a saga as derived from its steps
+type Instance struct {
+	CorrelationID models.ID
+	Type          SagaType
+	// AggregateID is the aggregate of the event that started the saga, the
+	// payment for both payment and refund sagas
+	AggregateID models.ID
+	Status      SagaStatus
+	CurrentStep string
+	StartedAt   time.Time
+	UpdatedAt   time.Time
+	// FinishedAt is set once the saga reaches a terminal status
+	FinishedAt *time.Time
+	Steps      []Step
+}
+
+// Duration is how long the saga ran, or has been running as of now
+func (i *Instance) Duration(now time.Time) time.Duration {
+	if i.FinishedAt != nil {
+		return i.FinishedAt.Sub(i.StartedAt)
+	}
+	return now.Sub(i.StartedAt)
+}
+
+// Log records saga steps keyed by correlation ID and keeps the instance
+// derived from them with Replay
+type Log interface {
+	// Record adds step to the saga of correlationID and returns the status
+	// the saga had before it, empty for a new saga, and the saga after it.
+	// A step whose event was already recorded returns a nil instance.
+	Record(ctx context.Context, correlationID models.ID, step Step) (SagaStatus, *Instance, error)
+	// Get returns the saga of correlationID or ErrSagaNotFound
+	Get(ctx context.Context, correlationID models.ID) (*Instance, error)
+	// FindByAggregate returns the sagas started for an aggregate, oldest first
+	FindByAggregate(ctx context.Context, aggregateID models.ID) ([]*Instance, error)
+}
+
+// Replay derives a saga from its steps. The type is given by the start event
+// or, until it arrives, by the first step only one saga type knows. Steps are
+// ordered by occurrence and annotated with their definition and hop duration.
+func Replay(correlationID models.ID, steps []Step) *Instance {
+	steps = append([]Step(nil), steps...)
+	sort.SliceStable(steps, func(i, j int) bool {
+		if !steps[i].OccurredAt.Equal(steps[j].OccurredAt) {
+			return steps[i].OccurredAt.Before(steps[j].OccurredAt)
+		}
+		return steps[i].RecordedAt.Before(steps[j].RecordedAt)
+	})
+
+	instance := &Instance{CorrelationID: correlationID, Steps: steps}
+	if len(steps) == 0 {
+		return instance
+	}
+
+	definition, aggregateID := identify(steps)
+	instance.Type = definition.Type
+	instance.AggregateID = aggregateID
+
+	var (
+		ended                    SagaStatus
+		requested, compensations int
+	)
+	for i := range steps {
+		step := &steps[i]
+		if i > 0 {
+			step.Duration = step.OccurredAt.Sub(steps[i-1].OccurredAt)
+		}
+
+		stepDef, ok := definition.Steps[step.Topic]
+		if !ok {
+			step.Name = step.Topic.String()
+			continue
+		}
+		step.Name = stepDef.Name
+		step.Outcome = stepDef.Outcome
+		step.Compensation = stepDef.Compensation
+
+		if stepDef.Compensation {
+			switch stepDef.Outcome {
+			case StepRequested:
+				requested++
+			case StepSucceeded:
+				compensations++
+			}
+		}
+		if stepDef.Ends != "" && ended == "" {
+			ended = stepDef.Ends
+			finishedAt := step.OccurredAt
+			instance.FinishedAt = &finishedAt
+		}
+	}
+
+	first, last := steps[0], steps[len(steps)-1]
+	instance.StartedAt = first.OccurredAt
+	instance.UpdatedAt = last.OccurredAt
+	instance.CurrentStep = last.Name
+
+	switch {
+	case requested > compensations:
+		instance.Status = SagaStatusCompensating
+		instance.FinishedAt = nil
+	case compensations > 0:
+		instance.Status = SagaStatusCompensated
+		finishedAt := last.OccurredAt
+		instance.FinishedAt = &finishedAt
+	case ended != "":
+		instance.Status = ended
+	case len(steps) == 1:
+		instance.Status = SagaStatusStarted
+	default:
+		instance.Status = SagaStatusInProgress
+	}
+
+	return instance
+}
+
+// identify returns the definition of the saga the steps belong to and its
+// aggregate ID
+func identify(steps []Step) (Definition, models.ID) {
+	definitions := Definitions()
+
+	for _, step := range steps {
+		for _, definition := range definitions {
+			if step.Topic == definition.Start {
+				return definition, step.AggregateID
+			}
+		}
+	}
+
+	for _, step := range steps {
+		var matches []Definition
+		for _, definition := range definitions {
+			if _, ok := definition.Steps[step.Topic]; ok {
+				matches = append(matches, definition)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], steps[0].AggregateID
+		}
+	}
+
+	return Definition{}, steps[0].AggregateID
+}
+
+// Tracker is the event handler feeding the saga log. It records every event
+// of the tracked sagas under its correlation ID and publishes saga.started
+// when a saga opens and saga.completed, saga.failed or saga.compensated when
+// it reaches that status.
+type Tracker struct {
+	log       Log
+	publisher events.Publisher
+}
+
+// NewTracker creates a Tracker recording to log and publishing status
+// changes through publisher
+func NewTracker(log Log, publisher events.Publisher) *Tracker {
+	return &Tracker{
+		log:       log,
+		publisher: publisher,
+	}
+}
+
+// HandlerID returns the unique identifier for this event handler
+func (t *Tracker) HandlerID() string {
+	return "saga-tracker"
+}
+
+// Topics returns the topics of every tracked saga type
+func (t *Tracker) Topics() []events.Topic {
+	seen := make(map[events.Topic]bool)
+	var topics []events.Topic
+	for _, definition := range Definitions() {
+		for topic := range definition.Steps {
+			if !seen[topic] {
+				seen[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+
+	sort.Slice(topics, func(i, j int) bool { return topics[i] < topics[j] })
+	return topics
+}
+
+// Handle records the event as a step of its saga. Events without a
+// correlation ID start their own chain, so they correlate to themselves.
+func (t *Tracker) Handle(ctx context.Context, event *events.Event) error {
+	correlationID := event.CorrelationID
+	if correlationID == "" {
+		correlationID = event.ID
+	}
+
+	before, instance, err := t.log.Record(ctx, correlationID, Step{
+		EventID:     event.ID,
+		Topic:       event.Topic,
+		AggregateID: event.AggregateID,
+		Reason:      failureReason(event),
+		OccurredAt:  event.Timestamp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record saga step %s: %w", event.Topic, err)
+	}
+
+	// Redelivered event
+	if instance == nil {
+		return nil
+	}
+
+	var statusEvents []*events.Event
+	if before == "" {
+		statusEvents = append(statusEvents, t.statusEvent(events.SagaStartedEvent, instance, event))
+	}
+	if instance.Status != before {
+		switch instance.Status {
+		case SagaStatusCompleted:
+			statusEvents = append(statusEvents, t.statusEvent(events.SagaCompletedEvent, instance, event))
+		case SagaStatusFailed:
+			statusEvents = append(statusEvents, t.statusEvent(events.SagaFailedEvent, instance, event))
+		case SagaStatusCompensated:
+			statusEvents = append(statusEvents, t.statusEvent(events.SagaCompensatedEvent, instance, event))
+		}
+	}
+
+	if len(statusEvents) == 0 {
+		return nil
+	}
+
+	if err := t.publisher.Publish(ctx, statusEvents...); err != nil {
+		return fmt.Errorf("failed to publish saga status: %w", err)
+	}
+
+	return nil
+}
+
+// statusEvent creates a saga status event caused by event
+func (t *Tracker) statusEvent(topic string, instance *Instance, event *events.Event) *events.Event {
+	return events.NewEvent(instance.AggregateID, topic, SagaStatusChangedPayload{
+		CorrelationID: instance.CorrelationID,
+		SagaType:      instance.Type,
+		Status:        instance.Status,
+		Step:          instance.CurrentStep,
+		StartedAt:     instance.StartedAt,
+	}).CausedBy(event)
+}
+
+// failureReason returns the error message or reason carried by the payload
+func failureReason(event *events.Event) string {
+	raw, err := event.MarshalPayload()
+	if err != nil {
+		return ""
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return ""
+	}
+
+	for _, key := range []string{"error_message", "error", "reason"} {
+		if reason, ok := payload[key].(string); ok && reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,272 @@
+package saga
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memLog keeps saga steps in memory
+type memLog struct {
+	mux    sync.Mutex
+	steps  map[models.ID][]Step
+	status map[models.ID]SagaStatus
+}
+
+func newMemLog() *memLog {
+	return &memLog{
+		steps:  make(map[models.ID][]Step),
+		status: make(map[models.ID]SagaStatus),
+	}
+}
+
+func (l *memLog) Record(ctx context.Context, correlationID models.ID, step Step) (SagaStatus, *Instance, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	for _, recorded := range l.steps[correlationID] {
+		if recorded.EventID == step.EventID {
+			return "", nil, nil
+		}
+	}
+
+	step.RecordedAt = time.Now()
+	l.steps[correlationID] = append(l.steps[correlationID], step)
+
+	before := l.status[correlationID]
+	instance := Replay(correlationID, l.steps[correlationID])
+	l.status[correlationID] = instance.Status
+	return before, instance, nil
+}
+
+func (l *memLog) Get(ctx context.Context, correlationID models.ID) (*Instance, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if len(l.steps[correlationID]) == 0 {
+		return nil, ErrSagaNotFound
+	}
+	return Replay(correlationID, l.steps[correlationID]), nil
+}
+
+func (l *memLog) FindByAggregate(ctx context.Context, aggregateID models.ID) ([]*Instance, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	var instances []*Instance
+	for correlationID, steps := range l.steps {
+		if instance := Replay(correlationID, steps); instance.AggregateID == aggregateID {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+// recordingPublisher keeps published events
+type recordingPublisher struct {
+	events []*events.Event
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, evts ...*events.Event) error {
+	p.events = append(p.events, evts...)
+	return nil
+}
+
+var sagaStart = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// steps creates one step per topic, a second apart
+func steps(topics ...events.Topic) []Step {
+	result := make([]Step, 0, len(topics))
+	for i, topic := range topics {
+		result = append(result, Step{
+			EventID:     models.GenerateUUID(),
+			Topic:       topic,
+			AggregateID: "payment-1",
+			OccurredAt:  sagaStart.Add(time.Duration(i) * time.Second),
+		})
+	}
+	return result
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name                string
+		steps               []Step
+		expectedType        SagaType
+		expectedStatus      SagaStatus
+		expectedCurrentStep string
+		expectedFinished    bool
+	}{
+		{
+			name:                "started",
+			steps:               steps(events.PaymentCreatedEvent),
+			expectedType:        SagaTypePayment,
+			expectedStatus:      SagaStatusStarted,
+			expectedCurrentStep: "payment created",
+		},
+		{
+			name:                "in progress",
+			steps:               steps(events.PaymentCreatedEvent, events.WalletDebitRequestedEvent),
+			expectedType:        SagaTypePayment,
+			expectedStatus:      SagaStatusInProgress,
+			expectedCurrentStep: "wallet debit",
+		},
+		{
+			name: "completed",
+			steps: steps(events.PaymentCreatedEvent, events.WalletDebitRequestedEvent,
+				events.WalletDebitedEvent, events.PaymentCompletedEvent),
+			expectedType:        SagaTypePayment,
+			expectedStatus:      SagaStatusCompleted,
+			expectedCurrentStep: "payment completed",
+			expectedFinished:    true,
+		},
+		{
+			name: "failed",
+			steps: steps(events.PaymentCreatedEvent, events.WalletDebitRequestedEvent,
+				events.InsufficientFundsEvent, events.PaymentFailedEvent),
+			expectedType:        SagaTypePayment,
+			expectedStatus:      SagaStatusFailed,
+			expectedCurrentStep: "payment failed",
+			expectedFinished:    true,
+		},
+		{
+			name: "compensating",
+			steps: steps(events.PaymentCreatedEvent, events.WalletDebitedEvent,
+				events.PaymentFailedEvent, events.WalletCreditRequestedEvent),
+			expectedType:        SagaTypePayment,
+			expectedStatus:      SagaStatusCompensating,
+			expectedCurrentStep: "wallet credit",
+		},
+		{
+			name: "compensated",
+			steps: steps(events.PaymentCreatedEvent, events.WalletDebitedEvent,
+				events.PaymentFailedEvent, events.WalletCreditRequestedEvent, events.WalletCreditedEvent),
+			expectedType:        SagaTypePayment,
+			expectedStatus:      SagaStatusCompensated,
+			expectedCurrentStep: "wallet credit",
+			expectedFinished:    true,
+		},
+		{
+			name: "refund completed by the wallet credit",
+			steps: steps(events.PaymentRefundInitiatedEvent, events.WalletMovementCreationRequestedEvent,
+				events.WalletCreditedEvent),
+			expectedType:        SagaTypeRefund,
+			expectedStatus:      SagaStatusCompleted,
+			expectedCurrentStep: "wallet credit",
+			expectedFinished:    true,
+		},
+		{
+			name:                "refund failed at the provider",
+			steps:               steps(events.PaymentRefundInitiatedEvent, events.PaymentOperationFailedEvent),
+			expectedType:        SagaTypeRefund,
+			expectedStatus:      SagaStatusFailed,
+			expectedCurrentStep: "provider refund",
+			expectedFinished:    true,
+		},
+		{
+			name:                "type from a step before the start event",
+			steps:               steps(events.WalletDebitedEvent),
+			expectedType:        SagaTypePayment,
+			expectedStatus:      SagaStatusStarted,
+			expectedCurrentStep: "wallet debit",
+		},
+		{
+			name:                "ambiguous step",
+			steps:               steps(events.WalletCreditedEvent),
+			expectedStatus:      SagaStatusStarted,
+			expectedCurrentStep: events.WalletCreditedEvent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := Replay("correlation-1", tt.steps)
+
+			assert.Equal(t, models.ID("correlation-1"), instance.CorrelationID)
+			assert.Equal(t, tt.expectedType, instance.Type)
+			assert.Equal(t, tt.expectedStatus, instance.Status)
+			assert.Equal(t, tt.expectedCurrentStep, instance.CurrentStep)
+			assert.Equal(t, tt.expectedFinished, instance.FinishedAt != nil)
+			assert.Equal(t, models.ID("payment-1"), instance.AggregateID)
+		})
+	}
+}
+
+func TestReplay_OrdersStepsAndTimesHops(t *testing.T) {
+	recorded := steps(events.PaymentCreatedEvent, events.WalletDebitRequestedEvent, events.WalletDebitedEvent)
+	recorded[2].OccurredAt = sagaStart.Add(5 * time.Second)
+	recorded[2].Reason = "debited"
+
+	// Delivered out of order
+	instance := Replay("correlation-1", []Step{recorded[2], recorded[0], recorded[1]})
+
+	require.Len(t, instance.Steps, 3)
+	assert.Equal(t, events.Topic(events.PaymentCreatedEvent), instance.Steps[0].Topic)
+	assert.Equal(t, time.Duration(0), instance.Steps[0].Duration)
+	assert.Equal(t, time.Second, instance.Steps[1].Duration)
+	assert.Equal(t, StepRequested, instance.Steps[1].Outcome)
+	assert.Equal(t, 4*time.Second, instance.Steps[2].Duration)
+	assert.Equal(t, StepSucceeded, instance.Steps[2].Outcome)
+	assert.Equal(t, sagaStart, instance.StartedAt)
+	assert.Equal(t, sagaStart.Add(5*time.Second), instance.UpdatedAt)
+	assert.Equal(t, 10*time.Second, instance.Duration(sagaStart.Add(10*time.Second)))
+}
+
+func TestTracker_Handle(t *testing.T) {
+	log := newMemLog()
+	publisher := &recordingPublisher{}
+	tracker := NewTracker(log, publisher)
+
+	created := events.NewEvent("payment-1", events.PaymentCreatedEvent, nil)
+	created.CorrelationID = created.ID
+	created.Timestamp = sagaStart
+
+	debitRequested := events.NewEvent("payment-1", events.WalletDebitRequestedEvent, nil).CausedBy(created)
+	debitRequested.Timestamp = sagaStart.Add(time.Second)
+
+	failed := events.NewEvent("payment-1", events.PaymentFailedEvent, map[string]interface{}{
+		"reason": "insufficient funds",
+	}).CausedBy(debitRequested)
+	failed.Timestamp = sagaStart.Add(2 * time.Second)
+
+	ctx := context.Background()
+	require.NoError(t, tracker.Handle(ctx, created))
+	require.NoError(t, tracker.Handle(ctx, debitRequested))
+	require.NoError(t, tracker.Handle(ctx, failed))
+	// Redelivery
+	require.NoError(t, tracker.Handle(ctx, failed))
+
+	instance, err := log.Get(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, SagaStatusFailed, instance.Status)
+	require.Len(t, instance.Steps, 3)
+	assert.Equal(t, "insufficient funds", instance.Steps[2].Reason)
+
+	require.Len(t, publisher.events, 2)
+	assert.Equal(t, events.Topic(events.SagaStartedEvent), publisher.events[0].Topic)
+	assert.Equal(t, events.Topic(events.SagaFailedEvent), publisher.events[1].Topic)
+	assert.Equal(t, created.ID, publisher.events[1].CorrelationID)
+	assert.Equal(t, failed.ID, publisher.events[1].CausationID)
+	assert.Equal(t, SagaStatusChangedPayload{
+		CorrelationID: created.ID,
+		SagaType:      SagaTypePayment,
+		Status:        SagaStatusFailed,
+		Step:          "payment failed",
+		StartedAt:     sagaStart,
+	}, publisher.events[1].Data)
+}
+
+func TestTracker_Topics(t *testing.T) {
+	topics := NewTracker(nil, nil).Topics()
+
+	assert.Contains(t, topics, events.Topic(events.PaymentCreatedEvent))
+	assert.Contains(t, topics, events.Topic(events.PaymentRefundInitiatedEvent))
+	assert.Contains(t, topics, events.Topic(events.WalletCreditedEvent))
+	assert.NotContains(t, topics, events.Topic(events.SagaStartedEvent))
+}
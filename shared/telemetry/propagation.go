@@ -0,0 +1,36 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// W3C trace context keys written by InjectTraceContext
+const (
+	TraceParentKey = "traceparent"
+	TraceStateKey  = "tracestate"
+)
+
+// Messaging semantic convention attribute keys used by producer and
+// consumer spans
+const (
+	MessagingSystemKey          = "messaging.system"
+	MessagingOperationTypeKey   = "messaging.operation.type"
+	MessagingDestinationNameKey = "messaging.destination.name"
+	MessagingMessageIDKey       = "messaging.message.id"
+	MessagingSQSReceiveCountKey = "messaging.aws.sqs.receive_count"
+)
+
+// InjectTraceContext writes the span context of ctx into carrier as W3C
+// traceparent/tracestate entries, e.g. event metadata or message attributes
+func InjectTraceContext(ctx context.Context, carrier map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(carrier))
+}
+
+// ExtractTraceContext returns ctx with the remote span context found in
+// carrier, so spans started from it join the producer's trace
+func ExtractTraceContext(ctx context.Context, carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
@@ -0,0 +1,516 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	paymentsapp "github.com/draftea/payment-system/payments-service/application"
+	paymentsdomain "github.com/draftea/payment-system/payments-service/domain"
+	paymentshandlers "github.com/draftea/payment-system/payments-service/handlers"
+	"github.com/draftea/payment-system/shared/events"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
+	"github.com/draftea/payment-system/shared/models"
+	"github.com/draftea/payment-system/shared/saga"
+	walletapp "github.com/draftea/payment-system/wallet-service/application"
+	walletdomain "github.com/draftea/payment-system/wallet-service/domain"
+	wallethandlers "github.com/draftea/payment-system/wallet-service/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryPaymentRepository stores copies so use cases never share aggregates
+type memoryPaymentRepository struct {
+	mux      sync.Mutex
+	payments map[models.ID]paymentsdomain.Payment
+}
+
+func (r *memoryPaymentRepository) Save(ctx context.Context, payment *paymentsdomain.Payment) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	stored := *payment
+	stored.ClearEvents()
+	r.payments[payment.ID] = stored
+	return nil
+}
+
+func (r *memoryPaymentRepository) FindByID(ctx context.Context, id models.ID) (*paymentsdomain.Payment, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	stored, ok := r.payments[id]
+	if !ok {
+		return nil, nil
+	}
+	return &stored, nil
+}
+
+func (r *memoryPaymentRepository) FindByUserID(ctx context.Context, userID models.ID) ([]*paymentsdomain.Payment, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var payments []*paymentsdomain.Payment
+	for _, stored := range r.payments {
+		if stored.UserID == userID {
+			stored := stored
+			payments = append(payments, &stored)
+		}
+	}
+	return payments, nil
+}
+
+// memoryRefundRepository stores copies of refunds in request order
+type memoryRefundRepository struct {
+	mux     sync.Mutex
+	refunds []paymentsdomain.Refund
+}
+
+func (r *memoryRefundRepository) LockPendingAmount(ctx context.Context, paymentID models.ID) (models.Money, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var pending models.Money
+	for _, stored := range r.refunds {
+		if stored.PaymentID == paymentID && stored.Status == paymentsdomain.RefundStatusPending {
+			pending = models.NewMoney(pending.Amount+stored.Amount.Amount, stored.Amount.Currency)
+		}
+	}
+	return pending, nil
+}
+
+func (r *memoryRefundRepository) Save(ctx context.Context, refund *paymentsdomain.Refund) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for i := range r.refunds {
+		if r.refunds[i].ID == refund.ID {
+			r.refunds[i] = *refund
+			return nil
+		}
+	}
+	r.refunds = append(r.refunds, *refund)
+	return nil
+}
+
+func (r *memoryRefundRepository) FindByID(ctx context.Context, id models.ID) (*paymentsdomain.Refund, error) {
+	refunds := r.filter(func(refund paymentsdomain.Refund) bool { return refund.ID == id })
+	if len(refunds) == 0 {
+		return nil, nil
+	}
+	return refunds[0], nil
+}
+
+func (r *memoryRefundRepository) FindPending(ctx context.Context, paymentID models.ID, amount models.Money) (*paymentsdomain.Refund, error) {
+	refunds := r.filter(func(refund paymentsdomain.Refund) bool {
+		return refund.PaymentID == paymentID && refund.Status == paymentsdomain.RefundStatusPending && refund.Amount == amount
+	})
+	if len(refunds) == 0 {
+		return nil, nil
+	}
+	return refunds[0], nil
+}
+
+func (r *memoryRefundRepository) FindByPaymentID(ctx context.Context, paymentID models.ID) ([]*paymentsdomain.Refund, error) {
+	return r.filter(func(refund paymentsdomain.Refund) bool { return refund.PaymentID == paymentID }), nil
+}
+
+func (r *memoryRefundRepository) filter(keep func(paymentsdomain.Refund) bool) []*paymentsdomain.Refund {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var refunds []*paymentsdomain.Refund
+	for _, stored := range r.refunds {
+		if keep(stored) {
+			stored := stored
+			refunds = append(refunds, &stored)
+		}
+	}
+	return refunds
+}
+
+type memoryWalletRepository struct {
+	mux     sync.Mutex
+	wallets map[models.ID]walletdomain.Wallet
+}
+
+func (r *memoryWalletRepository) Save(ctx context.Context, wallet *walletdomain.Wallet) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	stored := *wallet
+	stored.ClearEvents()
+	r.wallets[wallet.ID] = stored
+	return nil
+}
+
+func (r *memoryWalletRepository) FindByID(ctx context.Context, id models.ID) (*walletdomain.Wallet, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	stored, ok := r.wallets[id]
+	if !ok {
+		return nil, nil
+	}
+	return &stored, nil
+}
+
+func (r *memoryWalletRepository) FindByUserID(ctx context.Context, userID models.ID) (*walletdomain.Wallet, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, stored := range r.wallets {
+		if stored.UserID == userID {
+			return &stored, nil
+		}
+	}
+	return nil, nil
+}
+
+type memoryTransactionRepository struct {
+	mux          sync.Mutex
+	transactions []walletdomain.Transaction
+}
+
+func (r *memoryTransactionRepository) Save(ctx context.Context, transaction *walletdomain.Transaction) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.transactions = append(r.transactions, *transaction)
+	return nil
+}
+
+func (r *memoryTransactionRepository) FindByID(ctx context.Context, id models.ID) (*walletdomain.Transaction, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, stored := range r.transactions {
+		if stored.ID == id {
+			return &stored, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryTransactionRepository) FindByWalletID(ctx context.Context, walletID models.ID, limit, offset int) ([]*walletdomain.Transaction, error) {
+	return r.filter(func(t walletdomain.Transaction) bool { return t.WalletID == walletID }), nil
+}
+
+func (r *memoryTransactionRepository) FindByPaymentID(ctx context.Context, paymentID models.ID) ([]*walletdomain.Transaction, error) {
+	return r.filter(func(t walletdomain.Transaction) bool { return t.PaymentID != nil && *t.PaymentID == paymentID }), nil
+}
+
+func (r *memoryTransactionRepository) filter(keep func(walletdomain.Transaction) bool) []*walletdomain.Transaction {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var transactions []*walletdomain.Transaction
+	for _, stored := range r.transactions {
+		if keep(stored) {
+			stored := stored
+			transactions = append(transactions, &stored)
+		}
+	}
+	return transactions
+}
+
+// choreography wires both services to one in-memory bus the way their
+// dependencies.go files wire them to SNS/SQS
+type choreography struct {
+	bus           *sharedinfra.InMemoryBus
+	payments      *memoryPaymentRepository
+	refunds       *memoryRefundRepository
+	wallets       *memoryWalletRepository
+	transactions  *memoryTransactionRepository
+	createPayment *paymentsapp.CreatePaymentChoreography
+	refundPayment *paymentsapp.RefundPayment
+
+	paymentHandlers *paymentshandlers.PaymentEventHandlers
+	walletHandlers  *wallethandlers.WalletEventHandlers
+}
+
+func newChoreography(t *testing.T, opts ...sharedinfra.MemoryBusOption) *choreography {
+	ctx := context.Background()
+	bus := sharedinfra.NewInMemoryBus(opts...)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	c := &choreography{
+		bus:          bus,
+		payments:     &memoryPaymentRepository{payments: make(map[models.ID]paymentsdomain.Payment)},
+		refunds:      &memoryRefundRepository{},
+		wallets:      &memoryWalletRepository{wallets: make(map[models.ID]walletdomain.Wallet)},
+		transactions: &memoryTransactionRepository{},
+	}
+
+	// Use cases publish through the same decorator as in dependencies.go so
+	// events are linked to the event being handled
+	publisher := events.NewCausalPublisher(bus)
+
+	c.createPayment = paymentsapp.NewCreatePaymentChoreography(c.payments, publisher)
+	c.refundPayment = paymentsapp.NewRefundPayment(c.payments, c.refunds, publisher)
+	processRefundResult := paymentsapp.NewProcessRefundResult(c.payments, c.refunds, publisher)
+	c.paymentHandlers = paymentshandlers.NewPaymentEventHandlers(
+		paymentsapp.NewProcessPaymentMethod(c.payments, publisher),
+		paymentsapp.NewProcessWalletDebit(c.payments, publisher),
+		paymentsapp.NewHandleExternalWebhooks(publisher),
+		paymentsapp.NewProcessExternalProviderUpdates(c.payments, publisher),
+		paymentsapp.NewProcessPaymentOperationResult(c.payments, publisher, processRefundResult),
+		paymentsapp.NewProcessPaymentInconsistentOperation(c.payments, publisher),
+		c.refundPayment,
+		paymentsapp.NewProcessRefund(c.payments, publisher),
+		processRefundResult,
+		paymentshandlers.NewPaymentEventRegistry(),
+	)
+
+	c.walletHandlers = wallethandlers.NewWalletEventHandlers(
+		walletapp.NewCreateMovement(c.wallets, c.transactions, publisher),
+		walletapp.NewRevertMovement(c.wallets, c.transactions, publisher),
+		wallethandlers.NewWalletEventRegistry(),
+	)
+
+	require.NoError(t, bus.Subscribe(ctx, "", c.paymentHandlers))
+	require.NoError(t, bus.Subscribe(ctx, "wallet.#", c.walletHandlers))
+
+	return c
+}
+
+func (c *choreography) addWallet(t *testing.T, balance int64) models.ID {
+	wallet := &walletdomain.Wallet{
+		ID:         models.GenerateUUID(),
+		UserID:     models.GenerateUUID(),
+		Balance:    models.NewMoney(balance, "USD"),
+		Status:     walletdomain.WalletStatusActive,
+		Timestamps: models.NewTimestamps(),
+	}
+	require.NoError(t, c.wallets.Save(context.Background(), wallet))
+	return wallet.ID
+}
+
+func (c *choreography) pay(t *testing.T, walletID models.ID, amount int64) models.ID {
+	wallet := walletID.String()
+	response, err := c.createPayment.Execute(context.Background(), &paymentsapp.CreatePaymentCommand{
+		UserID:            models.GenerateUUID().String(),
+		Amount:            amount,
+		Currency:          "USD",
+		PaymentMethodType: paymentsdomain.PaymentMethodTypeWallet.String(),
+		WalletID:          &wallet,
+		Description:       "choreography test",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.bus.Drain(ctx))
+
+	return models.ID(response.PaymentID)
+}
+
+func TestPaymentWalletChoreography(t *testing.T) {
+	deliveryModes := map[string][]sharedinfra.MemoryBusOption{
+		"sync":  nil,
+		"async": {sharedinfra.WithAsyncDelivery(4)},
+	}
+
+	tests := []struct {
+		name                 string
+		balance              int64
+		amount               int64
+		expectedStatus       paymentsdomain.PaymentStatus
+		expectedBalance      int64
+		expectedTransactions int
+		expectedTopics       []events.Topic
+	}{
+		{
+			name:                 "wallet debit completes the payment",
+			balance:              10000,
+			amount:               2500,
+			expectedStatus:       paymentsdomain.PaymentStatusCompleted,
+			expectedBalance:      7500,
+			expectedTransactions: 1,
+			expectedTopics: []events.Topic{
+				events.PaymentCreatedEvent,
+				events.WalletMovementCreationRequestedEvent,
+				events.WalletDebitedEvent,
+				events.WalletMovementCreatedEvent,
+				events.PaymentOperationCompletedEvent,
+				events.PaymentCompletedEvent,
+			},
+		},
+		{
+			name:                 "insufficient funds fails the payment",
+			balance:              1000,
+			amount:               2500,
+			expectedStatus:       paymentsdomain.PaymentStatusFailed,
+			expectedBalance:      1000,
+			expectedTransactions: 0,
+			expectedTopics: []events.Topic{
+				events.PaymentCreatedEvent,
+				events.WalletMovementCreationRequestedEvent,
+				events.InsufficientFundsEvent,
+				events.PaymentOperationFailedEvent,
+				events.PaymentFailedEvent,
+			},
+		},
+	}
+
+	for mode, opts := range deliveryModes {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				c := newChoreography(t, opts...)
+				walletID := c.addWallet(t, tt.balance)
+
+				paymentID := c.pay(t, walletID, tt.amount)
+
+				payment, err := c.payments.FindByID(context.Background(), paymentID)
+				require.NoError(t, err)
+				require.NotNil(t, payment)
+				assert.Equal(t, tt.expectedStatus, payment.Status)
+
+				wallet, err := c.wallets.FindByID(context.Background(), walletID)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedBalance, wallet.Balance.Amount)
+
+				transactions, err := c.transactions.FindByPaymentID(context.Background(), paymentID)
+				require.NoError(t, err)
+				assert.Len(t, transactions, tt.expectedTransactions)
+
+				for _, topic := range tt.expectedTopics {
+					assert.NotEmpty(t, c.bus.PublishedMatching(topic), "expected %s to be published", topic)
+				}
+				assert.Empty(t, c.bus.DeadLetters())
+
+				// Every event descends from payment.created
+				roots := events.BuildCausalTree(c.bus.Published())
+				require.Len(t, roots, 1)
+				assert.Equal(t, events.Topic(events.PaymentCreatedEvent), roots[0].Event.Topic)
+				for _, event := range c.bus.Published() {
+					assert.Equal(t, roots[0].Event.ID, event.CorrelationID, "%s is not correlated", event.Topic)
+				}
+			})
+		}
+	}
+}
+
+func TestRefundLedgerChoreography(t *testing.T) {
+	c := newChoreography(t)
+	walletID := c.addWallet(t, 10000)
+	paymentID := c.pay(t, walletID, 6000)
+
+	refund := func(amount int64) (*paymentsapp.RefundPaymentResponse, error) {
+		response, err := c.refundPayment.Execute(context.Background(), &paymentsapp.RefundPaymentCommand{
+			PaymentID:   paymentID,
+			Amount:      models.NewMoney(amount, "USD"),
+			Reason:      "choreography test",
+			RequestedBy: "admin-1",
+		})
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			require.NoError(t, c.bus.Drain(ctx))
+		}
+		return response, err
+	}
+	assertPayment := func(status paymentsdomain.PaymentStatus, refunded, balance int64) {
+		payment, err := c.payments.FindByID(context.Background(), paymentID)
+		require.NoError(t, err)
+		assert.Equal(t, status, payment.Status)
+		assert.Equal(t, refunded, payment.RefundedAmount.Amount)
+
+		wallet, err := c.wallets.FindByID(context.Background(), walletID)
+		require.NoError(t, err)
+		assert.Equal(t, balance, wallet.Balance.Amount)
+	}
+
+	// A partial refund is credited and counted once the wallet is credited
+	first, err := refund(2500)
+	require.NoError(t, err)
+	assertPayment(paymentsdomain.PaymentStatusPartiallyRefunded, 2500, 6500)
+
+	// Refunding more than is left is rejected without crediting the wallet
+	_, err = refund(4000)
+	assert.ErrorIs(t, err, paymentsdomain.ErrRefundExceedsRefundable)
+	assertPayment(paymentsdomain.PaymentStatusPartiallyRefunded, 2500, 6500)
+
+	// Refunding no amount refunds what is left
+	second, err := refund(0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3500), second.Amount.Amount)
+	assertPayment(paymentsdomain.PaymentStatusRefunded, 6000, 10000)
+
+	_, err = refund(100)
+	assert.ErrorIs(t, err, paymentsdomain.ErrPaymentNotRefundable)
+
+	refunds, err := c.refunds.FindByPaymentID(context.Background(), paymentID)
+	require.NoError(t, err)
+	require.Len(t, refunds, 2)
+	assert.Equal(t, first.RefundID, refunds[0].ID)
+	assert.Equal(t, second.RefundID, refunds[1].ID)
+	for _, refund := range refunds {
+		assert.Equal(t, paymentsdomain.RefundStatusCompleted, refund.Status)
+	}
+	assert.Len(t, c.bus.PublishedMatching(events.PaymentPartiallyRefundedEvent), 1)
+	assert.Len(t, c.bus.PublishedMatching(events.PaymentRefundedEvent), 1)
+	assert.Empty(t, c.bus.DeadLetters())
+}
+
+func TestChoreographies_RoutedByParticipants(t *testing.T) {
+	c := newChoreography(t)
+	routes := map[string][]events.Topic{
+		saga.ParticipantPayments: c.paymentHandlers.ConsumedTopics(),
+		saga.ParticipantWallet:   c.walletHandlers.ConsumedTopics(),
+	}
+
+	for _, choreography := range saga.Choreographies() {
+		t.Run(choreography.Name, func(t *testing.T) {
+			for _, step := range choreography.Steps {
+				consumed, ok := routes[step.Participant]
+				if !ok {
+					// External providers are not handlers of this repository
+					continue
+				}
+				for _, topic := range step.Triggers {
+					assert.Contains(t, consumed, topic, "step %q is declared on %s, which %s does not route",
+						step.Name, topic, step.Participant)
+				}
+			}
+		})
+	}
+}
+
+func TestInMemoryBus_Redelivery(t *testing.T) {
+	var attempts []int
+	bus := sharedinfra.NewInMemoryBus(
+		sharedinfra.WithMaxDeliveries(3),
+		sharedinfra.WithDeliveryHook(func(d sharedinfra.Delivery) {
+			attempts = append(attempts, d.Attempt)
+		}),
+	)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	failures := 1
+	require.NoError(t, bus.Subscribe(context.Background(), "payment.*", events.EventHandlerFunc(
+		func(ctx context.Context, event *events.Event) error {
+			if failures > 0 {
+				failures--
+				return assert.AnError
+			}
+			return nil
+		},
+	)))
+	require.NoError(t, bus.Subscribe(context.Background(), "payment.created", events.EventHandlerFunc(
+		func(ctx context.Context, event *events.Event) error {
+			return assert.AnError
+		},
+	)))
+
+	event := events.NewEvent(models.GenerateUUID(), events.PaymentCreatedEvent, map[string]string{"payment_id": "p-1"})
+	require.NoError(t, bus.Publish(context.Background(), event))
+
+	assert.Equal(t, []int{1, 2, 1, 2, 3}, attempts)
+
+	deadLetters := bus.DeadLetters()
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, event.ID, deadLetters[0].Event.ID)
+	assert.Equal(t, 3, deadLetters[0].Attempts)
+}
@@ -22,6 +22,9 @@ type CreateMovementCommand struct {
 	Reference   string    `json:"reference"`
 	PaymentID   string    `json:"payment_id,omitempty"`
 	Description string    `json:"description,omitempty"`
+	// RefundID is the refund a credit pays out, echoed on the movement
+	// created event
+	RefundID string `json:"refund_id,omitempty"`
 }
 
 // CreateMovementResponse represents the response after creating a movement
@@ -151,6 +154,13 @@ func (uc *CreateMovement) Execute(ctx context.Context, cmd *CreateMovementComman
 		transaction, err = wallet.Debit(amount, *paymentID, cmd.Reference)
 		if err != nil {
 			span.RecordError(err)
+			if errors.Is(err, domain.ErrInsufficientFunds) {
+				// The payment waits for the rejection to fail
+				if err := uc.eventPublisher.Publish(ctx, wallet.Events()...); err != nil {
+					return nil, errors.Wrap(err, "failed to publish insufficient funds event")
+				}
+				wallet.ClearEvents()
+			}
 			return nil, errors.Wrap(err, "failed to debit wallet")
 		}
 
@@ -172,9 +182,8 @@ func (uc *CreateMovement) Execute(ctx context.Context, cmd *CreateMovementComman
 		return nil, errors.Wrap(err, "failed to save transaction")
 	}
 
-	// Publish domain events with tracing
+	// Publish domain events
 	if len(wallet.Events()) > 0 {
-		uc.publishEventsWithTracing(ctx, wallet.Events())
 		if err := uc.eventPublisher.Publish(ctx, wallet.Events()...); err != nil {
 			span.RecordError(err)
 			return nil, errors.Wrap(err, "failed to publish events")
@@ -196,10 +205,10 @@ func (uc *CreateMovement) Execute(ctx context.Context, cmd *CreateMovementComman
 		Reference:     cmd.Reference,
 		Description:   cmd.Description,
 		PaymentID:     paymentID,
+		RefundID:      cmd.RefundID,
 	})
 
-	// Publish movement event with tracing
-	uc.publishEventsWithTracing(ctx, []*events.Event{movementEvent})
+	// Publish movement event
 	if err := uc.eventPublisher.Publish(ctx, movementEvent); err != nil {
 		span.RecordError(err)
 		return nil, errors.Wrap(err, "failed to publish movement created event")
@@ -255,31 +264,10 @@ func (uc *CreateMovement) validateCommand(cmd *CreateMovementCommand) error {
 	return nil
 }
 
-// publishEventsWithTracing publishes events with telemetry tracking
-func (uc *CreateMovement) publishEventsWithTracing(ctx context.Context, events []*events.Event) {
-	for _, event := range events {
-		start := time.Now()
-		eventType := event.EventType
-
-		defer func() {
-			duration := time.Since(start)
-			// Record event publishing metrics
-			telemetry.RecordCounter(ctx, "events_published_total", "Total events published", 1,
-				attribute.String("event_type", eventType),
-				attribute.String("status", "success"),
-			)
-			telemetry.RecordHistogram(ctx, "event_publish_duration_seconds", "Event publishing duration", duration.Seconds(),
-				attribute.String("event_type", eventType),
-				attribute.String("status", "success"),
-			)
-		}()
-	}
-}
-
 // WalletMovementCreatedData represents data for wallet movement created event
 type WalletMovementCreatedData struct {
-	WalletID      models.ID    `json:"wallet_id"`
-	TransactionID models.ID    `json:"transaction_id"`
+	WalletID      models.ID    `json:"wallet_id" validate:"required"`
+	TransactionID models.ID    `json:"transaction_id" validate:"required"`
 	UserID        models.ID    `json:"user_id"`
 	Type          string       `json:"type"`
 	Amount        models.Money `json:"amount"`
@@ -288,4 +276,17 @@ type WalletMovementCreatedData struct {
 	Reference     string       `json:"reference"`
 	Description   string       `json:"description,omitempty"`
 	PaymentID     *models.ID   `json:"payment_id,omitempty"`
-}
\ No newline at end of file
+	RefundID      string       `json:"refund_id,omitempty"`
+}
+
+// WalletMovementCreationRequestedData represents data for wallet movement creation requested event
+type WalletMovementCreationRequestedData struct {
+	WalletID    string `json:"wallet_id" validate:"required"`
+	Type        string `json:"type" validate:"required"`
+	Amount      int64  `json:"amount" validate:"required"`
+	Currency    string `json:"currency" validate:"required,len=3"`
+	Reference   string `json:"reference" validate:"required"`
+	PaymentID   string `json:"payment_id,omitempty"`
+	Description string `json:"description,omitempty"`
+	RefundID    string `json:"refund_id,omitempty"`
+}
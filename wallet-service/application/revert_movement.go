@@ -254,10 +254,10 @@ func (uc *RevertMovement) validateCommand(cmd *RevertMovementCommand) error {
 
 // WalletMovementRevertedData represents data for wallet movement reverted event
 type WalletMovementRevertedData struct {
-	WalletID              models.ID    `json:"wallet_id"`
+	WalletID              models.ID    `json:"wallet_id" validate:"required"`
 	UserID                models.ID    `json:"user_id"`
-	OriginalTransactionID models.ID    `json:"original_transaction_id"`
-	ReversalTransactionID models.ID    `json:"reversal_transaction_id"`
+	OriginalTransactionID models.ID    `json:"original_transaction_id" validate:"required"`
+	ReversalTransactionID models.ID    `json:"reversal_transaction_id" validate:"required"`
 	OriginalType          string       `json:"original_type"`
 	Amount                models.Money `json:"amount"`
 	BalanceBefore         models.Money `json:"balance_before"`
@@ -265,4 +265,11 @@ type WalletMovementRevertedData struct {
 	Reason                string       `json:"reason"`
 	RequestedBy           string       `json:"requested_by"`
 	PaymentID             *models.ID   `json:"payment_id,omitempty"`
-}
\ No newline at end of file
+}
+
+// WalletMovementRevertRequestedData represents data for wallet movement revert requested event
+type WalletMovementRevertRequestedData struct {
+	MovementID  string `json:"movement_id" validate:"required"`
+	Reason      string `json:"reason" validate:"required"`
+	RequestedBy string `json:"requested_by" validate:"required"`
+}
@@ -5,7 +5,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
+	"github.com/draftea/payment-system/shared/events"
 	"github.com/spf13/viper"
 )
 
@@ -15,7 +17,12 @@ type Config struct {
 	Port        string    `mapstructure:"port"`
 	Database    Database  `mapstructure:"database"`
 	AWS         AWS       `mapstructure:"aws"`
+	Transport   Transport `mapstructure:"transport"`
 	Telemetry   Telemetry `mapstructure:"telemetry"`
+	Outbox      Outbox    `mapstructure:"outbox"`
+	Inbox       Inbox     `mapstructure:"inbox"`
+	Handlers    Handlers  `mapstructure:"handlers"`
+	Schemas     Schemas   `mapstructure:"schemas"`
 }
 
 type Database struct {
@@ -35,6 +42,51 @@ type AWS struct {
 	EndpointSQS     string `mapstructure:"endpoint_sqs"`
 	SNSTopicArn     string `mapstructure:"sns_topic_arn"`
 	SQSQueueURL     string `mapstructure:"sqs_queue_url"`
+	// SQSDeadLetterQueueURL receives messages that failed SQSMaxReceiveCount
+	// times or cannot be decoded; empty leaves them to the queue's redrive
+	// policy
+	SQSDeadLetterQueueURL string        `mapstructure:"sqs_dlq_url"`
+	SQSMaxReceiveCount    int32         `mapstructure:"sqs_max_receive_count"`
+	DLQMonitorInterval    time.Duration `mapstructure:"dlq_monitor_interval"`
+	// ClaimCheckBucket stores event bodies larger than ClaimCheckThreshold
+	// bytes, which are published as a reference; empty disables offloading
+	ClaimCheckBucket         string `mapstructure:"claim_check_bucket"`
+	ClaimCheckThreshold      int    `mapstructure:"claim_check_threshold"`
+	ClaimCheckExpirationDays int32  `mapstructure:"claim_check_expiration_days"`
+	S3UsePathStyle           bool   `mapstructure:"s3_use_path_style"`
+}
+
+// Event transport kinds
+const (
+	TransportSNS      = "sns"
+	TransportPostgres = "postgres"
+	TransportMemory   = "memory"
+)
+
+// Event message formats
+const (
+	FormatJSON        = "json"
+	FormatCloudEvents = "cloudevents"
+)
+
+type Transport struct {
+	// Kind selects the event transport: "sns" (SNS/SQS), "postgres" or
+	// "memory" (in-process, for local runs of a single service)
+	Kind string `mapstructure:"kind"`
+	// Format selects how published events are encoded: "json" or
+	// "cloudevents" (structured mode). Consumers read both.
+	Format   string            `mapstructure:"format"`
+	Postgres PostgresTransport `mapstructure:"postgres"`
+}
+
+type PostgresTransport struct {
+	// ConsumerGroup names the queue this service consumes; instances of the
+	// same service share it and compete for its events
+	ConsumerGroup     string        `mapstructure:"consumer_group"`
+	VisibilityTimeout time.Duration `mapstructure:"visibility_timeout"`
+	PollInterval      time.Duration `mapstructure:"poll_interval"`
+	BatchSize         int           `mapstructure:"batch_size"`
+	MaxAttempts       int           `mapstructure:"max_attempts"`
 }
 
 type Telemetry struct {
@@ -42,6 +94,47 @@ type Telemetry struct {
 	Enabled      bool   `mapstructure:"enabled"`
 }
 
+type Outbox struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+}
+
+type Inbox struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Retention       time.Duration `mapstructure:"retention"`
+	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+}
+
+// Handlers configures the middleware every consumed event goes through
+type Handlers struct {
+	// Timeout bounds a single handler attempt
+	Timeout     time.Duration `mapstructure:"timeout"`
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	// RetryBackoff is the base of the jittered exponential backoff between
+	// attempts
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+	// BreakerThreshold consecutive failures open the circuit breaker for
+	// BreakerCooldown
+	BreakerThreshold int           `mapstructure:"breaker_threshold"`
+	BreakerCooldown  time.Duration `mapstructure:"breaker_cooldown"`
+}
+
+type Schemas struct {
+	// DualPublish maps a topic under migration to the legacy schema version
+	// published alongside the current one
+	DualPublish map[string]string `mapstructure:"dual_publish"`
+}
+
+// LegacyVersions returns DualPublish keyed by topic
+func (s Schemas) LegacyVersions() map[events.Topic]string {
+	versions := make(map[events.Topic]string, len(s.DualPublish))
+	for topic, version := range s.DualPublish {
+		versions[events.Topic(topic)] = version
+	}
+	return versions
+}
+
 func ReadConfig() (*Config, error) {
 	_, filename, _, ok := runtime.Caller(0)
 	if !ok {
@@ -112,10 +205,43 @@ func setDefaultsFromEnv() {
 	viper.SetDefault("aws.endpoint_sqs", getEnv("AWS_ENDPOINT_URL_SQS", "http://localhost:4566"))
 	viper.SetDefault("aws.sns_topic_arn", getEnv("SNS_TOPIC_ARN", "arn:aws:sns:us-east-1:000000000000:payment-events"))
 	viper.SetDefault("aws.sqs_queue_url", getEnv("SQS_QUEUE_URL", "http://localhost:4566/000000000000/wallet-events"))
+	viper.SetDefault("aws.sqs_dlq_url", getEnv("SQS_DLQ_URL", "http://localhost:4566/000000000000/wallet-events-dlq"))
+	viper.SetDefault("aws.sqs_max_receive_count", 5)
+	viper.SetDefault("aws.dlq_monitor_interval", getEnv("DLQ_MONITOR_INTERVAL", "1m"))
+	viper.SetDefault("aws.claim_check_bucket", getEnv("CLAIM_CHECK_BUCKET", ""))
+	viper.SetDefault("aws.claim_check_threshold", getEnv("CLAIM_CHECK_THRESHOLD", "196608"))
+	viper.SetDefault("aws.claim_check_expiration_days", getEnv("CLAIM_CHECK_EXPIRATION_DAYS", "15"))
+	viper.SetDefault("aws.s3_use_path_style", getEnv("S3_USE_PATH_STYLE", "false"))
+
+	// Transport defaults
+	viper.SetDefault("transport.kind", getEnv("EVENT_TRANSPORT", TransportSNS))
+	viper.SetDefault("transport.format", getEnv("EVENT_FORMAT", FormatJSON))
+	viper.SetDefault("transport.postgres.consumer_group", getEnv("EVENT_CONSUMER_GROUP", "wallet-service"))
+	viper.SetDefault("transport.postgres.visibility_timeout", getEnv("EVENT_QUEUE_VISIBILITY_TIMEOUT", "30s"))
+	viper.SetDefault("transport.postgres.poll_interval", getEnv("EVENT_QUEUE_POLL_INTERVAL", "5s"))
+	viper.SetDefault("transport.postgres.batch_size", 10)
+	viper.SetDefault("transport.postgres.max_attempts", 5)
 
 	// Telemetry defaults
 	viper.SetDefault("telemetry.otlp_endpoint", getEnv("OTLP_ENDPOINT", "http://localhost:4318"))
 	viper.SetDefault("telemetry.enabled", getEnv("TELEMETRY_ENABLED", "true") == "true")
+
+	// Outbox defaults
+	viper.SetDefault("outbox.enabled", getEnv("OUTBOX_ENABLED", "true") == "true")
+	viper.SetDefault("outbox.poll_interval", getEnv("OUTBOX_POLL_INTERVAL", "1s"))
+	viper.SetDefault("outbox.batch_size", 10)
+
+	// Inbox defaults
+	viper.SetDefault("inbox.enabled", getEnv("INBOX_ENABLED", "true") == "true")
+	viper.SetDefault("inbox.retention", getEnv("INBOX_RETENTION", "168h"))
+	viper.SetDefault("inbox.cleanup_interval", getEnv("INBOX_CLEANUP_INTERVAL", "1h"))
+
+	// Event handler defaults
+	viper.SetDefault("handlers.timeout", getEnv("HANDLER_TIMEOUT", "30s"))
+	viper.SetDefault("handlers.max_attempts", 3)
+	viper.SetDefault("handlers.retry_backoff", getEnv("HANDLER_RETRY_BACKOFF", "200ms"))
+	viper.SetDefault("handlers.breaker_threshold", 5)
+	viper.SetDefault("handlers.breaker_cooldown", getEnv("HANDLER_BREAKER_COOLDOWN", "30s"))
 }
 
 func getEnv(key, defaultValue string) string {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/draftea/payment-system/shared/events"
 	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
 	"github.com/draftea/payment-system/wallet-service/application"
 	"github.com/draftea/payment-system/wallet-service/handlers"
@@ -32,10 +33,20 @@ type Dependencies struct {
 
 	// Event Handlers
 	WalletEventHandlers *handlers.WalletEventHandlers
+	// EventHandler is the subscriber's handler: the service's event handlers
+	// inside a transaction, behind the handler middleware chain
+	EventHandler sharedinfra.EventHandler
 
 	// Infrastructure
-	EventPublisher  *sharedinfra.SNSPublisherAdapter
-	EventSubscriber *sharedinfra.SQSSubscriberAdapter
+	EventPublisher    sharedinfra.TransportPublisher
+	EventSubscriber   sharedinfra.TransportSubscriber
+	EventStore        *sharedinfra.PostgresEventStore
+	Transactor        *sharedinfra.PostgresTransactor
+	OutboxPublisher   *sharedinfra.OutboxPublisher
+	OutboxRelay       *sharedinfra.OutboxRelay
+	Inbox             *sharedinfra.PostgresInbox
+	EventRegistry     *events.Registry
+	DeadLetterMonitor *sharedinfra.DeadLetterMonitor
 
 	// Telemetry
 	Telemetry         *telemetry.Telemetry
@@ -69,49 +80,204 @@ func BuildDependencies(ctx context.Context, config *Config) (*Dependencies, erro
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 	deps.DB = db
+	deps.EventStore = sharedinfra.NewPostgresEventStore(db)
+	deps.Transactor = sharedinfra.NewPostgresTransactor(db)
 
-	// Initialize AWS infrastructure
-	eventPublisher, err := sharedinfra.NewSNSPublisherAdapter(config.AWS.SNSTopicArn)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create SNS publisher: %w", err)
+	if config.Inbox.Enabled {
+		deps.Inbox = sharedinfra.NewPostgresInbox(db, deps.Transactor,
+			sharedinfra.WithInboxRetention(config.Inbox.Retention),
+			sharedinfra.WithInboxCleanupInterval(config.Inbox.CleanupInterval),
+		)
 	}
-	deps.EventPublisher = eventPublisher
 
-	eventSubscriber, err := sharedinfra.NewSQSSubscriberAdapter(config.AWS.SQSQueueURL)
+	// Initialize event transport
+	eventPublisher, eventSubscriber, err := buildTransport(ctx, config, db)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SQS subscriber: %w", err)
+		return nil, err
 	}
+	deps.EventPublisher = eventPublisher
 	deps.EventSubscriber = eventSubscriber
 
+	isSQS := config.Transport.Kind == TransportSNS || config.Transport.Kind == ""
+	if isSQS && config.AWS.SQSDeadLetterQueueURL != "" {
+		sqsClient, err := sharedinfra.NewSQSClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SQS client: %w", err)
+		}
+		deps.DeadLetterMonitor = sharedinfra.NewDeadLetterMonitor(
+			sharedinfra.NewDeadLetterQueue(sqsClient, config.AWS.SQSDeadLetterQueueURL, config.AWS.SQSQueueURL),
+			config.ServiceName,
+			config.AWS.DLQMonitorInterval,
+		)
+	}
+	deps.EventRegistry = handlers.NewWalletEventRegistry()
+
+	// Topics under a schema migration are also published in their legacy
+	// version until every consumer understands the current one
+	var transport events.Publisher = eventPublisher
+	if len(config.Schemas.DualPublish) > 0 {
+		transport = events.NewDualPublisher(eventPublisher, deps.EventRegistry, config.Schemas.LegacyVersions())
+	}
+
+	// Use cases publish through the outbox so events are committed with the
+	// aggregate; the relay forwards them to the transport
+	publisher := transport
+	if config.Outbox.Enabled {
+		deps.OutboxPublisher = sharedinfra.NewOutboxPublisher(db, config.ServiceName)
+		deps.OutboxRelay = sharedinfra.NewOutboxRelay(db, config.ServiceName, transport,
+			sharedinfra.WithOutboxPollInterval(config.Outbox.PollInterval),
+			sharedinfra.WithOutboxBatchSize(config.Outbox.BatchSize),
+		)
+		publisher = deps.OutboxPublisher
+	}
+
+	// Events published while handling another event are linked to it, and
+	// every emitted event is kept in event_stream so the causal tree of a
+	// payment can be rebuilt with EventStore.GetEventsByCorrelationID. The
+	// trace context is written to the metadata before the event is stored, so
+	// consumers continue the publisher's trace.
+	publisher = events.ChainPublisher(publisher,
+		events.CausationMiddleware(),
+		events.ValidationMiddleware(deps.EventRegistry),
+		sharedinfra.PublishLoggingMiddleware(nil),
+		sharedinfra.PublishMetricsMiddleware(),
+		sharedinfra.PublishTracingMiddleware(),
+		events.RecordingMiddleware(deps.EventStore),
+	)
+
 	// Initialize repositories
 	deps.WalletRepository = *infrastructure.NewPostgresWalletRepository(db)
 	deps.TransactionRepository = *infrastructure.NewPostgresTransactionRepository(db)
 
 	// Initialize use cases
 	deps.GetWallet = application.NewGetWallet(&deps.WalletRepository)
-	deps.CreateMovement = application.NewCreateMovement(&deps.WalletRepository, &deps.TransactionRepository, eventPublisher)
-	deps.RevertMovement = application.NewRevertMovement(&deps.WalletRepository, &deps.TransactionRepository, eventPublisher)
+	deps.CreateMovement = application.NewCreateMovement(&deps.WalletRepository, &deps.TransactionRepository, publisher)
+	deps.RevertMovement = application.NewRevertMovement(&deps.WalletRepository, &deps.TransactionRepository, publisher)
 
 	// Initialize handlers
-	deps.WalletHandlers = handlers.NewWalletHandlers(deps.GetWallet, deps.CreateMovement, deps.RevertMovement)
-	deps.WalletEventHandlers = handlers.NewWalletEventHandlers(deps.CreateMovement, deps.RevertMovement)
+	deps.WalletHandlers = handlers.NewWalletHandlers(deps.GetWallet, deps.CreateMovement, deps.RevertMovement, deps.Transactor)
+	deps.WalletEventHandlers = handlers.NewWalletEventHandlers(deps.CreateMovement, deps.RevertMovement, deps.EventRegistry)
+
+	// Each attempt runs in its own transaction, deduplicated through the inbox
+	// when enabled
+	var handler sharedinfra.EventHandler = sharedinfra.NewTransactionalEventHandler(deps.Transactor, deps.WalletEventHandlers)
+	if deps.Inbox != nil {
+		handler = deps.Inbox.Wrap(deps.WalletEventHandlers)
+	}
+	deps.EventHandler = sharedinfra.ChainHandler(handler,
+		sharedinfra.HandlerMetricsMiddleware(),
+		sharedinfra.HandlerLoggingMiddleware(nil),
+		sharedinfra.SchemaValidationMiddleware(deps.EventRegistry),
+		sharedinfra.CircuitBreakerMiddleware(config.Handlers.BreakerThreshold, config.Handlers.BreakerCooldown),
+		sharedinfra.RetryMiddleware(config.Handlers.MaxAttempts, config.Handlers.RetryBackoff, 10*config.Handlers.RetryBackoff),
+		sharedinfra.TimeoutMiddleware(config.Handlers.Timeout),
+		sharedinfra.RecoverMiddleware(),
+	)
 
 	return deps, nil
 }
 
+// buildTransport creates the event publisher and subscriber selected by
+// config.Transport.Kind
+func buildTransport(ctx context.Context, config *Config, db *sqlx.DB) (sharedinfra.TransportPublisher, sharedinfra.TransportSubscriber, error) {
+	// Consumers always read CloudEvents and plain JSON, so producers can
+	// switch formats independently
+	decoder := sharedinfra.NewCloudEventsCodec(config.ServiceName)
+
+	var encoder sharedinfra.Codec
+	switch config.Transport.Format {
+	case FormatJSON, "":
+		encoder = sharedinfra.NewJSONCodec()
+	case FormatCloudEvents:
+		encoder = decoder
+	default:
+		return nil, nil, fmt.Errorf("unknown event format %q", config.Transport.Format)
+	}
+
+	switch config.Transport.Kind {
+	case TransportSNS, "":
+		publisherOpts := []sharedinfra.SNSPublisherOption{sharedinfra.WithPublisherCodec(encoder)}
+		subscriberOpts := []sharedinfra.SQSSubscriberOption{
+			sharedinfra.WithCodec(decoder),
+			sharedinfra.WithCircuitOpenDelay(config.Handlers.BreakerCooldown),
+		}
+
+		// Bodies over the SNS size limit are offloaded to S3
+		if config.AWS.ClaimCheckBucket != "" {
+			s3Client, err := sharedinfra.NewS3Client(ctx, config.AWS.S3UsePathStyle)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to create S3 client: %w", err)
+			}
+
+			store := sharedinfra.NewS3PayloadStore(s3Client, config.AWS.ClaimCheckBucket)
+			if config.AWS.ClaimCheckExpirationDays > 0 {
+				if err := store.EnsureLifecycle(ctx, config.AWS.ClaimCheckExpirationDays); err != nil {
+					log.Printf("Failed to configure claim-check expiration: %v", err)
+				}
+			}
+
+			publisherOpts = append(publisherOpts, sharedinfra.WithClaimCheck(store, config.AWS.ClaimCheckThreshold))
+			subscriberOpts = append(subscriberOpts, sharedinfra.WithPayloadStore(store))
+		}
+
+		publisher, err := sharedinfra.NewSNSPublisherAdapter(config.AWS.SNSTopicArn, publisherOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create SNS publisher: %w", err)
+		}
+
+		if config.AWS.SQSDeadLetterQueueURL != "" {
+			subscriberOpts = append(subscriberOpts,
+				sharedinfra.WithDeadLetterQueue(config.AWS.SQSDeadLetterQueueURL, config.AWS.SQSMaxReceiveCount),
+			)
+		}
+
+		subscriber, err := sharedinfra.NewSQSSubscriberAdapter(config.AWS.SQSQueueURL, subscriberOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create SQS subscriber: %w", err)
+		}
+
+		return publisher, subscriber, nil
+	case TransportPostgres:
+		opts := []sharedinfra.PostgresQueueOption{
+			sharedinfra.WithQueueVisibilityTimeout(config.Transport.Postgres.VisibilityTimeout),
+			sharedinfra.WithQueuePollInterval(config.Transport.Postgres.PollInterval),
+			sharedinfra.WithQueueBatchSize(config.Transport.Postgres.BatchSize),
+			sharedinfra.WithQueueMaxAttempts(config.Transport.Postgres.MaxAttempts),
+			sharedinfra.WithQueueCircuitOpenDelay(config.Handlers.BreakerCooldown),
+		}
+
+		publisher := sharedinfra.NewPostgresEventPublisher(db, sharedinfra.WithQueueCodec(encoder))
+		subscriber := sharedinfra.NewPostgresEventSubscriber(db, config.GetDatabaseURL(), config.Transport.Postgres.ConsumerGroup,
+			append(opts, sharedinfra.WithQueueCodec(decoder))...,
+		)
+		return publisher, subscriber, nil
+	case TransportMemory:
+		bus := sharedinfra.NewInMemoryBus(sharedinfra.WithAsyncDelivery(1), sharedinfra.WithBusCodec(encoder))
+		return bus, bus, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown event transport %q", config.Transport.Kind)
+	}
+}
+
 // Close closes all dependencies
 func (d *Dependencies) Close() error {
 	var errs []error
 
-	if d.DB != nil {
-		if err := d.DB.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("failed to close database: %w", err))
+	if d.OutboxRelay != nil {
+		if err := d.OutboxRelay.Stop(context.Background()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop outbox relay: %w", err))
 		}
 	}
 
-	if d.EventPublisher != nil {
-		if err := d.EventPublisher.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("failed to close event publisher: %w", err))
+	if d.Inbox != nil {
+		if err := d.Inbox.Stop(context.Background()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop inbox: %w", err))
+		}
+	}
+
+	if d.DeadLetterMonitor != nil {
+		if err := d.DeadLetterMonitor.Stop(context.Background()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop dead-letter monitor: %w", err))
 		}
 	}
 
@@ -121,6 +287,19 @@ func (d *Dependencies) Close() error {
 		}
 	}
 
+	if d.EventPublisher != nil {
+		if err := d.EventPublisher.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close event publisher: %w", err))
+		}
+	}
+
+	// The Postgres transport needs the database until it is closed
+	if d.DB != nil {
+		if err := d.DB.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close database: %w", err))
+		}
+	}
+
 	if d.TelemetryShutdown != nil {
 		d.TelemetryShutdown()
 	}
@@ -8,6 +8,9 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrInsufficientFunds is returned when a debit exceeds the wallet balance
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
 // WalletStatus represents the status of a wallet
 type WalletStatus string
 
@@ -82,7 +85,7 @@ func CreateWallet(userID models.ID, currency string) (*Wallet, error) {
 	}
 
 	// Record domain event
-	event := events.NewEvent(wallet.ID, "wallet.created", WalletCreatedData{
+	event := events.NewEvent(wallet.ID, events.WalletCreatedEvent, WalletCreatedData{
 		WalletID: wallet.ID,
 		UserID:   wallet.UserID,
 		Currency: wallet.Balance.Currency,
@@ -117,7 +120,7 @@ func (w *Wallet) Debit(amount models.Money, paymentID models.ID, reference strin
 			Shortfall:       models.NewMoney(amount.Amount-w.Balance.Amount, amount.Currency),
 		})
 		w.recordEvent(event)
-		return nil, errors.New("insufficient funds")
+		return nil, ErrInsufficientFunds
 	}
 
 	// Create transaction
@@ -273,16 +276,16 @@ func (w *Wallet) recordEvent(event *events.Event) {
 
 // Event Data Structures
 type WalletCreatedData struct {
-	WalletID models.ID `json:"wallet_id"`
-	UserID   models.ID `json:"user_id"`
-	Currency string    `json:"currency"`
+	WalletID models.ID `json:"wallet_id" validate:"required"`
+	UserID   models.ID `json:"user_id" validate:"required"`
+	Currency string    `json:"currency" validate:"required,len=3"`
 }
 
 type WalletDebitedData struct {
-	WalletID      models.ID    `json:"wallet_id"`
+	WalletID      models.ID    `json:"wallet_id" validate:"required"`
 	UserID        models.ID    `json:"user_id"`
-	PaymentID     models.ID    `json:"payment_id"`
-	TransactionID models.ID    `json:"transaction_id"`
+	PaymentID     models.ID    `json:"payment_id" validate:"required"`
+	TransactionID models.ID    `json:"transaction_id" validate:"required"`
 	Amount        models.Money `json:"amount"`
 	BalanceBefore models.Money `json:"balance_before"`
 	BalanceAfter  models.Money `json:"balance_after"`
@@ -290,9 +293,9 @@ type WalletDebitedData struct {
 }
 
 type WalletCreditedData struct {
-	WalletID      models.ID    `json:"wallet_id"`
+	WalletID      models.ID    `json:"wallet_id" validate:"required"`
 	UserID        models.ID    `json:"user_id"`
-	TransactionID models.ID    `json:"transaction_id"`
+	TransactionID models.ID    `json:"transaction_id" validate:"required"`
 	Amount        models.Money `json:"amount"`
 	BalanceBefore models.Money `json:"balance_before"`
 	BalanceAfter  models.Money `json:"balance_after"`
@@ -300,21 +303,21 @@ type WalletCreditedData struct {
 }
 
 type InsufficientFundsData struct {
-	WalletID         models.ID    `json:"wallet_id"`
+	WalletID         models.ID    `json:"wallet_id" validate:"required"`
 	UserID           models.ID    `json:"user_id"`
-	PaymentID        models.ID    `json:"payment_id"`
+	PaymentID        models.ID    `json:"payment_id" validate:"required"`
 	RequestedAmount  models.Money `json:"requested_amount"`
 	AvailableBalance models.Money `json:"available_balance"`
 	Shortfall        models.Money `json:"shortfall"`
 }
 
 type WalletFrozenData struct {
-	WalletID models.ID `json:"wallet_id"`
+	WalletID models.ID `json:"wallet_id" validate:"required"`
 	UserID   models.ID `json:"user_id"`
 }
 
 type WalletUnfrozenData struct {
-	WalletID models.ID `json:"wallet_id"`
+	WalletID models.ID `json:"wallet_id" validate:"required"`
 	UserID   models.ID `json:"user_id"`
 }
 
@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 	"github.com/draftea/payment-system/wallet-service/application"
+	"github.com/draftea/payment-system/wallet-service/domain"
 
 	"github.com/draftea/payment-system/shared/events"
 	"github.com/pkg/errors"
@@ -13,30 +13,38 @@ import (
 type WalletEventHandlers struct {
 	createMovement *application.CreateMovement
 	revertMovement *application.RevertMovement
+	registry       *events.Registry
+	router         *events.Router
 }
 
 // NewWalletEventHandlers creates new wallet event handlers
 func NewWalletEventHandlers(
 	createMovement *application.CreateMovement,
 	revertMovement *application.RevertMovement,
+	registry *events.Registry,
 ) *WalletEventHandlers {
-	return &WalletEventHandlers{
+	h := &WalletEventHandlers{
 		createMovement: createMovement,
 		revertMovement: revertMovement,
+		registry:       registry,
 	}
+
+	h.router = events.NewRouter(h.HandlerID()).
+		RegisterFunc(events.WalletMovementCreationRequestedEvent, h.HandleMovementCreationRequest).
+		RegisterFunc(events.WalletMovementRevertRequestedEvent, h.HandleMovementRevertRequest)
+
+	return h
 }
 
 // Handle implements the events.EventHandler interface
 func (h *WalletEventHandlers) Handle(ctx context.Context, event *events.Event) error {
-	switch event.EventType {
-	case events.WalletMovementCreationRequestedEvent:
-		return h.HandleMovementCreationRequest(ctx, event)
-	case events.WalletMovementRevertRequestedEvent:
-		return h.HandleMovementRevertRequest(ctx, event)
-	default:
-		// Unknown event type, ignore
-		return nil
-	}
+	return h.router.Handle(ctx, event)
+}
+
+// ConsumedTopics implements the events.TopicConsumer interface with the
+// topics routed in the constructor
+func (h *WalletEventHandlers) ConsumedTopics() []events.Topic {
+	return h.router.Topics()
 }
 
 // HandlerID returns the unique identifier for this event handler
@@ -44,65 +52,34 @@ func (h *WalletEventHandlers) HandlerID() string {
 	return "wallet-service-event-handler"
 }
 
-// HandleMovementCreationRequest handles movement creation requests
+// HandleMovementCreationRequest handles movement creation requests. An
+// expense the balance does not cover is not an error: the wallet publishes
+// wallet.insufficient.funds and the payment service fails the payment.
 func (h *WalletEventHandlers) HandleMovementCreationRequest(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.WalletMovementCreationRequestedEvent {
-		return nil
-	}
-
-	// Extract data from event
-	data := event.Data.(map[string]interface{})
-
-	// Validate required fields
-	walletID, ok := data["wallet_id"].(string)
-	if !ok {
-		return errors.New("wallet_id is required")
-	}
-
-	movementType, ok := data["type"].(string)
-	if !ok {
-		return errors.New("movement type is required")
-	}
-
-	amount, ok := data["amount"].(float64)
-	if !ok {
-		return errors.New("amount is required")
-	}
-
-	currency, ok := data["currency"].(string)
-	if !ok {
-		return errors.New("currency is required")
-	}
-
-	reference, ok := data["reference"].(string)
-	if !ok {
-		return errors.New("reference is required")
-	}
-
-	// Extract optional fields
-	var paymentID string
-	if pid, exists := data["payment_id"].(string); exists {
-		paymentID = pid
+	data, err := events.DecodePayload[application.WalletMovementCreationRequestedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode movement creation request")
 	}
 
-	description, _ := data["description"].(string)
-
 	// Create command
 	cmd := &application.CreateMovementCommand{
-		WalletID:    walletID,
-		Type:        movementType,
-		Amount:      int64(amount),
-		Currency:    currency,
-		Reference:   reference,
-		PaymentID:   paymentID,
-		Description: description,
+		WalletID:    data.WalletID,
+		Type:        data.Type,
+		Amount:      data.Amount,
+		Currency:    data.Currency,
+		Reference:   data.Reference,
+		PaymentID:   data.PaymentID,
+		Description: data.Description,
+		RefundID:    data.RefundID,
 	}
 
 	// Execute create movement use case
-	_, err := h.createMovement.Execute(ctx, cmd)
+	_, err = h.createMovement.Execute(ctx, cmd)
+	if errors.Is(err, domain.ErrInsufficientFunds) {
+		return nil
+	}
 	if err != nil {
-		fmt.Printf("Failed to create movement for wallet %s: %v\n", walletID, err)
-		return err
+		return errors.Wrapf(err, "failed to create movement for wallet %s", data.WalletID)
 	}
 
 	return nil
@@ -110,41 +87,22 @@ func (h *WalletEventHandlers) HandleMovementCreationRequest(ctx context.Context,
 
 // HandleMovementRevertRequest handles movement revert requests
 func (h *WalletEventHandlers) HandleMovementRevertRequest(ctx context.Context, event *events.Event) error {
-	if event.EventType != events.WalletMovementRevertRequestedEvent {
-		return nil
-	}
-
-	// Extract data from event
-	data := event.Data.(map[string]interface{})
-
-	// Validate required fields
-	movementID, ok := data["movement_id"].(string)
-	if !ok {
-		return errors.New("movement_id is required")
-	}
-
-	reason, ok := data["reason"].(string)
-	if !ok {
-		return errors.New("reason is required")
-	}
-
-	requestedBy, ok := data["requested_by"].(string)
-	if !ok {
-		return errors.New("requested_by is required")
+	data, err := events.DecodePayload[application.WalletMovementRevertRequestedData](h.registry, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode movement revert request")
 	}
 
 	// Create command
 	cmd := &application.RevertMovementCommand{
-		MovementID:  movementID,
-		Reason:      reason,
-		RequestedBy: requestedBy,
+		MovementID:  data.MovementID,
+		Reason:      data.Reason,
+		RequestedBy: data.RequestedBy,
 	}
 
 	// Execute revert movement use case
-	_, err := h.revertMovement.Execute(ctx, cmd)
+	_, err = h.revertMovement.Execute(ctx, cmd)
 	if err != nil {
-		fmt.Printf("Failed to revert movement %s: %v\n", movementID, err)
-		return err
+		return errors.Wrapf(err, "failed to revert movement %s", data.MovementID)
 	}
 
 	return nil
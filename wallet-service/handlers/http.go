@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
 	"github.com/draftea/payment-system/wallet-service/application"
 	"github.com/go-chi/chi/v5"
 )
@@ -13,6 +15,7 @@ type WalletHandlers struct {
 	getWallet      *application.GetWallet
 	createMovement *application.CreateMovement
 	revertMovement *application.RevertMovement
+	transactor     sharedinfra.Transactor
 }
 
 // NewWalletHandlers creates new wallet handlers
@@ -20,11 +23,13 @@ func NewWalletHandlers(
 	getWallet *application.GetWallet,
 	createMovement *application.CreateMovement,
 	revertMovement *application.RevertMovement,
+	transactor sharedinfra.Transactor,
 ) *WalletHandlers {
 	return &WalletHandlers{
 		getWallet:      getWallet,
 		createMovement: createMovement,
 		revertMovement: revertMovement,
+		transactor:     transactor,
 	}
 }
 
@@ -73,7 +78,12 @@ func (h *WalletHandlers) CreateMovement(w http.ResponseWriter, r *http.Request)
 
 	cmd.WalletID = walletID
 
-	response, err := h.createMovement.Execute(r.Context(), &cmd)
+	var response *application.CreateMovementResponse
+	err := h.transactor.WithinTransaction(r.Context(), func(ctx context.Context) error {
+		var err error
+		response, err = h.createMovement.Execute(ctx, &cmd)
+		return err
+	})
 	if err != nil {
 		if err.Error() == "wallet not found" {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -108,7 +118,12 @@ func (h *WalletHandlers) RevertMovement(w http.ResponseWriter, r *http.Request)
 
 	cmd.MovementID = movementID
 
-	response, err := h.revertMovement.Execute(r.Context(), &cmd)
+	var response *application.RevertMovementResponse
+	err := h.transactor.WithinTransaction(r.Context(), func(ctx context.Context) error {
+		var err error
+		response, err = h.revertMovement.Execute(ctx, &cmd)
+		return err
+	})
 	if err != nil {
 		if err.Error() == "original transaction not found" || err.Error() == "movement not found" {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"github.com/draftea/payment-system/shared/events"
+	"github.com/draftea/payment-system/wallet-service/application"
+	"github.com/draftea/payment-system/wallet-service/domain"
+)
+
+// NewWalletEventRegistry creates the payload registry for the topics the
+// wallet service consumes and produces
+func NewWalletEventRegistry() *events.Registry {
+	return events.NewRegistry().
+		// Consumed
+		MustRegister(events.WalletMovementCreationRequestedEvent, application.WalletMovementCreationRequestedData{}).
+		MustRegister(events.WalletMovementRevertRequestedEvent, application.WalletMovementRevertRequestedData{}).
+		// Produced
+		MustRegister(events.WalletCreatedEvent, domain.WalletCreatedData{}).
+		MustRegister(events.WalletDebitedEvent, domain.WalletDebitedData{}).
+		MustRegister(events.WalletCreditedEvent, domain.WalletCreditedData{}).
+		MustRegister(events.InsufficientFundsEvent, domain.InsufficientFundsData{}).
+		MustRegister(events.WalletFrozenEvent, domain.WalletFrozenData{}).
+		MustRegister(events.WalletUnfrozenEvent, domain.WalletUnfrozenData{}).
+		MustRegister(events.WalletMovementCreatedEvent, application.WalletMovementCreatedData{}).
+		MustRegister(events.WalletMovementRevertedEvent, application.WalletMovementRevertedData{})
+}
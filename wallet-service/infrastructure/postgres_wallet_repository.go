@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/draftea/payment-system/shared/events"
+	sharedinfra "github.com/draftea/payment-system/shared/infrastructure"
 	"github.com/draftea/payment-system/shared/models"
 	"github.com/draftea/payment-system/wallet-service/domain"
 	"github.com/jmoiron/sqlx"
@@ -62,7 +63,7 @@ func (r *PostgresWalletRepository) insertWallet(ctx context.Context, wallet *dom
 		)`
 
 	pgWallet := r.toPostgres(wallet)
-	_, err := r.db.NamedExecContext(ctx, query, pgWallet)
+	_, err := sharedinfra.Executor(ctx, r.db).NamedExecContext(ctx, query, pgWallet)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert wallet")
 	}
@@ -77,7 +78,7 @@ func (r *PostgresWalletRepository) updateWallet(ctx context.Context, wallet *dom
 		SET balance = :balance, status = :status, updated_at = :updated_at, version = :version
 		WHERE id = :id AND version = :old_version`
 
-	_, err := r.db.NamedExecContext(ctx, query, map[string]interface{}{
+	_, err := sharedinfra.Executor(ctx, r.db).NamedExecContext(ctx, query, map[string]interface{}{
 		"id":          wallet.ID.String(),
 		"balance":     wallet.Balance.Amount,
 		"status":      string(wallet.Status),
@@ -102,7 +103,7 @@ func (r *PostgresWalletRepository) FindByID(ctx context.Context, id models.ID) (
 		WHERE id = $1 AND deleted_at IS NULL`
 
 	var pgWallet postgresWallet
-	err := r.db.GetContext(ctx, &pgWallet, query, id.String())
+	err := sharedinfra.Executor(ctx, r.db).GetContext(ctx, &pgWallet, query, id.String())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Wallet not found
@@ -123,7 +124,7 @@ func (r *PostgresWalletRepository) FindByUserID(ctx context.Context, userID mode
 		LIMIT 1`
 
 	var pgWallet postgresWallet
-	err := r.db.GetContext(ctx, &pgWallet, query, userID.String())
+	err := sharedinfra.Executor(ctx, r.db).GetContext(ctx, &pgWallet, query, userID.String())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Wallet not found
@@ -217,7 +218,7 @@ func (r *PostgresTransactionRepository) Save(ctx context.Context, transaction *d
 		)`
 
 	pgTransaction := r.transactionToPostgres(transaction)
-	_, err := r.db.NamedExecContext(ctx, query, pgTransaction)
+	_, err := sharedinfra.Executor(ctx, r.db).NamedExecContext(ctx, query, pgTransaction)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert transaction")
 	}
@@ -234,7 +235,7 @@ func (r *PostgresTransactionRepository) FindByID(ctx context.Context, id models.
 		WHERE id = $1 AND deleted_at IS NULL`
 
 	var pgTransaction postgresTransaction
-	err := r.db.GetContext(ctx, &pgTransaction, query, id.String())
+	err := sharedinfra.Executor(ctx, r.db).GetContext(ctx, &pgTransaction, query, id.String())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Transaction not found
@@ -256,7 +257,7 @@ func (r *PostgresTransactionRepository) FindByWalletID(ctx context.Context, wall
 		LIMIT $2 OFFSET $3`
 
 	var pgTransactions []postgresTransaction
-	err := r.db.SelectContext(ctx, &pgTransactions, query, walletID.String(), limit, offset)
+	err := sharedinfra.Executor(ctx, r.db).SelectContext(ctx, &pgTransactions, query, walletID.String(), limit, offset)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find transactions by wallet ID")
 	}
@@ -283,7 +284,7 @@ func (r *PostgresTransactionRepository) FindByPaymentID(ctx context.Context, pay
 		ORDER BY created_at DESC`
 
 	var pgTransactions []postgresTransaction
-	err := r.db.SelectContext(ctx, &pgTransactions, query, paymentID.String())
+	err := sharedinfra.Executor(ctx, r.db).SelectContext(ctx, &pgTransactions, query, paymentID.String())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find transactions by payment ID")
 	}